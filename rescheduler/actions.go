@@ -0,0 +1,221 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kube_client "k8s.io/client-go/kubernetes"
+	"k8s.io/contrib/rescheduler/metrics"
+)
+
+// Verbs of actions.
+const (
+	actionTaint   = "taint"
+	actionEvict   = "evict"
+	actionRelease = "release"
+)
+
+// The resource the "crd" action sink creates an object of for every action.
+// The CustomResourceDefinition has to be installed separately.
+const (
+	actionGroup    = "rescheduler.k8s.io"
+	actionVersion  = "v1alpha1"
+	actionResource = "rescheduleractions"
+	actionKind     = "ReschedulerAction"
+)
+
+// webhookTimeout bounds a single call of a "webhook" action sink.
+const webhookTimeout = 5 * time.Second
+
+// action is a change the rescheduler made to the cluster, as passed to ActionSinks.
+type action struct {
+	Time metav1.Time `json:"time"`
+	// Verb is taint, evict or release.
+	Verb string `json:"action"`
+	Node string `json:"node"`
+	// Pod is the evicted pod of evict actions.
+	Pod string `json:"pod,omitempty"`
+	// CriticalPod is the pod the action was taken for, empty for the taints
+	// of dedicated nodes.
+	CriticalPod string `json:"criticalPod,omitempty"`
+	DecisionID  string `json:"decisionID,omitempty"`
+	Instance    string `json:"instance"`
+}
+
+// ActionSink receives every taint, eviction and release the rescheduler
+// carries out, e.g. for auditing or notifications. Sinks are chosen with
+// --action-sink and called in order after the action succeeded; a sink which
+// fails doesn't stop the action or the other sinks.
+type ActionSink interface {
+	// Name identifies the sink in logs and metrics.
+	Name() string
+	Record(a action) error
+}
+
+// actionSinks are the sinks set from --action-sink in main; tests leave it empty.
+var actionSinks []ActionSink
+
+// recordAction passes <a> to all actionSinks.
+func recordAction(a action) {
+	if len(actionSinks) == 0 {
+		return
+	}
+	a.Time = metav1.Now()
+	a.Instance = instanceID()
+	for _, sink := range actionSinks {
+		if err := sink.Record(a); err != nil {
+			repeats.Warningf("action-sink/"+sink.Name(), "Failed to record %s action on node %v to %s: %v", a.Verb, a.Node, sink.Name(), err)
+			metrics.ActionSinkErrorsCount.WithLabelValues(sink.Name()).Inc()
+		}
+	}
+}
+
+// parseActionSink splits a --action-sink value into its kind and argument:
+// "stdout", "file:<path>", "webhook:<url>" or "crd".
+func parseActionSink(spec string) (string, string, error) {
+	kind, arg := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		kind, arg = spec[:i], spec[i+1:]
+	}
+	switch kind {
+	case "stdout", "crd":
+		if arg != "" {
+			return "", "", fmt.Errorf("action sink %s takes no argument, got %q", kind, spec)
+		}
+	case "file":
+		if arg == "" {
+			return "", "", fmt.Errorf("action sink file needs a path, e.g. file:/var/log/rescheduler/actions.log")
+		}
+	case "webhook":
+		if u, err := url.Parse(arg); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return "", "", fmt.Errorf("action sink webhook needs an http(s) URL, got %q", arg)
+		}
+	default:
+		return "", "", fmt.Errorf("unknown action sink %q, available: stdout, file:<path>, webhook:<url>, crd", spec)
+	}
+	return kind, arg, nil
+}
+
+// newActionSinks builds the sinks of <specs>, see parseActionSink.
+func newActionSinks(specs []string, client kube_client.Interface) ([]ActionSink, error) {
+	sinks := []ActionSink{}
+	for _, spec := range specs {
+		kind, arg, err := parseActionSink(spec)
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case "stdout":
+			sinks = append(sinks, &writerActionSink{name: "stdout", writer: os.Stdout})
+		case "file":
+			file, err := os.OpenFile(arg, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, &writerActionSink{name: "file", writer: file})
+		case "webhook":
+			sinks = append(sinks, &webhookActionSink{url: arg, client: &http.Client{Timeout: webhookTimeout}})
+		case "crd":
+			sinks = append(sinks, &crdActionSink{client: client})
+		}
+	}
+	return sinks, nil
+}
+
+// writerActionSink writes each action as a line of JSON.
+type writerActionSink struct {
+	name   string
+	writer io.Writer
+	mutex  sync.Mutex
+}
+
+func (s *writerActionSink) Name() string {
+	return s.name
+}
+
+func (s *writerActionSink) Record(a action) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, err = s.writer.Write(append(data, '\n'))
+	return err
+}
+
+// webhookActionSink POSTs each action as JSON to url.
+type webhookActionSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *webhookActionSink) Name() string {
+	return "webhook"
+}
+
+func (s *webhookActionSink) Record(a action) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	response, err := s.client.Post(s.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", s.url, response.Status)
+	}
+	return nil
+}
+
+// crdActionSink creates a ReschedulerAction object in the rescheduler's
+// namespace for each action, with the action as its spec.
+type crdActionSink struct {
+	client kube_client.Interface
+}
+
+func (s *crdActionSink) Name() string {
+	return "crd"
+}
+
+func (s *crdActionSink) Record(a action) error {
+	object := map[string]interface{}{
+		"apiVersion": actionGroup + "/" + actionVersion,
+		"kind":       actionKind,
+		"metadata":   map[string]interface{}{"generateName": a.Verb + "-"},
+		"spec":       a,
+	}
+	data, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("/apis/%s/%s/namespaces/%s/%s", actionGroup, actionVersion, ownNamespace(), actionResource)
+	return s.client.Discovery().RESTClient().Post().AbsPath(path).SetHeader("Content-Type", "application/json").Body(data).Do().Error()
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseActionSink(t *testing.T) {
+	for _, spec := range []string{"stdout", "crd", "file:/var/log/actions.log", "webhook:https://audit.example.com/actions"} {
+		_, _, err := parseActionSink(spec)
+		assert.NoError(t, err, spec)
+	}
+	for _, spec := range []string{"syslog", "file:", "stdout:actions", "webhook:audit.example.com"} {
+		_, _, err := parseActionSink(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestActionSinks(t *testing.T) {
+	var received []action
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var a action
+		assert.NoError(t, json.Unmarshal(body, &a))
+		received = append(received, a)
+		if a.Verb == actionRelease {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	sinks, err := newActionSinks([]string{"webhook:" + server.URL}, nil)
+	assert.NoError(t, err)
+	var buffer bytes.Buffer
+	actionSinks = append(sinks, &writerActionSink{name: "file", writer: &buffer})
+	defer func() { actionSinks = nil }()
+
+	recordAction(action{Verb: actionEvict, Node: "node1", Pod: "default/p1", CriticalPod: "kube-system/c1", DecisionID: "d1"})
+	recordAction(action{Verb: actionRelease, Node: "node1", CriticalPod: "kube-system/c1"})
+
+	assert.Len(t, received, 2)
+	assert.Equal(t, "default/p1", received[0].Pod)
+	assert.Equal(t, instanceID(), received[0].Instance)
+	assert.False(t, received[0].Time.IsZero())
+
+	lines := bytes.Split(bytes.TrimSpace(buffer.Bytes()), []byte("\n"))
+	assert.Len(t, lines, 2)
+	var a action
+	assert.NoError(t, json.Unmarshal(lines[1], &a))
+	assert.Equal(t, action{Time: a.Time, Verb: actionRelease, Node: "node1", CriticalPod: "kube-system/c1", Instance: instanceID()}, a)
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+	authentication "k8s.io/api/authentication/v1"
+	authorization "k8s.io/api/authorization/v1"
+	kube_client "k8s.io/client-go/kubernetes"
+)
+
+// adminMux holds the admin endpoints. When --admin-listen-address is set they
+// are served there behind delegatedAuth, otherwise on --listen-address.
+var adminMux = http.NewServeMux()
+
+// delegatedAuth authenticates requests with a bearer token via TokenReview and
+// authorizes them via SubjectAccessReview against the apiserver, as a
+// non-resource request for the URL path with the lowercased HTTP method as verb.
+// Granting access to the admin endpoints therefore is plain RBAC, e.g. a
+// ClusterRole with nonResourceURLs: ["/simulate"] and verbs: ["post"].
+type delegatedAuth struct {
+	client  kube_client.Interface
+	handler http.Handler
+}
+
+func (a *delegatedAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := bearerToken(r)
+	if token == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	review, err := a.client.AuthenticationV1().TokenReviews().Create(&authentication.TokenReview{
+		Spec: authentication.TokenReviewSpec{Token: token},
+	})
+	if err != nil {
+		glog.Warningf("Failed to review token for %s %s: %v", r.Method, r.URL.Path, err)
+		http.Error(w, "Authentication failed", http.StatusInternalServerError)
+		return
+	}
+	if !review.Status.Authenticated {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	user := review.Status.User
+	extra := map[string]authorization.ExtraValue{}
+	for key, value := range user.Extra {
+		extra[key] = authorization.ExtraValue(value)
+	}
+	access, err := a.client.AuthorizationV1().SubjectAccessReviews().Create(&authorization.SubjectAccessReview{
+		Spec: authorization.SubjectAccessReviewSpec{
+			User:   user.Username,
+			UID:    user.UID,
+			Groups: user.Groups,
+			Extra:  extra,
+			NonResourceAttributes: &authorization.NonResourceAttributes{
+				Path: r.URL.Path,
+				Verb: strings.ToLower(r.Method),
+			},
+		},
+	})
+	if err != nil {
+		glog.Warningf("Failed to authorize %s %s for %s: %v", r.Method, r.URL.Path, user.Username, err)
+		http.Error(w, "Authorization failed", http.StatusInternalServerError)
+		return
+	}
+	if !access.Status.Allowed {
+		glog.Infof("Denied %s %s for %s: %s", r.Method, r.URL.Path, user.Username, access.Status.Reason)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	glog.V(2).Infof("Admin request %s %s by %s", r.Method, r.URL.Path, user.Username)
+	a.handler.ServeHTTP(w, r)
+}
+
+func bearerToken(r *http.Request) string {
+	auth := strings.TrimSpace(r.Header.Get("Authorization"))
+	parts := strings.SplitN(auth, " ", 2)
+	if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
+		return ""
+	}
+	return strings.TrimSpace(parts[1])
+}
+
+// serveAdmin starts serving <adminMux> on --admin-listen-address over TLS in
+// the background, or registers it on the default mux if no admin address is set.
+func serveAdmin(ctx context.Context, client kube_client.Interface) {
+	if *adminListenAddress == "" {
+		http.Handle("/", adminMux)
+		return
+	}
+	cert, err := tls.LoadX509KeyPair(*adminTLSCertFile, *adminTLSKeyFile)
+	if err != nil {
+		glog.Fatalf("Failed to load admin serving certificate: %v", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	go serveHTTP(ctx, *adminListenAddress, &delegatedAuth{client: client, handler: adminMux}, tlsConfig, true, nil)
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	authentication "k8s.io/api/authentication/v1"
+	authorization "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+)
+
+func newAuthTestClient(tokens map[string]string, allowed map[string]bool) *fake.Clientset {
+	client := &fake.Clientset{}
+	client.AddReactor("create", "tokenreviews", func(action core.Action) (bool, runtime.Object, error) {
+		review := action.(core.CreateAction).GetObject().(*authentication.TokenReview)
+		if user, found := tokens[review.Spec.Token]; found {
+			review.Status.Authenticated = true
+			review.Status.User = authentication.UserInfo{Username: user}
+		}
+		return true, review, nil
+	})
+	client.AddReactor("create", "subjectaccessreviews", func(action core.Action) (bool, runtime.Object, error) {
+		review := action.(core.CreateAction).GetObject().(*authorization.SubjectAccessReview)
+		attributes := review.Spec.NonResourceAttributes
+		review.Status.Allowed = allowed[review.Spec.User+" "+attributes.Verb+" "+attributes.Path]
+		return true, review, nil
+	})
+	return client
+}
+
+func TestDelegatedAuth(t *testing.T) {
+	client := newAuthTestClient(
+		map[string]string{"admin-token": "admin", "viewer-token": "viewer"},
+		map[string]bool{"admin post /simulate": true})
+	handler := &delegatedAuth{
+		client: client,
+		handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}),
+	}
+
+	testCases := []struct {
+		header string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Basic YWRtaW46YWRtaW4=", http.StatusUnauthorized},
+		{"Bearer unknown-token", http.StatusUnauthorized},
+		{"Bearer viewer-token", http.StatusForbidden},
+		{"Bearer admin-token", http.StatusNoContent},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest(http.MethodPost, "/simulate", nil)
+		if tc.header != "" {
+			req.Header.Set("Authorization", tc.header)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, tc.status, w.Code, "Authorization: %q", tc.header)
+	}
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	"k8s.io/contrib/rescheduler/engine"
+	"k8s.io/contrib/rescheduler/metrics"
+)
+
+// maxSuccessfulNodes bounds the number of nodes successfulNodes remembers.
+const maxSuccessfulNodes = 1000
+
+// successfulNodes remembers the nodes critical pods were recently scheduled
+// on after a placement. They are always part of the sample a planning pass
+// falls back to once it is over --planning-budget, as they are the nodes most
+// likely to fit the next critical pod too.
+var successfulNodes = &nodeSet{nodes: map[string]time.Time{}}
+
+type nodeSet struct {
+	nodes map[string]time.Time
+	mutex sync.Mutex
+}
+
+// Add remembers <node> at <at>, dropping the node remembered longest ago if
+// the set is full.
+func (s *nodeSet) Add(node string, at time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, found := s.nodes[node]; !found && len(s.nodes) >= maxSuccessfulNodes {
+		oldest := ""
+		for name, added := range s.nodes {
+			if oldest == "" || added.Before(s.nodes[oldest]) {
+				oldest = name
+			}
+		}
+		delete(s.nodes, oldest)
+	}
+	s.nodes[node] = at
+}
+
+// Has returns true if <node> is remembered.
+func (s *nodeSet) Has(node string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, found := s.nodes[node]
+	return found
+}
+
+// Len returns the number of nodes remembered.
+func (s *nodeSet) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.nodes)
+}
+
+// findSnapshotWithinBudget is findSnapshotForPod which stops evaluating
+// <nodes> once the planning pass is over --planning-budget, so that a huge
+// cluster can't hold up housekeeping for minutes. The pod, and every pod
+// planned after it in the pass, is then planned on a sample of the nodes.
+func (r *rescheduler) findSnapshotWithinBudget(ctx context.Context, pass *planningPass, nodes []*v1.Node, pod *v1.Pod) *engine.NodeSnapshot {
+	if pass.deadline.IsZero() || len(nodes) <= *planningSampleSize {
+		return findSnapshotForPod(ctx, r.client, r.predicateChecker, pass.lists, nodes, pod)
+	}
+	if remaining := pass.deadline.Sub(r.clock.Now()); !pass.truncated && remaining > 0 {
+		budgetCtx, cancel := context.WithTimeout(ctx, remaining)
+		defer cancel()
+		snapshot := findSnapshotForPod(budgetCtx, r.client, r.predicateChecker, pass.lists, nodes, pod)
+		if budgetCtx.Err() == nil || ctx.Err() != nil {
+			return snapshot
+		}
+	}
+	if !pass.truncated {
+		glog.Warningf("Planning took longer than %v, planning critical pod %s and the remaining ones on at most %d of %d nodes plus those recently used.",
+			*planningBudget, podId(pod), *planningSampleSize, len(nodes))
+		metrics.PlanningTruncationsCount.Inc()
+		pass.truncated = true
+	}
+	metrics.SampledPlanningPodsCount.Inc()
+	return findSnapshotForPod(ctx, r.client, r.predicateChecker, pass.lists, sampleNodes(nodes, *planningSampleSize), pod)
+}
+
+// sampleNodes returns the nodes of <nodes> which are in successfulNodes and
+// up to <size> others picked at random, keeping their order.
+func sampleNodes(nodes []*v1.Node, size int) []*v1.Node {
+	picked := make([]bool, len(nodes))
+	for i, node := range nodes {
+		picked[i] = successfulNodes.Has(node.Name)
+	}
+	others := 0
+	for _, i := range rand.Perm(len(nodes)) {
+		if others >= size {
+			break
+		}
+		if !picked[i] {
+			picked[i] = true
+			others++
+		}
+	}
+	sample := []*v1.Node{}
+	for i, node := range nodes {
+		if picked[i] {
+			sample = append(sample, node)
+		}
+	}
+	return sample
+}
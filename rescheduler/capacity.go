@@ -0,0 +1,79 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/contrib/rescheduler/engine"
+	"k8s.io/contrib/rescheduler/metrics"
+)
+
+// exportCapacity sets the free capacity per zone and, for each of
+// <criticalPods>, the number of nodes it fits on without evictions, so that
+// capacity planners see placements are about to need disruption.
+func (r *rescheduler) exportCapacity(criticalPods []*v1.Pod) {
+	nodes, err := r.nodeLister.List()
+	if err != nil {
+		repeats.Errorf("list-nodes", "Failed to list nodes: %v", err)
+		return
+	}
+	podList, err := r.client.CoreV1().Pods(v1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		repeats.Errorf("list-all-pods", "Failed to list pods: %v", err)
+		return
+	}
+	podsByNode := map[string][]*v1.Pod{}
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if pod.Spec.NodeName != "" {
+			podsByNode[pod.Spec.NodeName] = append(podsByNode[pod.Spec.NodeName], pod)
+		}
+	}
+
+	freeCPU, freeMemory := map[string]int64{}, map[string]int64{}
+	fitting := map[string]int{}
+	for _, pod := range criticalPods {
+		fitting[podId(pod)] = 0
+	}
+	for _, node := range nodes {
+		snapshot := &engine.NodeSnapshot{Node: node, Pods: podsByNode[node.Name], CountTerminating: *countTerminatingPods}
+		zone := engine.NodeZone(node)
+		if zone == "" {
+			zone = "unknown"
+		}
+		cpu, memory := engine.FreeResources(snapshot)
+		freeCPU[zone] += cpu
+		freeMemory[zone] += memory
+		for _, pod := range criticalPods {
+			if engine.CheckPlatform(node, pod) == nil && engine.FitsWithoutEvictions(r.predicateChecker, snapshot, pod) == nil {
+				fitting[podId(pod)]++
+			}
+		}
+	}
+
+	metrics.ZoneFreeCPUCores.Reset()
+	metrics.ZoneFreeMemoryBytes.Reset()
+	for zone, cpu := range freeCPU {
+		metrics.ZoneFreeCPUCores.WithLabelValues(zone).Set(float64(cpu) / 1000)
+		metrics.ZoneFreeMemoryBytes.WithLabelValues(zone).Set(float64(freeMemory[zone]))
+	}
+	metrics.NodesWithoutEvictions.Reset()
+	for id, count := range fitting {
+		metrics.NodesWithoutEvictions.WithLabelValues(id).Set(float64(count))
+	}
+}
@@ -0,0 +1,385 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/contrib/rescheduler/metrics"
+)
+
+const (
+	// clusterLabel is added to the metrics of each cluster's rescheduler.
+	clusterLabel = "cluster"
+	// clusterMetricsPrefix names the metrics of the supervising process itself.
+	clusterMetricsPrefix = "rescheduler_cluster_"
+)
+
+var (
+	// clusterRestartInitialDelay and clusterRestartMaxDelay bound the
+	// exponential backoff between restarts of a cluster's rescheduler.
+	clusterRestartInitialDelay = time.Second
+	clusterRestartMaxDelay     = time.Minute
+	// clusterStopTimeout is how long a cluster's rescheduler gets to shut down
+	// gracefully before it is killed.
+	clusterStopTimeout = 30 * time.Second
+)
+
+// managedCluster is a cluster named by --cluster-contexts or
+// --cluster-kubeconfig-dir. Empty Kubeconfig and Context stand for the
+// default kubeconfig and its current context.
+type managedCluster struct {
+	Name       string
+	Kubeconfig string
+	Context    string
+}
+
+// managedClusters returns the clusters named by --cluster-contexts or, if it
+// is empty, by --cluster-kubeconfig-dir, ordered by name. validateFlags
+// ensures that only one of them is set.
+func managedClusters() ([]managedCluster, error) {
+	var clusters []managedCluster
+	var err error
+	if *clusterContexts != "" {
+		clusters, err = contextClusters()
+	} else {
+		clusters, err = kubeconfigDirClusters()
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].Name < clusters[j].Name })
+	for i := 1; i < len(clusters); i++ {
+		if clusters[i].Name == clusters[i-1].Name {
+			return nil, fmt.Errorf("cluster %q is named twice", clusters[i].Name)
+		}
+	}
+	return clusters, nil
+}
+
+// contextClusters returns a cluster for each context of --kubeconfig named
+// by --cluster-contexts.
+func contextClusters() ([]managedCluster, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = *kubeconfig
+	config, err := loadingRules.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig: %v", err)
+	}
+	names := strings.Split(*clusterContexts, ",")
+	if *clusterContexts == "*" {
+		if len(config.Contexts) == 0 {
+			return nil, fmt.Errorf("--cluster-contexts=* found no contexts in the kubeconfig")
+		}
+		names = []string{}
+		for name := range config.Contexts {
+			names = append(names, name)
+		}
+	}
+	clusters := []managedCluster{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if _, found := config.Contexts[name]; !found {
+			return nil, fmt.Errorf("context %q of --cluster-contexts not found in kubeconfig", name)
+		}
+		clusters = append(clusters, managedCluster{Name: name, Kubeconfig: *kubeconfig, Context: name})
+	}
+	return clusters, nil
+}
+
+// kubeconfigDirClusters returns a cluster for each kubeconfig in
+// --cluster-kubeconfig-dir, named after the file without its extension.
+func kubeconfigDirClusters() ([]managedCluster, error) {
+	files, err := ioutil.ReadDir(*clusterKubeconfigDir)
+	if err != nil {
+		return nil, err
+	}
+	clusters := []managedCluster{}
+	for _, file := range files {
+		// Secret and ConfigMap volumes keep their data in hidden directories
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		name := strings.TrimSuffix(file.Name(), filepath.Ext(file.Name()))
+		clusters = append(clusters, managedCluster{Name: name, Kubeconfig: filepath.Join(*clusterKubeconfigDir, file.Name())})
+	}
+	if len(clusters) == 0 {
+		return nil, fmt.Errorf("--cluster-kubeconfig-dir %s contains no kubeconfigs", *clusterKubeconfigDir)
+	}
+	return clusters, nil
+}
+
+// clusterArgs returns the arguments of the rescheduler of <cluster>: the
+// supervisor's own <args> followed by the flags which make it manage
+// <cluster> and serve its metrics on the unix socket <socket>. Later flags
+// override earlier ones.
+func clusterArgs(args []string, cluster managedCluster, socket string) []string {
+	return append(append([]string{}, args...),
+		"--cluster-contexts=",
+		"--cluster-kubeconfig-dir=",
+		"--running-in-cluster=false",
+		"--kubeconfig="+cluster.Kubeconfig,
+		"--kube-context="+cluster.Context,
+		"--listen-address="+unixSocketPrefix+socket,
+		"--listen-address-file=",
+		"--admin-listen-address=",
+		"--push-gateway-url=",
+	)
+}
+
+// superviseClusters runs a rescheduler process for each of <clusters> until
+// <ctx> is cancelled, restarting those which exit, and serves their metrics
+// on --listen-address with a cluster label. The clusters get a process each
+// because the state of a rescheduler, such as failed placements, eviction
+// budgets and disruption history, is kept per process and keyed by names of
+// pods and nodes which are the same in every cluster.
+func superviseClusters(ctx context.Context, clusters []managedCluster) {
+	executable, err := os.Executable()
+	if err != nil {
+		glog.Fatalf("Failed to find the rescheduler executable: %v", err)
+	}
+	dir, err := ioutil.TempDir("", "rescheduler-clusters")
+	if err != nil {
+		glog.Fatalf("Failed to create a directory for the metrics sockets: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	handler := &clusterMetricsHandler{clients: map[string]*http.Client{}}
+	var wg sync.WaitGroup
+	for i, cluster := range clusters {
+		socket := filepath.Join(dir, fmt.Sprintf("cluster-%d.sock", i))
+		handler.clients[cluster.Name] = newClusterMetricsClient(socket)
+		glog.Infof("Managing cluster %s", cluster.Name)
+		wg.Add(1)
+		go func(cluster managedCluster, args []string) {
+			defer wg.Done()
+			runCluster(ctx, cluster.Name, executable, args)
+		}(cluster, clusterArgs(os.Args[1:], cluster, socket))
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+	serveHTTP(ctx, *listenAddress, mux, nil, *metricsFailureFatal, publishListenAddress)
+	wg.Wait()
+}
+
+// runCluster runs <executable> with <args> as the rescheduler of cluster
+// <name> until <ctx> is cancelled, restarting it with backoff whenever it
+// exits. Its log lines are prefixed with the name of the cluster. It is
+// killed if the supervisor dies, so that a restarted supervisor doesn't run
+// a second rescheduler for the cluster next to an orphaned one.
+func runCluster(ctx context.Context, name, executable string, args []string) {
+	// Pdeathsig fires when the thread which started the process exits, not
+	// the supervisor, so that thread is kept for as long as the process runs.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	delay := clusterRestartInitialDelay
+	for {
+		started := time.Now()
+		cmd := exec.Command(executable, args...)
+		cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGKILL}
+		cmd.Stdout = &linePrefixWriter{prefix: "[" + name + "] ", out: os.Stdout}
+		cmd.Stderr = &linePrefixWriter{prefix: "[" + name + "] ", out: os.Stderr}
+		err := cmd.Start()
+		if err == nil {
+			err = waitForCluster(ctx, cmd)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > 10*clusterRestartMaxDelay {
+			delay = clusterRestartInitialDelay
+		}
+		glog.Errorf("Rescheduler of cluster %s exited, restarting in %v: %v", name, delay, err)
+		metrics.ClusterRestartsCount.WithLabelValues(name).Inc()
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		delay *= 2
+		if delay > clusterRestartMaxDelay {
+			delay = clusterRestartMaxDelay
+		}
+	}
+}
+
+// waitForCluster waits for the started <cmd> to exit. Once <ctx> is cancelled
+// it is sent SIGTERM, and killed if it doesn't exit within clusterStopTimeout.
+func waitForCluster(ctx context.Context, cmd *exec.Cmd) error {
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	select {
+	case err := <-exited:
+		return err
+	case <-ctx.Done():
+	}
+	cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case err := <-exited:
+		return err
+	case <-time.After(clusterStopTimeout):
+		cmd.Process.Kill()
+		return <-exited
+	}
+}
+
+// linePrefixWriter writes whole lines to out, each prefixed with prefix.
+type linePrefixWriter struct {
+	prefix  string
+	out     io.Writer
+	partial []byte
+}
+
+func (w *linePrefixWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		if _, err := io.WriteString(w.out, w.prefix+string(w.partial[:i+1])); err != nil {
+			return 0, err
+		}
+		w.partial = w.partial[i+1:]
+	}
+}
+
+// clusterMetricsHandler serves the metrics of the rescheduler of each
+// cluster, read with its client in clients (by cluster name), with a cluster
+// label, and the rescheduler_cluster_* metrics of the supervisor.
+type clusterMetricsHandler struct {
+	clients map[string]*http.Client
+}
+
+// newClusterMetricsClient returns a client for the metrics served on the unix
+// socket <socket>. It is kept for all scrapes, so that they reuse its
+// keep-alive connection instead of leaving a new one open each time.
+func newClusterMetricsClient(socket string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+			MaxIdleConnsPerHost: 1,
+		},
+		Timeout: 10 * time.Second,
+	}
+}
+
+func (h *clusterMetricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	families := map[string]*dto.MetricFamily{}
+	for name, client := range h.clients {
+		clusterFamilies, err := fetchClusterMetrics(r.Context(), client)
+		if err != nil {
+			repeats.Warningf("cluster-metrics/"+name, "Failed to read metrics of cluster %s: %v", name, err)
+			metrics.ClusterUp.WithLabelValues(name).Set(0)
+			continue
+		}
+		metrics.ClusterUp.WithLabelValues(name).Set(1)
+		mergeClusterMetrics(families, clusterFamilies, name)
+	}
+	own, err := metrics.Registry.Gather()
+	if err != nil {
+		glog.Warningf("Failed to gather metrics: %v", err)
+	}
+	for _, family := range own {
+		// the rescheduler metrics of the supervisor itself are all zero
+		if strings.HasPrefix(family.GetName(), clusterMetricsPrefix) {
+			families[family.GetName()] = family
+		}
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	w.Header().Set("Content-Type", string(expfmt.FmtText))
+	for _, name := range names {
+		if _, err := expfmt.MetricFamilyToText(w, families[name]); err != nil {
+			glog.Warningf("Failed to write metric %s: %v", name, err)
+			return
+		}
+	}
+}
+
+// fetchClusterMetrics reads the metrics of a cluster's rescheduler with <client>.
+func fetchClusterMetrics(ctx context.Context, client *http.Client) ([]*dto.MetricFamily, error) {
+	// the host is ignored, every request goes to the socket
+	request, err := http.NewRequest(http.MethodGet, "http://rescheduler/metrics", nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", response.Status)
+	}
+	families := []*dto.MetricFamily{}
+	decoder := expfmt.NewDecoder(response.Body, expfmt.ResponseFormat(response.Header))
+	for {
+		family := &dto.MetricFamily{}
+		if err := decoder.Decode(family); err == io.EOF {
+			return families, nil
+		} else if err != nil {
+			return nil, err
+		}
+		families = append(families, family)
+	}
+}
+
+// mergeClusterMetrics adds the metrics in <clusterFamilies> to <families>,
+// by family name, with the label cluster=<cluster>.
+func mergeClusterMetrics(families map[string]*dto.MetricFamily, clusterFamilies []*dto.MetricFamily, cluster string) {
+	for _, family := range clusterFamilies {
+		for _, metric := range family.Metric {
+			metric.Label = append(metric.Label, &dto.LabelPair{Name: proto.String(clusterLabel), Value: proto.String(cluster)})
+		}
+		if merged, found := families[family.GetName()]; found {
+			merged.Metric = append(merged.Metric, family.Metric...)
+		} else {
+			families[family.GetName()] = family
+		}
+	}
+}
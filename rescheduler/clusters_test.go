@@ -0,0 +1,206 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+func TestManagedClusters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rescheduler")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "kubeconfig")
+	config := clientcmdapi.NewConfig()
+	for _, name := range []string{"prod", "staging"} {
+		config.Clusters[name] = &clientcmdapi.Cluster{Server: "https://" + name}
+		config.AuthInfos[name] = &clientcmdapi.AuthInfo{Token: name}
+		config.Contexts[name] = &clientcmdapi.Context{Cluster: name, AuthInfo: name}
+	}
+	assert.NoError(t, clientcmd.WriteToFile(*config, path))
+
+	defer func(path, contexts string) {
+		*kubeconfig = path
+		*clusterContexts = contexts
+	}(*kubeconfig, *clusterContexts)
+	*kubeconfig = path
+
+	*clusterContexts = "staging, prod"
+	clusters, err := managedClusters()
+	assert.NoError(t, err)
+	assert.Equal(t, []managedCluster{
+		{Name: "prod", Kubeconfig: path, Context: "prod"},
+		{Name: "staging", Kubeconfig: path, Context: "staging"},
+	}, clusters)
+
+	*clusterContexts = "*"
+	all, err := managedClusters()
+	assert.NoError(t, err)
+	assert.Equal(t, clusters, all)
+
+	*clusterContexts = "prod,dev"
+	_, err = managedClusters()
+	assert.Error(t, err)
+
+	*clusterContexts = "prod,prod"
+	_, err = managedClusters()
+	assert.Error(t, err)
+
+	assert.NoError(t, clientcmd.WriteToFile(*clientcmdapi.NewConfig(), path))
+	*clusterContexts = "*"
+	_, err = managedClusters()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "--cluster-contexts")
+	}
+}
+
+func TestManagedClustersFromDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rescheduler")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"eu.yaml", "us", ".hidden"} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte{}, 0600))
+	}
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "..data"), 0700))
+
+	defer func(dir string) { *clusterKubeconfigDir = dir }(*clusterKubeconfigDir)
+	*clusterKubeconfigDir = dir
+	clusters, err := managedClusters()
+	assert.NoError(t, err)
+	assert.Equal(t, []managedCluster{
+		{Name: "eu", Kubeconfig: filepath.Join(dir, "eu.yaml")},
+		{Name: "us", Kubeconfig: filepath.Join(dir, "us")},
+	}, clusters)
+
+	empty, err := ioutil.TempDir("", "rescheduler")
+	assert.NoError(t, err)
+	defer os.RemoveAll(empty)
+	*clusterKubeconfigDir = empty
+	_, err = managedClusters()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "--cluster-kubeconfig-dir")
+	}
+}
+
+func TestClusterArgs(t *testing.T) {
+	cluster := managedCluster{Name: "prod", Kubeconfig: "/etc/kubeconfig", Context: "prod"}
+	args := []string{"--cluster-contexts=prod,staging", "--listen-address=:9235", "--housekeeping-interval=5s"}
+	childArgs := clusterArgs(args, cluster, "/tmp/cluster-0.sock")
+	assert.Equal(t, args, childArgs[:len(args)], "the supervisor's flags come first")
+
+	// pflag keeps the last value of a flag given several times
+	last := map[string]string{}
+	for _, arg := range childArgs {
+		if parts := strings.SplitN(strings.TrimPrefix(arg, "--"), "=", 2); len(parts) == 2 {
+			last[parts[0]] = parts[1]
+		}
+	}
+	assert.Equal(t, map[string]string{
+		"cluster-contexts":       "",
+		"cluster-kubeconfig-dir": "",
+		"running-in-cluster":     "false",
+		"kubeconfig":             "/etc/kubeconfig",
+		"kube-context":           "prod",
+		"listen-address":         "unix:/tmp/cluster-0.sock",
+		"listen-address-file":    "",
+		"admin-listen-address":   "",
+		"push-gateway-url":       "",
+		"housekeeping-interval":  "5s",
+	}, last)
+	for name := range last {
+		assert.NotNil(t, flags.Lookup(name), "--%s", name)
+	}
+}
+
+func TestMergeClusterMetrics(t *testing.T) {
+	family := func(value float64) *dto.MetricFamily {
+		return &dto.MetricFamily{
+			Name: proto.String("rescheduler_deleted_pods_total"),
+			Type: dto.MetricType_COUNTER.Enum(),
+			Metric: []*dto.Metric{
+				{Counter: &dto.Counter{Value: proto.Float64(value)}},
+			},
+		}
+	}
+	families := map[string]*dto.MetricFamily{}
+	mergeClusterMetrics(families, []*dto.MetricFamily{family(1)}, "prod")
+	mergeClusterMetrics(families, []*dto.MetricFamily{family(2)}, "staging")
+
+	merged := families["rescheduler_deleted_pods_total"]
+	if assert.NotNil(t, merged) && assert.Len(t, merged.Metric, 2) {
+		for i, cluster := range []string{"prod", "staging"} {
+			assert.Equal(t, clusterLabel, merged.Metric[i].Label[0].GetName())
+			assert.Equal(t, cluster, merged.Metric[i].Label[0].GetValue())
+			assert.Equal(t, float64(i+1), merged.Metric[i].Counter.GetValue())
+		}
+	}
+}
+
+func TestFetchClusterMetricsReusesConnection(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rescheduler")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "cluster-0.sock")
+	listener, err := net.Listen("unix", socket)
+	assert.NoError(t, err)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("rescheduler_deleted_pods_total 3\n"))
+	}))
+	server.Listener = listener
+	connections := int32(0)
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&connections, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	client := newClusterMetricsClient(socket)
+	for i := 0; i < 3; i++ {
+		families, err := fetchClusterMetrics(context.Background(), client)
+		if assert.NoError(t, err) && assert.Len(t, families, 1) {
+			assert.Equal(t, "rescheduler_deleted_pods_total", families[0].GetName())
+		}
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&connections), "scrapes share a connection")
+}
+
+func TestLinePrefixWriter(t *testing.T) {
+	out := &bytes.Buffer{}
+	w := &linePrefixWriter{prefix: "[prod] ", out: out}
+	w.Write([]byte("I1015 first"))
+	assert.Empty(t, out.String(), "partial lines are held back")
+	w.Write([]byte(" line\nI1015 second line\nI1015 th"))
+	assert.Equal(t, "[prod] I1015 first line\n[prod] I1015 second line\n", out.String())
+}
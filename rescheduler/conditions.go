@@ -0,0 +1,93 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kube_client "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// ReschedulerReservingCondition is the condition of a critical pod which is
+// True while a node is reserved for it, so that kubectl describe on the pod
+// shows what the rescheduler is doing.
+const ReschedulerReservingCondition v1.PodConditionType = "ReschedulerReserving"
+
+// Reasons of ReschedulerReservingCondition.
+const (
+	conditionReasonNodeReserved = "NodeReserved"
+	conditionReasonScheduled    = "Scheduled"
+	conditionReasonTimedOut     = "TimedOut"
+	conditionReasonAborted      = "Aborted"
+	conditionReasonNodeDeleted  = "NodeDeleted"
+)
+
+// setReservingCondition sets ReschedulerReservingCondition of <pod>. Failures
+// are only logged, the condition is informational.
+func setReservingCondition(client kube_client.Interface, pod *v1.Pod, status v1.ConditionStatus, reason, message string) {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		fresh, err := client.CoreV1().Pods(pod.Namespace).Get(pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if fresh == nil || fresh.UID != pod.UID {
+			// the pod was replaced, the placement isn't about this one
+			return nil
+		}
+		if !updatePodCondition(&fresh.Status, v1.PodCondition{
+			Type:    ReschedulerReservingCondition,
+			Status:  status,
+			Reason:  reason,
+			Message: message,
+		}) {
+			return nil
+		}
+		_, err = client.CoreV1().Pods(pod.Namespace).UpdateStatus(fresh)
+		return err
+	})
+	if err != nil {
+		repeats.Warningf("pod-condition/"+podId(pod), "Failed to set %s condition of pod %s: %v", ReschedulerReservingCondition, podId(pod), err)
+		return
+	}
+	glog.V(4).Infof("Set %s=%s (%s) on pod %s", ReschedulerReservingCondition, status, reason, podId(pod))
+}
+
+// updatePodCondition sets <condition> in <status>, keeping the transition
+// time if its status doesn't change. It returns false if nothing changed.
+func updatePodCondition(status *v1.PodStatus, condition v1.PodCondition) bool {
+	now := metav1.Now()
+	condition.LastProbeTime = now
+	condition.LastTransitionTime = now
+	for i := range status.Conditions {
+		existing := &status.Conditions[i]
+		if existing.Type != condition.Type {
+			continue
+		}
+		if existing.Status == condition.Status && existing.Reason == condition.Reason && existing.Message == condition.Message {
+			return false
+		}
+		if existing.Status == condition.Status {
+			condition.LastTransitionTime = existing.LastTransitionTime
+		}
+		*existing = condition
+		return true
+	}
+	status.Conditions = append(status.Conditions, condition)
+	return true
+}
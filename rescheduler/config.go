@@ -0,0 +1,438 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	kube_client "k8s.io/client-go/kubernetes"
+	kube_record "k8s.io/client-go/tools/record"
+	"k8s.io/contrib/rescheduler/engine"
+	"k8s.io/contrib/rescheduler/metrics"
+)
+
+// reschedulerConfig holds the settings which can be changed at runtime by
+// editing the file passed with --config. Fields which are not set in the file
+// keep the values given on the command line. Only these fields are reloadable:
+// other flags, such as --node-shard-selector which scopes the node watch, take
+// effect on startup, and the file is rejected if it sets anything else.
+type reschedulerConfig struct {
+	HousekeepingInterval metav1.Duration `json:"housekeepingInterval"`
+	PodScheduledTimeout  metav1.Duration `json:"podScheduledTimeout"`
+	GracePeriod          metav1.Duration `json:"gracePeriod"`
+	// ShadowMode replaces taints and evictions with WouldTaint/WouldDelete events.
+	ShadowMode bool `json:"shadowMode"`
+	// NodeAnnotationPolicies maps node annotations, e.g. the one set by
+	// maintenance tooling, to exclude, avoid, prefer or ignore. Entries in the
+	// file are added to the defaults.
+	NodeAnnotationPolicies engine.NodeAnnotationPolicies `json:"nodeAnnotationPolicies,omitempty"`
+	// PriorityClasses tunes the handling of critical pods by priority class
+	// name. Entries in the file replace the defaults for their class.
+	PriorityClasses map[string]priorityClassPolicy `json:"priorityClasses,omitempty"`
+	// MaintenanceWindows limits when evictions may happen, see maintenanceWindows.
+	MaintenanceWindows *maintenanceWindows `json:"maintenanceWindows,omitempty"`
+	// RequiredPods extends or relaxes which pods are never deleted to make
+	// room, see engine.RequiredPodRules.
+	RequiredPods *engine.RequiredPodRules `json:"requiredPods,omitempty"`
+	// EvictionBudget and EvictionBudgetWindow replace --eviction-budget and
+	// --eviction-budget-window. Evictions already in the window still count.
+	EvictionBudget       int             `json:"evictionBudget"`
+	EvictionBudgetWindow metav1.Duration `json:"evictionBudgetWindow"`
+	// CriticalPodSelector replaces --critical-pod-selector.
+	CriticalPodSelector string `json:"criticalPodSelector"`
+}
+
+// priorityClassPolicy is how critical pods of one priority class are handled.
+// Pods of classes without a policy get the zero policy.
+type priorityClassPolicy struct {
+	// Urgency orders critical pods within a housekeeping pass, highest first,
+	// so that they get the first pick of nodes.
+	Urgency int32 `json:"urgency"`
+	// MaxVictims caps the evictions of a single placement, 0 means no cap.
+	MaxVictims int `json:"maxVictims,omitempty"`
+	// RetryAfter is how long a pod whose placement failed or timed out waits
+	// before it is placed again. 0 retries in the next housekeeping pass.
+	RetryAfter metav1.Duration `json:"retryAfter,omitempty"`
+	// PodScheduledTimeout replaces podScheduledTimeout for the class, e.g. a
+	// short one for node-critical pods, which should move on to another node
+	// quickly. 0 keeps podScheduledTimeout.
+	PodScheduledTimeout metav1.Duration `json:"podScheduledTimeout,omitempty"`
+}
+
+// PodScheduledTimeoutAnnotationKey on a critical pod replaces the
+// podScheduledTimeout of its priority class, e.g. "20m" for a pod which
+// takes long to bind.
+const PodScheduledTimeoutAnnotationKey = "rescheduler.alpha.kubernetes.io/pod-scheduled-timeout"
+
+const (
+	// systemNodeCritical pods, e.g. the network plugin, keep their node from
+	// running anything else, so by default they go before systemClusterCritical ones.
+	systemNodeCritical    = "system-node-critical"
+	systemClusterCritical = "system-cluster-critical"
+)
+
+// priorityClassPolicyOf returns the policy for the priority class of <pod>.
+func priorityClassPolicyOf(pod *v1.Pod) priorityClassPolicy {
+	return currentConfig().PriorityClasses[pod.Spec.PriorityClassName]
+}
+
+// podScheduledTimeoutOf returns how long to wait for <pod> to be scheduled
+// once its node is prepared: from its annotation, its priority class or the
+// configuration, in that order. Invalid annotations are ignored.
+func podScheduledTimeoutOf(pod *v1.Pod) time.Duration {
+	config := currentConfig()
+	if value, found := pod.Annotations[PodScheduledTimeoutAnnotationKey]; found {
+		timeout, err := time.ParseDuration(value)
+		if err == nil && timeout > config.GracePeriod.Duration {
+			return timeout
+		}
+		repeats.Warningf("pod-scheduled-timeout/"+podId(pod), "Ignoring invalid %s annotation %q on pod %s, it must be a duration longer than gracePeriod (%v)",
+			PodScheduledTimeoutAnnotationKey, value, podId(pod), config.GracePeriod.Duration)
+	}
+	if timeout := config.PriorityClasses[pod.Spec.PriorityClassName].PodScheduledTimeout.Duration; timeout > 0 {
+		return timeout
+	}
+	return config.PodScheduledTimeout.Duration
+}
+
+// configFromFlags returns the configuration built only from command line flags.
+func configFromFlags() reschedulerConfig {
+	return reschedulerConfig{
+		HousekeepingInterval: metav1.Duration{Duration: *housekeepingInterval},
+		PodScheduledTimeout:  metav1.Duration{Duration: *podScheduledTimeout},
+		GracePeriod:          metav1.Duration{Duration: *gracePeriod},
+		ShadowMode:           *shadowMode,
+		NodeAnnotationPolicies: engine.NodeAnnotationPolicies{
+			engine.ScaleDownDisabledAnnotation: engine.AnnotationPolicyPrefer,
+		},
+		PriorityClasses: map[string]priorityClassPolicy{
+			systemNodeCritical:    {Urgency: 2},
+			systemClusterCritical: {Urgency: 1},
+		},
+		EvictionBudget:       *evictionBudgetSize,
+		EvictionBudgetWindow: metav1.Duration{Duration: *evictionBudgetWindow},
+		CriticalPodSelector:  *criticalPodSelector,
+	}
+}
+
+// loadConfig reads the config file at path on top of the flag values.
+func loadConfig(path string) (reschedulerConfig, error) {
+	config := configFromFlags()
+	if path == "" {
+		return config, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("failed to read config file %s: %v", path, err)
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+	if err := checkConfigKeys(data); err != nil {
+		return config, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	if err := config.validate(); err != nil {
+		return config, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// checkConfigKeys returns an error if the config file <data> sets anything
+// but the fields of reschedulerConfig, which yaml.Unmarshal would ignore:
+// a reload would otherwise leave the setting unchanged without saying so.
+func checkConfigKeys(data []byte) error {
+	settings := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return err
+	}
+	known := map[string]bool{}
+	configType := reflect.TypeOf(reschedulerConfig{})
+	for i := 0; i < configType.NumField(); i++ {
+		known[strings.Split(configType.Field(i).Tag.Get("json"), ",")[0]] = true
+	}
+	unknown := []string{}
+	for key := range settings {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("%s can't be set in the config file, only on the command line", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+func (c *reschedulerConfig) validate() error {
+	if c.HousekeepingInterval.Duration <= 0 {
+		return fmt.Errorf("housekeepingInterval must be positive, got %v", c.HousekeepingInterval.Duration)
+	}
+	if c.PodScheduledTimeout.Duration <= 0 {
+		return fmt.Errorf("podScheduledTimeout must be positive, got %v", c.PodScheduledTimeout.Duration)
+	}
+	if c.EvictionBudget < 0 {
+		return fmt.Errorf("evictionBudget must not be negative, got %d", c.EvictionBudget)
+	}
+	if c.EvictionBudgetWindow.Duration <= 0 {
+		return fmt.Errorf("evictionBudgetWindow must be positive, got %v", c.EvictionBudgetWindow.Duration)
+	}
+	if _, err := labels.Parse(c.CriticalPodSelector); err != nil {
+		return fmt.Errorf("criticalPodSelector: %v", err)
+	}
+	if c.PodScheduledTimeout.Duration <= c.GracePeriod.Duration {
+		return fmt.Errorf("podScheduledTimeout (%v) must be longer than gracePeriod (%v), otherwise placements time out before victims terminate",
+			c.PodScheduledTimeout.Duration, c.GracePeriod.Duration)
+	}
+	for key, policy := range c.NodeAnnotationPolicies {
+		switch policy {
+		case engine.AnnotationPolicyExclude, engine.AnnotationPolicyAvoid, engine.AnnotationPolicyPrefer, engine.AnnotationPolicyIgnore:
+		default:
+			return fmt.Errorf("nodeAnnotationPolicies: unknown policy %q for %s", policy, key)
+		}
+	}
+	if c.MaintenanceWindows != nil {
+		if err := c.MaintenanceWindows.validate(); err != nil {
+			return fmt.Errorf("maintenanceWindows: %v", err)
+		}
+	}
+	if c.RequiredPods != nil {
+		if err := c.RequiredPods.Validate(); err != nil {
+			return fmt.Errorf("requiredPods: %v", err)
+		}
+	}
+	for name, policy := range c.PriorityClasses {
+		if policy.MaxVictims < 0 {
+			return fmt.Errorf("priorityClasses: maxVictims of %s must not be negative, got %d", name, policy.MaxVictims)
+		}
+		if policy.RetryAfter.Duration < 0 {
+			return fmt.Errorf("priorityClasses: retryAfter of %s must not be negative, got %v", name, policy.RetryAfter.Duration)
+		}
+		if timeout := policy.PodScheduledTimeout.Duration; timeout != 0 && timeout <= c.GracePeriod.Duration {
+			return fmt.Errorf("priorityClasses: podScheduledTimeout of %s (%v) must be longer than gracePeriod (%v)", name, timeout, c.GracePeriod.Duration)
+		}
+	}
+	return nil
+}
+
+// Thread safe holder of the currently applied configuration.
+type configHolder struct {
+	config *reschedulerConfig
+	mutex  sync.RWMutex
+}
+
+var activeConfig = &configHolder{}
+
+// currentConfig returns the configuration which should be used right now.
+func currentConfig() reschedulerConfig {
+	return activeConfig.Get()
+}
+
+// Get returns a copy of the current configuration. Until the first Set it
+// returns the configuration built from flags.
+func (h *configHolder) Get() reschedulerConfig {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	if h.config == nil {
+		return configFromFlags()
+	}
+	return *h.config
+}
+
+// Set replaces the current configuration.
+func (h *configHolder) Set(config reschedulerConfig) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.config = &config
+}
+
+// ownNamespace returns the namespace the rescheduler runs in, falling back to
+// the system namespace when POD_NAMESPACE is not set.
+func ownNamespace() string {
+	if namespace := os.Getenv("POD_NAMESPACE"); namespace != "" {
+		return namespace
+	}
+	return *systemNamespace
+}
+
+// selfReference returns a reference to the rescheduler's own pod, based on
+// POD_NAME and POD_NAMESPACE set via the downward API, or nil if they are unset.
+func selfReference() *v1.ObjectReference {
+	name, namespace := os.Getenv("POD_NAME"), os.Getenv("POD_NAMESPACE")
+	if name == "" || namespace == "" {
+		return nil
+	}
+	return &v1.ObjectReference{
+		Kind:      "Pod",
+		Name:      name,
+		Namespace: namespace,
+	}
+}
+
+// instanceID identifies this rescheduler replica in events and logs. It is
+// the pod name set via the downward API as POD_NAME, or the hostname, which
+// is the pod name too unless the pod uses the host network.
+func instanceID() string {
+	if name := os.Getenv("POD_NAME"); name != "" {
+		return name
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		return hostname
+	}
+	return "unknown"
+}
+
+// recordEffectiveConfig writes the resolved configuration, together with all
+// flag values, into a ConfigMap in the rescheduler's namespace, so that it is
+// visible what a running instance is actually using.
+func recordEffectiveConfig(client kube_client.Interface, config reschedulerConfig) error {
+	if *effectiveConfigMap == "" {
+		return nil
+	}
+	configYaml, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	flagValues := &bytes.Buffer{}
+	dumpFlags(flagValues)
+
+	configMap := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      *effectiveConfigMap,
+			Namespace: ownNamespace(),
+		},
+		Data: map[string]string{
+			"config.yaml": string(configYaml),
+			"flags":       flagValues.String(),
+			"configFile":  *configFile,
+			"updated":     time.Now().UTC().Format(time.RFC3339),
+		},
+	}
+	if pod := os.Getenv("POD_NAME"); pod != "" {
+		configMap.Data["pod"] = pod
+	}
+	if node := os.Getenv("NODE_NAME"); node != "" {
+		configMap.Data["node"] = node
+	}
+
+	configMaps := client.CoreV1().ConfigMaps(configMap.Namespace)
+	existing, err := configMaps.Get(configMap.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = configMaps.Create(configMap)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	existing.Data = configMap.Data
+	_, err = configMaps.Update(existing)
+	return err
+}
+
+// applyConfig makes config the active configuration and records it.
+func applyConfig(client kube_client.Interface, config reschedulerConfig) {
+	activeConfig.Set(config)
+	if err := recordEffectiveConfig(client, config); err != nil {
+		glog.Warningf("Failed to record effective configuration in ConfigMap %s/%s: %v", ownNamespace(), *effectiveConfigMap, err)
+	}
+}
+
+// reloadConfig loads the config file and applies it if it is valid. An invalid
+// config is rejected and the last good one stays in use. If force is false and
+// the file didn't change nothing is reported.
+func reloadConfig(path string, client kube_client.Interface, recorder kube_record.EventRecorder, force bool) {
+	config, err := loadConfig(path)
+	self := selfReference()
+	if err != nil {
+		glog.Errorf("Rejecting new configuration, keeping the previous one: %v", err)
+		metrics.ConfigReloadsCount.WithLabelValues("failure").Inc()
+		if self != nil {
+			recorder.Eventf(self, v1.EventTypeWarning, EventReasonConfigReloadFailed,
+				"Rejected configuration from %s: %v", path, err)
+		}
+		return
+	}
+	if !force && reflect.DeepEqual(config, activeConfig.Get()) {
+		return
+	}
+	applyConfig(client, config)
+	glog.Infof("Applied configuration from %s: %+v", path, config)
+	metrics.ConfigReloadsCount.WithLabelValues("success").Inc()
+	metrics.ConfigLastReloadSuccessTimestamp.Set(float64(time.Now().Unix()))
+	if self != nil {
+		recorder.Eventf(self, v1.EventTypeNormal, EventReasonConfigReloaded,
+			"Applied configuration from %s.", path)
+	}
+}
+
+// watchConfig reloads the config file whenever it changes or the process
+// receives SIGHUP. The parent directory is watched rather than the file itself,
+// so that atomic replacements (as done for mounted ConfigMaps) are noticed.
+func watchConfig(path string, client kube_client.Interface, recorder kube_record.EventRecorder, stopChannel <-chan struct{}) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		glog.Errorf("Failed to watch config file %s, only SIGHUP will trigger reload: %v", path, err)
+	} else if err := watcher.Add(filepath.Dir(path)); err != nil {
+		glog.Errorf("Failed to watch config file %s, only SIGHUP will trigger reload: %v", path, err)
+		watcher.Close()
+		watcher = nil
+	}
+
+	var fileEvents <-chan fsnotify.Event
+	var fileErrors <-chan error
+	if watcher != nil {
+		defer watcher.Close()
+		fileEvents = watcher.Events
+		fileErrors = watcher.Errors
+	}
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	for {
+		select {
+		case <-hangup:
+			glog.Infof("Received SIGHUP, reloading configuration")
+			reloadConfig(path, client, recorder, true)
+		case event := <-fileEvents:
+			glog.V(4).Infof("Config directory event: %v", event)
+			reloadConfig(path, client, recorder, false)
+		case err := <-fileErrors:
+			glog.Warningf("Error while watching config file %s: %v", path, err)
+		case <-stopChannel:
+			return
+		}
+	}
+}
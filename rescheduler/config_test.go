@@ -0,0 +1,242 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	kube_record "k8s.io/client-go/tools/record"
+	"k8s.io/contrib/rescheduler/engine"
+)
+
+func writeTestConfig(t *testing.T, dir, content string) string {
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rescheduler-config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config, err := loadConfig("")
+	assert.NoError(t, err)
+	assert.Equal(t, configFromFlags(), config)
+
+	path := writeTestConfig(t, dir, "housekeepingInterval: 30s\n")
+	config, err = loadConfig(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Second, config.HousekeepingInterval.Duration)
+	assert.Equal(t, *podScheduledTimeout, config.PodScheduledTimeout.Duration)
+
+	path = writeTestConfig(t, dir, "housekeepingInterval: -1s\n")
+	_, err = loadConfig(path)
+	assert.Error(t, err)
+
+	path = writeTestConfig(t, dir, "housekeepingInterval: [\n")
+	_, err = loadConfig(path)
+	assert.Error(t, err)
+
+	path = writeTestConfig(t, dir, "evictionBudget: 5\nevictionBudgetWindow: 30m\ncriticalPodSelector: k8s-app=kube-dns\n")
+	config, err = loadConfig(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, config.EvictionBudget)
+	assert.Equal(t, 30*time.Minute, config.EvictionBudgetWindow.Duration)
+	assert.Equal(t, "k8s-app=kube-dns", config.CriticalPodSelector)
+
+	path = writeTestConfig(t, dir, "criticalPodSelector: k8s-app in (kube-dns\n")
+	_, err = loadConfig(path)
+	assert.Error(t, err)
+
+	// settings which are only read on startup are rejected rather than ignored
+	path = writeTestConfig(t, dir, "housekeepingInterval: 30s\nnodeShardSelector: zone=a\n")
+	_, err = loadConfig(path)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "nodeShardSelector")
+	}
+}
+
+func TestReloadConfigKeepsLastGood(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rescheduler-config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer activeConfig.Set(configFromFlags())
+
+	os.Setenv("POD_NAME", "rescheduler")
+	os.Setenv("POD_NAMESPACE", "kube-system")
+	defer os.Unsetenv("POD_NAME")
+	defer os.Unsetenv("POD_NAMESPACE")
+	*effectiveConfigMap = "rescheduler-effective-config"
+	defer func() { *effectiveConfigMap = "" }()
+
+	client := fake.NewSimpleClientset()
+	recorder := kube_record.NewFakeRecorder(10)
+	path := writeTestConfig(t, dir, "podScheduledTimeout: 1m\n")
+	reloadConfig(path, client, recorder, false)
+	assert.Equal(t, time.Minute, currentConfig().PodScheduledTimeout.Duration)
+	assert.Contains(t, <-recorder.Events, "ConfigReloaded")
+	configMap, err := client.CoreV1().ConfigMaps("kube-system").Get(*effectiveConfigMap, metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Contains(t, configMap.Data["config.yaml"], "podScheduledTimeout: 1m0s")
+	assert.Contains(t, configMap.Data["flags"], "--pod-scheduled-timeout=10m0s")
+	assert.Equal(t, "rescheduler", configMap.Data["pod"])
+
+	writeTestConfig(t, dir, "podScheduledTimeout: 0s\n")
+	reloadConfig(path, client, recorder, false)
+	assert.Equal(t, time.Minute, currentConfig().PodScheduledTimeout.Duration)
+	assert.Contains(t, <-recorder.Events, "ConfigReloadFailed")
+
+	writeTestConfig(t, dir, "podScheduledTimeout: 1m\n")
+	reloadConfig(path, client, recorder, false)
+	assert.Equal(t, 0, len(recorder.Events))
+}
+
+func TestLoadConfigNodeAnnotationPolicies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rescheduler-config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := writeTestConfig(t, dir, "nodeAnnotationPolicies:\n  ops.example.com/maintenance: exclude\n")
+	config, err := loadConfig(path)
+	assert.NoError(t, err)
+	assert.Equal(t, engine.NodeAnnotationPolicies{
+		engine.ScaleDownDisabledAnnotation: engine.AnnotationPolicyPrefer,
+		"ops.example.com/maintenance":      engine.AnnotationPolicyExclude,
+	}, config.NodeAnnotationPolicies)
+
+	path = writeTestConfig(t, dir, "nodeAnnotationPolicies:\n  ops.example.com/maintenance: drain\n")
+	_, err = loadConfig(path)
+	assert.Error(t, err)
+}
+
+func TestLoadConfigPriorityClasses(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rescheduler-config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := writeTestConfig(t, dir, "priorityClasses:\n  system-cluster-critical:\n    urgency: 1\n    maxVictims: 3\n    retryAfter: 5m\n")
+	config, err := loadConfig(path)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]priorityClassPolicy{
+		systemNodeCritical:    {Urgency: 2},
+		systemClusterCritical: {Urgency: 1, MaxVictims: 3, RetryAfter: metav1.Duration{Duration: 5 * time.Minute}},
+	}, config.PriorityClasses)
+
+	path = writeTestConfig(t, dir, "priorityClasses:\n  system-cluster-critical:\n    maxVictims: -1\n")
+	_, err = loadConfig(path)
+	assert.Error(t, err)
+
+	path = writeTestConfig(t, dir, "gracePeriod: 1m\npriorityClasses:\n  system-node-critical:\n    podScheduledTimeout: 30s\n")
+	_, err = loadConfig(path)
+	assert.Error(t, err)
+}
+
+func TestPodScheduledTimeoutOf(t *testing.T) {
+	config := configFromFlags()
+	config.PriorityClasses[systemNodeCritical] = priorityClassPolicy{Urgency: 2, PodScheduledTimeout: metav1.Duration{Duration: 2 * time.Minute}}
+	activeConfig.Set(config)
+	defer activeConfig.Set(configFromFlags())
+
+	pod := createTestPod("pod", "kube-system", true, true, 100)
+	assert.Equal(t, config.PodScheduledTimeout.Duration, podScheduledTimeoutOf(pod))
+	pod.Spec.PriorityClassName = systemNodeCritical
+	assert.Equal(t, 2*time.Minute, podScheduledTimeoutOf(pod))
+	pod.Annotations[PodScheduledTimeoutAnnotationKey] = "20m"
+	assert.Equal(t, 20*time.Minute, podScheduledTimeoutOf(pod))
+	pod.Annotations[PodScheduledTimeoutAnnotationKey] = "soon"
+	assert.Equal(t, 2*time.Minute, podScheduledTimeoutOf(pod))
+}
+
+func TestMaintenanceWindows(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rescheduler-config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := writeTestConfig(t, dir, `maintenanceWindows:
+  mode: allow
+  windows:
+  - days: [Sat, Sun]
+    start: "00:00"
+    end: "23:59"
+  - days: [Mon, Tue, Wed, Thu, Fri]
+    start: "22:00"
+    end: "06:00"
+  exemptPriorityClasses: [system-node-critical]
+`)
+	config, err := loadConfig(path)
+	assert.NoError(t, err)
+	windows := config.MaintenanceWindows
+	pod := createTestPod("critical", "kube-system", true, true, 100)
+	at := func(value string) time.Time {
+		t, _ := time.Parse(time.RFC3339, value)
+		return t
+	}
+
+	// 2026-10-14 is a Wednesday.
+	assert.False(t, windows.EvictionsAllowed(pod, at("2026-10-14T12:00:00Z")))
+	assert.True(t, windows.EvictionsAllowed(pod, at("2026-10-14T23:00:00Z")))
+	assert.True(t, windows.EvictionsAllowed(pod, at("2026-10-15T05:59:00Z")))
+	assert.True(t, windows.EvictionsAllowed(pod, at("2026-10-17T12:00:00Z")))
+	// Early Monday belongs to Sunday night, which has no overnight window.
+	assert.False(t, windows.EvictionsAllowed(pod, at("2026-10-19T05:00:00Z")))
+	pod.Spec.PriorityClassName = systemNodeCritical
+	assert.True(t, windows.EvictionsAllowed(pod, at("2026-10-14T12:00:00Z")))
+
+	windows.Mode = "forbid"
+	pod.Spec.PriorityClassName = ""
+	assert.True(t, windows.EvictionsAllowed(pod, at("2026-10-14T12:00:00Z")))
+	assert.False(t, windows.EvictionsAllowed(pod, at("2026-10-14T23:00:00Z")))
+	assert.True(t, (*maintenanceWindows)(nil).EvictionsAllowed(pod, at("2026-10-14T12:00:00Z")))
+
+	path = writeTestConfig(t, dir, "maintenanceWindows:\n  mode: allow\n  windows:\n  - start: \"9am\"\n    end: \"17:00\"\n")
+	_, err = loadConfig(path)
+	assert.Error(t, err)
+}
+
+func TestRequiredPodsConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rescheduler-config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := writeTestConfig(t, dir, `requiredPods:
+  required:
+  - ownerKinds: [StatefulSet]
+  deletable:
+  - namespaces: [logging]
+    selector:
+      matchLabels:
+        app: fluentd
+`)
+	config, err := loadConfig(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"StatefulSet"}, config.RequiredPods.Required[0].OwnerKinds)
+	assert.Equal(t, "fluentd", config.RequiredPods.Deletable[0].Selector.MatchLabels["app"])
+
+	path = writeTestConfig(t, dir, "requiredPods:\n  deletable:\n  - {}\n")
+	_, err = loadConfig(path)
+	assert.Error(t, err)
+}
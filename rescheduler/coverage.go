@@ -0,0 +1,215 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/contrib/rescheduler/engine"
+	"k8s.io/contrib/rescheduler/metrics"
+)
+
+// Reasons why a node wanted by a critical DaemonSet lacks a running pod.
+const (
+	// coverageNoPod: the DaemonSet controller hasn't created the pod.
+	coverageNoPod = "no_pod"
+	// coverageUnschedulable: the pod is pending and no placement was tried yet.
+	coverageUnschedulable = "unschedulable"
+	// coveragePendingReservation: a node is reserved and the pod waits for it.
+	coveragePendingReservation = "pending_reservation"
+	// coverageNoFeasibleNode: the last plan found no node for the pod.
+	coverageNoFeasibleNode = "no_feasible_node"
+	// coverageStarting: the pod is scheduled but not running yet.
+	coverageStarting = "starting"
+)
+
+// coverageReportKey is the key of the report in --coverage-configmap.
+const coverageReportKey = "coverage.json"
+
+// daemonSetCoverage tells how many of the nodes a critical DaemonSet should
+// run on lack a running pod, and why.
+type daemonSetCoverage struct {
+	DaemonSet string         `json:"daemonSet"`
+	Desired   int32          `json:"desired"`
+	Running   int32          `json:"running"`
+	Missing   map[string]int `json:"missing,omitempty"`
+}
+
+// unplaceablePods holds the critical pods which the last plan found no node for.
+var unplaceablePods = &podIdSet{ids: sets.String{}}
+
+type podIdSet struct {
+	ids   sets.String
+	mutex sync.Mutex
+}
+
+// Set replaces the pods in the set with those in <unplaceable>.
+func (s *podIdSet) Set(unplaceable []*engine.Unplaceable) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.ids = sets.String{}
+	for _, u := range unplaceable {
+		s.ids.Insert(podId(u.Pod))
+	}
+}
+
+func (s *podIdSet) Has(pod *v1.Pod) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.ids.Has(podId(pod))
+}
+
+// reportCoverage reports the coverage of critical DaemonSets every
+// --coverage-report-interval until <ctx> is cancelled.
+func (r *rescheduler) reportCoverage(ctx context.Context) {
+	for {
+		select {
+		case <-r.clock.After(*coverageReportInterval):
+			r.coverageReport()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// coverageReport exports the coverage of critical DaemonSets in the system
+// namespace as metrics and, with --coverage-configmap, in a ConfigMap.
+func (r *rescheduler) coverageReport() []daemonSetCoverage {
+	daemonSets, err := r.client.AppsV1().DaemonSets(*systemNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		repeats.Warningf("list-daemonsets", "Failed to list DaemonSets: %v", err)
+		return nil
+	}
+	report := []daemonSetCoverage{}
+	for i := range daemonSets.Items {
+		ds := &daemonSets.Items[i]
+		if !isCriticalDaemonSet(ds) {
+			continue
+		}
+		coverage, err := r.daemonSetCoverage(ds)
+		if err != nil {
+			repeats.Warningf("list-pods/"+ds.Name, "Failed to list pods of DaemonSet %s/%s: %v", ds.Namespace, ds.Name, err)
+			continue
+		}
+		report = append(report, coverage)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].DaemonSet < report[j].DaemonSet })
+
+	metrics.DaemonSetMissingPods.Reset()
+	for _, coverage := range report {
+		for reason, count := range coverage.Missing {
+			metrics.DaemonSetMissingPods.WithLabelValues(coverage.DaemonSet, reason).Set(float64(count))
+		}
+	}
+	if *coverageConfigMap != "" {
+		if err := r.saveCoverageReport(report); err != nil {
+			repeats.Warningf("save-coverage-report", "Failed to save coverage report to ConfigMap %s/%s: %v", ownNamespace(), *coverageConfigMap, err)
+		}
+	}
+	return report
+}
+
+// isCriticalDaemonSet returns true if the pods of <ds> are critical.
+func isCriticalDaemonSet(ds *appsv1.DaemonSet) bool {
+	template := ds.Spec.Template
+	switch template.Spec.PriorityClassName {
+	case systemNodeCritical, systemClusterCritical:
+		return ds.Namespace == metav1.NamespaceSystem
+	}
+	pod := &v1.Pod{ObjectMeta: template.ObjectMeta, Spec: template.Spec}
+	pod.Namespace = ds.Namespace
+	return engine.IsCriticalPod(pod)
+}
+
+// daemonSetCoverage counts the pods of <ds> which aren't running, by reason.
+func (r *rescheduler) daemonSetCoverage(ds *appsv1.DaemonSet) (daemonSetCoverage, error) {
+	coverage := daemonSetCoverage{
+		DaemonSet: ds.Name,
+		Desired:   ds.Status.DesiredNumberScheduled,
+		Missing:   map[string]int{},
+	}
+	selector, err := metav1.LabelSelectorAsSelector(ds.Spec.Selector)
+	if err != nil {
+		return coverage, err
+	}
+	podList, err := r.client.CoreV1().Pods(ds.Namespace).List(metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return coverage, err
+	}
+	var pods int32
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if controller := metav1.GetControllerOf(pod); controller == nil || controller.UID != ds.UID || engine.IsTerminal(pod) {
+			continue
+		}
+		pods++
+		switch {
+		case pod.Status.Phase == v1.PodRunning:
+			coverage.Running++
+		case pod.Spec.NodeName != "":
+			coverage.Missing[coverageStarting]++
+		case r.podsBeingProcessed.Has(pod):
+			coverage.Missing[coveragePendingReservation]++
+		case unplaceablePods.Has(pod):
+			coverage.Missing[coverageNoFeasibleNode]++
+		default:
+			coverage.Missing[coverageUnschedulable]++
+		}
+	}
+	if pods < coverage.Desired {
+		coverage.Missing[coverageNoPod] = int(coverage.Desired - pods)
+	}
+	return coverage, nil
+}
+
+// saveCoverageReport writes <report> to --coverage-configmap.
+func (r *rescheduler) saveCoverageReport(report []daemonSetCoverage) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	configMap := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: *coverageConfigMap, Namespace: ownNamespace()},
+		Data: map[string]string{
+			coverageReportKey: string(data),
+			"updated":         r.clock.Now().UTC().Format(time.RFC3339),
+		},
+	}
+	configMaps := r.client.CoreV1().ConfigMaps(configMap.Namespace)
+	existing, err := configMaps.Get(configMap.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		glog.V(2).Infof("Creating coverage report ConfigMap %s/%s", configMap.Namespace, configMap.Name)
+		_, err = configMaps.Create(configMap)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	existing.Data = configMap.Data
+	_, err = configMaps.Update(existing)
+	return err
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	kube_restclient "k8s.io/client-go/rest"
+)
+
+// serviceAccountTokenFile is where the service account token is mounted in
+// every container, and refreshed by the kubelet for projected tokens.
+const serviceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/" + v1.ServiceAccountTokenKey
+
+// rotatingToken is a bearer token which is reloaded at most once per refresh
+// interval, or right away once the API server rejected it, so that rotated
+// service account tokens and kubeconfig credentials are picked up without a
+// restart.
+type rotatingToken struct {
+	load     func() (string, error)
+	refresh  time.Duration
+	now      func() time.Time
+	token    string
+	loadedAt time.Time
+	mutex    sync.Mutex
+}
+
+// Token returns the current token. If reloading fails the previous token is
+// kept.
+func (t *rotatingToken) Token() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.token != "" && t.now().Sub(t.loadedAt) < t.refresh {
+		return t.token
+	}
+	token, err := t.load()
+	if err != nil {
+		repeats.Warningf("reload-credentials", "Failed to reload API credentials, using the previous ones: %v", err)
+		return t.token
+	}
+	if t.token != "" && token != t.token {
+		glog.Infof("API credentials rotated")
+	}
+	t.token, t.loadedAt = token, t.now()
+	return t.token
+}
+
+// Expire makes the next Token call reload the token.
+func (t *rotatingToken) Expire() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.loadedAt = time.Time{}
+}
+
+type bearerRoundTripper struct {
+	token *rotatingToken
+	rt    http.RoundTripper
+}
+
+func (rt *bearerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") != "" {
+		return rt.rt.RoundTrip(req)
+	}
+	req = utilnet.CloneRequest(req)
+	req.Header.Set("Authorization", "Bearer "+rt.token.Token())
+	resp, err := rt.rt.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		rt.token.Expire()
+	}
+	return resp, err
+}
+
+// withRotatingToken makes <config> send the token returned by <load>,
+// reloading it every <refresh>, instead of the token it was created with.
+func withRotatingToken(config *kube_restclient.Config, load func() (string, error), refresh time.Duration) {
+	token := &rotatingToken{load: load, refresh: refresh, now: time.Now, token: config.BearerToken, loadedAt: time.Now()}
+	config.BearerToken = ""
+	wrap := config.WrapTransport
+	config.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		if wrap != nil {
+			rt = wrap(rt)
+		}
+		return &bearerRoundTripper{token: token, rt: rt}
+	}
+}
+
+// withProxy makes <config> connect to the apiserver through <proxy>.
+func withProxy(config *kube_restclient.Config, proxy *url.URL) {
+	wrap := config.WrapTransport
+	config.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		if transport, ok := rt.(*http.Transport); ok {
+			transport = transport.Clone()
+			transport.Proxy = http.ProxyURL(proxy)
+			rt = transport
+		} else {
+			glog.Warningf("Can't use --api-proxy-url with transport %T", rt)
+		}
+		if wrap != nil {
+			rt = wrap(rt)
+		}
+		return rt
+	}
+}
+
+// readTokenFile returns the token stored in <path>.
+func readTokenFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", path)
+	}
+	return token, nil
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	kube_restclient "k8s.io/client-go/rest"
+)
+
+func TestRotatingToken(t *testing.T) {
+	now := time.Now()
+	tokens := []string{"first", "second"}
+	loads := 0
+	config := &kube_restclient.Config{BearerToken: "initial"}
+	withRotatingToken(config, func() (string, error) {
+		token := tokens[loads]
+		loads++
+		return token, nil
+	}, time.Minute)
+
+	seen := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token := req.Header.Get("Authorization")
+		seen = append(seen, token)
+		if token == "Bearer first" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: config.WrapTransport(http.DefaultTransport)}
+	get := func() {
+		resp, err := client.Get(server.URL)
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+		}
+	}
+
+	assert.Equal(t, "", config.BearerToken)
+	get()
+	token := client.Transport.(*bearerRoundTripper).token
+	token.now = func() time.Time { return now.Add(2 * time.Minute) }
+	get() // reloads "first", which is rejected
+	get() // reloads "second" right away
+	get()
+	assert.Equal(t, []string{"Bearer initial", "Bearer first", "Bearer second", "Bearer second"}, seen)
+	assert.Equal(t, 2, loads)
+}
+
+func TestWithProxy(t *testing.T) {
+	proxied := []string{}
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		proxied = append(proxied, req.URL.String())
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	assert.NoError(t, err)
+
+	config := &kube_restclient.Config{}
+	withProxy(config, proxyURL)
+	client := &http.Client{Transport: config.WrapTransport(&http.Transport{})}
+	resp, err := client.Get("http://apiserver.example:8080/api")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+	assert.Equal(t, []string{"http://apiserver.example:8080/api"}, proxied)
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	"k8s.io/contrib/rescheduler/engine"
+	"k8s.io/contrib/rescheduler/metrics"
+)
+
+// decisionRecord is what /debug/decisions reports about a single decision.
+type decisionRecord struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+	Pod  string    `json:"pod"`
+	// Owner is the top-level controller of the pod, as in "Deployment/kube-dns".
+	Owner string `json:"owner,omitempty"`
+	// CandidateNodes is the number of nodes considered.
+	CandidateNodes int      `json:"candidateNodes"`
+	Node           string   `json:"node,omitempty"`
+	Victims        []string `json:"victims,omitempty"`
+	// Path is "no_evictions" or "evictions", see placementPath.
+	Path   string `json:"path,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	// Outcome is "planned" until the decision is carried out, and then one of
+	// the outcomes of rescheduler_placements_total, "shadow" or "skipped".
+	Outcome string `json:"outcome"`
+}
+
+// decisionLog keeps the last decisions in a ring buffer.
+type decisionLog struct {
+	mutex   sync.Mutex
+	records []*decisionRecord
+	next    int
+}
+
+// decisions is empty and records nothing unless --debug-decisions is set.
+var decisions = newDecisionLog(0)
+
+func newDecisionLog(size int) *decisionLog {
+	return &decisionLog{records: make([]*decisionRecord, size)}
+}
+
+// AddPlacement records a planned placement.
+func (l *decisionLog) AddPlacement(placement *engine.Placement, candidateNodes int) {
+	victims := []string{}
+	for _, victim := range placement.Victims {
+		victims = append(victims, podId(victim))
+	}
+	l.add(&decisionRecord{
+		ID:             placement.DecisionID,
+		Pod:            podId(placement.Pod),
+		Owner:          owners.TopOwner(placement.Pod).String(),
+		CandidateNodes: candidateNodes,
+		Node:           placement.Node.Name,
+		Victims:        victims,
+		Path:           placementPath(placement),
+		Outcome:        "planned",
+	})
+}
+
+// AddUnplaceable records a critical pod for which no node was found.
+func (l *decisionLog) AddUnplaceable(unplaceable *engine.Unplaceable, candidateNodes int) {
+	l.add(&decisionRecord{
+		ID:             unplaceable.DecisionID,
+		Pod:            podId(unplaceable.Pod),
+		Owner:          owners.TopOwner(unplaceable.Pod).String(),
+		CandidateNodes: candidateNodes,
+		Reason:         unplaceable.Reason,
+		Outcome:        "no_node",
+	})
+}
+
+func (l *decisionLog) add(record *decisionRecord) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if len(l.records) == 0 {
+		return
+	}
+	record.Time = time.Now()
+	l.records[l.next] = record
+	l.next = (l.next + 1) % len(l.records)
+}
+
+// SetOutcome updates the outcome of decision <id> if it is still in the log.
+func (l *decisionLog) SetOutcome(id, outcome string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, record := range l.records {
+		if record != nil && record.ID == id {
+			record.Outcome = outcome
+			return
+		}
+	}
+}
+
+// List returns copies of the recorded decisions, newest first.
+func (l *decisionLog) List() []decisionRecord {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	result := []decisionRecord{}
+	for i := 1; i <= len(l.records); i++ {
+		record := l.records[(l.next-i+len(l.records))%len(l.records)]
+		if record == nil {
+			break
+		}
+		result = append(result, *record)
+	}
+	return result
+}
+
+// ServeHTTP serves the recorded decisions as JSON.
+func (l *decisionLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(l.List()); err != nil {
+		glog.Warningf("Failed to write decisions: %v", err)
+	}
+}
+
+// recordOutcome counts the outcome of a placement and records it in the decision log.
+func recordOutcome(pod *v1.Pod, decisionID, outcome string) {
+	metrics.PlacementsCount.WithLabelValues(outcome, k8sApp(pod)).Inc()
+	decisions.SetOutcome(decisionID, outcome)
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/api/core/v1"
+	"k8s.io/contrib/rescheduler/engine"
+)
+
+func TestDecisionLog(t *testing.T) {
+	log := newDecisionLog(2)
+	node := createTestNode("node1", 1000)
+	for i := 0; i < 3; i++ {
+		log.AddPlacement(&engine.Placement{
+			Pod:        createTestPod(fmt.Sprintf("critical%d", i), "kube-system", true, true, 100),
+			Node:       node,
+			Victims:    []*v1.Pod{createTestPod("victim", "default", false, false, 100)},
+			DecisionID: fmt.Sprintf("d%d", i),
+		}, 5)
+	}
+	log.SetOutcome("d1", "success")
+	log.SetOutcome("d0", "timeout")
+
+	records := log.List()
+	assert.Equal(t, 2, len(records))
+	assert.Equal(t, "d2", records[0].ID)
+	assert.Equal(t, "planned", records[0].Outcome)
+	assert.Equal(t, "d1", records[1].ID)
+	assert.Equal(t, "success", records[1].Outcome)
+	assert.Equal(t, "node1", records[1].Node)
+	assert.Equal(t, []string{"default_victim"}, records[1].Victims)
+	assert.Equal(t, "evictions", records[1].Path)
+	assert.Equal(t, 5, records[1].CandidateNodes)
+
+	w := httptest.NewRecorder()
+	log.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/decisions", nil))
+	served := []decisionRecord{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &served))
+	assert.Equal(t, 2, len(served))
+
+	disabled := newDecisionLog(0)
+	disabled.AddUnplaceable(&engine.Unplaceable{Pod: createTestPod("critical", "kube-system", true, true, 100)}, 1)
+	assert.Equal(t, 0, len(disabled.List()))
+}
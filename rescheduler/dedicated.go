@@ -0,0 +1,184 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"sort"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/contrib/rescheduler/metrics"
+)
+
+// dedicatedTaintValue is the value of the CriticalAddonsOnly taint on nodes
+// kept for critical addons by --dedicated-addon-nodes. Reservation taints
+// carry the ID of their critical pod instead.
+const dedicatedTaintValue = "dedicated"
+
+func dedicatedTaint() v1.Taint {
+	return v1.Taint{
+		Key:    criticalAddonsOnlyTaintKey,
+		Value:  dedicatedTaintValue,
+		Effect: v1.TaintEffectNoSchedule,
+	}
+}
+
+// dedicatedSince returns when <node> was dedicated to critical addons by this
+// rescheduler, or nil if it isn't.
+func dedicatedSince(node *v1.Node) *metav1.Time {
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == criticalAddonsOnlyTaintKey && taint.Value == dedicatedTaintValue && ownsTaint(node, taint) {
+			if taint.TimeAdded == nil {
+				return &metav1.Time{}
+			}
+			return taint.TimeAdded
+		}
+	}
+	return nil
+}
+
+// maintainDedicatedNodes keeps --dedicated-addon-nodes nodes tainted for
+// critical addons. Nodes which are ready, not reserved and not excluded by
+// the annotation policies are dedicated in candidateNodes order, and surplus
+// ones are released. With --dedicated-addon-nodes-rotation the longest
+// dedicated node is released once its replacement has been dedicated.
+func (r *rescheduler) maintainDedicatedNodes(ctx context.Context) {
+	nodes, err := r.nodeLister.List()
+	if err != nil {
+		repeats.Errorf("list-nodes", "Failed to list nodes: %v", err)
+		return
+	}
+	dedicated := []*v1.Node{}
+	others := []*v1.Node{}
+	for _, node := range nodes {
+		if dedicatedSince(node) != nil {
+			dedicated = append(dedicated, node)
+		} else {
+			others = append(others, node)
+		}
+	}
+	sort.SliceStable(dedicated, func(i, j int) bool {
+		return dedicatedSince(dedicated[i]).Before(dedicatedSince(dedicated[j]))
+	})
+
+	want := *dedicatedAddonNodes
+	for len(dedicated) > want {
+		r.releaseDedicatedNode(dedicated[len(dedicated)-1])
+		dedicated = dedicated[:len(dedicated)-1]
+	}
+	var rotated *v1.Node
+	if rotation := *dedicatedAddonNodesRotation; rotation > 0 && len(dedicated) == want && want > 0 &&
+		r.clock.Since(dedicatedSince(dedicated[0]).Time) >= rotation {
+		rotated = dedicated[0]
+		dedicated = dedicated[1:]
+	}
+
+	count := len(dedicated)
+	for _, node := range candidateNodes(others, &v1.Pod{}) {
+		if count >= want || ctx.Err() != nil {
+			break
+		}
+		if err := checkReservation(node); err != nil {
+			continue
+		}
+		if r.dedicateNode(node) {
+			count++
+		}
+	}
+	if rotated != nil {
+		if count < want {
+			// there is no replacement, keep the node
+			count++
+		} else {
+			glog.Infof("Rotated node %v, dedicated to critical addons for more than %v", rotated.Name, *dedicatedAddonNodesRotation)
+			r.releaseDedicatedNode(rotated)
+		}
+	}
+	if count < want {
+		repeats.Warningf("dedicated-nodes", "Only %d of %d nodes could be dedicated to critical addons", count, want)
+	}
+}
+
+// dedicateNode adds the dedicated taint to <node> and returns true on success.
+func (r *rescheduler) dedicateNode(node *v1.Node) bool {
+	if inShadowMode() {
+		glog.Infof("Shadow mode: would dedicate node %v to critical addons", node.Name)
+		metrics.ShadowActionsCount.WithLabelValues("taint").Inc()
+		return true
+	}
+	taint := dedicatedTaint()
+	now := metav1.NewTime(r.clock.Now())
+	taint.TimeAdded = &now
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		fresh, err := r.client.CoreV1().Nodes().Get(node.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if err := checkReservation(fresh); err != nil {
+			return err
+		}
+		return addTaint(r.client, fresh, taint, nil)
+	})
+	if err != nil {
+		glog.Warningf("Failed to dedicate node %v to critical addons: %v", node.Name, err)
+		return false
+	}
+	glog.Infof("Dedicated node %v to critical addons", node.Name)
+	r.recorder.Eventf(node, v1.EventTypeNormal, EventReasonDedicatedNode, "Node %s dedicated to critical addons.", node.Name)
+	recordAction(action{Verb: actionTaint, Node: node.Name})
+	return true
+}
+
+// releaseDedicatedNode removes the dedicated taint from <node>.
+func (r *rescheduler) releaseDedicatedNode(node *v1.Node) {
+	if inShadowMode() {
+		glog.Infof("Shadow mode: would release node %v dedicated to critical addons", node.Name)
+		return
+	}
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		fresh, err := r.client.CoreV1().Nodes().Get(node.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		taints := []v1.Taint{}
+		released := []v1.Taint{}
+		for _, taint := range fresh.Spec.Taints {
+			if taint.Key == criticalAddonsOnlyTaintKey && taint.Value == dedicatedTaintValue && ownsTaint(fresh, taint) {
+				released = append(released, taint)
+			} else {
+				taints = append(taints, taint)
+			}
+		}
+		if len(released) == 0 {
+			return nil
+		}
+		fresh.Spec.Taints = taints
+		disownTaints(fresh, released)
+		_, err = r.client.CoreV1().Nodes().Update(fresh)
+		return err
+	})
+	if err != nil {
+		glog.Warningf("Failed to release node %v dedicated to critical addons: %v", node.Name, err)
+		return
+	}
+	glog.Infof("Released node %v dedicated to critical addons", node.Name)
+	r.recorder.Eventf(node, v1.EventTypeNormal, EventReasonDedicatedNodeReleased, "Node %s no longer dedicated to critical addons.", node.Name)
+	recordAction(action{Verb: actionRelease, Node: node.Name})
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kube_client "k8s.io/client-go/kubernetes"
+)
+
+// disruptionHistoryKey is the key holding the history in --disruption-history-configmap.
+const disruptionHistoryKey = "history"
+
+// disruptions remembers which controllers recently lost pods to placements,
+// so that their pods are avoided as victims for --disruption-history-window.
+var disruptions = &disruptionHistory{evictions: map[string][]time.Time{}}
+
+type disruptionHistory struct {
+	mutex sync.Mutex
+	// evictions maps controller keys to the times their pods were evicted, oldest first.
+	evictions map[string][]time.Time
+}
+
+// controllerKeyOf identifies the controller of <pod>, or returns "" if it has none.
+func controllerKeyOf(pod *v1.Pod) string {
+	if ref := metav1.GetControllerOf(pod); ref != nil {
+		return pod.Namespace + "/" + ref.Kind + "/" + ref.Name
+	}
+	return ""
+}
+
+// Record adds the evictions of <victims> at <at>.
+func (h *disruptionHistory) Record(victims []*v1.Pod, at time.Time) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for _, victim := range victims {
+		if key := controllerKeyOf(victim); key != "" {
+			h.evictions[key] = append(h.evictions[key], at)
+		}
+	}
+	h.prune(at)
+}
+
+// RecentlyDisrupted returns true if the controller of <pod> lost a pod within
+// the window before <now>.
+func (h *disruptionHistory) RecentlyDisrupted(pod *v1.Pod, now time.Time) bool {
+	key := controllerKeyOf(pod)
+	if key == "" {
+		return false
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	times := h.evictions[key]
+	return len(times) > 0 && now.Sub(times[len(times)-1]) < *disruptionHistoryWindow
+}
+
+// Empty returns true if nothing was disrupted within the window before <now>.
+func (h *disruptionHistory) Empty(now time.Time) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.prune(now)
+	return len(h.evictions) == 0
+}
+
+// Len returns the number of controllers in the history.
+func (h *disruptionHistory) Len() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return len(h.evictions)
+}
+
+// prune drops evictions older than the window. The caller must hold the mutex.
+func (h *disruptionHistory) prune(now time.Time) {
+	for key, times := range h.evictions {
+		recent := times[:0]
+		for _, t := range times {
+			if now.Sub(t) < *disruptionHistoryWindow {
+				recent = append(recent, t)
+			}
+		}
+		if len(recent) == 0 {
+			delete(h.evictions, key)
+		} else {
+			h.evictions[key] = recent
+		}
+	}
+}
+
+// Load reads the history from --disruption-history-configmap, if set, so
+// that it survives restarts. A missing ConfigMap is an empty history.
+func (h *disruptionHistory) Load(client kube_client.Interface) error {
+	if *disruptionHistoryConfigMap == "" {
+		return nil
+	}
+	configMap, err := client.CoreV1().ConfigMaps(ownNamespace()).Get(*disruptionHistoryConfigMap, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	evictions := map[string][]time.Time{}
+	if data, found := configMap.Data[disruptionHistoryKey]; found {
+		if err := json.Unmarshal([]byte(data), &evictions); err != nil {
+			return err
+		}
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.evictions = evictions
+	h.prune(time.Now())
+	return nil
+}
+
+// Save writes the history to --disruption-history-configmap, if set.
+func (h *disruptionHistory) Save(client kube_client.Interface) {
+	if *disruptionHistoryConfigMap == "" {
+		return
+	}
+	h.mutex.Lock()
+	data, err := json.Marshal(h.evictions)
+	h.mutex.Unlock()
+	if err != nil {
+		glog.Warningf("Failed to encode disruption history: %v", err)
+		return
+	}
+
+	configMaps := client.CoreV1().ConfigMaps(ownNamespace())
+	existing, err := configMaps.Get(*disruptionHistoryConfigMap, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = configMaps.Create(&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: *disruptionHistoryConfigMap, Namespace: ownNamespace()},
+			Data:       map[string]string{disruptionHistoryKey: string(data)},
+		})
+	} else if err == nil {
+		if existing.Data == nil {
+			existing.Data = map[string]string{}
+		}
+		existing.Data[disruptionHistoryKey] = string(data)
+		_, err = configMaps.Update(existing)
+	}
+	if err != nil {
+		repeats.Warningf("save-disruption-history", "Failed to save disruption history to ConfigMap %s/%s: %v", ownNamespace(), *disruptionHistoryConfigMap, err)
+	}
+}
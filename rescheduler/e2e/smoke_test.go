@@ -0,0 +1,177 @@
+//go:build e2e
+// +build e2e
+
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package e2e holds the smoke test run against a real cluster by
+// hack/kind-smoke.sh, see "make test-e2e-kind".
+package e2e
+
+import (
+	"flag"
+	"os"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	kube_client "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var (
+	kubeconfig = flag.String("kubeconfig", os.Getenv("KUBECONFIG"), "Kubeconfig of the cluster the rescheduler runs against.")
+	timeout    = flag.Duration("timeout", 5*time.Minute, "How long to wait for the critical DaemonSet pod.")
+)
+
+const (
+	hogName      = "rescheduler-smoke-hog"
+	criticalName = "rescheduler-smoke-critical"
+	// criticalCPU is what the critical DaemonSet pod requests, in millicores.
+	criticalCPU = 200
+)
+
+// TestCriticalDaemonSetPodIsScheduled fills the only node with a Deployment,
+// so that a critical DaemonSet pod doesn't fit, and expects the rescheduler
+// to make room for it.
+func TestCriticalDaemonSetPodIsScheduled(t *testing.T) {
+	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+	if err != nil {
+		t.Fatalf("Failed to load kubeconfig: %v", err)
+	}
+	client := kube_client.NewForConfigOrDie(config)
+	node := onlyNode(t, client)
+
+	free := freeMilliCPU(t, client, node)
+	if free <= criticalCPU {
+		t.Fatalf("Node %s has only %dm CPU free, the critical pod needs %dm", node.Name, free, criticalCPU)
+	}
+	// leave less than the critical pod needs
+	hog := hogDeployment(free - criticalCPU/2)
+	if _, err := client.AppsV1().Deployments(metav1.NamespaceDefault).Create(hog); err != nil {
+		t.Fatalf("Failed to create %s: %v", hogName, err)
+	}
+	defer client.AppsV1().Deployments(metav1.NamespaceDefault).Delete(hogName, &metav1.DeleteOptions{})
+	waitFor(t, "the hog to run", func() (bool, error) {
+		d, err := client.AppsV1().Deployments(metav1.NamespaceDefault).Get(hogName, metav1.GetOptions{})
+		return err == nil && d.Status.AvailableReplicas == 1, nil
+	})
+
+	if _, err := client.AppsV1().DaemonSets(metav1.NamespaceSystem).Create(criticalDaemonSet()); err != nil {
+		t.Fatalf("Failed to create %s: %v", criticalName, err)
+	}
+	defer client.AppsV1().DaemonSets(metav1.NamespaceSystem).Delete(criticalName, &metav1.DeleteOptions{})
+	waitFor(t, "the critical DaemonSet pod to be scheduled", func() (bool, error) {
+		pods, err := client.CoreV1().Pods(metav1.NamespaceSystem).List(metav1.ListOptions{LabelSelector: "app=" + criticalName})
+		if err != nil {
+			return false, nil
+		}
+		for _, pod := range pods.Items {
+			if pod.Spec.NodeName == node.Name {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+}
+
+func onlyNode(t *testing.T, client kube_client.Interface) *v1.Node {
+	nodes, err := client.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Failed to list nodes: %v", err)
+	}
+	if len(nodes.Items) != 1 {
+		t.Fatalf("The smoke test needs a single node cluster, got %d nodes", len(nodes.Items))
+	}
+	return &nodes.Items[0]
+}
+
+// freeMilliCPU returns the CPU of <node> not requested by the pods on it.
+func freeMilliCPU(t *testing.T, client kube_client.Interface, node *v1.Node) int64 {
+	pods, err := client.CoreV1().Pods(metav1.NamespaceAll).List(metav1.ListOptions{FieldSelector: "spec.nodeName=" + node.Name})
+	if err != nil {
+		t.Fatalf("Failed to list pods: %v", err)
+	}
+	free := node.Status.Allocatable.Cpu().MilliValue()
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		for _, container := range pod.Spec.Containers {
+			free -= container.Resources.Requests.Cpu().MilliValue()
+		}
+	}
+	return free
+}
+
+func hogDeployment(milliCPU int64) *appsv1.Deployment {
+	replicas := int32(1)
+	labels := map[string]string{"app": hogName}
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: hogName, Namespace: metav1.NamespaceDefault},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec:       pauseSpec(milliCPU),
+			},
+		},
+	}
+}
+
+// criticalDaemonSet is critical by annotation only, so that the scheduler
+// doesn't preempt the hog itself.
+func criticalDaemonSet() *appsv1.DaemonSet {
+	labels := map[string]string{"app": criticalName}
+	spec := pauseSpec(criticalCPU)
+	spec.Tolerations = []v1.Toleration{{Key: "CriticalAddonsOnly", Operator: v1.TolerationOpExists}}
+	return &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Name: criticalName, Namespace: metav1.NamespaceSystem},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      labels,
+					Annotations: map[string]string{"scheduler.alpha.kubernetes.io/critical-pod": ""},
+				},
+				Spec: spec,
+			},
+		},
+	}
+}
+
+func pauseSpec(milliCPU int64) v1.PodSpec {
+	return v1.PodSpec{
+		Containers: []v1.Container{{
+			Name:  "pause",
+			Image: "k8s.gcr.io/pause:3.1",
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{v1.ResourceCPU: *resource.NewMilliQuantity(milliCPU, resource.DecimalSI)},
+			},
+		}},
+	}
+}
+
+func waitFor(t *testing.T, what string, condition wait.ConditionFunc) {
+	if err := wait.PollImmediate(2*time.Second, *timeout, condition); err != nil {
+		t.Fatalf("Timed out waiting for %s: %v", what, err)
+	}
+}
@@ -0,0 +1,320 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package engine contains the rescheduler's decision logic. Functions in this
+// package never talk to the API server: they take snapshots of nodes and the
+// pods running on them and return what should be done.
+package engine
+
+import (
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	ca_simulator "k8s.io/autoscaler/cluster-autoscaler/simulator"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/kubernetes/pkg/scheduler/algorithm/predicates"
+	"k8s.io/kubernetes/pkg/scheduler/schedulercache"
+	hashutil "k8s.io/kubernetes/pkg/util/hash"
+)
+
+// NodeSnapshot is a node together with all pods bound to it.
+type NodeSnapshot struct {
+	Node *v1.Node
+	Pods []*v1.Pod
+	// ClassifyVictim, if set, decides how pods which could be deleted are treated.
+	ClassifyVictim func(pod *v1.Pod) VictimClass
+	// RequiredPods, if set, changes which pods can't be deleted, see GroupPodsWithRules.
+	RequiredPods *RequiredPodRules
+	// CountTerminating makes pods which are being deleted occupy the node
+	// until they are gone. Otherwise they are ignored. Either way they are
+	// never victims. Succeeded and failed pods are always ignored.
+	CountTerminating bool
+	// Taken is when Pods were listed.
+	Taken time.Time
+
+	// victimClasses caches the results of ClassifyVictim, which may call the
+	// API server, so that reusing the snapshot doesn't classify pods again.
+	victimClasses map[*v1.Pod]VictimClass
+}
+
+// VictimClass says how a pod which could be deleted is treated when choosing victims.
+type VictimClass int
+
+const (
+	// VictimAllowed pods are deleted if they don't fit next to the critical pod.
+	VictimAllowed VictimClass = iota
+	// VictimAvoided pods are kept in preference to allowed ones: they're only
+	// deleted if the critical pod doesn't fit even without all allowed pods.
+	VictimAvoided
+	// VictimProtected pods are never deleted, like DaemonSet and mirror pods.
+	VictimProtected
+)
+
+// group divides the pods into those which can't be deleted and the others,
+// taking ClassifyVictim into account. The others are split into avoided and
+// allowed pods, in the order in which they should be kept.
+func (s *NodeSnapshot) group() ([]*v1.Pod, [][]*v1.Pod) {
+	pods := make([]*v1.Pod, 0, len(s.Pods))
+	terminating := []*v1.Pod{}
+	for _, pod := range s.Pods {
+		switch {
+		case IsTerminal(pod):
+		case pod.DeletionTimestamp != nil:
+			terminating = append(terminating, pod)
+		default:
+			pods = append(pods, pod)
+		}
+	}
+	requiredPods, otherPods := GroupPodsWithRules(pods, s.RequiredPods)
+	if s.CountTerminating {
+		requiredPods = append(requiredPods, terminating...)
+	}
+	if s.ClassifyVictim == nil {
+		return requiredPods, [][]*v1.Pod{otherPods}
+	}
+	avoided, allowed := []*v1.Pod{}, []*v1.Pod{}
+	for _, pod := range otherPods {
+		switch s.classifyVictim(pod) {
+		case VictimProtected:
+			requiredPods = append(requiredPods, pod)
+		case VictimAvoided:
+			avoided = append(avoided, pod)
+		default:
+			allowed = append(allowed, pod)
+		}
+	}
+	return requiredPods, [][]*v1.Pod{avoided, allowed}
+}
+
+func (s *NodeSnapshot) classifyVictim(pod *v1.Pod) VictimClass {
+	if class, found := s.victimClasses[pod]; found {
+		return class
+	}
+	if s.victimClasses == nil {
+		s.victimClasses = map[*v1.Pod]VictimClass{}
+	}
+	class := s.ClassifyVictim(pod)
+	s.victimClasses[pod] = class
+	return class
+}
+
+// Placement is the decision to reserve Node for the critical Pod. Node has to
+// be tainted with Taint and Victims deleted so that Pod fits there.
+type Placement struct {
+	Pod  *v1.Pod
+	Node *v1.Node
+	// Taint is the reservation taint, see ReservationTaint. It is set by the caller.
+	Taint   v1.Taint
+	Victims []*v1.Pod
+	// DecisionID identifies this placement in events and logs. It is set by the caller.
+	DecisionID string
+	// Snapshot is the state of Node the placement was planned on.
+	Snapshot *NodeSnapshot
+}
+
+// CheckNode returns nil if <pod> fits on the node once all pods which can be
+// deleted are gone. Outside tests the predicate checker runs the scheduler's
+// default predicates, which include NoVolumeZoneConflict and the per-cloud
+// volume count limits (MaxEBSVolumeCount, MaxGCEPDVolumeCount,
+// MaxAzureDiskVolumeCount), so zonal PVCs and attach limits are respected.
+func CheckNode(predicateChecker *ca_simulator.PredicateChecker, snapshot *NodeSnapshot, pod *v1.Pod) error {
+	requiredPods, _ := snapshot.group()
+	if err := CheckHostPorts(requiredPods, pod); err != nil {
+		return err
+	}
+	nodeInfo := schedulercache.NewNodeInfo(requiredPods...)
+	nodeInfo.SetNode(snapshot.Node)
+	return predicateChecker.CheckPredicates(pod, nil, nodeInfo, true)
+}
+
+// FitFingerprint hashes what CheckNode looks at: the spec of <pod>, the
+// labels, taints and allocatable resources of the node, and the identity and
+// resourceVersion of the pods which can't be deleted from it. While it stays
+// the same, CheckNode returns the same result.
+func FitFingerprint(snapshot *NodeSnapshot, pod *v1.Pod) uint64 {
+	requiredPods, _ := snapshot.group()
+	versions := make([]string, 0, len(requiredPods))
+	for _, required := range requiredPods {
+		versions = append(versions, fmt.Sprintf("%s/%s/%s", podId(required), required.UID, required.ResourceVersion))
+	}
+	node := snapshot.Node
+	hasher := fnv.New64a()
+	hashutil.DeepHashObject(hasher, []interface{}{pod.Spec, node.Labels, node.Spec.Taints, node.Spec.Unschedulable, node.Status.Allocatable, versions})
+	return hasher.Sum64()
+}
+
+// FitsWithoutEvictions returns nil if <pod> fits on the node as it is, i.e.
+// next to every pod running there.
+func FitsWithoutEvictions(predicateChecker *ca_simulator.PredicateChecker, snapshot *NodeSnapshot, pod *v1.Pod) error {
+	pods := snapshot.occupants()
+	if err := CheckHostPorts(pods, pod); err != nil {
+		return err
+	}
+	nodeInfo := schedulercache.NewNodeInfo(pods...)
+	nodeInfo.SetNode(snapshot.Node)
+	return predicateChecker.CheckPredicates(pod, nil, nodeInfo, true)
+}
+
+// FreeResources returns the allocatable CPU, in millicores, and memory, in
+// bytes, of the node which isn't requested by the pods occupying it.
+func FreeResources(snapshot *NodeSnapshot) (int64, int64) {
+	nodeInfo := schedulercache.NewNodeInfo(snapshot.occupants()...)
+	nodeInfo.SetNode(snapshot.Node)
+	allocatable, requested := nodeInfo.AllocatableResource(), nodeInfo.RequestedResource()
+	return allocatable.MilliCPU - requested.MilliCPU, allocatable.Memory - requested.Memory
+}
+
+// PodRequests returns the CPU, in millicores, and memory, in bytes, <pod>
+// requests, the way the scheduler counts them.
+func PodRequests(pod *v1.Pod) (int64, int64) {
+	request := predicates.GetResourceRequest(pod)
+	return request.MilliCPU, request.Memory
+}
+
+// occupants returns the pods which take up space on the node as it is.
+func (s *NodeSnapshot) occupants() []*v1.Pod {
+	requiredPods, classes := s.group()
+	pods := append([]*v1.Pod{}, requiredPods...)
+	for _, class := range classes {
+		pods = append(pods, class...)
+	}
+	return pods
+}
+
+// FindVictims returns pods which have to be deleted from the node so that
+// <criticalPod> fits there. Pods are re-added to the node one by one and
+// those which don't fit any more become victims; this is tried in a few
+// orders and the victims displacing the least resources are returned.
+func FindVictims(predicateChecker *ca_simulator.PredicateChecker, snapshot *NodeSnapshot, criticalPod *v1.Pod) ([]*v1.Pod, error) {
+	node := snapshot.Node
+	requiredPods, classes := snapshot.group()
+	if err := CheckHostPorts(requiredPods, criticalPod); err != nil {
+		return nil, &FitError{Pod: podId(criticalPod), Node: node.Name, Err: err}
+	}
+
+	nodeInfo := schedulercache.NewNodeInfo(requiredPods...)
+	nodeInfo.SetNode(node)
+
+	// check whether critical pod still fit
+	if err := predicateChecker.CheckPredicates(criticalPod, nil, nodeInfo, true); err != nil {
+		return nil, &FitError{Pod: podId(criticalPod), Node: node.Name, Err: err}
+	}
+	requiredPods = append(requiredPods, criticalPod)
+
+	var best []*v1.Pod
+	bestDisplaced := 0.0
+	for _, order := range keepOrders {
+		otherPods := []*v1.Pod{}
+		for _, class := range classes {
+			otherPods = append(otherPods, order(node, class)...)
+		}
+		victims := victimsKeeping(predicateChecker, node, requiredPods, otherPods)
+		if displaced := displacement(node, victims); best == nil || displaced < bestDisplaced {
+			best, bestDisplaced = victims, displaced
+		}
+	}
+	return best, nil
+}
+
+// victimsKeeping adds <otherPods> in order to a node running <requiredPods>
+// and returns those which don't fit.
+func victimsKeeping(predicateChecker *ca_simulator.PredicateChecker, node *v1.Node, requiredPods, otherPods []*v1.Pod) []*v1.Pod {
+	nodeInfo := schedulercache.NewNodeInfo(requiredPods...)
+	nodeInfo.SetNode(node)
+	victims := make([]*v1.Pod, 0)
+	for _, p := range otherPods {
+		if err := predicateChecker.CheckPredicates(p, nil, nodeInfo, true); err != nil {
+			victims = append(victims, p)
+		} else {
+			newPods := append(nodeInfo.Pods(), p)
+			nodeInfo = schedulercache.NewNodeInfo(newPods...)
+			nodeInfo.SetNode(node)
+		}
+	}
+	return victims
+}
+
+// PlanPlacement computes the placement of <criticalPod> on the node described by <snapshot>.
+func PlanPlacement(predicateChecker *ca_simulator.PredicateChecker, snapshot *NodeSnapshot, criticalPod *v1.Pod) (*Placement, error) {
+	victims, err := FindVictims(predicateChecker, snapshot, criticalPod)
+	if err != nil {
+		return nil, err
+	}
+	return &Placement{
+		Pod:      criticalPod,
+		Node:     snapshot.Node,
+		Victims:  victims,
+		Snapshot: snapshot,
+	}, nil
+}
+
+// CheckReservation returns an error if the node is reserved for a critical pod
+// by a taint added less than <freshFor> before <now>. Older reservations have
+// outlived their placement and are about to be released. A zero <freshFor>
+// treats every reservation as fresh, as do taints without TimeAdded.
+// Reservations which expired are never fresh.
+func CheckReservation(node *v1.Node, now time.Time, freshFor time.Duration) error {
+	for _, taint := range node.Spec.Taints {
+		if taint.Key != CriticalAddonsOnlyTaintKey {
+			continue
+		}
+		if reservation, err := ParseReservation(taint.Value); err == nil && reservation.Expired(now) {
+			continue
+		}
+		if freshFor == 0 || taint.TimeAdded == nil || now.Sub(taint.TimeAdded.Time) < freshFor {
+			return fmt.Errorf("CriticalAddonsOnly taint with value: %v", taint.Value)
+		}
+	}
+	return nil
+}
+
+// CheckTolerations returns an error if <node> has a NoSchedule or NoExecute
+// taint <pod> doesn't tolerate. Reservation taints are left to
+// CheckReservation: the pod has to tolerate the one added for it anyway.
+// Nodes with taints the pod tolerates, e.g. dedicated monitoring nodes for a
+// monitoring DaemonSet, are good candidates.
+func CheckTolerations(node *v1.Node, pod *v1.Pod) error {
+	for i := range node.Spec.Taints {
+		taint := &node.Spec.Taints[i]
+		if taint.Key == CriticalAddonsOnlyTaintKey || taint.Effect == v1.TaintEffectPreferNoSchedule {
+			continue
+		}
+		if !toleratesTaint(pod.Spec.Tolerations, taint) {
+			return fmt.Errorf("pod %s doesn't tolerate taint %s", podId(pod), taint.ToString())
+		}
+	}
+	return nil
+}
+
+func toleratesTaint(tolerations []v1.Toleration, taint *v1.Taint) bool {
+	for i := range tolerations {
+		if tolerations[i].ToleratesTaint(taint) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckTaints returns an error if the node is already reserved for a critical pod.
+func CheckTaints(node *v1.Node) error {
+	return CheckReservation(node, time.Time{}, 0)
+}
+
+func podId(pod *v1.Pod) string {
+	return fmt.Sprintf("%s_%s", pod.Namespace, pod.Name)
+}
@@ -0,0 +1,477 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	"k8s.io/contrib/rescheduler/synthetic"
+	"k8s.io/kubernetes/pkg/kubelet/types"
+)
+
+func podNames(pods []*v1.Pod) []string {
+	names := []string{}
+	for _, pod := range pods {
+		names = append(names, pod.Name)
+	}
+	return names
+}
+
+func TestGroupPods(t *testing.T) {
+	mirror := synthetic.NewPod("mirror", "kube-system", 100)
+	mirror.Annotations = map[string]string{types.ConfigMirrorAnnotationKey: ""}
+	criticalNotInSystem := synthetic.NewPod("critical-default", "default", 100)
+	criticalNotInSystem.Annotations = map[string]string{CriticalPodAnnotation: ""}
+
+	required, other := GroupPods([]*v1.Pod{
+		synthetic.NewCriticalDaemonSetPod("ds", 100),
+		synthetic.NewPod("regular", "default", 100),
+		mirror,
+		criticalNotInSystem,
+	})
+	assert.Equal(t, []string{"ds", "mirror"}, podNames(required))
+	assert.Equal(t, []string{"regular", "critical-default"}, podNames(other))
+}
+
+func TestGroupPodsWithRules(t *testing.T) {
+	isController := true
+	logs := synthetic.NewPod("fluentd", "logging", 100)
+	logs.OwnerReferences = []metav1.OwnerReference{{Kind: "DaemonSet", Name: "fluentd", Controller: &isController}}
+	database := synthetic.NewPod("db-0", "default", 100)
+	database.OwnerReferences = []metav1.OwnerReference{{Kind: "StatefulSet", Name: "db", Controller: &isController}}
+	pinned := synthetic.NewPod("pinned", "default", 100)
+	pinned.Labels = map[string]string{"pinned": "true"}
+	pods := []*v1.Pod{synthetic.NewCriticalDaemonSetPod("ds", 100), logs, database, pinned, synthetic.NewPod("regular", "default", 100)}
+
+	required, _ := GroupPodsWithRules(pods, nil)
+	assert.Equal(t, []string{"ds", "fluentd"}, podNames(required))
+
+	rules := &RequiredPodRules{
+		Required: []PodMatcher{
+			{OwnerKinds: []string{"StatefulSet"}},
+			{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"pinned": "true"}}},
+		},
+		// critical DaemonSet pods stay required
+		Deletable: []PodMatcher{{Namespaces: []string{"logging", "kube-system"}}},
+	}
+	assert.NoError(t, rules.Validate())
+	required, other := GroupPodsWithRules(pods, rules)
+	assert.Equal(t, []string{"ds", "db-0", "pinned"}, podNames(required))
+	assert.Equal(t, []string{"fluentd", "regular"}, podNames(other))
+
+	assert.Error(t, (&RequiredPodRules{Deletable: []PodMatcher{{}}}).Validate())
+	invalid := &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "a", Operator: "Near"}}}
+	assert.Error(t, (&RequiredPodRules{Required: []PodMatcher{{Selector: invalid}}}).Validate())
+}
+
+func TestPlanPlacement(t *testing.T) {
+	predicateChecker := simulator.NewTestPredicateChecker()
+	snapshot := &NodeSnapshot{
+		Node: synthetic.NewNode("node", 1000),
+		Pods: []*v1.Pod{
+			synthetic.NewCriticalDaemonSetPod("ds", 150),
+			synthetic.NewPod("p1", "default", 150),
+			synthetic.NewPod("p2", "default", 250),
+			synthetic.NewPod("p3", "default", 150),
+		},
+	}
+	criticalPod := synthetic.NewCriticalDaemonSetPod("critical", 500)
+
+	assert.NoError(t, CheckNode(predicateChecker, snapshot, criticalPod))
+	placement, err := PlanPlacement(predicateChecker, snapshot, criticalPod)
+	assert.NoError(t, err)
+	assert.Equal(t, "node", placement.Node.Name)
+	assert.Equal(t, []string{"p2"}, podNames(placement.Victims))
+
+	tooBig := synthetic.NewCriticalDaemonSetPod("too-big", 900)
+	assert.Error(t, CheckNode(predicateChecker, snapshot, tooBig))
+	_, err = PlanPlacement(predicateChecker, snapshot, tooBig)
+	assert.Error(t, err)
+}
+
+func TestPrintPlan(t *testing.T) {
+	plan := NewPlan()
+	plan.Placements = append(plan.Placements, &Placement{
+		Pod:     synthetic.NewCriticalDaemonSetPod("critical", 500),
+		Node:    synthetic.NewNode("node", 1000),
+		Taint:   ReservationTaint(synthetic.NewCriticalDaemonSetPod("critical", 500), time.Unix(1500000000, 0), "rescheduler-0"),
+		Victims: []*v1.Pod{synthetic.NewPod("victim", "default", 100)},
+	})
+	plan.Unplaceable = append(plan.Unplaceable, &Unplaceable{
+		Pod:    synthetic.NewCriticalDaemonSetPod("too-big", 5000),
+		Reason: "no node satisfies predicates",
+	})
+
+	data, err := plan.Print("yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, `placements:
+- node: node
+  pod: kube-system_critical
+  taint:
+    effect: NoSchedule
+    key: CriticalAddonsOnly
+    value: kube-system/critical.ot27eo.fab4b220
+  victims:
+  - default_victim
+unplaceable:
+- pod: kube-system_too-big
+  reason: no node satisfies predicates
+`, string(data))
+
+	data, err = plan.Print("json")
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"victims": [
+        "default_victim"
+      ]`)
+}
+
+func TestCheckPlatform(t *testing.T) {
+	unlabeled := synthetic.NewNode("unlabeled", 1000)
+	linux := synthetic.NewNode("linux", 1000)
+	linux.Labels = map[string]string{BetaOSLabel: "linux", BetaArchLabel: "amd64"}
+	windows := synthetic.NewNode("windows", 1000)
+	windows.Labels = map[string]string{OSLabel: "windows", ArchLabel: "amd64"}
+	arm := synthetic.NewNode("arm", 1000)
+	arm.Labels = map[string]string{OSLabel: "linux", ArchLabel: "arm64"}
+
+	linuxPod := synthetic.NewCriticalDaemonSetPod("linux-ds", 100)
+	assert.NoError(t, CheckPlatform(unlabeled, linuxPod))
+	assert.NoError(t, CheckPlatform(linux, linuxPod))
+	assert.NoError(t, CheckPlatform(arm, linuxPod))
+	assert.Error(t, CheckPlatform(windows, linuxPod))
+
+	windowsPod := synthetic.NewCriticalDaemonSetPod("windows-ds", 100)
+	windowsPod.Spec.NodeSelector = map[string]string{BetaOSLabel: "windows"}
+	assert.NoError(t, CheckPlatform(windows, windowsPod))
+	assert.Error(t, CheckPlatform(linux, windowsPod))
+
+	amd64Pod := synthetic.NewCriticalDaemonSetPod("amd64-ds", 100)
+	amd64Pod.Spec.Affinity = &v1.Affinity{NodeAffinity: &v1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{
+			NodeSelectorTerms: []v1.NodeSelectorTerm{{
+				MatchExpressions: []v1.NodeSelectorRequirement{{
+					Key: ArchLabel, Operator: v1.NodeSelectorOpIn, Values: []string{"amd64"},
+				}},
+			}},
+		},
+	}}
+	assert.NoError(t, CheckPlatform(linux, amd64Pod))
+	assert.Error(t, CheckPlatform(arm, amd64Pod))
+}
+
+func TestCheckHostPorts(t *testing.T) {
+	withHostPort := func(pod *v1.Pod, hostIP string, port int32) *v1.Pod {
+		pod.Spec.Containers[0].Ports = []v1.ContainerPort{{HostIP: hostIP, HostPort: port, ContainerPort: port}}
+		return pod
+	}
+	predicateChecker := simulator.NewTestPredicateChecker()
+	holder := withHostPort(synthetic.NewCriticalDaemonSetPod("ingress", 100), "", 443)
+	snapshot := &NodeSnapshot{
+		Node: synthetic.NewNode("node", 1000),
+		Pods: []*v1.Pod{holder, withHostPort(synthetic.NewPod("regular", "default", 100), "", 80)},
+	}
+
+	// Ports held by pods which can be deleted don't matter.
+	assert.NoError(t, CheckNode(predicateChecker, snapshot, withHostPort(synthetic.NewCriticalDaemonSetPod("web", 100), "", 80)))
+	assert.NoError(t, CheckNode(predicateChecker, snapshot, withHostPort(synthetic.NewCriticalDaemonSetPod("other", 100), "", 8443)))
+
+	err := CheckNode(predicateChecker, snapshot, withHostPort(synthetic.NewCriticalDaemonSetPod("proxy", 100), "10.0.0.1", 443))
+	conflict, ok := err.(*HostPortConflictError)
+	assert.True(t, ok, "unexpected error %v", err)
+	assert.Equal(t, holder, conflict.Holder)
+	assert.Equal(t, "host port TCP/443 is held by kube-system_ingress", err.Error())
+
+	_, err = FindVictims(predicateChecker, snapshot, withHostPort(synthetic.NewCriticalDaemonSetPod("proxy", 100), "10.0.0.1", 443))
+	assert.Equal(t, ErrNoFeasibleNode, KindOf(err), "unexpected error %v", err)
+	_, ok = err.(*FitError).Err.(*HostPortConflictError)
+	assert.True(t, ok)
+}
+
+func TestOrderNodes(t *testing.T) {
+	pod := synthetic.NewCriticalDaemonSetPod("ds", 100)
+	pod.Spec.Containers[0].Image = "gcr.io/addon:v1"
+	cold := synthetic.NewNode("cold", 1000)
+	warm := synthetic.NewNode("warm", 1000)
+	warm.Status.Images = []v1.ContainerImage{{Names: []string{"gcr.io/addon@sha256:abc", "gcr.io/addon:v1"}, SizeBytes: 500}}
+	other := synthetic.NewNode("other", 1000)
+	other.Status.Images = []v1.ContainerImage{{Names: []string{"gcr.io/other:v1"}, SizeBytes: 900}}
+	nodes := []*v1.Node{cold, other, warm}
+
+	assert.Equal(t, nodes, OrderNodes(nodes, pod, nil))
+	assert.Equal(t, []*v1.Node{warm, cold, other}, OrderNodes(nodes, pod, []Scorer{ImageLocalityScorer{}}))
+	assert.Equal(t, []*v1.Node{cold, other, warm}, nodes, "input must not be reordered")
+}
+
+func TestReplicaSpread(t *testing.T) {
+	inZone := func(name, zone string) *v1.Node {
+		node := synthetic.NewNode(name, 1000)
+		node.Labels = map[string]string{ZoneLabel: zone}
+		return node
+	}
+	a1, a2, b1 := inZone("a1", "a"), inZone("a2", "a"), inZone("b1", "b")
+	nodes := []*v1.Node{a1, a2, b1}
+	replica := func(name string) *v1.Pod {
+		pod := synthetic.NewPod(name, "kube-system", 100)
+		controller := true
+		pod.OwnerReferences = []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "dns", Controller: &controller}}
+		return pod
+	}
+
+	spread := NewReplicaSpread()
+	assert.Equal(t, nodes, OrderNodes(nodes, replica("dns-1"), spread.Scorers()))
+	spread.Add(a1, replica("dns-1"))
+	assert.Equal(t, []*v1.Node{b1, a2, a1}, OrderNodes(nodes, replica("dns-2"), spread.Scorers()))
+	spread.Add(b1, replica("dns-2"))
+	assert.Equal(t, []*v1.Node{a2, a1, b1}, OrderNodes(nodes, replica("dns-3"), spread.Scorers()))
+
+	// Other controllers and pods without one aren't affected.
+	assert.Equal(t, nodes, OrderNodes(nodes, synthetic.NewPod("standalone", "kube-system", 100), spread.Scorers()))
+}
+
+func TestNodeAnnotationPolicies(t *testing.T) {
+	annotated := func(name string, annotations map[string]string) *v1.Node {
+		node := synthetic.NewNode(name, 1000)
+		node.Annotations = annotations
+		return node
+	}
+	plain := annotated("plain", nil)
+	pinned := annotated("pinned", map[string]string{ScaleDownDisabledAnnotation: "true"})
+	unpinned := annotated("unpinned", map[string]string{ScaleDownDisabledAnnotation: "false"})
+	draining := annotated("draining", map[string]string{"ops.example.com/maintenance": "2026-10-14"})
+	policies := NodeAnnotationPolicies{
+		ScaleDownDisabledAnnotation:   AnnotationPolicyPrefer,
+		"ops.example.com/maintenance": AnnotationPolicyAvoid,
+	}
+
+	pod := synthetic.NewCriticalDaemonSetPod("ds", 100)
+	assert.Equal(t, []*v1.Node{pinned, plain, unpinned, draining},
+		OrderNodes([]*v1.Node{draining, plain, unpinned, pinned}, pod, []Scorer{policies}))
+	assert.False(t, policies.Excludes(draining))
+	policies["ops.example.com/maintenance"] = AnnotationPolicyExclude
+	assert.True(t, policies.Excludes(draining))
+	assert.False(t, policies.Excludes(pinned))
+}
+
+func TestCostScorer(t *testing.T) {
+	withCost := func(name, cost string) *v1.Node {
+		node := synthetic.NewNode(name, 1000)
+		if cost != "" {
+			node.Labels = map[string]string{"cost": cost}
+		}
+		return node
+	}
+	gpu, general, spot := withCost("gpu", "2.48"), withCost("general", "0.19"), withCost("spot", "0.0475")
+	unknown, invalid := withCost("unknown", ""), withCost("invalid", "cheap")
+
+	pod := synthetic.NewCriticalDaemonSetPod("ds", 100)
+	assert.Equal(t, []*v1.Node{spot, general, gpu, unknown, invalid},
+		OrderNodes([]*v1.Node{unknown, gpu, invalid, general, spot}, pod, []Scorer{CostScorer{Label: "cost"}}))
+}
+
+func TestClassifyVictim(t *testing.T) {
+	predicateChecker := simulator.NewTestPredicateChecker()
+	stateful := synthetic.NewPod("stateful", "default", 300)
+	snapshot := func(class VictimClass) *NodeSnapshot {
+		return &NodeSnapshot{
+			Node: synthetic.NewNode("node", 1000),
+			Pods: []*v1.Pod{
+				synthetic.NewCriticalDaemonSetPod("ds", 150),
+				synthetic.NewPod("p1", "default", 300),
+				synthetic.NewPod("p2", "default", 300),
+				stateful,
+			},
+			ClassifyVictim: func(pod *v1.Pod) VictimClass {
+				if pod == stateful {
+					return class
+				}
+				return VictimAllowed
+			},
+		}
+	}
+	criticalPod := synthetic.NewCriticalDaemonSetPod("critical", 500)
+
+	victims, err := FindVictims(predicateChecker, snapshot(VictimAllowed), criticalPod)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"p2", "stateful"}, podNames(victims))
+
+	victims, err = FindVictims(predicateChecker, snapshot(VictimAvoided), criticalPod)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"p1", "p2"}, podNames(victims))
+
+	victims, err = FindVictims(predicateChecker, snapshot(VictimProtected), criticalPod)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"p1", "p2"}, podNames(victims))
+	tooBig := synthetic.NewCriticalDaemonSetPod("too-big", 600)
+	assert.NoError(t, CheckNode(predicateChecker, snapshot(VictimAvoided), tooBig))
+	assert.Error(t, CheckNode(predicateChecker, snapshot(VictimProtected), tooBig))
+}
+
+func TestFindVictimsMinimizesDisplacement(t *testing.T) {
+	predicateChecker := simulator.NewTestPredicateChecker()
+	snapshot := &NodeSnapshot{
+		Node: synthetic.NewNode("node", 1000),
+		Pods: []*v1.Pod{
+			synthetic.NewPod("big", "default", 500),
+			synthetic.NewPod("small1", "default", 300),
+			synthetic.NewPod("small2", "default", 300),
+		},
+	}
+	criticalPod := synthetic.NewCriticalDaemonSetPod("critical", 400)
+
+	// Keeping pods in list order would evict both small pods (600m).
+	victims, err := FindVictims(predicateChecker, snapshot, criticalPod)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"big"}, podNames(victims))
+}
+
+func TestFitsWithoutEvictions(t *testing.T) {
+	predicateChecker := simulator.NewTestPredicateChecker()
+	snapshot := &NodeSnapshot{
+		Node: synthetic.NewNode("node", 1000),
+		Pods: []*v1.Pod{
+			synthetic.NewCriticalDaemonSetPod("ds", 300),
+			synthetic.NewPod("other", "default", 300),
+		},
+	}
+	assert.NoError(t, FitsWithoutEvictions(predicateChecker, snapshot, synthetic.NewCriticalDaemonSetPod("small", 400)))
+	assert.Error(t, FitsWithoutEvictions(predicateChecker, snapshot, synthetic.NewCriticalDaemonSetPod("big", 500)))
+	assert.NoError(t, CheckNode(predicateChecker, snapshot, synthetic.NewCriticalDaemonSetPod("big", 500)))
+
+	cpu, memory := FreeResources(snapshot)
+	assert.Equal(t, int64(400), cpu)
+	assert.Equal(t, int64(2*1024*1024*1024), memory)
+}
+
+func TestFindVictimsSkipsTerminatedPods(t *testing.T) {
+	predicateChecker := simulator.NewTestPredicateChecker()
+	completed := synthetic.NewPod("completed", "default", 400)
+	completed.Status.Phase = v1.PodSucceeded
+	terminating := synthetic.NewPod("terminating", "default", 400)
+	now := metav1.Now()
+	terminating.DeletionTimestamp = &now
+	snapshot := &NodeSnapshot{
+		Node: synthetic.NewNode("node", 1000),
+		Pods: []*v1.Pod{completed, terminating, synthetic.NewPod("running", "default", 400)},
+	}
+	criticalPod := synthetic.NewCriticalDaemonSetPod("critical", 500)
+
+	victims, err := FindVictims(predicateChecker, snapshot, criticalPod)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, podNames(victims))
+
+	snapshot.CountTerminating = true
+	victims, err = FindVictims(predicateChecker, snapshot, criticalPod)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"running"}, podNames(victims))
+	assert.Error(t, CheckNode(predicateChecker, snapshot, synthetic.NewCriticalDaemonSetPod("too-big", 700)))
+}
+
+func TestCheckReservation(t *testing.T) {
+	now := time.Now()
+	reservedAt := func(added time.Time) *v1.Node {
+		node := synthetic.NewNode("node", 1000)
+		taint := ReservationTaint(synthetic.NewCriticalDaemonSetPod("other", 100), now.Add(time.Hour), "rescheduler-0")
+		taint.TimeAdded = &metav1.Time{Time: added}
+		node.Spec.Taints = []v1.Taint{taint}
+		return node
+	}
+	fresh, stale := reservedAt(now.Add(-time.Minute)), reservedAt(now.Add(-time.Hour))
+
+	assert.NoError(t, CheckReservation(synthetic.NewNode("free", 1000), now, 0))
+	assert.Error(t, CheckReservation(fresh, now, 0))
+	assert.Error(t, CheckReservation(stale, now, 0))
+	assert.Error(t, CheckReservation(fresh, now, 10*time.Minute))
+	assert.NoError(t, CheckReservation(stale, now, 10*time.Minute))
+	assert.Error(t, CheckTaints(stale))
+
+	expired := reservedAt(now.Add(-time.Minute))
+	expired.Spec.Taints[0] = ReservationTaint(synthetic.NewCriticalDaemonSetPod("other", 100), now.Add(-time.Second), "rescheduler-0")
+	assert.NoError(t, CheckReservation(expired, now, 0))
+}
+
+func TestParseReservation(t *testing.T) {
+	expires := time.Unix(1500000000, 0)
+	taint := ReservationTaint(synthetic.NewCriticalDaemonSetPod("critical", 100), expires, "rescheduler-0")
+	assert.True(t, len(taint.Value) <= 63)
+	reservation, err := ParseReservation(taint.Value)
+	assert.NoError(t, err)
+	assert.Equal(t, Reservation{UID: "kube-system/critical", Expires: expires, Instance: InstanceHash("rescheduler-0")}, reservation)
+	assert.True(t, reservation.Expired(expires.Add(time.Second)))
+	assert.False(t, reservation.Expired(expires))
+
+	for _, value := range []string{"kube-system_critical", "kube-system_kube-proxy.node-1", "uid.~.fab4b220", ".ot27eo.fab4b220"} {
+		_, err := ParseReservation(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestCheckTolerations(t *testing.T) {
+	tainted := func(taints ...v1.Taint) *v1.Node {
+		node := synthetic.NewNode("node", 1000)
+		node.Spec.Taints = taints
+		return node
+	}
+	dedicated := v1.Taint{Key: "dedicated", Value: "monitoring", Effect: v1.TaintEffectNoSchedule}
+	gpu := v1.Taint{Key: "nvidia.com/gpu", Effect: v1.TaintEffectNoExecute}
+	soft := v1.Taint{Key: "preferably-not", Effect: v1.TaintEffectPreferNoSchedule}
+	reserved := ReservationTaint(synthetic.NewCriticalDaemonSetPod("other", 100), time.Now().Add(time.Hour), "rescheduler-0")
+
+	pod := synthetic.NewCriticalDaemonSetPod("node-exporter", 100)
+	pod.Spec.Tolerations = []v1.Toleration{{Key: "dedicated", Operator: v1.TolerationOpEqual, Value: "monitoring"}}
+	assert.NoError(t, CheckTolerations(tainted(), pod))
+	assert.NoError(t, CheckTolerations(tainted(dedicated, soft, reserved), pod))
+	assert.Error(t, CheckTolerations(tainted(dedicated, gpu), pod))
+
+	pod.Spec.Tolerations = []v1.Toleration{{Operator: v1.TolerationOpExists}}
+	assert.NoError(t, CheckTolerations(tainted(dedicated, gpu), pod))
+}
+
+func TestPrefilter(t *testing.T) {
+	check := func(node *v1.Node, pod *v1.Pod) string {
+		if err, ok := Prefilter(node, pod).(*PrefilterError); ok {
+			return err.Check
+		}
+		return ""
+	}
+	pod := synthetic.NewCriticalDaemonSetPod("node-exporter", 500)
+	pod.Spec.NodeSelector = map[string]string{"pool": "system"}
+	node := synthetic.NewNode("node", 1000)
+	node.Labels = map[string]string{"pool": "system"}
+	assert.Equal(t, "", check(node, pod))
+
+	windows := node.DeepCopy()
+	windows.Labels[OSLabel] = "windows"
+	assert.Equal(t, PrefilterPlatform, check(windows, pod))
+
+	tainted := node.DeepCopy()
+	tainted.Spec.Taints = []v1.Taint{{Key: "dedicated", Effect: v1.TaintEffectNoSchedule}}
+	assert.Equal(t, PrefilterTaints, check(tainted, pod))
+
+	otherPool := node.DeepCopy()
+	otherPool.Labels["pool"] = "default"
+	assert.Equal(t, PrefilterNodeSelector, check(otherPool, pod))
+
+	assert.Equal(t, PrefilterResources, check(synthetic.NewNode("small", 400), &v1.Pod{Spec: v1.PodSpec{Containers: pod.Spec.Containers}}))
+	assert.Equal(t, ErrNoFeasibleNode, KindOf(Prefilter(otherPool, pod)))
+	assert.Nil(t, KindOf(errors.New("other")))
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Kinds of failures of the engine and of placements, see KindOf.
+var (
+	// ErrNoFeasibleNode means a pod doesn't fit on a node even once all pods
+	// which may be deleted are gone.
+	ErrNoFeasibleNode = errors.New("no feasible node")
+	// ErrTaintConflict means a node couldn't be reserved: it changed or was
+	// reserved by someone else since it was planned, or its update failed.
+	ErrTaintConflict = errors.New("node can't be reserved")
+	// ErrEvictionVetoed means a victim couldn't be deleted, e.g. because the
+	// API server refused it.
+	ErrEvictionVetoed = errors.New("eviction vetoed")
+)
+
+// FitError means Pod doesn't fit on Node, for the reason in Err.
+type FitError struct {
+	Pod  string
+	Node string
+	Err  error
+}
+
+func (e *FitError) Error() string {
+	return fmt.Sprintf("Pod %s doesn't fit to node %v: %v", e.Pod, e.Node, e.Err)
+}
+
+// Kind returns ErrNoFeasibleNode.
+func (e *FitError) Kind() error {
+	return ErrNoFeasibleNode
+}
+
+// TaintError means Node couldn't be tainted to reserve it, for the reason in Err.
+type TaintError struct {
+	Node string
+	Err  error
+}
+
+func (e *TaintError) Error() string {
+	return fmt.Sprintf("Error while adding taint to node %v: %v", e.Node, e.Err)
+}
+
+// Kind returns ErrTaintConflict.
+func (e *TaintError) Kind() error {
+	return ErrTaintConflict
+}
+
+// EvictionError means victim Pod couldn't be deleted, for the reason in Err.
+type EvictionError struct {
+	Pod string
+	Err error
+}
+
+func (e *EvictionError) Error() string {
+	return fmt.Sprintf("Failed to delete pod %s: %v", e.Pod, e.Err)
+}
+
+// Kind returns ErrEvictionVetoed.
+func (e *EvictionError) Kind() error {
+	return ErrEvictionVetoed
+}
+
+// kinded is implemented by the errors which have a kind.
+type kinded interface {
+	Kind() error
+}
+
+// KindOf returns the kind of <err>: ErrNoFeasibleNode, ErrTaintConflict,
+// ErrEvictionVetoed or nil if it has none of them.
+func KindOf(err error) error {
+	if k, ok := err.(kinded); ok {
+		return k.Kind()
+	}
+	switch err {
+	case ErrNoFeasibleNode, ErrTaintConflict, ErrEvictionVetoed:
+		return err
+	}
+	return nil
+}
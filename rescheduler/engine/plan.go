@@ -0,0 +1,96 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"encoding/json"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/api/core/v1"
+)
+
+// Plan is everything a single housekeeping pass decided to do.
+type Plan struct {
+	// Placements are the nodes to reserve and the pods to evict from them.
+	Placements []*Placement `json:"placements"`
+	// Unplaceable are the critical pods for which no node was found.
+	Unplaceable []*Unplaceable `json:"unplaceable"`
+}
+
+// Unplaceable is a critical pod which doesn't fit on any node.
+type Unplaceable struct {
+	Pod    *v1.Pod
+	Reason string
+	// DecisionID identifies this decision in events and logs. It is set by the caller.
+	DecisionID string
+}
+
+// NewPlan returns an empty plan.
+func NewPlan() *Plan {
+	return &Plan{
+		Placements:  []*Placement{},
+		Unplaceable: []*Unplaceable{},
+	}
+}
+
+// IsEmpty returns true if the plan has nothing to do or report.
+func (p *Plan) IsEmpty() bool {
+	return len(p.Placements) == 0 && len(p.Unplaceable) == 0
+}
+
+// MarshalJSON refers to pods and nodes by name.
+func (p *Placement) MarshalJSON() ([]byte, error) {
+	victims := []string{}
+	for _, victim := range p.Victims {
+		victims = append(victims, podId(victim))
+	}
+	return json.Marshal(struct {
+		Pod        string   `json:"pod"`
+		Node       string   `json:"node"`
+		Taint      v1.Taint `json:"taint"`
+		Victims    []string `json:"victims"`
+		DecisionID string   `json:"decisionID,omitempty"`
+	}{
+		Pod:        podId(p.Pod),
+		Node:       p.Node.Name,
+		Taint:      p.Taint,
+		Victims:    victims,
+		DecisionID: p.DecisionID,
+	})
+}
+
+// MarshalJSON refers to the pod by name.
+func (u *Unplaceable) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Pod        string `json:"pod"`
+		Reason     string `json:"reason"`
+		DecisionID string `json:"decisionID,omitempty"`
+	}{
+		Pod:        podId(u.Pod),
+		Reason:     u.Reason,
+		DecisionID: u.DecisionID,
+	})
+}
+
+// Print renders the plan in the given format, either "json" or "yaml".
+func (p *Plan) Print(format string) ([]byte, error) {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil || format == "json" {
+		return data, err
+	}
+	return yaml.JSONToYAML(data)
+}
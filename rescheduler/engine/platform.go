@@ -0,0 +1,108 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"fmt"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// Node labels describing the platform. Kubernetes 1.10 only sets the beta
+// labels; the GA ones are checked first so that newer nodes work too.
+const (
+	OSLabel       = "kubernetes.io/os"
+	ArchLabel     = "kubernetes.io/arch"
+	BetaOSLabel   = "beta.kubernetes.io/os"
+	BetaArchLabel = "beta.kubernetes.io/arch"
+)
+
+// defaultOS is assumed for pods which don't select an operating system:
+// Windows containers always have to select Windows nodes.
+const defaultOS = "linux"
+
+// CheckPlatform returns an error if the operating system or architecture of
+// <node> is not one <pod> can run on. It only looks at labels, so it's cheap
+// enough to run before listing the pods on the node. Nodes without platform
+// labels pass.
+func CheckPlatform(node *v1.Node, pod *v1.Pod) error {
+	if nodeOS := platformLabel(node.Labels, OSLabel, BetaOSLabel); nodeOS != "" {
+		allowed := requiredValues(pod, OSLabel, BetaOSLabel)
+		if allowed == nil {
+			allowed = sets.NewString(defaultOS)
+		}
+		if !allowed.Has(nodeOS) {
+			return fmt.Errorf("node %s runs %s, pod %s needs %v", node.Name, nodeOS, podId(pod), allowed.List())
+		}
+	}
+	if nodeArch := platformLabel(node.Labels, ArchLabel, BetaArchLabel); nodeArch != "" {
+		if allowed := requiredValues(pod, ArchLabel, BetaArchLabel); allowed != nil && !allowed.Has(nodeArch) {
+			return fmt.Errorf("node %s is %s, pod %s needs %v", node.Name, nodeArch, podId(pod), allowed.List())
+		}
+	}
+	return nil
+}
+
+func platformLabel(labels map[string]string, keys ...string) string {
+	for _, key := range keys {
+		if value, found := labels[key]; found {
+			return value
+		}
+	}
+	return ""
+}
+
+// requiredValues returns the values <pod> allows for any of the label <keys>,
+// from its node selector and required node affinity, or nil if it doesn't
+// constrain them.
+func requiredValues(pod *v1.Pod, keys ...string) sets.String {
+	var allowed sets.String
+	for _, key := range keys {
+		if value, found := pod.Spec.NodeSelector[key]; found {
+			allowed = intersect(allowed, sets.NewString(value))
+		}
+	}
+	affinity := pod.Spec.Affinity
+	if affinity == nil || affinity.NodeAffinity == nil || affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil ||
+		len(affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms) == 0 {
+		return allowed
+	}
+	// Terms are ORed: the pod is only constrained if every term constrains it.
+	fromTerms := sets.NewString()
+	for _, term := range affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		var termValues sets.String
+		for _, requirement := range term.MatchExpressions {
+			if requirement.Operator == v1.NodeSelectorOpIn && sets.NewString(keys...).Has(requirement.Key) {
+				termValues = intersect(termValues, sets.NewString(requirement.Values...))
+			}
+		}
+		if termValues == nil {
+			return allowed
+		}
+		fromTerms = fromTerms.Union(termValues)
+	}
+	return intersect(allowed, fromTerms)
+}
+
+// intersect treats a nil set as unconstrained.
+func intersect(a, b sets.String) sets.String {
+	if a == nil {
+		return b
+	}
+	return a.Intersection(b)
+}
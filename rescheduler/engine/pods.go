@@ -0,0 +1,100 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"k8s.io/api/core/v1"
+	kubeapi "k8s.io/kubernetes/pkg/apis/core"
+	"k8s.io/kubernetes/pkg/kubelet/types"
+)
+
+const (
+	// CriticalPodAnnotation marks a pod as critical.
+	CriticalPodAnnotation = "scheduler.alpha.kubernetes.io/critical-pod"
+	// CriticalAddonsOnlyTaintKey is the key of the taint reserving a node for a critical pod.
+	CriticalAddonsOnlyTaintKey = "CriticalAddonsOnly"
+
+	// HighestUserDefinablePriority is the highest priority for user defined priority classes. Priority values larger than 1 billion are reserved for Kubernetes system use.
+	HighestUserDefinablePriority = int32(1000000000)
+	// SystemCriticalPriority is the beginning of the range of priority values for critical system components.
+	SystemCriticalPriority = 2 * HighestUserDefinablePriority
+)
+
+// GroupPods divides <pods> into those which can't be deleted and the others:
+// mirror, DaemonSet and critical pods are required.
+func GroupPods(pods []*v1.Pod) ([]*v1.Pod, []*v1.Pod) {
+	return GroupPodsWithRules(pods, nil)
+}
+
+// GroupPodsWithRules is GroupPods with the required pods changed by <rules>.
+func GroupPodsWithRules(pods []*v1.Pod, rules *RequiredPodRules) ([]*v1.Pod, []*v1.Pod) {
+	requiredPods := make([]*v1.Pod, 0)
+	otherPods := make([]*v1.Pod, 0)
+	for _, pod := range pods {
+		if rules.IsRequired(pod) {
+			requiredPods = append(requiredPods, pod)
+		} else {
+			otherPods = append(otherPods, pod)
+		}
+	}
+	return requiredPods, otherPods
+}
+
+// IsCriticalPod checks whether the pod is a critical pod in the system namespace.
+func IsCriticalPod(pod *v1.Pod) bool {
+	return pod.Namespace == kubeapi.NamespaceSystem &&
+		(isCritical(pod.Annotations) || (pod.Spec.Priority != nil && isCriticalPodBasedOnPriority(*pod.Spec.Priority)))
+}
+
+// isCritical returns true if parameters bear the critical pod annotation
+func isCritical(annotations map[string]string) bool {
+	val, ok := annotations[CriticalPodAnnotation]
+	if ok && val == "" {
+		return true
+	}
+	return false
+}
+
+// isCriticalPodBasedOnPriority checks if the given pod is a critical pod based on priority resolved from pod Spec.
+func isCriticalPodBasedOnPriority(priority int32) bool {
+	if priority >= SystemCriticalPriority {
+		return true
+	}
+	return false
+}
+
+// IsTerminal returns true if all containers of <pod> have terminated for good.
+func IsTerminal(pod *v1.Pod) bool {
+	return pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed
+}
+
+// IsMirrorPod checks whether the pod is a mirror pod.
+func IsMirrorPod(pod *v1.Pod) bool {
+	_, found := pod.ObjectMeta.Annotations[types.ConfigMirrorAnnotationKey]
+	return found
+}
+
+// IsDaemonSetPod checks where the pod is a daemonset pod.
+func IsDaemonSetPod(pod *v1.Pod) bool {
+	ownerRefList := pod.ObjectMeta.GetOwnerReferences()
+	for _, ownerRef := range ownerRefList {
+		if ownerRef.Kind == "DaemonSet" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"fmt"
+
+	"k8s.io/api/core/v1"
+)
+
+// HostPortConflictError means a host port of the critical pod is held by a
+// pod which can't be deleted, so no amount of evictions frees the node.
+type HostPortConflictError struct {
+	Port   v1.ContainerPort
+	Holder *v1.Pod
+}
+
+func (e *HostPortConflictError) Error() string {
+	return fmt.Sprintf("host port %s/%d is held by %s", e.Port.Protocol, e.Port.HostPort, podId(e.Holder))
+}
+
+// CheckHostPorts returns a *HostPortConflictError if <pod> needs a host port
+// already used by one of <requiredPods>.
+func CheckHostPorts(requiredPods []*v1.Pod, pod *v1.Pod) error {
+	for _, port := range hostPorts(pod) {
+		for _, holder := range requiredPods {
+			for _, used := range hostPorts(holder) {
+				if portsConflict(port, used) {
+					return &HostPortConflictError{Port: port, Holder: holder}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func hostPorts(pod *v1.Pod) []v1.ContainerPort {
+	ports := []v1.ContainerPort{}
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			if port.HostPort == 0 {
+				continue
+			}
+			if port.Protocol == "" {
+				port.Protocol = v1.ProtocolTCP
+			}
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+// portsConflict follows the scheduler: an empty or 0.0.0.0 host IP binds all addresses.
+func portsConflict(a, b v1.ContainerPort) bool {
+	if a.HostPort != b.HostPort || a.Protocol != b.Protocol {
+		return false
+	}
+	return isWildcardIP(a.HostIP) || isWildcardIP(b.HostIP) || a.HostIP == b.HostIP
+}
+
+func isWildcardIP(ip string) bool {
+	return ip == "" || ip == "0.0.0.0"
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"fmt"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/kubernetes/pkg/scheduler/algorithm/predicates"
+	"k8s.io/kubernetes/pkg/scheduler/schedulercache"
+)
+
+// Checks of Prefilter, as reported in PrefilterError.Check.
+const (
+	PrefilterPlatform     = "platform"
+	PrefilterTaints       = "taints"
+	PrefilterNodeSelector = "node_selector"
+	PrefilterResources    = "resources"
+)
+
+// PrefilterError means <pod> can't run on the node whatever pods are deleted
+// from it, as found by the check named Check.
+type PrefilterError struct {
+	Check string
+	Err   error
+}
+
+func (e *PrefilterError) Error() string {
+	return e.Err.Error()
+}
+
+// Kind returns ErrNoFeasibleNode.
+func (e *PrefilterError) Kind() error {
+	return ErrNoFeasibleNode
+}
+
+// Prefilter returns a *PrefilterError if <pod> can't run on <node> even once
+// every pod on it is gone. It only looks at the node and the pod, so it is
+// cheap enough to narrow down the candidate nodes before the pods on each are
+// listed and the predicates run. The checks go from cheapest to most
+// expensive: platform labels, taints, node selector and required node
+// affinity, and the pod's requests against the node's allocatable resources.
+func Prefilter(node *v1.Node, pod *v1.Pod) error {
+	if err := CheckPlatform(node, pod); err != nil {
+		return &PrefilterError{Check: PrefilterPlatform, Err: err}
+	}
+	if err := CheckTolerations(node, pod); err != nil {
+		return &PrefilterError{Check: PrefilterTaints, Err: err}
+	}
+	nodeInfo := schedulercache.NewNodeInfo()
+	nodeInfo.SetNode(node)
+	if fits, _, err := predicates.PodMatchNodeSelector(pod, nil, nodeInfo); err != nil || !fits {
+		return &PrefilterError{Check: PrefilterNodeSelector, Err: fmt.Errorf("node %s doesn't match the node selector or affinity of pod %s", node.Name, podId(pod))}
+	}
+	if fits, _, err := predicates.PodFitsResources(pod, nil, nodeInfo); err != nil || !fits {
+		return &PrefilterError{Check: PrefilterResources, Err: fmt.Errorf("allocatable resources of node %s are less than pod %s requests", node.Name, podId(pod))}
+	}
+	return nil
+}
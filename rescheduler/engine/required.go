@@ -0,0 +1,130 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"fmt"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// RequiredPodRules changes which pods GroupPods treats as required, i.e.
+// never deletes. Mirror and critical pods are always required.
+type RequiredPodRules struct {
+	// Required pods are never deleted, in addition to the default ones.
+	Required []PodMatcher `json:"required,omitempty"`
+	// Deletable DaemonSet pods may be deleted, e.g. those of DaemonSets whose
+	// pods are recreated right away and can wait for the critical pod.
+	// Required wins over Deletable.
+	Deletable []PodMatcher `json:"deletable,omitempty"`
+	// DaemonSetPriorityBelow, if set, makes DaemonSet pods with a lower
+	// priority deletable. It depends on the critical pod and is set by the
+	// caller, not in the configuration.
+	DaemonSetPriorityBelow *int32 `json:"-"`
+}
+
+// PodMatcher matches the pods which satisfy all of its fields. At least one
+// field has to be set.
+type PodMatcher struct {
+	Selector   *metav1.LabelSelector `json:"selector,omitempty"`
+	Namespaces []string              `json:"namespaces,omitempty"`
+	// OwnerKinds are kinds of the pod's own controller, e.g. StatefulSet.
+	OwnerKinds []string `json:"ownerKinds,omitempty"`
+}
+
+// Validate returns an error if a matcher is empty or has an invalid selector.
+func (r *RequiredPodRules) Validate() error {
+	for name, matchers := range map[string][]PodMatcher{"required": r.Required, "deletable": r.Deletable} {
+		for i, matcher := range matchers {
+			if matcher.Selector == nil && len(matcher.Namespaces) == 0 && len(matcher.OwnerKinds) == 0 {
+				return fmt.Errorf("%s[%d] matches every pod, set selector, namespaces or ownerKinds", name, i)
+			}
+			if matcher.Selector != nil {
+				if _, err := metav1.LabelSelectorAsSelector(matcher.Selector); err != nil {
+					return fmt.Errorf("%s[%d]: %v", name, i, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// IsRequired returns true if <pod> must not be deleted according to the
+// defaults of GroupPods and the rules. Nil rules are the defaults.
+func (r *RequiredPodRules) IsRequired(pod *v1.Pod) bool {
+	if IsMirrorPod(pod) || IsCriticalPod(pod) {
+		return true
+	}
+	if r == nil {
+		return IsDaemonSetPod(pod)
+	}
+	if matchesAny(r.Required, pod) {
+		return true
+	}
+	if !IsDaemonSetPod(pod) || matchesAny(r.Deletable, pod) {
+		return false
+	}
+	return r.DaemonSetPriorityBelow == nil || podPriority(pod) >= *r.DaemonSetPriorityBelow
+}
+
+// podPriority returns the priority of <pod>, 0 if it has none.
+func podPriority(pod *v1.Pod) int32 {
+	if pod.Spec.Priority == nil {
+		return 0
+	}
+	return *pod.Spec.Priority
+}
+
+func matchesAny(matchers []PodMatcher, pod *v1.Pod) bool {
+	for _, matcher := range matchers {
+		if matcher.Matches(pod) {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches returns true if <pod> satisfies all fields of the matcher.
+func (m PodMatcher) Matches(pod *v1.Pod) bool {
+	if len(m.Namespaces) > 0 && !containsString(m.Namespaces, pod.Namespace) {
+		return false
+	}
+	if len(m.OwnerKinds) > 0 {
+		controller := metav1.GetControllerOf(pod)
+		if controller == nil || !containsString(m.OwnerKinds, controller.Kind) {
+			return false
+		}
+	}
+	if m.Selector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(m.Selector)
+		if err != nil || !selector.Matches(labels.Set(pod.Labels)) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
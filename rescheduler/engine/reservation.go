@@ -0,0 +1,93 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Reservation is the metadata encoded in the value of a reservation taint.
+// Taint values have to be valid label values of at most 63 characters, so it
+// is encoded as the pod UID, the expiry in base 36 Unix seconds and the hash
+// of the instance, separated by dots, e.g.
+// "3f2a5c8e-1d6b-11e8-9f4c-42010a800002.p5a7xk.1c9a3f02".
+type Reservation struct {
+	// UID is the UID of the critical pod the node is reserved for.
+	UID types.UID
+	// Expires is when the placement times out; the taint can be released by
+	// anyone after that.
+	Expires time.Time
+	// Instance is InstanceHash of the rescheduler which added the taint.
+	Instance string
+}
+
+// InstanceHash returns the short form of <instance> stored in reservation taints.
+func InstanceHash(instance string) string {
+	h := fnv.New32a()
+	h.Write([]byte(instance))
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// String returns the taint value encoding <r>.
+func (r Reservation) String() string {
+	return fmt.Sprintf("%s.%s.%s", r.UID, strconv.FormatInt(r.Expires.Unix(), 36), r.Instance)
+}
+
+// Expired returns true if the placement of <r> timed out before <now>.
+func (r Reservation) Expired(now time.Time) bool {
+	return now.After(r.Expires)
+}
+
+// ParseReservation decodes the value of a reservation taint. Values written by
+// older reschedulers, which are just the namespace and name of the pod, are
+// rejected.
+func ParseReservation(value string) (Reservation, error) {
+	parts := strings.Split(value, ".")
+	if len(parts) != 3 || parts[0] == "" || len(parts[2]) != 8 {
+		return Reservation{}, fmt.Errorf("invalid reservation %q", value)
+	}
+	expires, err := strconv.ParseInt(parts[1], 36, 64)
+	if err != nil {
+		return Reservation{}, fmt.Errorf("invalid expiry of reservation %q: %v", value, err)
+	}
+	return Reservation{
+		UID:      types.UID(parts[0]),
+		Expires:  time.Unix(expires, 0),
+		Instance: parts[2],
+	}, nil
+}
+
+// ReservationTaint returns the taint which reserves a node for <criticalPod>
+// until <expires> on behalf of the rescheduler <instance>.
+func ReservationTaint(criticalPod *v1.Pod, expires time.Time, instance string) v1.Taint {
+	return v1.Taint{
+		Key: CriticalAddonsOnlyTaintKey,
+		Value: Reservation{
+			UID:      criticalPod.UID,
+			Expires:  expires,
+			Instance: InstanceHash(instance),
+		}.String(),
+		Effect: v1.TaintEffectNoSchedule,
+	}
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	"k8s.io/api/core/v1"
+)
+
+// Scorer rates nodes for a critical pod; nodes with higher scores are tried
+// first. Scorers only look at the node and pod objects, so they're cheap
+// compared to the predicate checks.
+type Scorer interface {
+	// Name identifies the scorer in --node-scorers.
+	Name() string
+	Score(node *v1.Node, pod *v1.Pod) int64
+}
+
+// OrderNodes returns <nodes> sorted by <scorers>. Scorers are applied in
+// order, each one only breaking the ties left by the previous ones; nodes
+// with equal scores keep their relative order.
+func OrderNodes(nodes []*v1.Node, pod *v1.Pod, scorers []Scorer) []*v1.Node {
+	ordered := append([]*v1.Node{}, nodes...)
+	if len(scorers) == 0 {
+		return ordered
+	}
+	scores := make(map[*v1.Node][]int64, len(nodes))
+	for _, node := range nodes {
+		for _, scorer := range scorers {
+			scores[node] = append(scores[node], scorer.Score(node, pod))
+		}
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		a, b := scores[ordered[i]], scores[ordered[j]]
+		for k := range a {
+			if a[k] != b[k] {
+				return a[k] > b[k]
+			}
+		}
+		return false
+	})
+	return ordered
+}
+
+// ImageLocalityScorer prefers nodes which already have the pod's images, so
+// that the pod starts sooner. The score is the total size of those images.
+// Image names are matched exactly, as the scheduler does.
+type ImageLocalityScorer struct{}
+
+// Name implements Scorer.
+func (ImageLocalityScorer) Name() string {
+	return "image-locality"
+}
+
+// Score implements Scorer.
+func (ImageLocalityScorer) Score(node *v1.Node, pod *v1.Pod) int64 {
+	sizes := map[string]int64{}
+	for _, image := range node.Status.Images {
+		for _, name := range image.Names {
+			sizes[name] = image.SizeBytes
+		}
+	}
+	var score int64
+	for _, container := range pod.Spec.Containers {
+		score += sizes[container.Image]
+	}
+	return score
+}
+
+// Policies for nodes carrying an annotation, see NodeAnnotationPolicies.
+const (
+	// AnnotationPolicyExclude never reserves the node.
+	AnnotationPolicyExclude = "exclude"
+	// AnnotationPolicyAvoid tries the node after all others.
+	AnnotationPolicyAvoid = "avoid"
+	// AnnotationPolicyPrefer tries the node before all others.
+	AnnotationPolicyPrefer = "prefer"
+	// AnnotationPolicyIgnore disables a default policy.
+	AnnotationPolicyIgnore = "ignore"
+)
+
+// ScaleDownDisabledAnnotation marks nodes the cluster autoscaler won't remove.
+// Such nodes are good candidates for reserving, since they are going to stay.
+const ScaleDownDisabledAnnotation = "cluster-autoscaler.kubernetes.io/scale-down-disabled"
+
+// NodeAnnotationPolicies maps node annotation keys to one of the
+// AnnotationPolicy* values. An annotation applies if it is present with any
+// value other than "false".
+type NodeAnnotationPolicies map[string]string
+
+// Excludes returns true if <node> carries an annotation with the exclude policy.
+func (p NodeAnnotationPolicies) Excludes(node *v1.Node) bool {
+	for key, policy := range p {
+		if policy == AnnotationPolicyExclude && hasAnnotation(node, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Name implements Scorer.
+func (p NodeAnnotationPolicies) Name() string {
+	return "annotation-policy"
+}
+
+// Score implements Scorer: every preferred annotation adds one, every avoided one subtracts one.
+func (p NodeAnnotationPolicies) Score(node *v1.Node, pod *v1.Pod) int64 {
+	var score int64
+	for key, policy := range p {
+		if !hasAnnotation(node, key) {
+			continue
+		}
+		switch policy {
+		case AnnotationPolicyPrefer:
+			score++
+		case AnnotationPolicyAvoid:
+			score--
+		}
+	}
+	return score
+}
+
+func hasAnnotation(node *v1.Node, key string) bool {
+	value, found := node.Annotations[key]
+	return found && value != "false"
+}
+
+// CostScorer prefers cheaper nodes, so that critical pods don't pin expensive
+// nodes, e.g. GPU ones, when a cheaper node fits too. The cost is read from
+// the node label <Label> as a decimal number in any unit, as long as all
+// nodes use the same one. Nodes without a valid cost are tried last.
+type CostScorer struct {
+	Label string
+}
+
+// Name implements Scorer.
+func (CostScorer) Name() string {
+	return "cost"
+}
+
+// Score implements Scorer. Costs are compared with a precision of 1/1000000.
+func (s CostScorer) Score(node *v1.Node, pod *v1.Pod) int64 {
+	cost, err := strconv.ParseFloat(node.Labels[s.Label], 64)
+	if err != nil || math.IsNaN(cost) || cost < 0 || cost > maxCost {
+		return math.MinInt64
+	}
+	return -int64(cost * 1e6)
+}
+
+// maxCost keeps scaled costs within int64.
+const maxCost = 1e12
@@ -0,0 +1,111 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	kubeletapis "k8s.io/kubernetes/pkg/kubelet/apis"
+)
+
+// ZoneLabel is the GA zone label; 1.10 nodes only carry kubeletapis.LabelZoneFailureDomain.
+const ZoneLabel = "topology.kubernetes.io/zone"
+
+// ReplicaSpread remembers where replicas of each controller were placed
+// during a housekeeping pass, so that further replicas pending at the same
+// time go to other zones and nodes. Pods without a controller aren't spread.
+type ReplicaSpread struct {
+	zones map[string]int
+	nodes map[string]int
+}
+
+// NewReplicaSpread returns a ReplicaSpread with no placements.
+func NewReplicaSpread() *ReplicaSpread {
+	return &ReplicaSpread{zones: map[string]int{}, nodes: map[string]int{}}
+}
+
+// Add records that <pod> is placed on <node>.
+func (s *ReplicaSpread) Add(node *v1.Node, pod *v1.Pod) {
+	owner := controllerKey(pod)
+	if owner == "" {
+		return
+	}
+	if zone := NodeZone(node); zone != "" {
+		s.zones[owner+"/"+zone]++
+	}
+	s.nodes[owner+"/"+node.Name]++
+}
+
+// Remove forgets a placement recorded by Add.
+func (s *ReplicaSpread) Remove(node *v1.Node, pod *v1.Pod) {
+	owner := controllerKey(pod)
+	if owner == "" {
+		return
+	}
+	if zone := NodeZone(node); zone != "" {
+		s.zones[owner+"/"+zone]--
+	}
+	s.nodes[owner+"/"+node.Name]--
+}
+
+// Scorers returns the scorers preferring zones, and then nodes, with fewer
+// replicas of the pod's controller placed so far.
+func (s *ReplicaSpread) Scorers() []Scorer {
+	return []Scorer{spreadScorer{name: "zone-spread", counts: s.zones, key: NodeZone}, spreadScorer{name: "node-spread", counts: s.nodes, key: nodeName}}
+}
+
+type spreadScorer struct {
+	name   string
+	counts map[string]int
+	key    func(*v1.Node) string
+}
+
+func (s spreadScorer) Name() string {
+	return s.name
+}
+
+func (s spreadScorer) Score(node *v1.Node, pod *v1.Pod) int64 {
+	owner, key := controllerKey(pod), s.key(node)
+	if owner == "" || key == "" {
+		return 0
+	}
+	return -int64(s.counts[owner+"/"+key])
+}
+
+// NodeZone returns the zone of <node>, or "" if it's not labeled with one.
+func NodeZone(node *v1.Node) string {
+	return platformLabel(node.Labels, ZoneLabel, kubeletapis.LabelZoneFailureDomain)
+}
+
+// RequiredZones returns the zones <pod> may run in, from its node selector
+// and required node affinity, or nil if it may run in any zone.
+func RequiredZones(pod *v1.Pod) sets.String {
+	return requiredValues(pod, ZoneLabel, kubeletapis.LabelZoneFailureDomain)
+}
+
+func nodeName(node *v1.Node) string {
+	return node.Name
+}
+
+func controllerKey(pod *v1.Pod) string {
+	controller := metav1.GetControllerOf(pod)
+	if controller == nil {
+		return ""
+	}
+	return pod.Namespace + "/" + controller.Kind + "/" + controller.Name
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"sort"
+
+	"k8s.io/api/core/v1"
+)
+
+// keepOrders are the orders in which FindVictims tries to keep pods. The
+// original order comes first, so it wins ties.
+var keepOrders = []func(node *v1.Node, pods []*v1.Pod) []*v1.Pod{
+	func(node *v1.Node, pods []*v1.Pod) []*v1.Pod { return pods },
+	bySize(false),
+	bySize(true),
+}
+
+// bySize orders pods by their share of the node, smallest first unless <descending>.
+func bySize(descending bool) func(node *v1.Node, pods []*v1.Pod) []*v1.Pod {
+	return func(node *v1.Node, pods []*v1.Pod) []*v1.Pod {
+		sorted := append([]*v1.Pod{}, pods...)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			a, b := nodeShare(node, sorted[i]), nodeShare(node, sorted[j])
+			if descending {
+				return a > b
+			}
+			return a < b
+		})
+		return sorted
+	}
+}
+
+// displacement is the total share of the node requested by <victims>.
+func displacement(node *v1.Node, victims []*v1.Pod) float64 {
+	total := 0.0
+	for _, victim := range victims {
+		total += nodeShare(node, victim)
+	}
+	return total
+}
+
+// nodeShare is the sum of the fractions of the node's allocatable CPU and
+// memory requested by <pod>, so that both resources weigh the same.
+func nodeShare(node *v1.Node, pod *v1.Pod) float64 {
+	share := 0.0
+	for _, resource := range []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory} {
+		allocatable, found := node.Status.Allocatable[resource]
+		if !found || allocatable.IsZero() {
+			continue
+		}
+		var requested int64
+		for _, container := range pod.Spec.Containers {
+			if quantity, found := container.Resources.Requests[resource]; found {
+				requested += quantity.MilliValue()
+			}
+		}
+		share += float64(requested) / float64(allocatable.MilliValue())
+	}
+	return share
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes/scheme"
+	kube_record "k8s.io/client-go/tools/record"
+	"k8s.io/client-go/tools/reference"
+)
+
+// Reasons of the events emitted by the rescheduler. They are part of its
+// interface: automation may key off them, so they must not be renamed.
+const (
+	// EventReasonReservedNode is emitted on a node tainted for a critical pod.
+	EventReasonReservedNode = "ReservedNode"
+	// EventReasonReleasedNode is emitted on a node whose reservation for a critical pod was released.
+	EventReasonReleasedNode = "ReleasedNode"
+	// EventReasonEvictedForCriticalPod is emitted on a pod deleted to make room for a critical pod.
+	EventReasonEvictedForCriticalPod = "EvictedForCriticalPod"
+	// EventReasonPlacementTimedOut is emitted on a critical pod not scheduled within --pod-scheduled-timeout.
+	EventReasonPlacementTimedOut = "PlacementTimedOut"
+	// EventReasonPlacementRolledBack is emitted on a critical pod after a timed out placement was undone,
+	// or a placement was stopped because a victim couldn't be deleted.
+	EventReasonPlacementRolledBack = "PlacementRolledBack"
+	// EventReasonPlacementNodeDeleted is emitted on a critical pod whose reserved node was deleted before it was scheduled.
+	EventReasonPlacementNodeDeleted = "PlacementNodeDeleted"
+	// EventReasonPlacementAbandoned is emitted on a critical pod given up after --max-placement-attempts timed out placements.
+	EventReasonPlacementAbandoned = "PlacementAbandoned"
+	// EventReasonPlacementCancelled is emitted on a critical pod whose placement was made for an outdated version of it.
+	EventReasonPlacementCancelled = "PlacementCancelled"
+	// EventReasonEvictionFailed is emitted on a pod which couldn't be deleted to make room for a critical pod.
+	EventReasonEvictionFailed = "EvictionFailed"
+	// EventReasonEvictionBudgetExhausted is emitted on a critical pod whose placement is deferred by --eviction-budget.
+	EventReasonEvictionBudgetExhausted = "EvictionBudgetExhausted"
+	// EventReasonStaticPodNotPlaced is emitted on an unschedulable critical static pod, which only its kubelet can help.
+	EventReasonStaticPodNotPlaced = "StaticPodNotPlaced"
+	// EventReasonNoFeasibleNode is emitted on a critical pod which doesn't fit on any node.
+	EventReasonNoFeasibleNode = "NoFeasibleNode"
+	// EventReasonCapacityShortfall is emitted on the rescheduler's own pod instead
+	// of NoFeasibleNode when several critical pods don't fit on any node.
+	EventReasonCapacityShortfall = "CapacityShortfall"
+	// EventReasonTaintReleaseFailed is emitted on a node whose reservation taint couldn't be
+	// removed in --taint-release-retries housekeeping passes.
+	EventReasonTaintReleaseFailed = "TaintReleaseFailed"
+	// EventReasonReservationRestored is emitted on a node whose reservation taint was removed by someone else and re-added.
+	EventReasonReservationRestored = "ReservationRestored"
+	// EventReasonDedicatedNode and EventReasonDedicatedNodeReleased are emitted on a node
+	// which starts or stops being dedicated to critical addons by --dedicated-addon-nodes.
+	EventReasonDedicatedNode         = "DedicatedNode"
+	EventReasonDedicatedNodeReleased = "DedicatedNodeReleased"
+	// EventReasonWouldTaint and EventReasonWouldDelete are the shadow mode counterparts of ReservedNode and EvictedForCriticalPod.
+	EventReasonWouldTaint  = "WouldTaint"
+	EventReasonWouldDelete = "WouldDelete"
+	// EventReasonConfigReloaded and EventReasonConfigReloadFailed are emitted on the rescheduler's own pod.
+	EventReasonConfigReloaded     = "ConfigReloaded"
+	EventReasonConfigReloadFailed = "ConfigReloadFailed"
+)
+
+// Annotations attached to events related to a placement.
+const (
+	// CriticalPodUIDAnnotation is the UID of the critical pod the event is about.
+	CriticalPodUIDAnnotation = "rescheduler.alpha.kubernetes.io/critical-pod-uid"
+	// DecisionIDAnnotation identifies the placement decision; all events caused
+	// by the same decision carry the same ID.
+	DecisionIDAnnotation = "rescheduler.alpha.kubernetes.io/decision-id"
+	// InstanceAnnotation is the ID of the rescheduler replica which acted, see instanceID.
+	InstanceAnnotation = "rescheduler.alpha.kubernetes.io/instance"
+)
+
+// newDecisionID returns a new unique ID for a placement decision.
+func newDecisionID() string {
+	return string(uuid.NewUUID())
+}
+
+// placementAnnotations returns the event annotations for a decision about <criticalPod>.
+func placementAnnotations(criticalPod *v1.Pod, decisionID string) map[string]string {
+	annotations := map[string]string{
+		CriticalPodUIDAnnotation: string(criticalPod.UID),
+		InstanceAnnotation:       instanceID(),
+	}
+	if decisionID != "" {
+		annotations[DecisionIDAnnotation] = decisionID
+	}
+	return annotations
+}
+
+// annotatedEventRecorder is implemented by recorders which can attach
+// annotations to events. The vendored client-go EventRecorder can't.
+type annotatedEventRecorder interface {
+	AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{})
+}
+
+// annotatedEventf records an event with <annotations> if <recorder> supports
+// it, and a plain event otherwise.
+func annotatedEventf(recorder kube_record.EventRecorder, object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	if annotated, ok := recorder.(annotatedEventRecorder); ok && len(annotations) > 0 {
+		annotated.AnnotatedEventf(object, annotations, eventtype, reason, messageFmt, args...)
+		return
+	}
+	recorder.Eventf(object, eventtype, reason, messageFmt, args...)
+}
+
+// placementEventf records an event annotated with the critical pod UID and the decision ID.
+func placementEventf(recorder kube_record.EventRecorder, object runtime.Object, criticalPod *v1.Pod, decisionID, eventtype, reason, messageFmt string, args ...interface{}) {
+	annotatedEventf(recorder, object, placementAnnotations(criticalPod, decisionID), eventtype, reason, messageFmt, args...)
+}
+
+// annotatingRecorder is an EventRecorder which also supports annotated events.
+// Plain events go through the broadcaster; annotated events are created
+// directly, since the broadcaster drops annotations.
+type annotatingRecorder struct {
+	kube_record.EventRecorder
+	sink   kube_record.EventSink
+	source v1.EventSource
+}
+
+// AnnotatedEventf creates an event with <annotations> on <object>.
+func (r *annotatingRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	ref, err := reference.GetReference(scheme.Scheme, object)
+	if err != nil {
+		glog.Errorf("Could not construct reference to %#v, will not report event %v %v: %v", object, eventtype, reason, err)
+		return
+	}
+	message := fmt.Sprintf(messageFmt, args...)
+	now := metav1.Now()
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+	event := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        fmt.Sprintf("%v.%x", ref.Name, now.UnixNano()),
+			Namespace:   namespace,
+			Annotations: annotations,
+		},
+		InvolvedObject: *ref,
+		Reason:         reason,
+		Message:        message,
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           eventtype,
+		Source:         r.source,
+	}
+	glog.Infof("Event(%#v): type: '%v' reason: '%v' %v", event.InvolvedObject, eventtype, reason, message)
+	if _, err := r.sink.Create(event); err != nil {
+		glog.Warningf("Failed to record event %s on %s/%s: %v", reason, ref.Namespace, ref.Name, err)
+	}
+}
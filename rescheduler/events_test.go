@@ -0,0 +1,180 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	kube_client "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	kube_record "k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/contrib/rescheduler/metrics"
+)
+
+// namespaceEventSink creates events in their own namespace, unlike the fake
+// clientset's CreateWithEventNamespace.
+type namespaceEventSink struct {
+	client kube_client.Interface
+}
+
+func (s *namespaceEventSink) Create(event *v1.Event) (*v1.Event, error) {
+	return s.client.CoreV1().Events(event.Namespace).Create(event)
+}
+
+func (s *namespaceEventSink) Update(event *v1.Event) (*v1.Event, error) {
+	return s.client.CoreV1().Events(event.Namespace).Update(event)
+}
+
+func (s *namespaceEventSink) Patch(event *v1.Event, data []byte) (*v1.Event, error) {
+	return s.client.CoreV1().Events(event.Namespace).Patch(event.Name, types.StrategicMergePatchType, data)
+}
+
+func TestAnnotatedEvents(t *testing.T) {
+	os.Setenv("POD_NAME", "rescheduler-1")
+	defer os.Unsetenv("POD_NAME")
+	client := fake.NewSimpleClientset()
+	recorder := &annotatingRecorder{
+		EventRecorder: kube_record.NewFakeRecorder(10),
+		sink:          &namespaceEventSink{client: client},
+		source:        v1.EventSource{Component: "rescheduler"},
+	}
+	criticalPod := createTestPod("critical", "kube-system", true, true, 100)
+	criticalPod.UID = "critical-uid"
+	victim := createTestPod("victim", "default", false, false, 100)
+
+	placementEventf(recorder, victim, criticalPod, "decision-1", v1.EventTypeNormal, EventReasonEvictedForCriticalPod,
+		"Deleted for critical pod %s.", podId(criticalPod))
+
+	events, err := client.CoreV1().Events("default").List(metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(events.Items))
+	event := events.Items[0]
+	assert.Equal(t, EventReasonEvictedForCriticalPod, event.Reason)
+	assert.Equal(t, "Deleted for critical pod kube-system_critical.", event.Message)
+	assert.Equal(t, "victim", event.InvolvedObject.Name)
+	assert.Equal(t, "rescheduler", event.Source.Component)
+	assert.Equal(t, map[string]string{
+		CriticalPodUIDAnnotation: "critical-uid",
+		DecisionIDAnnotation:     "decision-1",
+		InstanceAnnotation:       "rescheduler-1",
+	}, event.Annotations)
+
+	// Recorders without annotation support still get the event.
+	fakeRecorder := kube_record.NewFakeRecorder(10)
+	placementEventf(fakeRecorder, victim, criticalPod, "decision-1", v1.EventTypeNormal, EventReasonEvictedForCriticalPod, "Deleted.")
+	assert.Equal(t, "Normal EvictedForCriticalPod Deleted.", <-fakeRecorder.Events)
+}
+
+func TestEventSinks(t *testing.T) {
+	os.Setenv("POD_NAME", "rescheduler-1")
+	defer os.Unsetenv("POD_NAME")
+	client := fake.NewSimpleClientset()
+	pod := createTestPod("victim", "default", false, false, 100)
+	node := createTestNode("node1", 1000)
+	podEvent := &v1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: "victim.1", Namespace: "default"},
+		InvolvedObject: v1.ObjectReference{Kind: "Pod", Namespace: pod.Namespace, Name: pod.Name},
+		Reason:         EventReasonEvictedForCriticalPod,
+		FirstTimestamp: metav1.Now(),
+	}
+	nodeEvent := &v1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: "node1.1", Namespace: "default"},
+		InvolvedObject: v1.ObjectReference{Kind: "Node", Name: node.Name},
+		Reason:         EventReasonReservedNode,
+		FirstTimestamp: metav1.Now(),
+	}
+
+	// Events are moved to --event-namespace, those about nodes to kube-system.
+	var sink kube_record.EventSink = &namespacedEventSink{EventSink: &namespaceEventSink{client: client}, namespace: "rescheduler-events"}
+	_, err := sink.Create(podEvent)
+	assert.NoError(t, err)
+	_, err = sink.Create(nodeEvent)
+	assert.NoError(t, err)
+	moved, err := client.CoreV1().Events("rescheduler-events").Get("victim.1", metav1.GetOptions{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "default", moved.InvolvedObject.Namespace)
+		assert.Equal(t, eventReportingController, moved.ReportingController)
+		assert.Equal(t, "rescheduler-1", moved.ReportingInstance)
+		assert.Equal(t, EventReasonEvictedForCriticalPod, moved.Action)
+		assert.False(t, moved.EventTime.IsZero())
+	}
+	_, err = client.CoreV1().Events(metav1.NamespaceSystem).Get("node1.1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "default", podEvent.Namespace, "the original event is unchanged")
+
+	// Events beyond the burst are dropped.
+	before := metricValue(t, metrics.DroppedEventsCount)
+	limited := &rateLimitedEventSink{EventSink: &namespaceEventSink{client: client}, limiter: flowcontrol.NewTokenBucketRateLimiter(0.001, 1)}
+	for _, name := range []string{"a", "b"} {
+		event := podEvent.DeepCopy()
+		event.Name = name
+		_, err := limited.Create(event)
+		assert.NoError(t, err)
+	}
+	events, err := client.CoreV1().Events("default").List(metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, events.Items, 1)
+	assert.Equal(t, before+1, metricValue(t, metrics.DroppedEventsCount))
+}
+
+func TestSeriesEventSink(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	fakeClock := clock.NewFakeClock(time.Now())
+	sink := newSeriesEventSink(client.EventsV1beta1(), fakeClock)
+	newEvent := func(name, message string) *v1.Event {
+		return &v1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: name, Namespace: "kube-system"},
+			InvolvedObject: v1.ObjectReference{Kind: "Pod", Namespace: "kube-system", Name: "critical"},
+			Type:           v1.EventTypeWarning,
+			Reason:         EventReasonNoFeasibleNode,
+			Message:        message,
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err := sink.Create(newEvent(fmt.Sprintf("critical.%d", i), "Critical pod doesn't fit on any node."))
+		assert.NoError(t, err)
+		fakeClock.Step(time.Minute)
+	}
+	_, err := sink.Create(newEvent("critical.other", "Another message."))
+	assert.NoError(t, err)
+	events, err := client.EventsV1beta1().Events("kube-system").List(metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, events.Items, 2)
+	first, err := client.EventsV1beta1().Events("kube-system").Get("critical.0", metav1.GetOptions{})
+	if assert.NoError(t, err) && assert.NotNil(t, first.Series) {
+		assert.Equal(t, int32(3), first.Series.Count)
+		assert.Equal(t, eventReportingController, first.ReportingController)
+		assert.Equal(t, EventReasonNoFeasibleNode, first.Action)
+	}
+
+	// After a pause a new series starts.
+	fakeClock.Step(eventSeriesWindow)
+	_, err = sink.Create(newEvent("critical.later", "Critical pod doesn't fit on any node."))
+	assert.NoError(t, err)
+	_, err = client.EventsV1beta1().Events("kube-system").Get("critical.later", metav1.GetOptions{})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,300 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/api/core/v1"
+	eventsv1beta1 "k8s.io/api/events/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	kube_client "k8s.io/client-go/kubernetes"
+	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
+	eventsclient "k8s.io/client-go/kubernetes/typed/events/v1beta1"
+	kube_record "k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/contrib/rescheduler/metrics"
+)
+
+// Values of --event-sink.
+const (
+	// eventSinkAPIServer writes events to the apiserver.
+	eventSinkAPIServer = "apiserver"
+	// eventSinkEventsAPI writes events to the apiserver with the events.k8s.io
+	// API, recording repeated occurrences as series.
+	eventSinkEventsAPI = "events-api"
+	// eventSinkLog only logs events.
+	eventSinkLog = "log"
+	// eventSinkNone drops events without logging them.
+	eventSinkNone = "none"
+)
+
+// eventReportingController is the reportingController of events moved to
+// --event-namespace or written with the events.k8s.io API.
+const eventReportingController = "rescheduler"
+
+// eventSeriesWindow is how soon an event has to occur again to continue the
+// series of its previous occurrence rather than start a new event.
+const eventSeriesWindow = 6 * time.Minute
+
+// maxEventSeries bounds the number of series a seriesEventSink remembers.
+// When it is exceeded, series which weren't continued within
+// eventSeriesWindow are dropped.
+const maxEventSeries = 10000
+
+// maxEventNoteLength is the longest message the apiserver accepts in events
+// with EventTime set.
+const maxEventNoteLength = 1024
+
+// newEventSink returns the sink events are written to, following
+// --event-sink, --event-namespace and --event-qps.
+func newEventSink(client kube_client.Interface) kube_record.EventSink {
+	var sink kube_record.EventSink
+	switch *eventSink {
+	case eventSinkLog:
+		return discardEventSink{}
+	case eventSinkEventsAPI:
+		series := newSeriesEventSink(client.EventsV1beta1(), clock.RealClock{})
+		metrics.RegisterCacheSize("event_series", series.Len)
+		sink = series
+	default:
+		sink = &v1core.EventSinkImpl{Interface: v1core.New(client.CoreV1().RESTClient()).Events("")}
+	}
+	if *eventNamespace != "" {
+		sink = &namespacedEventSink{EventSink: sink, namespace: *eventNamespace}
+	}
+	if *eventQPS > 0 {
+		sink = &rateLimitedEventSink{EventSink: sink, limiter: flowcontrol.NewTokenBucketRateLimiter(float32(*eventQPS), *eventBurst)}
+	}
+	return sink
+}
+
+// discardEventSink drops all events.
+type discardEventSink struct{}
+
+func (discardEventSink) Create(event *v1.Event) (*v1.Event, error) { return event, nil }
+func (discardEventSink) Update(event *v1.Event) (*v1.Event, error) { return event, nil }
+func (discardEventSink) Patch(event *v1.Event, data []byte) (*v1.Event, error) {
+	return event, nil
+}
+
+// rateLimitedEventSink drops events written faster than its limiter allows,
+// so that an event storm doesn't exhaust the event quota of a namespace.
+// Updates and patches of aggregated events count as writes too.
+type rateLimitedEventSink struct {
+	kube_record.EventSink
+	limiter flowcontrol.RateLimiter
+}
+
+func (s *rateLimitedEventSink) Create(event *v1.Event) (*v1.Event, error) {
+	if !s.accept() {
+		return event, nil
+	}
+	return s.EventSink.Create(event)
+}
+
+func (s *rateLimitedEventSink) Update(event *v1.Event) (*v1.Event, error) {
+	if !s.accept() {
+		return event, nil
+	}
+	return s.EventSink.Update(event)
+}
+
+func (s *rateLimitedEventSink) Patch(event *v1.Event, data []byte) (*v1.Event, error) {
+	if !s.accept() {
+		return event, nil
+	}
+	return s.EventSink.Patch(event, data)
+}
+
+func (s *rateLimitedEventSink) accept() bool {
+	if s.limiter.TryAccept() {
+		return true
+	}
+	metrics.DroppedEventsCount.Inc()
+	return false
+}
+
+// namespacedEventSink writes all events to one namespace. The apiserver only
+// accepts events outside the namespace of their object if they have EventTime
+// and the reporting fields set, which are filled in; events about
+// cluster-scoped objects such as nodes have to go to kube-system then.
+type namespacedEventSink struct {
+	kube_record.EventSink
+	namespace string
+}
+
+func (s *namespacedEventSink) Create(event *v1.Event) (*v1.Event, error) {
+	return s.EventSink.Create(s.move(event))
+}
+
+func (s *namespacedEventSink) Update(event *v1.Event) (*v1.Event, error) {
+	return s.EventSink.Update(s.move(event))
+}
+
+func (s *namespacedEventSink) Patch(event *v1.Event, data []byte) (*v1.Event, error) {
+	return s.EventSink.Patch(s.move(event), data)
+}
+
+func (s *namespacedEventSink) move(event *v1.Event) *v1.Event {
+	moved := event.DeepCopy()
+	moved.Namespace = s.namespace
+	if moved.InvolvedObject.Namespace == "" {
+		moved.Namespace = metav1.NamespaceSystem
+	}
+	if moved.EventTime.IsZero() {
+		moved.EventTime = metav1.NewMicroTime(moved.FirstTimestamp.Time)
+	}
+	moved.ReportingController = eventReportingController
+	moved.ReportingInstance = instanceID()
+	moved.Action = moved.Reason
+	if len(moved.Message) > maxEventNoteLength {
+		moved.Message = moved.Message[:maxEventNoteLength]
+	}
+	return moved
+}
+
+// seriesEventSink writes events with the events.k8s.io/v1beta1 API, the
+// version this client knows. An event which occurs again within
+// eventSeriesWindow - with the same type, reason and message about the same
+// object - updates the series of the event first recorded instead of
+// creating another object, so that a critical pod which stays unschedulable
+// for hours doesn't keep adding events to etcd. Updates and patches of
+// events aggregated by the broadcaster are such occurrences too.
+type seriesEventSink struct {
+	client eventsclient.EventsV1beta1Interface
+	clock  clock.Clock
+	series map[string]*eventsv1beta1.Event
+	mutex  sync.Mutex
+}
+
+func newSeriesEventSink(client eventsclient.EventsV1beta1Interface, clock clock.Clock) *seriesEventSink {
+	return &seriesEventSink{client: client, clock: clock, series: map[string]*eventsv1beta1.Event{}}
+}
+
+func (s *seriesEventSink) Create(event *v1.Event) (*v1.Event, error) {
+	return s.record(event)
+}
+
+func (s *seriesEventSink) Update(event *v1.Event) (*v1.Event, error) {
+	return s.record(event)
+}
+
+func (s *seriesEventSink) Patch(event *v1.Event, data []byte) (*v1.Event, error) {
+	return s.record(event)
+}
+
+func (s *seriesEventSink) record(event *v1.Event) (*v1.Event, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.clock.Now()
+	key := eventSeriesKey(event)
+	if previous, found := s.series[key]; found && now.Sub(lastObserved(previous)) < eventSeriesWindow {
+		continued := previous.DeepCopy()
+		if continued.Series == nil {
+			continued.Series = &eventsv1beta1.EventSeries{Count: 1}
+		}
+		continued.Series.Count++
+		continued.Series.LastObservedTime = metav1.NewMicroTime(now)
+		continued.Series.State = eventsv1beta1.EventSeriesStateOngoing
+		updated, err := s.client.Events(continued.Namespace).Update(continued)
+		if err == nil {
+			s.series[key] = updated
+			return event, nil
+		}
+		if !errors.IsNotFound(err) {
+			return nil, err
+		}
+		// the event expired meanwhile, a new one is created
+	}
+	if len(s.series) >= maxEventSeries {
+		s.prune(now)
+	}
+	created, err := s.client.Events(eventNamespaceOf(event)).Create(toEventsV1beta1(event, now))
+	if err != nil {
+		return nil, err
+	}
+	s.series[key] = created
+	return event, nil
+}
+
+func (s *seriesEventSink) prune(now time.Time) {
+	for key, event := range s.series {
+		if now.Sub(lastObserved(event)) >= eventSeriesWindow {
+			delete(s.series, key)
+		}
+	}
+}
+
+// Len returns the number of series remembered.
+func (s *seriesEventSink) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.series)
+}
+
+func eventSeriesKey(event *v1.Event) string {
+	ref := event.InvolvedObject
+	return fmt.Sprintf("%s/%s/%s/%s/%s/%s/%s", ref.Kind, ref.Namespace, ref.Name, ref.UID, event.Type, event.Reason, event.Message)
+}
+
+func lastObserved(event *eventsv1beta1.Event) time.Time {
+	if event.Series != nil {
+		return event.Series.LastObservedTime.Time
+	}
+	return event.EventTime.Time
+}
+
+// eventNamespaceOf returns the namespace of <event>, except that events about
+// cluster-scoped objects have to be in kube-system when EventTime is set.
+func eventNamespaceOf(event *v1.Event) string {
+	if event.InvolvedObject.Namespace == "" {
+		return metav1.NamespaceSystem
+	}
+	return event.Namespace
+}
+
+// toEventsV1beta1 converts <event>, first observed at <now>, to the events.k8s.io API.
+func toEventsV1beta1(event *v1.Event, now time.Time) *eventsv1beta1.Event {
+	note := event.Message
+	if len(note) > maxEventNoteLength {
+		note = note[:maxEventNoteLength]
+	}
+	return &eventsv1beta1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        event.Name,
+			Namespace:   eventNamespaceOf(event),
+			Annotations: event.Annotations,
+		},
+		EventTime:                metav1.NewMicroTime(now),
+		ReportingController:      eventReportingController,
+		ReportingInstance:        instanceID(),
+		Action:                   event.Reason,
+		Reason:                   event.Reason,
+		Regarding:                event.InvolvedObject,
+		Note:                     note,
+		Type:                     event.Type,
+		DeprecatedSource:         event.Source,
+		DeprecatedFirstTimestamp: event.FirstTimestamp,
+		DeprecatedLastTimestamp:  event.LastTimestamp,
+		DeprecatedCount:          event.Count,
+	}
+}
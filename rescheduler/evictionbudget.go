@@ -0,0 +1,103 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/contrib/rescheduler/engine"
+)
+
+// evictions counts the pods evicted for placements against the eviction
+// budget of the configuration.
+var evictions = &evictionBudget{}
+
+// evictionBudget bounds the evictions within a sliding window. Unlike the
+// disruption history it is not kept across restarts.
+type evictionBudget struct {
+	mutex sync.Mutex
+	// times are the times of evictions within the window, oldest first.
+	times []time.Time
+}
+
+// Remaining returns how many more pods may be evicted at <now>, or -1 if
+// there is no budget.
+func (b *evictionBudget) Remaining(now time.Time) int {
+	config := currentConfig()
+	if config.EvictionBudget <= 0 {
+		return -1
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.prune(now, config.EvictionBudgetWindow.Duration)
+	if remaining := config.EvictionBudget - len(b.times); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// Spend records <n> evictions at <now>.
+func (b *evictionBudget) Spend(n int, now time.Time) {
+	config := currentConfig()
+	if config.EvictionBudget <= 0 || n <= 0 {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for i := 0; i < n; i++ {
+		b.times = append(b.times, now)
+	}
+	b.prune(now, config.EvictionBudgetWindow.Duration)
+}
+
+// prune drops evictions older than <window>. The caller must hold the mutex.
+func (b *evictionBudget) prune(now time.Time, window time.Duration) {
+	i := 0
+	for i < len(b.times) && now.Sub(b.times[i]) >= window {
+		i++
+	}
+	b.times = b.times[i:]
+}
+
+// placementsByUrgency returns <placements> ordered by the urgency of their
+// pods like byUrgency, so that the eviction budget goes to the most
+// important pods; resolveConflict may have moved placements out of order.
+func placementsByUrgency(placements []*engine.Placement) []*engine.Placement {
+	sorted := append([]*engine.Placement{}, placements...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return moreUrgent(sorted[i].Pod, sorted[j].Pod)
+	})
+	return sorted
+}
+
+// withinEvictionBudget returns true if the victims of <placement> may be
+// evicted given the <remaining> budget, see evictionBudget.Remaining. Once a
+// placement didn't fit, <exhausted> is set and all later placements which
+// need evictions are deferred as well, so that the budget isn't spent on less
+// important pods needing fewer victims while a more important one waits.
+func withinEvictionBudget(placement *engine.Placement, remaining int, exhausted *bool) bool {
+	if remaining < 0 || len(placement.Victims) == 0 {
+		return true
+	}
+	if *exhausted || len(placement.Victims) > remaining {
+		*exhausted = true
+		return false
+	}
+	return true
+}
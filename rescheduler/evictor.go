@@ -0,0 +1,235 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	ca_simulator "k8s.io/autoscaler/cluster-autoscaler/simulator"
+	kube_client "k8s.io/client-go/kubernetes"
+	kube_record "k8s.io/client-go/tools/record"
+	"k8s.io/contrib/rescheduler/engine"
+	"k8s.io/contrib/rescheduler/metrics"
+)
+
+// victimStatus is what became of a victim of a placement.
+type victimStatus string
+
+const (
+	victimPending victimStatus = "pending"
+	victimEvicted victimStatus = "evicted"
+	// victimGone victims were deleted by someone else before the rescheduler got to them.
+	victimGone    victimStatus = "gone"
+	victimFailed  victimStatus = "failed"
+	victimSkipped victimStatus = "skipped"
+)
+
+// evictionDecision is how an evictionExecutor goes on after a victim couldn't be deleted.
+type evictionDecision string
+
+const (
+	// evictionContinue means the critical pod still fits once the remaining
+	// victims are gone, so the failed victim may stay.
+	evictionContinue evictionDecision = "continue"
+	// evictionRollBack means the critical pod can't fit anymore although some
+	// victims were already deleted: they are reported as deleted in vain.
+	evictionRollBack evictionDecision = "roll_back"
+	// evictionAbandon means the critical pod can't fit and nothing was deleted yet.
+	evictionAbandon evictionDecision = "abandon"
+)
+
+// evictionRetryBackoff spaces out the attempts to delete a victim after
+// transient errors; --eviction-retries limits their number instead of Steps.
+var evictionRetryBackoff = wait.Backoff{Duration: 200 * time.Millisecond, Factor: 2, Jitter: 0.1}
+
+// evictionExecutor deletes the victims of a placement on the node of
+// <snapshot>, retrying transient failures, and keeps the status of each. When
+// a victim can't be deleted, it checks whether the space freed so far and
+// still to be freed is enough for the critical pod to decide what to do.
+type evictionExecutor struct {
+	client           kube_client.Interface
+	recorder         kube_record.EventRecorder
+	predicateChecker *ca_simulator.PredicateChecker
+	snapshot         *engine.NodeSnapshot
+	criticalPod      *v1.Pod
+	decisionID       string
+
+	statuses map[string]victimStatus
+}
+
+func newEvictionExecutor(client kube_client.Interface, recorder kube_record.EventRecorder, predicateChecker *ca_simulator.PredicateChecker, snapshot *engine.NodeSnapshot, criticalPod *v1.Pod, decisionID string) *evictionExecutor {
+	return &evictionExecutor{
+		client:           client,
+		recorder:         recorder,
+		predicateChecker: predicateChecker,
+		snapshot:         snapshot,
+		criticalPod:      criticalPod,
+		decisionID:       decisionID,
+		statuses:         map[string]victimStatus{},
+	}
+}
+
+// Status returns what became of <victim>.
+func (e *evictionExecutor) Status(victim *v1.Pod) victimStatus {
+	return e.statuses[podId(victim)]
+}
+
+// Run deletes <victims> in order and returns those which were deleted. It
+// returns an error if the placement is cancelled, rolled back or abandoned,
+// in which case the remaining victims are skipped.
+func (e *evictionExecutor) Run(ctx context.Context, victims []*v1.Pod) ([]*v1.Pod, error) {
+	for _, p := range victims {
+		e.statuses[podId(p)] = victimPending
+	}
+
+	node := e.snapshot.Node
+	deleted := []*v1.Pod{}
+	for i, p := range victims {
+		if ctx.Err() != nil {
+			e.skip(victims[i:], "cancelled")
+			return deleted, fmt.Errorf("Stopped preparing node %v for pod %s: %v", node.Name, podId(e.criticalPod), ctx.Err())
+		}
+		glog.Infof("Pod %s will be deleted in order to schedule critical pod %s.", podId(p), podId(e.criticalPod))
+		err := e.evict(ctx, p)
+		switch {
+		case err == nil:
+			e.statuses[podId(p)] = victimEvicted
+			placementEventf(e.recorder, p, e.criticalPod, e.decisionID, v1.EventTypeNormal, EventReasonEvictedForCriticalPod,
+				"Deleted by rescheduler in order to schedule critical pod %s.", podId(e.criticalPod))
+			metrics.DeletedPodsCount.Inc()
+			recordAction(action{Verb: actionEvict, Node: node.Name, Pod: podId(p), CriticalPod: podId(e.criticalPod), DecisionID: e.decisionID})
+			deleted = append(deleted, p)
+		case errors.IsNotFound(err):
+			glog.V(2).Infof("Pod %s was already gone when it was to be deleted for critical pod %s", podId(p), podId(e.criticalPod))
+			e.statuses[podId(p)] = victimGone
+			metrics.SparedVictimsCount.WithLabelValues("gone").Inc()
+		case ctx.Err() != nil:
+			// cancelled while retrying
+			e.skip(victims[i:], "cancelled")
+			return deleted, fmt.Errorf("Stopped preparing node %v for pod %s: %v", node.Name, podId(e.criticalPod), ctx.Err())
+		default:
+			e.statuses[podId(p)] = victimFailed
+			evictionErr := &engine.EvictionError{Pod: podId(p), Err: err}
+			placementEventf(e.recorder, p, e.criticalPod, e.decisionID, v1.EventTypeWarning, EventReasonEvictionFailed,
+				"Couldn't be deleted to make room for critical pod %s: %v", podId(e.criticalPod), err)
+			decision := e.decide(deleted)
+			metrics.EvictionFailuresCount.WithLabelValues(string(decision)).Inc()
+			if decision == evictionContinue {
+				glog.Warningf("%v; critical pod %s still fits on node %v without it", evictionErr, podId(e.criticalPod), node.Name)
+				continue
+			}
+			e.skip(victims[i+1:], "eviction_failed")
+			if decision == evictionRollBack {
+				e.rollBack(deleted, evictionErr)
+			}
+			return deleted, evictionErr
+		}
+	}
+	return deleted, nil
+}
+
+// evict deletes <victim>, retrying transient errors up to --eviction-retries
+// times. It gives up with the error of <ctx> once that is done.
+func (e *evictionExecutor) evict(ctx context.Context, victim *v1.Pod) error {
+	deleteOptions := metav1.DeleteOptions{}
+	gracePeriodSeconds := int64(currentConfig().GracePeriod.Seconds())
+	if gracePeriodSeconds >= 0 && (victim.Spec.TerminationGracePeriodSeconds == nil || *victim.Spec.TerminationGracePeriodSeconds > gracePeriodSeconds) {
+		deleteOptions.GracePeriodSeconds = &gracePeriodSeconds
+	}
+
+	// wait.ExponentialBackoff can't be interrupted while it sleeps, so the
+	// retries are spaced out here to give up as soon as <ctx> is done.
+	backoff := evictionRetryBackoff
+	for attempt := 0; ; attempt++ {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		err := e.client.CoreV1().Pods(victim.Namespace).Delete(victim.Name, &deleteOptions)
+		if err == nil || !isTransientEvictionError(err) || attempt >= *evictionRetries {
+			return err
+		}
+		glog.V(2).Infof("Retrying to delete pod %s: %v", podId(victim), err)
+		delay := backoff.Duration
+		if backoff.Jitter > 0 {
+			delay = wait.Jitter(delay, backoff.Jitter)
+		}
+		backoff.Duration = time.Duration(float64(backoff.Duration) * backoff.Factor)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// isTransientEvictionError tells whether deleting a pod may succeed if retried.
+func isTransientEvictionError(err error) bool {
+	return errors.IsTooManyRequests(err) || errors.IsServerTimeout(err) || errors.IsTimeout(err) ||
+		errors.IsServiceUnavailable(err) || errors.IsInternalError(err)
+}
+
+// decide tells how to go on after a victim couldn't be deleted, given the
+// victims <deleted> so far. The critical pod is checked against the node
+// without the victims which were deleted, are gone or are still pending.
+func (e *evictionExecutor) decide(deleted []*v1.Pod) evictionDecision {
+	remaining := *e.snapshot
+	remaining.Pods = nil
+	for _, p := range e.snapshot.Pods {
+		if status, isVictim := e.statuses[podId(p)]; !isVictim || status == victimFailed {
+			remaining.Pods = append(remaining.Pods, p)
+		}
+	}
+	if engine.FitsWithoutEvictions(e.predicateChecker, &remaining, e.criticalPod) == nil {
+		return evictionContinue
+	}
+	if len(deleted) == 0 {
+		return evictionAbandon
+	}
+	return evictionRollBack
+}
+
+// skip marks <victims> as skipped for <reason>.
+func (e *evictionExecutor) skip(victims []*v1.Pod, reason string) {
+	for _, p := range victims {
+		e.statuses[podId(p)] = victimSkipped
+	}
+	if len(victims) > 0 {
+		metrics.SkippedEvictionsCount.WithLabelValues(reason).Add(float64(len(victims)))
+	}
+}
+
+// rollBack reports the pods <deleted> for a placement which failed with <err>.
+// The reservation itself is released by the caller.
+func (e *evictionExecutor) rollBack(deleted []*v1.Pod, err error) {
+	victims := []string{}
+	for _, victim := range deleted {
+		victims = append(victims, podId(victim))
+	}
+	metrics.EvictedInVainCount.Add(float64(len(victims)))
+	glog.Warningf("Rolling back placement of critical pod %s on node %v: %v; deleted in vain: %s",
+		podId(e.criticalPod), e.snapshot.Node.Name, err, strings.Join(victims, ", "))
+	placementEventf(e.recorder, e.criticalPod, e.criticalPod, e.decisionID, v1.EventTypeWarning, EventReasonPlacementRolledBack,
+		"Stopped preparing node %s for critical pod %s: %v; deleted in vain: %s.", e.snapshot.Node.Name, podId(e.criticalPod), err, strings.Join(victims, ", "))
+}
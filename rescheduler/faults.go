@@ -0,0 +1,162 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	kube_client "k8s.io/client-go/kubernetes"
+	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/contrib/rescheduler/metrics"
+)
+
+const (
+	// faultTaintConflict makes node updates fail with a conflict.
+	faultTaintConflict = "taint-conflict"
+	// faultDeleteForbidden makes pod deletions fail with 403 Forbidden.
+	faultDeleteForbidden = "delete-forbidden"
+	// faultNeverBind makes a placement never see its pod scheduled, as if the
+	// scheduler didn't bind it.
+	faultNeverBind = "never-bind"
+)
+
+var knownFaults = sets.NewString(faultDeleteForbidden, faultNeverBind, faultTaintConflict)
+
+// faults injects the failures configured with --inject-faults. It injects
+// nothing unless configured.
+var faults = &faultInjector{}
+
+type faultInjector struct {
+	rates map[string]float64
+	rand  *rand.Rand
+	mutex sync.Mutex
+}
+
+// parseFaultRates parses a comma separated list of fault=rate pairs, where
+// rate is the probability in [0, 1] of injecting the fault.
+func parseFaultRates(spec string) (map[string]float64, error) {
+	rates := map[string]float64{}
+	if spec == "" {
+		return rates, nil
+	}
+	for _, pair := range strings.Split(spec, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("expected fault=rate, got %q", pair)
+		}
+		fault := parts[0]
+		if !knownFaults.Has(fault) {
+			return nil, fmt.Errorf("unknown fault %q, must be one of %s", fault, strings.Join(knownFaults.List(), ", "))
+		}
+		rate, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("rate of %s must be a number between 0 and 1, got %q", fault, parts[1])
+		}
+		rates[fault] = rate
+	}
+	return rates, nil
+}
+
+// Configure sets the rates at which faults are injected.
+func (f *faultInjector) Configure(rates map[string]float64, seed int64) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.rates = rates
+	f.rand = rand.New(rand.NewSource(seed))
+}
+
+// Inject returns true if <fault> should be injected now.
+func (f *faultInjector) Inject(fault string) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	rate := f.rates[fault]
+	if rate <= 0 || f.rand.Float64() >= rate {
+		return false
+	}
+	metrics.InjectedFaultsCount.WithLabelValues(fault).Inc()
+	return true
+}
+
+// withInjectedFaults configures the faults of --inject-faults and wraps
+// <client> so that its node updates and pod deletions fail at the configured
+// rates. The client is returned as is if no faults are configured.
+func withInjectedFaults(client kube_client.Interface, spec string) (kube_client.Interface, error) {
+	rates, err := parseFaultRates(spec)
+	if err != nil || len(rates) == 0 {
+		return client, err
+	}
+	glog.Warningf("Injecting faults at rates %v; this is meant for testing only", rates)
+	faults.Configure(rates, time.Now().UnixNano())
+	return &faultyClient{Interface: client}, nil
+}
+
+type faultyClient struct {
+	kube_client.Interface
+}
+
+func (c *faultyClient) CoreV1() v1core.CoreV1Interface {
+	return &faultyCoreV1{CoreV1Interface: c.Interface.CoreV1()}
+}
+
+// Core is the same as CoreV1, so that callers of either see the faults.
+func (c *faultyClient) Core() v1core.CoreV1Interface {
+	return c.CoreV1()
+}
+
+type faultyCoreV1 struct {
+	v1core.CoreV1Interface
+}
+
+func (c *faultyCoreV1) Nodes() v1core.NodeInterface {
+	return &faultyNodes{NodeInterface: c.CoreV1Interface.Nodes()}
+}
+
+func (c *faultyCoreV1) Pods(namespace string) v1core.PodInterface {
+	return &faultyPods{PodInterface: c.CoreV1Interface.Pods(namespace)}
+}
+
+type faultyNodes struct {
+	v1core.NodeInterface
+}
+
+func (n *faultyNodes) Update(node *v1.Node) (*v1.Node, error) {
+	if faults.Inject(faultTaintConflict) {
+		return nil, errors.NewConflict(v1.Resource("nodes"), node.Name, fmt.Errorf("injected fault"))
+	}
+	return n.NodeInterface.Update(node)
+}
+
+type faultyPods struct {
+	v1core.PodInterface
+}
+
+func (p *faultyPods) Delete(name string, options *metav1.DeleteOptions) error {
+	if faults.Inject(faultDeleteForbidden) {
+		return errors.NewForbidden(v1.Resource("pods"), name, fmt.Errorf("injected fault"))
+	}
+	return p.PodInterface.Delete(name, options)
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"time"
+
+	"github.com/hashicorp/golang-lru"
+	"k8s.io/api/core/v1"
+	"k8s.io/contrib/rescheduler/engine"
+	"k8s.io/contrib/rescheduler/metrics"
+)
+
+const (
+	// maxFitFailures bounds the number of fit failures fitFailures remembers,
+	// the least recently used ones are dropped first.
+	maxFitFailures = 10000
+	// fitFailureMaxAge is how long a fit failure is trusted. It covers what
+	// engine.FitFingerprint doesn't see, like volumes and pods on other nodes.
+	fitFailureMaxAge = 10 * time.Minute
+)
+
+// fitFailures remembers why critical pods didn't fit on nodes, so that a
+// node isn't run through the predicates for a pod again in every
+// housekeeping pass while neither of them changed.
+var fitFailures = newFitFailureCache(maxFitFailures)
+
+type fitFailure struct {
+	fingerprint uint64
+	err         error
+	at          time.Time
+}
+
+type fitFailureCache struct {
+	cache *lru.Cache
+}
+
+func newFitFailureCache(size int) *fitFailureCache {
+	cache, err := lru.New(size)
+	if err != nil {
+		panic(err)
+	}
+	return &fitFailureCache{cache: cache}
+}
+
+func fitFailureKey(node *v1.Node, pod *v1.Pod) string {
+	return node.Name + "/" + podId(pod) + "/" + string(pod.UID)
+}
+
+// Get returns why <pod> didn't fit on the node of <snapshot>, if that was
+// found with the same engine.FitFingerprint within fitFailureMaxAge before
+// <now>, and nil otherwise.
+func (c *fitFailureCache) Get(snapshot *engine.NodeSnapshot, pod *v1.Pod, fingerprint uint64, now time.Time) error {
+	if value, found := c.cache.Get(fitFailureKey(snapshot.Node, pod)); found {
+		failure := value.(fitFailure)
+		if failure.fingerprint == fingerprint && now.Sub(failure.at) < fitFailureMaxAge {
+			metrics.FitFailureLookupsCount.WithLabelValues("hit").Inc()
+			return failure.err
+		}
+	}
+	metrics.FitFailureLookupsCount.WithLabelValues("miss").Inc()
+	return nil
+}
+
+// Set records the result of engine.CheckNode for <pod> on the node of
+// <snapshot>. Only failures are kept.
+func (c *fitFailureCache) Set(snapshot *engine.NodeSnapshot, pod *v1.Pod, fingerprint uint64, err error, now time.Time) {
+	key := fitFailureKey(snapshot.Node, pod)
+	if err == nil {
+		c.cache.Remove(key)
+		return
+	}
+	c.cache.Add(key, fitFailure{fingerprint: fingerprint, err: err, at: now})
+}
+
+// Len returns the number of fit failures remembered.
+func (c *fitFailureCache) Len() int {
+	return c.cache.Len()
+}
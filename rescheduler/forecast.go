@@ -0,0 +1,133 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/clock"
+	ca_simulator "k8s.io/autoscaler/cluster-autoscaler/simulator"
+	kube_utils "k8s.io/autoscaler/cluster-autoscaler/utils/kubernetes"
+	kube_client "k8s.io/client-go/kubernetes"
+)
+
+// forecastCacheSyncDelay is how long --forecast waits for the informers of the
+// predicate checker to fill, which can't be waited for directly.
+const forecastCacheSyncDelay = 10 * time.Second
+
+// apiUnschedulablePodLister lists pods in the system namespace which aren't
+// bound to a node straight from the apiserver, for one-off runs which don't
+// wait for informers.
+type apiUnschedulablePodLister struct {
+	client kube_client.Interface
+}
+
+func (l *apiUnschedulablePodLister) List() ([]*v1.Pod, error) {
+	podList, err := l.client.CoreV1().Pods(*systemNamespace).List(
+		metav1.ListOptions{FieldSelector: fields.SelectorFromSet(fields.Set{"spec.nodeName": ""}).String()})
+	if err != nil {
+		return nil, err
+	}
+	pods := []*v1.Pod{}
+	for i := range podList.Items {
+		pods = append(pods, &podList.Items[i])
+	}
+	return pods, nil
+}
+
+// apiReadyNodeLister lists ready and schedulable nodes of the shard straight
+// from the apiserver.
+type apiReadyNodeLister struct {
+	client kube_client.Interface
+}
+
+func (l *apiReadyNodeLister) List() ([]*v1.Node, error) {
+	options := metav1.ListOptions{}
+	nodeShardListOptions(&options)
+	nodeList, err := l.client.CoreV1().Nodes().List(options)
+	if err != nil {
+		return nil, err
+	}
+	nodes := []*v1.Node{}
+	for i := range nodeList.Items {
+		if kube_utils.IsNodeReadyAndSchedulable(&nodeList.Items[i]) {
+			nodes = append(nodes, &nodeList.Items[i])
+		}
+	}
+	return nodes, nil
+}
+
+// forecast writes to <w>, in <format>, the plan for the critical pods which
+// are pending now: where each would be placed and which pods would be
+// deleted. Nothing in the cluster is changed.
+func (r *rescheduler) forecast(ctx context.Context, w io.Writer, format string) error {
+	pods, err := r.unschedulablePodLister.List()
+	if err != nil {
+		return fmt.Errorf("failed to list unschedulable pods: %v", err)
+	}
+	plan := r.buildPlan(ctx, filterCriticalDaemonSetPods(pods, r.podsBeingProcessed))
+	data, err := plan.Print(format)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+// runForecast implements --forecast: it builds the plan once from the live
+// cluster state, prints it to <w> and returns.
+func runForecast(w io.Writer, format string) error {
+	config, err := loadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	activeConfig.Set(config)
+	client, err := createKubeClient(*inCluster)
+	if err != nil {
+		return err
+	}
+	if err := disruptions.Load(client); err != nil {
+		return fmt.Errorf("failed to load disruption history: %v", err)
+	}
+	if scorers, err = newScorers(*nodeScorers); err != nil {
+		return err
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	predicateChecker, err := ca_simulator.NewPredicateChecker(client, stop)
+	if err != nil {
+		return err
+	}
+	time.Sleep(forecastCacheSyncDelay)
+
+	r := &rescheduler{
+		client:                 client,
+		predicateChecker:       predicateChecker,
+		unschedulablePodLister: &apiUnschedulablePodLister{client: client},
+		nodeLister:             &apiReadyNodeLister{client: client},
+		podsBeingProcessed:     NewPodSet(),
+		killSwitch:             &killSwitch{},
+		clock:                  clock.RealClock{},
+	}
+	return r.forecast(context.Background(), w, format)
+}
@@ -0,0 +1,62 @@
+#!/bin/bash
+
+# Copyright 2017 The Kubernetes Authors.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+# Creates a single node kind cluster, runs the rescheduler against it from
+# the host and runs the smoke test in e2e/. The cluster is deleted afterwards
+# unless KEEP_CLUSTER is set.
+
+set -o errexit
+set -o nounset
+set -o pipefail
+
+CLUSTER_NAME=${CLUSTER_NAME:-rescheduler-smoke}
+# The rescheduler is built against the Kubernetes 1.10 APIs.
+KIND_NODE_IMAGE=${KIND_NODE_IMAGE:-kindest/node:v1.13.12}
+ROOT=$(cd "$(dirname "${BASH_SOURCE[0]}")/.." && pwd)
+WORK_DIR=$(mktemp -d)
+KUBECONFIG_PATH="${WORK_DIR}/kubeconfig"
+RESCHEDULER_PID=
+
+cleanup() {
+  if [[ -n "${RESCHEDULER_PID}" ]]; then
+    kill "${RESCHEDULER_PID}" || true
+  fi
+  if [[ -z "${KEEP_CLUSTER:-}" ]]; then
+    kind delete cluster --name "${CLUSTER_NAME}" || true
+  fi
+  rm -rf "${WORK_DIR}"
+}
+trap cleanup EXIT
+
+cd "${ROOT}"
+go build -o "${WORK_DIR}/rescheduler"
+
+kind create cluster --name "${CLUSTER_NAME}" --image "${KIND_NODE_IMAGE}" --wait 2m
+kind get kubeconfig --name "${CLUSTER_NAME}" > "${KUBECONFIG_PATH}"
+
+KUBECONFIG="${KUBECONFIG_PATH}" "${WORK_DIR}/rescheduler" \
+  --running-in-cluster=false \
+  --initial-delay=0s \
+  --housekeeping-interval=5s \
+  --listen-address=127.0.0.1:0 \
+  --v=2 > "${WORK_DIR}/rescheduler.log" 2>&1 &
+RESCHEDULER_PID=$!
+
+if ! go test -tags e2e -v ./e2e/ -kubeconfig="${KUBECONFIG_PATH}"; then
+  echo "Smoke test failed, rescheduler log:"
+  cat "${WORK_DIR}/rescheduler.log"
+  exit 1
+fi
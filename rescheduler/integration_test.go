@@ -0,0 +1,969 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	kube_client "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	kube_record "k8s.io/client-go/tools/record"
+	"k8s.io/contrib/rescheduler/engine"
+	"k8s.io/contrib/rescheduler/metrics"
+	"k8s.io/contrib/rescheduler/synthetic"
+)
+
+// clientNodeLister lists nodes directly from the client, so that updates made
+// by the rescheduler are visible immediately.
+type clientNodeLister struct {
+	client kube_client.Interface
+}
+
+func (l *clientNodeLister) List() ([]*v1.Node, error) {
+	nodeList, err := l.client.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	nodes := []*v1.Node{}
+	for i := range nodeList.Items {
+		nodes = append(nodes, &nodeList.Items[i])
+	}
+	return nodes, nil
+}
+
+// clientUnschedulablePodLister lists pods in kube-system which are not bound to a node.
+type clientUnschedulablePodLister struct {
+	client kube_client.Interface
+}
+
+func (l *clientUnschedulablePodLister) List() ([]*v1.Pod, error) {
+	podList, err := l.client.CoreV1().Pods(metav1.NamespaceSystem).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	pods := []*v1.Pod{}
+	for i := range podList.Items {
+		if podList.Items[i].Spec.NodeName == "" {
+			pods = append(pods, &podList.Items[i])
+		}
+	}
+	return pods, nil
+}
+
+// clientPendingBoundPodLister lists pods in kube-system which are bound to a
+// node but pending.
+type clientPendingBoundPodLister struct {
+	client kube_client.Interface
+}
+
+func (l *clientPendingBoundPodLister) List() ([]*v1.Pod, error) {
+	podList, err := l.client.CoreV1().Pods(metav1.NamespaceSystem).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	pods := []*v1.Pod{}
+	for i := range podList.Items {
+		if podList.Items[i].Spec.NodeName != "" && podList.Items[i].Status.Phase == v1.PodPending {
+			pods = append(pods, &podList.Items[i])
+		}
+	}
+	return pods, nil
+}
+
+// newIntegrationCluster returns a cluster with a critical pod of <criticalCPU>
+// pending, a 1000m node where it fits after evicting "b" and "c", and a node
+// which is too small for it.
+func newIntegrationCluster(criticalCPU int64) *synthetic.Cluster {
+	cluster := &synthetic.Cluster{
+		Nodes: []*v1.Node{
+			synthetic.NewNode("node-0", 1000),
+			synthetic.NewNode("node-1", 400),
+		},
+	}
+	for _, p := range []struct {
+		pod  *v1.Pod
+		node string
+	}{
+		{synthetic.NewCriticalDaemonSetPod("ds", 200), "node-0"},
+		{synthetic.NewPod("a", "default", 300), "node-0"},
+		{synthetic.NewPod("b", "default", 300), "node-0"},
+		{synthetic.NewPod("c", "default", 200), "node-0"},
+		{synthetic.NewCriticalDaemonSetPod("critical", criticalCPU), ""},
+	} {
+		p.pod.Spec.NodeName = p.node
+		cluster.Pods = append(cluster.Pods, p.pod)
+	}
+	return cluster
+}
+
+func newTestRescheduler(client kube_client.Interface, recorder kube_record.EventRecorder) *rescheduler {
+	return &rescheduler{
+		client:                 client,
+		recorder:               recorder,
+		predicateChecker:       simulator.NewTestPredicateChecker(),
+		unschedulablePodLister: &clientUnschedulablePodLister{client: client},
+		pendingBoundPodLister:  &clientPendingBoundPodLister{client: client},
+		nodeLister:             &clientNodeLister{client: client},
+		podsBeingProcessed:     NewPodSet(),
+		killSwitch:             &killSwitch{},
+		clock:                  clock.NewFakeClock(time.Now()),
+	}
+}
+
+// reservedPods returns the pods the reservation taints of the node are for,
+// as recorded in its reservation ledger, or the values of taints without an
+// entry, such as the dedicated node taint.
+func reservedPods(t *testing.T, client kube_client.Interface, nodeName string) []string {
+	node, err := client.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+	assert.NoError(t, err)
+	ledger := reservationLedger(node)
+	pods := []string{}
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == criticalAddonsOnlyTaintKey {
+			if entry, found := ledger[taint.Value]; found {
+				pods = append(pods, entry.Pod)
+			} else {
+				pods = append(pods, taint.Value)
+			}
+		}
+	}
+	return pods
+}
+
+func existingPods(t *testing.T, client kube_client.Interface, names ...string) []string {
+	existing := []string{}
+	for _, name := range names {
+		_, err := client.CoreV1().Pods("default").Get(name, metav1.GetOptions{})
+		if err == nil {
+			existing = append(existing, name)
+		} else if !errors.IsNotFound(err) {
+			t.Errorf("unexpected error getting pod %s: %v", name, err)
+		}
+	}
+	return existing
+}
+
+func bindPod(t *testing.T, client kube_client.Interface, name, nodeName string) {
+	pod, err := client.CoreV1().Pods(metav1.NamespaceSystem).Get(name, metav1.GetOptions{})
+	assert.NoError(t, err)
+	pod.Spec.NodeName = nodeName
+	_, err = client.CoreV1().Pods(metav1.NamespaceSystem).Update(pod)
+	assert.NoError(t, err)
+}
+
+func waitForNotProcessing(t *testing.T, r *rescheduler, id string) {
+	stepClockUntil(t, r.clock.(*clock.FakeClock), time.Minute, func() bool {
+		return !r.podsBeingProcessed.HasId(id)
+	})
+}
+
+func TestHousekeepingIntegration(t *testing.T) {
+	const criticalId = "kube-system_critical"
+
+	testCases := []struct {
+		name        string
+		criticalCPU int64
+		shadow      bool
+		inject      func(client *fake.Clientset)
+		// expectations right after the first housekeeping pass
+		expectPods       []string
+		expectTaints     []string
+		expectProcessing bool
+		expectEvent      string
+		// rejectEvent must not have been recorded after the first housekeeping pass.
+		rejectEvent string
+		// expectOutcome is the placement outcome counted once the placement is over.
+		expectOutcome string
+		// bind decides whether the critical pod gets scheduled after the placement.
+		bind bool
+	}{
+		{
+			name:             "successful placement",
+			criticalCPU:      500,
+			expectPods:       []string{"a"},
+			expectTaints:     []string{criticalId},
+			expectProcessing: true,
+			expectEvent:      EventReasonEvictedForCriticalPod,
+			bind:             true,
+			expectOutcome:    "success",
+		},
+		{
+			name:             "placement times out",
+			criticalCPU:      500,
+			expectPods:       []string{"a"},
+			expectTaints:     []string{criticalId},
+			expectProcessing: true,
+			expectEvent:      EventReasonEvictedForCriticalPod,
+			bind:             false,
+			expectOutcome:    "timeout",
+		},
+		{
+			name:        "shadow mode",
+			criticalCPU: 500,
+			shadow:      true,
+			expectPods:  []string{"a", "b", "c"},
+			expectEvent: "WouldTaint",
+		},
+		{
+			name:        "kill switch engaged",
+			criticalCPU: 500,
+			inject: func(client *fake.Clientset) {
+				client.CoreV1().Namespaces().Create(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+					Name:        "kube-system",
+					Annotations: map[string]string{killSwitchAnnotation: "true"},
+				}})
+			},
+			expectPods: []string{"a", "b", "c"},
+		},
+		{
+			name:          "no feasible node",
+			criticalCPU:   2000,
+			expectPods:    []string{"a", "b", "c"},
+			expectEvent:   EventReasonNoFeasibleNode,
+			expectOutcome: "no_node",
+		},
+		{
+			name:        "taint update conflict",
+			criticalCPU: 500,
+			inject: func(client *fake.Clientset) {
+				client.PrependReactor("update", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+					return true, nil, errors.NewConflict(v1.Resource("nodes"), "node-0", fmt.Errorf("injected conflict"))
+				})
+			},
+			expectPods:    []string{"a", "b", "c"},
+			expectOutcome: "failed",
+		},
+		{
+			name:        "delete error",
+			criticalCPU: 500,
+			inject: func(client *fake.Clientset) {
+				client.PrependReactor("delete", "pods", func(action core.Action) (bool, runtime.Object, error) {
+					return true, nil, fmt.Errorf("injected delete error")
+				})
+			},
+			expectPods:    []string{"a", "b", "c"},
+			expectEvent:   EventReasonEvictionFailed,
+			rejectEvent:   EventReasonEvictedForCriticalPod,
+			expectOutcome: "failed",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := configFromFlags()
+			config.ShadowMode = tc.shadow
+			activeConfig.Set(config)
+			defer activeConfig.Set(configFromFlags())
+			defer resetFailedPlacements()
+
+			client := newIntegrationCluster(tc.criticalCPU).Clientset()
+			if tc.inject != nil {
+				tc.inject(client)
+			}
+			recorder := kube_record.NewFakeRecorder(100)
+			r := newTestRescheduler(client, recorder)
+			outcomes := placementOutcomes(t)
+			succeeded := metricValue(t, metrics.SuccessfulPlacementPathsCount.WithLabelValues("evictions"))
+
+			r.housekeeping(context.Background())
+
+			assert.Equal(t, tc.expectPods, existingPods(t, client, "a", "b", "c"))
+			if tc.expectTaints == nil {
+				tc.expectTaints = []string{}
+			}
+			assert.Equal(t, tc.expectTaints, reservedPods(t, client, "node-0"))
+			assert.Equal(t, tc.expectProcessing, r.podsBeingProcessed.HasId(criticalId))
+			events := drainEvents(recorder)
+			if tc.expectEvent != "" {
+				assert.Contains(t, events, tc.expectEvent)
+			}
+			if tc.rejectEvent != "" {
+				assert.NotContains(t, events, tc.rejectEvent)
+			}
+			if tc.expectProcessing {
+				if tc.bind {
+					bindPod(t, client, "critical", "node-0")
+				}
+				waitForNotProcessing(t, r, criticalId)
+				releaseAllTaints(context.Background(), client, r.recorder, r.nodeLister, r.podsBeingProcessed)
+				assert.Equal(t, []string{}, reservedPods(t, client, "node-0"))
+			}
+			expectOutcomes := map[string]float64{}
+			for outcome, count := range outcomes {
+				expectOutcomes[outcome] = count
+			}
+			if tc.expectOutcome != "" {
+				expectOutcomes[tc.expectOutcome]++
+			}
+			assert.Equal(t, expectOutcomes, placementOutcomes(t))
+			if tc.expectOutcome == "success" {
+				succeeded++
+			}
+			assert.Equal(t, succeeded, metricValue(t, metrics.SuccessfulPlacementPathsCount.WithLabelValues("evictions")))
+		})
+	}
+}
+
+// resetFailedPlacements forgets the failed placements of all pods, so that
+// timeouts of one test don't exclude nodes in the next one.
+func resetFailedPlacements() {
+	failedPlacements.mutex.Lock()
+	defer failedPlacements.mutex.Unlock()
+	failedPlacements.placements = map[string]failedPlacement{}
+}
+
+// placementOutcomes returns the current values of the placements counter for
+// the critical pod in newIntegrationCluster, by outcome.
+func placementOutcomes(t *testing.T) map[string]float64 {
+	outcomes := map[string]float64{}
+	for _, outcome := range []string{"success", "timeout", "aborted", "failed", "cancelled", "node_deleted", "no_node"} {
+		outcomes[outcome] = metricValue(t, metrics.PlacementsCount.WithLabelValues(outcome, "unknown"))
+	}
+	return outcomes
+}
+
+// drainEvents returns all events recorded so far, one per line.
+func drainEvents(recorder *kube_record.FakeRecorder) string {
+	events := []string{}
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return strings.Join(events, "\n")
+		}
+	}
+}
+
+func TestPlacementOnDeletedNode(t *testing.T) {
+	client := newIntegrationCluster(500).Clientset()
+	recorder := kube_record.NewFakeRecorder(100)
+	r := newTestRescheduler(client, recorder)
+	r.retryNow = make(chan struct{}, 1)
+	outcomes := placementOutcomes(t)
+
+	r.housekeeping(context.Background())
+	assert.Equal(t, []string{"kube-system_critical"}, reservedPods(t, client, "node-0"))
+	assert.NoError(t, client.CoreV1().Nodes().Delete("node-0", nil))
+	waitForNotProcessing(t, r, "kube-system_critical")
+
+	assert.Contains(t, drainEvents(recorder), EventReasonPlacementNodeDeleted)
+	outcomes["node_deleted"]++
+	assert.Equal(t, outcomes, placementOutcomes(t))
+	select {
+	case <-r.retryNow:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("the placement wasn't retried")
+	}
+}
+
+func TestRunUsesClock(t *testing.T) {
+	client := newIntegrationCluster(500).Clientset()
+	r := newTestRescheduler(client, kube_record.NewFakeRecorder(100))
+	fakeClock := r.clock.(*clock.FakeClock)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.run(ctx)
+		close(done)
+	}()
+
+	// Nothing happens during the initial delay.
+	stepClockUntil(t, fakeClock, 0, fakeClock.HasWaiters)
+	fakeClock.Step(*initialDelay / 2)
+	assert.Equal(t, []string{}, reservedPods(t, client, "node-0"))
+
+	stepClockUntil(t, fakeClock, currentConfig().HousekeepingInterval.Duration, func() bool {
+		return r.podsBeingProcessed.HasId("kube-system_critical")
+	})
+	assert.Equal(t, []string{"kube-system_critical"}, reservedPods(t, client, "node-0"))
+	cancel()
+	<-done
+}
+
+func TestTimedOutPlacementIsRolledBack(t *testing.T) {
+	const criticalId = "kube-system_critical"
+	client := newIntegrationCluster(500).Clientset()
+	recorder := kube_record.NewFakeRecorder(100)
+	r := newTestRescheduler(client, recorder)
+	inVain := metricValue(t, metrics.EvictedInVainCount)
+
+	r.housekeeping(context.Background())
+	assert.Equal(t, []string{criticalId}, reservedPods(t, client, "node-0"))
+	waitForNotProcessing(t, r, criticalId)
+
+	// The taint is released without waiting for the next housekeeping pass.
+	assert.Equal(t, []string{}, reservedPods(t, client, "node-0"))
+	events := drainEvents(recorder)
+	assert.Contains(t, events, EventReasonPlacementRolledBack)
+	assert.Contains(t, events, "deleted in vain: default_b, default_c")
+	assert.Equal(t, inVain+2, metricValue(t, metrics.EvictedInVainCount))
+
+	// The next attempt doesn't use the failed node again.
+	critical, err := client.CoreV1().Pods(metav1.NamespaceSystem).Get("critical", metav1.GetOptions{})
+	assert.NoError(t, err)
+	defer failedPlacements.Forget(critical)
+	assert.Equal(t, int64(-1), failedPlacements.Score(synthetic.NewNode("node-0", 1000), critical))
+	assert.Equal(t, int64(0), failedPlacements.Score(synthetic.NewNode("node-1", 1000), critical))
+	assert.True(t, failedPlacements.Excluded(critical, "node-0"))
+	plan := r.buildPlan(context.Background(), []*v1.Pod{critical})
+	assert.Empty(t, plan.Placements)
+	assert.Len(t, plan.Unplaceable, 1)
+}
+
+func TestTimedOutPlacementsAreGivenUp(t *testing.T) {
+	const criticalId = "kube-system_critical"
+	assert.NoError(t, flags.Set("max-placement-attempts", "1"))
+	defer flags.Set("max-placement-attempts", "3")
+	defer resetFailedPlacements()
+	client := newIntegrationCluster(500).Clientset()
+	recorder := kube_record.NewFakeRecorder(100)
+	r := newTestRescheduler(client, recorder)
+
+	r.housekeeping(context.Background())
+	waitForNotProcessing(t, r, criticalId)
+	assert.Contains(t, drainEvents(recorder), EventReasonPlacementAbandoned)
+
+	critical, err := client.CoreV1().Pods(metav1.NamespaceSystem).Get("critical", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.True(t, failedPlacements.GivenUp(critical))
+	plan := r.buildPlan(context.Background(), []*v1.Pod{critical})
+	assert.True(t, plan.IsEmpty())
+
+	// a recreated pod starts over
+	critical.UID = "recreated"
+	assert.False(t, failedPlacements.GivenUp(critical))
+}
+
+func TestPlacementCancelledWhenPodChanges(t *testing.T) {
+	const criticalId = "kube-system_critical"
+	client := newIntegrationCluster(500).Clientset()
+	recorder := kube_record.NewFakeRecorder(100)
+	r := newTestRescheduler(client, recorder)
+	outcomes := placementOutcomes(t)
+
+	r.housekeeping(context.Background())
+	assert.Equal(t, []string{criticalId}, reservedPods(t, client, "node-0"))
+
+	critical, err := client.CoreV1().Pods(metav1.NamespaceSystem).Get("critical", metav1.GetOptions{})
+	assert.NoError(t, err)
+	critical.Labels = map[string]string{podTemplateGenerationLabel: "2"}
+	_, err = client.CoreV1().Pods(metav1.NamespaceSystem).Update(critical)
+	assert.NoError(t, err)
+	waitForNotProcessing(t, r, criticalId)
+
+	assert.Equal(t, []string{}, reservedPods(t, client, "node-0"))
+	assert.Contains(t, drainEvents(recorder), EventReasonPlacementCancelled)
+	assert.Equal(t, outcomes["cancelled"]+1, metricValue(t, metrics.PlacementsCount.WithLabelValues("cancelled", "unknown")))
+}
+
+func TestBuildPlanPrefersNodesWithoutEvictions(t *testing.T) {
+	const avoided = "example.com/avoid"
+	config := configFromFlags()
+	config.NodeAnnotationPolicies = engine.NodeAnnotationPolicies{avoided: engine.AnnotationPolicyAvoid}
+	activeConfig.Set(config)
+	defer activeConfig.Set(configFromFlags())
+
+	cluster := newIntegrationCluster(500)
+	free := synthetic.NewNode("node-2", 1000)
+	free.Annotations = map[string]string{avoided: "true"}
+	cluster.Nodes = append(cluster.Nodes, free)
+	client := cluster.Clientset()
+	r := newTestRescheduler(client, kube_record.NewFakeRecorder(100))
+	critical, err := client.CoreV1().Pods(metav1.NamespaceSystem).Get("critical", metav1.GetOptions{})
+	assert.NoError(t, err)
+	withoutEvictions := metricValue(t, metrics.PlacementPathsCount.WithLabelValues("no_evictions"))
+	spared := metricValue(t, metrics.SparedVictimsCount.WithLabelValues("free_fit"))
+
+	plan := r.buildPlan(context.Background(), []*v1.Pod{critical})
+	assert.Len(t, plan.Placements, 1)
+	assert.Equal(t, "node-2", plan.Placements[0].Node.Name)
+	assert.Empty(t, plan.Placements[0].Victims)
+	assert.Equal(t, withoutEvictions+1, metricValue(t, metrics.PlacementPathsCount.WithLabelValues("no_evictions")))
+	// node-0 was considered first and needed victims
+	assert.True(t, metricValue(t, metrics.SparedVictimsCount.WithLabelValues("free_fit")) > spared)
+}
+
+func TestSparedVictims(t *testing.T) {
+	a := createTestPod("a", "default", false, false, 100)
+	b := createTestPod("b", "default", false, false, 100)
+	c := createTestPod("c", "default", false, false, 100)
+	assert.Equal(t, 0, sparedVictims([]*v1.Pod{a, b}, []*v1.Pod{b, a}))
+	assert.Equal(t, 1, sparedVictims([]*v1.Pod{a, b}, []*v1.Pod{a, c}))
+	assert.Equal(t, 2, sparedVictims([]*v1.Pod{a, b}, nil))
+}
+
+func TestBuildPlanReservesDistinctNodes(t *testing.T) {
+	// both pods fit on node-1 without evictions, but only one at a time
+	cluster := newIntegrationCluster(300)
+	second := synthetic.NewCriticalDaemonSetPod("critical-2", 300)
+	cluster.Pods = append(cluster.Pods, second)
+	client := cluster.Clientset()
+	r := newTestRescheduler(client, kube_record.NewFakeRecorder(100))
+	pending, err := r.unschedulablePodLister.List()
+	assert.NoError(t, err)
+
+	plan := r.buildPlan(context.Background(), filterCriticalDaemonSetPods(pending, r.podsBeingProcessed))
+	assert.Len(t, plan.Placements, 2)
+	assert.Empty(t, plan.Unplaceable)
+	nodes := []string{}
+	for _, placement := range plan.Placements {
+		nodes = append(nodes, placement.Node.Name)
+	}
+	sort.Strings(nodes)
+	assert.Equal(t, []string{"node-0", "node-1"}, nodes)
+
+	// the pods of each node are listed once for both pods
+	lists := 0
+	for _, action := range client.Actions() {
+		if action.Matches("list", "pods") && action.(core.ListAction).GetListRestrictions().Fields.String() != "" {
+			lists++
+		}
+	}
+	assert.Equal(t, 2, lists)
+}
+
+func TestBuildPlanResolvesConflicts(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		firstMovable  bool
+		expectNodes   map[string]string
+		expectResult  string
+		expectMessage string
+	}{
+		{
+			name:         "first pod moves",
+			firstMovable: true,
+			expectNodes:  map[string]string{"first": "node-0", "second": "node-1"},
+			expectResult: "resolved",
+		},
+		{
+			name:          "both need the small node",
+			expectNodes:   map[string]string{"first": "node-1"},
+			expectResult:  "unresolved",
+			expectMessage: "node node-1 fits but is needed by critical pod kube-system_first",
+		},
+	} {
+		cluster := newIntegrationCluster(300)
+		cluster.Nodes[1].Labels = map[string]string{"pool": "small"}
+		first := synthetic.NewCriticalDaemonSetPod("first", 300)
+		second := synthetic.NewCriticalDaemonSetPod("second", 300)
+		second.Spec.NodeSelector = map[string]string{"pool": "small"}
+		if !tc.firstMovable {
+			first.Spec.NodeSelector = second.Spec.NodeSelector
+		}
+		client := cluster.Clientset()
+		r := newTestRescheduler(client, kube_record.NewFakeRecorder(100))
+		result := metricValue(t, metrics.ReservationConflictsCount.WithLabelValues(tc.expectResult))
+
+		plan := r.buildPlan(context.Background(), []*v1.Pod{first, second})
+		nodes := map[string]string{}
+		for _, placement := range plan.Placements {
+			nodes[placement.Pod.Name] = placement.Node.Name
+		}
+		assert.Equal(t, tc.expectNodes, nodes, tc.name)
+		assert.Equal(t, result+1, metricValue(t, metrics.ReservationConflictsCount.WithLabelValues(tc.expectResult)), tc.name)
+		if tc.expectMessage != "" && assert.Len(t, plan.Unplaceable, 1, tc.name) {
+			assert.Contains(t, plan.Unplaceable[0].Reason, tc.expectMessage, tc.name)
+		}
+	}
+}
+
+func TestByUrgency(t *testing.T) {
+	low, high := int32(1), int32(2)
+	a := createTestPod("a", "kube-system", true, true, 100)
+	b := createTestPod("b", "kube-system", true, true, 100)
+	c := createTestPod("c", "kube-system", true, true, 100)
+	a.Spec.Priority, b.Spec.Priority, c.Spec.Priority = &low, &high, &low
+	assert.Equal(t, []*v1.Pod{b, a, c}, byUrgency([]*v1.Pod{a, b, c}))
+}
+
+func TestMaintainDedicatedNodes(t *testing.T) {
+	defer flags.Set("dedicated-addon-nodes", "0")
+	defer flags.Set("dedicated-addon-nodes-rotation", "0")
+	cluster := &synthetic.Cluster{Nodes: []*v1.Node{
+		synthetic.NewNode("node-0", 1000),
+		synthetic.NewNode("node-1", 1000),
+		synthetic.NewNode("node-2", 1000),
+	}}
+	client := cluster.Clientset()
+	r := newTestRescheduler(client, kube_record.NewFakeRecorder(100))
+	dedicated := func() []string {
+		names := []string{}
+		for _, name := range []string{"node-0", "node-1", "node-2"} {
+			if values := reservedPods(t, client, name); len(values) > 0 {
+				assert.Equal(t, []string{dedicatedTaintValue}, values)
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+
+	flags.Set("dedicated-addon-nodes", "2")
+	r.housekeeping(context.Background())
+	assert.Equal(t, []string{"node-0", "node-1"}, dedicated())
+	// dedicated taints aren't released as stale reservations
+	r.housekeeping(context.Background())
+	assert.Equal(t, []string{"node-0", "node-1"}, dedicated())
+
+	flags.Set("dedicated-addon-nodes-rotation", "1h")
+	r.clock.(*clock.FakeClock).Step(2 * time.Hour)
+	r.housekeeping(context.Background())
+	assert.Len(t, dedicated(), 2)
+	assert.Contains(t, dedicated(), "node-2")
+
+	flags.Set("dedicated-addon-nodes", "1")
+	r.housekeeping(context.Background())
+	assert.Len(t, dedicated(), 1)
+
+	// once the mode is off, the remaining dedicated node is released
+	flags.Set("dedicated-addon-nodes", "0")
+	r.housekeeping(context.Background())
+	assert.Equal(t, []string{}, dedicated())
+}
+
+func TestBuildPlanPriorityClasses(t *testing.T) {
+	config := configFromFlags()
+	config.PriorityClasses[systemClusterCritical] = priorityClassPolicy{
+		Urgency:    1,
+		MaxVictims: 1,
+		RetryAfter: metav1.Duration{Duration: time.Hour},
+	}
+	activeConfig.Set(config)
+	defer activeConfig.Set(configFromFlags())
+
+	client := newIntegrationCluster(500).Clientset()
+	r := newTestRescheduler(client, kube_record.NewFakeRecorder(100))
+	clusterCritical, err := client.CoreV1().Pods(metav1.NamespaceSystem).Get("critical", metav1.GetOptions{})
+	assert.NoError(t, err)
+	clusterCritical.Spec.PriorityClassName = systemClusterCritical
+	nodeCritical := synthetic.NewCriticalDaemonSetPod("cni", 100)
+	nodeCritical.Spec.PriorityClassName = systemNodeCritical
+	assert.Equal(t, []*v1.Pod{nodeCritical, clusterCritical}, byUrgency([]*v1.Pod{clusterCritical, nodeCritical}))
+
+	// Evicting b and c exceeds the cap of system-cluster-critical.
+	plan := r.buildPlan(context.Background(), []*v1.Pod{clusterCritical})
+	assert.Empty(t, plan.Placements)
+	assert.Len(t, plan.Unplaceable, 1)
+	assert.Contains(t, plan.Unplaceable[0].Reason, "at most 1 are allowed")
+
+	// A pod whose placement just failed waits for retryAfter.
+	defer failedPlacements.Forget(clusterCritical)
+	failedPlacements.Record(clusterCritical, "node-0", r.clock.Now())
+	plan = r.buildPlan(context.Background(), []*v1.Pod{clusterCritical})
+	assert.True(t, plan.IsEmpty())
+	r.clock.(*clock.FakeClock).Step(time.Hour)
+	plan = r.buildPlan(context.Background(), []*v1.Pod{clusterCritical})
+	assert.Len(t, plan.Unplaceable, 1)
+}
+
+func TestBuildPlanDaemonSetOverrides(t *testing.T) {
+	testCases := []struct {
+		annotations     map[string]string
+		expectPlacement bool
+		expectEmpty     bool
+	}{
+		{annotations: map[string]string{}, expectPlacement: true},
+		{annotations: map[string]string{DisabledAnnotationKey: "true"}, expectEmpty: true},
+		{annotations: map[string]string{MaxVictimsAnnotationKey: "0"}},
+		{annotations: map[string]string{MaxVictimsAnnotationKey: "2"}, expectPlacement: true},
+		{annotations: map[string]string{MaxVictimsAnnotationKey: "many"}, expectPlacement: true},
+		{annotations: map[string]string{VictimNamespacesAnnotationKey: "kube-system, monitoring"}},
+		{annotations: map[string]string{VictimNamespacesAnnotationKey: "default"}, expectPlacement: true},
+		{annotations: map[string]string{ReservationNodeSelectorAnnotationKey: "pool=addons"}},
+	}
+	for _, tc := range testCases {
+		client := newIntegrationCluster(500).Clientset()
+		_, err := client.AppsV1().DaemonSets(metav1.NamespaceSystem).Create(&appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "critical", Namespace: metav1.NamespaceSystem, Annotations: tc.annotations},
+		})
+		assert.NoError(t, err)
+		r := newTestRescheduler(client, kube_record.NewFakeRecorder(100))
+		critical, err := client.CoreV1().Pods(metav1.NamespaceSystem).Get("critical", metav1.GetOptions{})
+		assert.NoError(t, err)
+
+		plan := r.buildPlan(context.Background(), []*v1.Pod{critical})
+		assert.Equal(t, tc.expectPlacement, len(plan.Placements) == 1, "%v", tc.annotations)
+		assert.Equal(t, tc.expectEmpty, plan.IsEmpty(), "%v", tc.annotations)
+	}
+}
+
+func TestReservingCondition(t *testing.T) {
+	const criticalId = "kube-system_critical"
+	client := newIntegrationCluster(500).Clientset()
+	r := newTestRescheduler(client, kube_record.NewFakeRecorder(100))
+	condition := func() *v1.PodCondition {
+		pod, err := client.CoreV1().Pods(metav1.NamespaceSystem).Get("critical", metav1.GetOptions{})
+		assert.NoError(t, err)
+		for i := range pod.Status.Conditions {
+			if pod.Status.Conditions[i].Type == ReschedulerReservingCondition {
+				return &pod.Status.Conditions[i]
+			}
+		}
+		return nil
+	}
+
+	r.housekeeping(context.Background())
+	reserving := condition()
+	if assert.NotNil(t, reserving) {
+		assert.Equal(t, v1.ConditionTrue, reserving.Status)
+		assert.Equal(t, conditionReasonNodeReserved, reserving.Reason)
+		assert.Contains(t, reserving.Message, "node-0")
+	}
+
+	bindPod(t, client, "critical", "node-0")
+	waitForNotProcessing(t, r, criticalId)
+	done := condition()
+	if assert.NotNil(t, done) {
+		assert.Equal(t, v1.ConditionFalse, done.Status)
+		assert.Equal(t, conditionReasonScheduled, done.Reason)
+	}
+}
+
+func TestShadowPredictions(t *testing.T) {
+	config := configFromFlags()
+	config.ShadowMode = true
+	activeConfig.Set(config)
+	defer activeConfig.Set(configFromFlags())
+	shadowPredictions.predictions = map[string]prediction{}
+	defer func() { shadowPredictions.predictions = map[string]prediction{} }()
+
+	testCases := []struct {
+		name   string
+		act    func(t *testing.T, client kube_client.Interface)
+		result string
+	}{
+		{"agreed", func(t *testing.T, client kube_client.Interface) { bindPod(t, client, "critical", "node-0") }, "agreed"},
+		{"disagreed", func(t *testing.T, client kube_client.Interface) { bindPod(t, client, "critical", "node-1") }, "disagreed"},
+		{"gone", func(t *testing.T, client kube_client.Interface) {
+			assert.NoError(t, client.CoreV1().Pods(metav1.NamespaceSystem).Delete("critical", &metav1.DeleteOptions{}))
+		}, "gone"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newIntegrationCluster(500).Clientset()
+			r := newTestRescheduler(client, kube_record.NewFakeRecorder(100))
+			before := metricValue(t, metrics.ShadowPredictionsCount.WithLabelValues(tc.result))
+
+			r.housekeeping(context.Background())
+			r.housekeeping(context.Background())
+			assert.Equal(t, before, metricValue(t, metrics.ShadowPredictionsCount.WithLabelValues(tc.result)))
+			assert.Len(t, shadowPredictions.predictions, 1)
+
+			tc.act(t, client)
+			r.housekeeping(context.Background())
+			assert.Equal(t, before+1, metricValue(t, metrics.ShadowPredictionsCount.WithLabelValues(tc.result)))
+			assert.Len(t, shadowPredictions.predictions, 0)
+		})
+	}
+}
+
+func TestExportCapacity(t *testing.T) {
+	for _, tc := range []struct {
+		criticalCPU int64
+		expectNodes float64
+	}{
+		{criticalCPU: 500, expectNodes: 0},
+		{criticalCPU: 300, expectNodes: 1},
+	} {
+		cluster := newIntegrationCluster(tc.criticalCPU)
+		cluster.Nodes[0].Labels = map[string]string{engine.ZoneLabel: "zone-a"}
+		client := cluster.Clientset()
+		r := newTestRescheduler(client, kube_record.NewFakeRecorder(100))
+		critical, err := client.CoreV1().Pods(metav1.NamespaceSystem).Get("critical", metav1.GetOptions{})
+		assert.NoError(t, err)
+
+		r.exportCapacity([]*v1.Pod{critical})
+		assert.Equal(t, 0.0, metricValue(t, metrics.ZoneFreeCPUCores.WithLabelValues("zone-a")))
+		assert.Equal(t, 0.4, metricValue(t, metrics.ZoneFreeCPUCores.WithLabelValues("unknown")))
+		assert.Equal(t, float64(2*1024*1024*1024), metricValue(t, metrics.ZoneFreeMemoryBytes.WithLabelValues("unknown")))
+		assert.Equal(t, tc.expectNodes, metricValue(t, metrics.NodesWithoutEvictions.WithLabelValues("kube-system_critical")), "critical pod of %dm", tc.criticalCPU)
+	}
+}
+
+func TestVictimNamespaceNotices(t *testing.T) {
+	flags.Set("victim-namespace-notices", "true")
+	defer flags.Set("victim-namespace-notices", "false")
+
+	client := newIntegrationCluster(500).Clientset()
+	_, err := client.CoreV1().Namespaces().Create(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}})
+	assert.NoError(t, err)
+	r := newTestRescheduler(client, kube_record.NewFakeRecorder(100))
+	r.housekeeping(context.Background())
+
+	ns, err := client.CoreV1().Namespaces().Get("default", metav1.GetOptions{})
+	assert.NoError(t, err)
+	notices := []disruptionNotice{}
+	assert.NoError(t, json.Unmarshal([]byte(ns.Annotations[DisruptionNoticesAnnotationKey]), &notices))
+	pods := []string{}
+	for _, notice := range notices {
+		pods = append(pods, notice.Pod)
+		assert.Equal(t, "kube-system_critical", notice.CriticalPod)
+		assert.Equal(t, "node-0", notice.Node)
+	}
+	sort.Strings(pods)
+	assert.Equal(t, []string{"b", "c"}, pods)
+
+	// a victim deleted before the placement failed gets a notice all the same
+	client = newIntegrationCluster(500).Clientset()
+	_, err = client.CoreV1().Namespaces().Create(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}})
+	assert.NoError(t, err)
+	deletes := 0
+	client.PrependReactor("delete", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		if deletes++; deletes > 1 {
+			return true, nil, errors.NewForbidden(v1.Resource("pods"), action.(core.DeleteAction).GetName(), fmt.Errorf("injected"))
+		}
+		return false, nil, nil
+	})
+	defer resetFailedPlacements()
+	r = newTestRescheduler(client, kube_record.NewFakeRecorder(100))
+	r.housekeeping(context.Background())
+
+	ns, err = client.CoreV1().Namespaces().Get("default", metav1.GetOptions{})
+	assert.NoError(t, err)
+	notices = []disruptionNotice{}
+	assert.NoError(t, json.Unmarshal([]byte(ns.Annotations[DisruptionNoticesAnnotationKey]), &notices))
+	assert.Len(t, notices, 1)
+}
+
+func TestForecast(t *testing.T) {
+	client := newIntegrationCluster(500).Clientset()
+	r := newTestRescheduler(client, kube_record.NewFakeRecorder(100))
+	out := &bytes.Buffer{}
+	assert.NoError(t, r.forecast(context.Background(), out, "yaml"))
+	assert.Contains(t, out.String(), "pod: kube-system_critical")
+	assert.Contains(t, out.String(), "node: node-0")
+	assert.Contains(t, out.String(), "- default_b")
+	assert.Equal(t, []string{"a", "b", "c"}, existingPods(t, client, "a", "b", "c"))
+	assert.Equal(t, []string{}, reservedPods(t, client, "node-0"))
+}
+
+func TestCapacityShortfall(t *testing.T) {
+	os.Setenv("POD_NAME", "rescheduler")
+	os.Setenv("POD_NAMESPACE", "kube-system")
+	defer os.Unsetenv("POD_NAME")
+	defer os.Unsetenv("POD_NAMESPACE")
+	flags.Set("shortfall-configmap", "shortfall")
+	defer flags.Set("shortfall-configmap", "")
+
+	cluster := newIntegrationCluster(2000)
+	zonal := synthetic.NewCriticalDaemonSetPod("zonal", 1500)
+	zonal.Spec.NodeSelector = map[string]string{engine.ZoneLabel: "zone-a"}
+	cluster.Pods = append(cluster.Pods, zonal)
+	client := cluster.Clientset()
+	recorder := kube_record.NewFakeRecorder(100)
+	r := newTestRescheduler(client, recorder)
+	r.housekeeping(context.Background())
+
+	events := drainEvents(recorder)
+	assert.Contains(t, events, EventReasonCapacityShortfall)
+	assert.Contains(t, events, "2 critical pods")
+	assert.NotContains(t, events, EventReasonNoFeasibleNode)
+	assert.Equal(t, 2.0, metricValue(t, metrics.CapacityShortfallCPUCores.WithLabelValues(anyZone)))
+	assert.Equal(t, 1.5, metricValue(t, metrics.CapacityShortfallCPUCores.WithLabelValues("zone-a")))
+
+	configMap, err := client.CoreV1().ConfigMaps("kube-system").Get("shortfall", metav1.GetOptions{})
+	if assert.NoError(t, err) {
+		report := []zoneShortfall{}
+		assert.NoError(t, json.Unmarshal([]byte(configMap.Data[shortfallReportKey]), &report))
+		assert.Equal(t, []zoneShortfall{
+			{Zone: anyZone, Pods: []string{"kube-system_critical"}, MilliCPU: 2000},
+			{Zone: "zone-a", Pods: []string{"kube-system_zonal"}, MilliCPU: 1500},
+		}, report)
+	}
+
+	// Once only one pod is left, it gets its own event again.
+	assert.NoError(t, client.CoreV1().Pods("kube-system").Delete("zonal", nil))
+	r.housekeeping(context.Background())
+	assert.Contains(t, drainEvents(recorder), EventReasonNoFeasibleNode)
+	assert.Equal(t, 0.0, metricValue(t, metrics.CapacityShortfallCPUCores.WithLabelValues("zone-a")))
+}
+
+func TestBuildPlanWithinBudget(t *testing.T) {
+	assert.NoError(t, flags.Set("planning-budget", "1ns"))
+	assert.NoError(t, flags.Set("planning-sample-size", "1"))
+	defer flags.Set("planning-budget", "30s")
+	defer flags.Set("planning-sample-size", "100")
+
+	// only node-9 fits without evictions, and a placement succeeded there before
+	cluster := newIntegrationCluster(500)
+	for i := 2; i < 9; i++ {
+		name := fmt.Sprintf("node-%d", i)
+		cluster.Nodes = append(cluster.Nodes, synthetic.NewNode(name, 600))
+		filler := synthetic.NewPod("filler-"+name, "default", 300)
+		filler.Spec.NodeName = name
+		cluster.Pods = append(cluster.Pods, filler)
+	}
+	cluster.Nodes = append(cluster.Nodes, synthetic.NewNode("node-9", 1000))
+	successfulNodes.Add("node-9", time.Now())
+	client := cluster.Clientset()
+	r := newTestRescheduler(client, kube_record.NewFakeRecorder(100))
+	critical, err := client.CoreV1().Pods(metav1.NamespaceSystem).Get("critical", metav1.GetOptions{})
+	assert.NoError(t, err)
+	truncations := metricValue(t, metrics.PlanningTruncationsCount)
+
+	plan := r.buildPlan(context.Background(), []*v1.Pod{critical})
+	assert.Len(t, plan.Placements, 1)
+	assert.Equal(t, "node-9", plan.Placements[0].Node.Name)
+	assert.Equal(t, truncations+1, metricValue(t, metrics.PlanningTruncationsCount))
+	lists := 0
+	for _, action := range client.Actions() {
+		if action.Matches("list", "pods") && action.(core.ListAction).GetListRestrictions().Fields.String() != "" {
+			lists++
+		}
+	}
+	// a node may be checked before the budget runs out, then the sample of two
+	assert.True(t, lists <= 3, "pods were listed on %d nodes", lists)
+}
+
+func TestBuildPlanFromPodSnapshot(t *testing.T) {
+	client := newIntegrationCluster(500).Clientset()
+	r := newTestRescheduler(client, kube_record.NewFakeRecorder(100))
+	stop := make(chan struct{})
+	defer close(stop)
+	r.boundPods = newBoundPodCache(client, stop)
+	assert.True(t, cache.WaitForCacheSync(stop, r.boundPods.synced))
+	critical, err := client.CoreV1().Pods(metav1.NamespaceSystem).Get("critical", metav1.GetOptions{})
+	assert.NoError(t, err)
+	client.ClearActions()
+
+	plan := r.buildPlan(context.Background(), []*v1.Pod{critical})
+	assert.Len(t, plan.Placements, 1)
+	assert.Equal(t, "node-0", plan.Placements[0].Node.Name)
+	assert.Len(t, plan.Placements[0].Victims, 2)
+	for _, action := range client.Actions() {
+		assert.False(t, action.Matches("list", "pods"), "pods were listed while planning")
+	}
+}
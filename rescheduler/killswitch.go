@@ -0,0 +1,104 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strconv"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kube_client "k8s.io/client-go/kubernetes"
+	"k8s.io/contrib/rescheduler/metrics"
+)
+
+const (
+	// killSwitchAnnotation set to "true" on the rescheduler's namespace disables
+	// all destructive actions (tainting nodes and deleting pods).
+	killSwitchAnnotation = "rescheduler.alpha.kubernetes.io/disable-actions"
+	// killSwitchConfigMapKey is the key checked in the --kill-switch-configmap ConfigMap.
+	killSwitchConfigMapKey = "disable-actions"
+)
+
+// killSwitch remembers the last observed state so that transitions are logged once.
+type killSwitch struct {
+	engaged bool
+}
+
+// Engaged checks the namespace annotation and the optional ConfigMap and
+// returns true if destructive actions must not be taken. Errors while reading
+// them, other than the namespace or ConfigMap not existing, are logged and
+// treated as engaged: the switch can't be trusted to be off while the
+// apiserver can't tell. Reading them is an essential permission, so lacking
+// it is reported on startup and keeps the rescheduler in shadow mode.
+func (k *killSwitch) Engaged(client kube_client.Interface) bool {
+	engaged, source := checkKillSwitch(client)
+	if engaged != k.engaged {
+		if engaged {
+			glog.Warningf("Kill switch engaged via %s, destructive actions are disabled", source)
+		} else {
+			glog.Infof("Kill switch released, destructive actions are enabled again")
+		}
+	}
+	k.engaged = engaged
+	if engaged {
+		metrics.KillSwitchEngaged.Set(1)
+	} else {
+		metrics.KillSwitchEngaged.Set(0)
+	}
+	return engaged
+}
+
+func checkKillSwitch(client kube_client.Interface) (bool, string) {
+	namespace := ownNamespace()
+	ns, err := client.CoreV1().Namespaces().Get(namespace, metav1.GetOptions{})
+	switch {
+	case errors.IsForbidden(err):
+		// the permission is essential, so the rescheduler already reported it and runs in shadow mode
+		repeats.Warningf("kill-switch-namespace", "Not allowed to check kill switch annotation on namespace %s, skipping destructive actions: %v", namespace, err)
+		return true, "missing permission to read namespace " + namespace
+	case err != nil && !errors.IsNotFound(err):
+		repeats.Warningf("kill-switch-namespace", "Failed to check kill switch annotation on namespace %s, skipping destructive actions: %v", namespace, err)
+		return true, "failure to read namespace " + namespace
+	case err == nil && isTrue(ns.Annotations[killSwitchAnnotation]):
+		return true, "annotation " + killSwitchAnnotation + " on namespace " + namespace
+	}
+
+	if *killSwitchConfigMap == "" {
+		return false, ""
+	}
+	configMap, err := client.CoreV1().ConfigMaps(namespace).Get(*killSwitchConfigMap, metav1.GetOptions{})
+	switch {
+	case errors.IsForbidden(err):
+		repeats.Warningf("kill-switch-configmap", "Not allowed to check kill switch ConfigMap %s/%s, skipping destructive actions: %v", namespace, *killSwitchConfigMap, err)
+		return true, "missing permission to read ConfigMap " + namespace + "/" + *killSwitchConfigMap
+	case errors.IsNotFound(err):
+		return false, ""
+	case err != nil:
+		repeats.Warningf("kill-switch-configmap", "Failed to check kill switch ConfigMap %s/%s, skipping destructive actions: %v", namespace, *killSwitchConfigMap, err)
+		return true, "failure to read ConfigMap " + namespace + "/" + *killSwitchConfigMap
+	}
+	if isTrue(configMap.Data[killSwitchConfigMapKey]) {
+		return true, "key " + killSwitchConfigMapKey + " in ConfigMap " + namespace + "/" + *killSwitchConfigMap
+	}
+	return false, ""
+}
+
+func isTrue(value string) bool {
+	b, err := strconv.ParseBool(value)
+	return err == nil && b
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+)
+
+func TestKillSwitch(t *testing.T) {
+	namespace := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system"}}
+	configMap := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "rescheduler-kill-switch", Namespace: "kube-system"},
+		Data:       map[string]string{killSwitchConfigMapKey: "false"},
+	}
+	fakeClient := fake.NewSimpleClientset(namespace, configMap)
+	k := &killSwitch{}
+	assert.False(t, k.Engaged(fakeClient))
+
+	namespace.Annotations = map[string]string{killSwitchAnnotation: "true"}
+	fakeClient.CoreV1().Namespaces().Update(namespace)
+	assert.True(t, k.Engaged(fakeClient))
+
+	namespace.Annotations = nil
+	fakeClient.CoreV1().Namespaces().Update(namespace)
+	assert.False(t, k.Engaged(fakeClient))
+
+	*killSwitchConfigMap = "rescheduler-kill-switch"
+	defer func() { *killSwitchConfigMap = "" }()
+	assert.False(t, k.Engaged(fakeClient))
+	configMap.Data[killSwitchConfigMapKey] = "true"
+	fakeClient.CoreV1().ConfigMaps("kube-system").Update(configMap)
+	assert.True(t, k.Engaged(fakeClient))
+
+	// The switch fails closed while the ConfigMap can't be read.
+	configMap.Data[killSwitchConfigMapKey] = "false"
+	fakeClient.CoreV1().ConfigMaps("kube-system").Update(configMap)
+	assert.False(t, k.Engaged(fakeClient))
+	fakeClient.PrependReactor("get", "configmaps", func(action core.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.NewServiceUnavailable("apiserver is down")
+	})
+	assert.True(t, k.Engaged(fakeClient))
+
+	// A missing ConfigMap doesn't engage it.
+	fakeClient = fake.NewSimpleClientset(namespace)
+	assert.False(t, k.Engaged(fakeClient))
+
+	// Lacking the permission to read it does, and is essential to placements.
+	fakeClient.PrependReactor("get", "namespaces", func(action core.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.NewForbidden(v1.Resource("namespaces"), "kube-system", fmt.Errorf("no RBAC rule"))
+	})
+	assert.True(t, k.Engaged(fakeClient))
+	for _, permission := range requiredPermissions() {
+		if permission.resource == "namespaces" && permission.feature == "the kill switch annotation" {
+			assert.True(t, permission.essential)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"k8s.io/contrib/rescheduler/engine"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// ReservationsAnnotationKey is the node annotation describing the reservation
+// taints on the node, keyed by taint value, so that users and other
+// controllers can tell why the node carries CriticalAddonsOnly.
+const ReservationsAnnotationKey = "rescheduler.alpha.kubernetes.io/reservations"
+
+// reservationEntry is the ledger entry of a single reservation taint.
+type reservationEntry struct {
+	// Pod is the critical pod the node is reserved for.
+	Pod string `json:"pod"`
+	// Reserved is when the taint was added.
+	Reserved metav1.Time `json:"reserved"`
+	// Expires is when the placement times out; the taint is released by then.
+	Expires metav1.Time `json:"expires"`
+}
+
+func reservationLedger(node *v1.Node) map[string]reservationEntry {
+	ledger := map[string]reservationEntry{}
+	data, found := node.Annotations[ReservationsAnnotationKey]
+	if !found {
+		return ledger
+	}
+	if err := json.Unmarshal([]byte(data), &ledger); err != nil {
+		glog.Warningf("Ignoring invalid %s annotation on node %v: %v", ReservationsAnnotationKey, node.Name, err)
+		return map[string]reservationEntry{}
+	}
+	return ledger
+}
+
+// setReservationLedger stores <ledger> on <node> like setTaintOwners.
+func setReservationLedger(node *v1.Node, ledger map[string]reservationEntry) {
+	annotations := map[string]string{}
+	for key, value := range node.Annotations {
+		annotations[key] = value
+	}
+	if len(ledger) == 0 {
+		delete(annotations, ReservationsAnnotationKey)
+	} else {
+		data, _ := json.Marshal(ledger)
+		annotations[ReservationsAnnotationKey] = string(data)
+	}
+	node.Annotations = annotations
+}
+
+// reservationTaint returns the taint reserving a node for <criticalPod> from
+// <now> until its placement times out.
+func reservationTaint(criticalPod *v1.Pod, now time.Time) v1.Taint {
+	return engine.ReservationTaint(criticalPod, now.Add(podScheduledTimeoutOf(criticalPod)), instanceID())
+}
+
+// reservedPodUID returns the UID of the pod <taint> reserves its node for, or
+// "" if the taint was added by an older rescheduler.
+func reservedPodUID(taint v1.Taint) types.UID {
+	reservation, err := engine.ParseReservation(taint.Value)
+	if err != nil {
+		return ""
+	}
+	return reservation.UID
+}
+
+// reservationReleasable returns true if <taint> no longer holds its node for a
+// placement in flight. Taints of pods being processed are kept. Taints added by
+// other instances are kept until they expire, as the other instance may still
+// be placing its pod. Taints of older reschedulers are always released.
+func reservationReleasable(taint v1.Taint, podsBeingProcessed *podSet, now time.Time) bool {
+	reservation, err := engine.ParseReservation(taint.Value)
+	if err != nil {
+		return true
+	}
+	if podsBeingProcessed.HasUID(reservation.UID) {
+		return false
+	}
+	return reservation.Instance == engine.InstanceHash(instanceID()) || reservation.Expired(now)
+}
+
+// recordReservation adds the ledger entry of <taint> reserving the node for
+// <criticalPod>, reserved at its TimeAdded or now if it has none.
+func recordReservation(node *v1.Node, taint v1.Taint, criticalPod *v1.Pod) {
+	reserved := metav1.Now()
+	if taint.TimeAdded != nil {
+		reserved = *taint.TimeAdded
+	}
+	expires := reserved.Add(podScheduledTimeoutOf(criticalPod))
+	if reservation, err := engine.ParseReservation(taint.Value); err == nil {
+		expires = reservation.Expires
+	}
+	ledger := reservationLedger(node)
+	ledger[taint.Value] = reservationEntry{
+		Pod:      podId(criticalPod),
+		Reserved: reserved,
+		Expires:  metav1.NewTime(expires),
+	}
+	setReservationLedger(node, ledger)
+}
+
+// pruneReservationLedger drops the entries of taints which are no longer on
+// <node> and returns true if it changed anything.
+func pruneReservationLedger(node *v1.Node) bool {
+	ledger := reservationLedger(node)
+	if len(ledger) == 0 {
+		return false
+	}
+	present := map[string]bool{}
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == criticalAddonsOnlyTaintKey {
+			present[taint.Value] = true
+		}
+	}
+	pruned := false
+	for value := range ledger {
+		if !present[value] {
+			delete(ledger, value)
+			pruned = true
+		}
+	}
+	if pruned {
+		setReservationLedger(node, ledger)
+	}
+	return pruned
+}
@@ -17,10 +17,18 @@ limitations under the License.
 package metrics
 
 import (
+	"net/http"
+	"os"
+
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var (
+	// Registry holds all rescheduler metrics. It is used instead of the global
+	// prometheus registry, so that only metrics registered on purpose are exported.
+	Registry = prometheus.NewRegistry()
+
 	// UnschedulableCriticalPodsCount tracks the number of time when a critical pod was unschedublable.
 	UnschedulableCriticalPodsCount = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -29,6 +37,15 @@ var (
 			Help:      "Number of times a critical pod was unschedulable.",
 		},
 		[]string{"k8s_app"})
+	// StaticCriticalPodsCount is the number of pending critical static pods at
+	// the last housekeeping pass, which the rescheduler never places.
+	StaticCriticalPodsCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "rescheduler",
+			Name:      "static_critical_pods",
+			Help:      "Number of pending critical static pods the rescheduler can't place.",
+		},
+		[]string{"k8s_app"})
 	// DeletedPodsCount tracks the number of deletion of pods in order to schedule a critical one.
 	DeletedPodsCount = prometheus.NewCounter(
 		prometheus.CounterOpts{
@@ -36,9 +53,389 @@ var (
 			Name:      "deleted_pods_count",
 			Help:      "Number of pods deleted in order to schedule a critical pod.",
 		})
+	// ConfigReloadsCount tracks the number of configuration reloads by result.
+	ConfigReloadsCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "rescheduler",
+			Name:      "config_reloads_count",
+			Help:      "Number of configuration reloads, by result.",
+		},
+		[]string{"result"})
+	// ConfigLastReloadSuccessTimestamp is the time of the last successful configuration reload.
+	ConfigLastReloadSuccessTimestamp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "rescheduler",
+			Name:      "config_last_reload_success_timestamp_seconds",
+			Help:      "Unix time of the last successful configuration reload.",
+		})
+	// KillSwitchEngaged is 1 when destructive actions are disabled by the kill switch.
+	KillSwitchEngaged = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "rescheduler",
+			Name:      "kill_switch_engaged",
+			Help:      "Whether destructive actions are disabled by the kill switch.",
+		})
+	// ShadowActionsCount tracks actions which would have been taken if shadow mode was off.
+	ShadowActionsCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "rescheduler",
+			Name:      "shadow_actions_count",
+			Help:      "Number of actions which would have been taken if shadow mode was disabled, by action.",
+		},
+		[]string{"action"})
+	// ShadowPredictionsCount tracks how the nodes picked in shadow mode compare
+	// to where the pods were eventually scheduled: agreed, disagreed or gone
+	// (deleted or recreated first). The agreement rate tells how far the
+	// simulation can be trusted before actions are enabled.
+	ShadowPredictionsCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "rescheduler",
+			Name:      "shadow_predictions_total",
+			Help:      "Number of shadow mode placements compared to the actual scheduling of the pod, by result.",
+		},
+		[]string{"result"})
+	// PlacementsCount tracks how placements of critical pods ended: success,
+	// timeout, aborted (shutdown), failed (node preparation failed), cancelled
+	// (the pod changed meanwhile), node_deleted (the reserved node was deleted
+	// first) or no_node, which is counted once per housekeeping pass in which
+	// the pod fit nowhere.
+	PlacementsCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "rescheduler",
+			Name:      "placements_total",
+			Help:      "Number of critical pod placements, by outcome.",
+		},
+		[]string{"outcome", "k8s_app"})
+	// PlacementPathsCount tracks whether planned placements needed evictions:
+	// no_evictions or evictions.
+	PlacementPathsCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "rescheduler",
+			Name:      "placement_paths_total",
+			Help:      "Number of planned critical pod placements, by whether they need evictions.",
+		},
+		[]string{"path"})
+	// PlacementOwnersCount tracks planned placements by the kind of the
+	// top-level controller of the critical pod, or none.
+	PlacementOwnersCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "rescheduler",
+			Name:      "placement_owners_total",
+			Help:      "Number of planned critical pod placements, by the kind of the pod's top-level controller.",
+		},
+		[]string{"owner_kind"})
+	// SuccessfulPlacementPathsCount tracks whether the placements whose pod got
+	// scheduled needed evictions: no_evictions or evictions.
+	SuccessfulPlacementPathsCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "rescheduler",
+			Name:      "successful_placement_paths_total",
+			Help:      "Number of successful critical pod placements, by whether pods were evicted for them.",
+		},
+		[]string{"path"})
+	// SparedVictimsCount tracks pods which would have been evicted but weren't:
+	// free_fit if a node needing evictions was passed over for a node the
+	// critical pod fits on as it is, revalidation if planned victims were no
+	// longer needed when the node was checked again before the evictions, gone
+	// if a victim was deleted by someone else before it was evicted.
+	SparedVictimsCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "rescheduler",
+			Name:      "spared_victims_total",
+			Help:      "Number of pods not evicted although a node needing their eviction was considered, by reason.",
+		},
+		[]string{"reason"})
+	// ReservationConflictsCount tracks pending critical pods which only fit on a
+	// node already planned for another critical pod: resolved if the other pod
+	// was moved to a different node, unresolved otherwise.
+	ReservationConflictsCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "rescheduler",
+			Name:      "reservation_conflicts_total",
+			Help:      "Number of critical pods which only fit on a node planned for another critical pod, by result.",
+		},
+		[]string{"result"})
+	// CapacityShortfallCPUCores is the CPU requested by the critical pods the
+	// last plan found no node for, by the zone they are confined to ("any" if
+	// they aren't). It is a lower bound of the capacity which has to be added.
+	CapacityShortfallCPUCores = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "rescheduler",
+			Name:      "capacity_shortfall_cpu_cores",
+			Help:      "CPU requested by critical pods which fit on no node, by zone.",
+		},
+		[]string{"zone"})
+	// CapacityShortfallMemoryBytes is the memory counterpart of CapacityShortfallCPUCores.
+	CapacityShortfallMemoryBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "rescheduler",
+			Name:      "capacity_shortfall_memory_bytes",
+			Help:      "Memory requested by critical pods which fit on no node, by zone.",
+		},
+		[]string{"zone"})
+	// PlacementDurationSeconds tracks how long it took from preparing a node
+	// until the critical pod was scheduled there, the wait timed out or the
+	// node was deleted.
+	PlacementDurationSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "rescheduler",
+			Name:      "placement_duration_seconds",
+			Help:      "Time from preparing a node until the critical pod was scheduled, by outcome.",
+			Buckets:   []float64{1, 2, 5, 10, 20, 30, 60, 120, 300, 600, 1200},
+		},
+		[]string{"outcome"})
+	// ZoneFreeCPUCores is the allocatable CPU not requested by any pod, per zone.
+	ZoneFreeCPUCores = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "rescheduler",
+			Name:      "zone_free_cpu_cores",
+			Help:      "Allocatable CPU of ready nodes not requested by any pod, by zone.",
+		},
+		[]string{"zone"})
+	// ZoneFreeMemoryBytes is the allocatable memory not requested by any pod, per zone.
+	ZoneFreeMemoryBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "rescheduler",
+			Name:      "zone_free_memory_bytes",
+			Help:      "Allocatable memory of ready nodes not requested by any pod, by zone.",
+		},
+		[]string{"zone"})
+	// NodesWithoutEvictions is the number of nodes each pending critical pod
+	// fits on as they are. Zero means placing it takes evictions.
+	NodesWithoutEvictions = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "rescheduler",
+			Name:      "critical_pod_nodes_without_evictions",
+			Help:      "Number of nodes a pending critical pod fits on without evicting any pod, by pod.",
+		},
+		[]string{"pod"})
+	// DaemonSetMissingPods is the number of nodes a critical DaemonSet should
+	// run on which lack a running pod, by reason.
+	DaemonSetMissingPods = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "rescheduler",
+			Name:      "daemonset_missing_pods",
+			Help:      "Number of nodes where a critical DaemonSet has no running pod, by DaemonSet and reason.",
+		},
+		[]string{"daemonset", "reason"})
+	// ListenPort is the port the metrics server listens on, which the
+	// system chooses if --listen-address has port 0.
+	ListenPort = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "rescheduler",
+			Name:      "listen_port",
+			Help:      "Port the metrics server listens on.",
+		})
+	// WaitingPlacements is the number of placements waiting for their critical
+	// pod to be scheduled, each in its own goroutine.
+	WaitingPlacements = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "rescheduler",
+			Name:      "waiting_placements",
+			Help:      "Number of placements waiting for their critical pod to be scheduled.",
+		})
+	// OldestTaintAgeSeconds is the age of the oldest reservation taint still held.
+	OldestTaintAgeSeconds = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "rescheduler",
+			Name:      "oldest_taint_age_seconds",
+			Help:      "Age of the oldest reservation taint held by the rescheduler, 0 if there is none.",
+		})
+	// ForceReleasedTaintsCount tracks reservation taints released without a known placement.
+	ForceReleasedTaintsCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "rescheduler",
+			Name:      "force_released_taints_count",
+			Help:      "Number of reservation taints released which didn't belong to a placement of this instance.",
+		})
+	// RestoredReservationsCount tracks reservation taints re-added after someone removed them.
+	RestoredReservationsCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "rescheduler",
+			Name:      "restored_reservations_count",
+			Help:      "Number of reservation taints of placements in flight which were removed by someone else and re-added.",
+		})
+	// EvictedInVainCount tracks pods deleted for placements which timed out or
+	// were rolled back after a victim couldn't be deleted.
+	EvictedInVainCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "rescheduler",
+			Name:      "evicted_in_vain_count",
+			Help:      "Number of pods deleted to make room for a critical pod which then wasn't scheduled in time.",
+		})
+	// ActionSinkErrorsCount tracks actions an --action-sink failed to record.
+	ActionSinkErrorsCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "rescheduler",
+			Name:      "action_sink_errors_total",
+			Help:      "Number of actions an action sink failed to record, by sink.",
+		},
+		[]string{"sink"})
+	// DroppedEventsCount tracks events not written because of --event-qps.
+	DroppedEventsCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "rescheduler",
+			Name:      "dropped_events_total",
+			Help:      "Number of events dropped because they were written faster than --event-qps allows.",
+		})
+	// PrefilteredNodesCount tracks nodes dropped from the candidates of a
+	// critical pod by a cheap check, before the pods on them were listed:
+	// platform, taints, node_selector or resources.
+	PrefilteredNodesCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "rescheduler",
+			Name:      "prefiltered_nodes_total",
+			Help:      "Number of candidate nodes dropped before listing their pods, by check.",
+		},
+		[]string{"check"})
+	// FitFailureLookupsCount tracks lookups of earlier fit failures of a
+	// critical pod on a node: hit if the node was skipped without running the
+	// predicates, miss otherwise.
+	FitFailureLookupsCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "rescheduler",
+			Name:      "fit_failure_lookups_total",
+			Help:      "Number of lookups of remembered fit failures of critical pods, by result.",
+		},
+		[]string{"result"})
+	// PlanningTruncationsCount tracks planning passes which exceeded
+	// --planning-budget and planned their remaining pods on a sample of nodes.
+	PlanningTruncationsCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "rescheduler",
+			Name:      "planning_truncations_total",
+			Help:      "Number of planning passes which exceeded their time budget.",
+		})
+	// SampledPlanningPodsCount tracks critical pods planned on a sample of
+	// nodes because their pass exceeded --planning-budget.
+	SampledPlanningPodsCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "rescheduler",
+			Name:      "sampled_planning_pods_total",
+			Help:      "Number of critical pods planned on a sample of nodes because planning exceeded its time budget.",
+		})
+	// InjectedFaultsCount tracks faults injected with --inject-faults.
+	InjectedFaultsCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "rescheduler",
+			Name:      "injected_faults_count",
+			Help:      "Number of faults injected for testing, by fault.",
+		},
+		[]string{"fault"})
+	// PlacementErrorsCount tracks placements which failed while being carried
+	// out, by kind: taint_conflict, eviction_vetoed, no_feasible_node (the node
+	// changed since it was planned), cancelled or other.
+	PlacementErrorsCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "rescheduler",
+			Name:      "placement_errors_total",
+			Help:      "Number of critical pod placements which failed while being carried out, by kind of error.",
+		},
+		[]string{"kind"})
+	// EvictionFailuresCount tracks victims which couldn't be deleted, by what
+	// was decided: continue, roll_back or abandon.
+	EvictionFailuresCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "rescheduler",
+			Name:      "eviction_failures_total",
+			Help:      "Number of victims which couldn't be deleted, by how the placement went on.",
+		},
+		[]string{"decision"})
+	// ClusterUp tells, in a process supervising several clusters, whether the
+	// metrics of the rescheduler of each cluster could be read when last scraped.
+	ClusterUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "rescheduler",
+			Name:      "cluster_up",
+			Help:      "Whether the metrics of the rescheduler of a cluster could be read at the last scrape, by cluster.",
+		},
+		[]string{"cluster"})
+	// ClusterRestartsCount tracks, in a process supervising several clusters,
+	// how often the rescheduler of each cluster exited and was started again.
+	ClusterRestartsCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "rescheduler",
+			Name:      "cluster_restarts_total",
+			Help:      "Number of times the rescheduler of a cluster exited and was restarted, by cluster.",
+		},
+		[]string{"cluster"})
+	// SkippedEvictionsCount tracks evictions which were planned but not carried out.
+	SkippedEvictionsCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "rescheduler",
+			Name:      "skipped_evictions_count",
+			Help:      "Number of planned evictions which were not carried out, by reason.",
+		},
+		[]string{"reason"})
 )
 
 func init() {
-	prometheus.MustRegister(UnschedulableCriticalPodsCount)
-	prometheus.MustRegister(DeletedPodsCount)
+	Registry.MustRegister(UnschedulableCriticalPodsCount)
+	Registry.MustRegister(DeletedPodsCount)
+	Registry.MustRegister(StaticCriticalPodsCount)
+	Registry.MustRegister(ConfigReloadsCount)
+	Registry.MustRegister(ConfigLastReloadSuccessTimestamp)
+	Registry.MustRegister(KillSwitchEngaged)
+	Registry.MustRegister(ShadowActionsCount)
+	Registry.MustRegister(ShadowPredictionsCount)
+	Registry.MustRegister(SkippedEvictionsCount)
+	Registry.MustRegister(EvictionFailuresCount)
+	Registry.MustRegister(PlacementErrorsCount)
+	Registry.MustRegister(PlacementsCount)
+	Registry.MustRegister(PlacementDurationSeconds)
+	Registry.MustRegister(PlacementPathsCount)
+	Registry.MustRegister(SuccessfulPlacementPathsCount)
+	Registry.MustRegister(PlacementOwnersCount)
+	Registry.MustRegister(SparedVictimsCount)
+	Registry.MustRegister(ReservationConflictsCount)
+	Registry.MustRegister(PrefilteredNodesCount)
+	Registry.MustRegister(FitFailureLookupsCount)
+	Registry.MustRegister(PlanningTruncationsCount)
+	Registry.MustRegister(SampledPlanningPodsCount)
+	Registry.MustRegister(OldestTaintAgeSeconds)
+	Registry.MustRegister(ZoneFreeCPUCores)
+	Registry.MustRegister(ZoneFreeMemoryBytes)
+	Registry.MustRegister(CapacityShortfallCPUCores)
+	Registry.MustRegister(CapacityShortfallMemoryBytes)
+	Registry.MustRegister(NodesWithoutEvictions)
+	Registry.MustRegister(DaemonSetMissingPods)
+	Registry.MustRegister(WaitingPlacements)
+	Registry.MustRegister(ListenPort)
+	Registry.MustRegister(ForceReleasedTaintsCount)
+	Registry.MustRegister(RestoredReservationsCount)
+	Registry.MustRegister(EvictedInVainCount)
+	Registry.MustRegister(InjectedFaultsCount)
+	Registry.MustRegister(DroppedEventsCount)
+	Registry.MustRegister(ActionSinkErrorsCount)
+	Registry.MustRegister(ClusterUp)
+	Registry.MustRegister(ClusterRestartsCount)
+}
+
+// RegisterRuntimeCollectors adds the process collector and, if <goMetrics> is
+// set, the Go runtime collector to Registry.
+func RegisterRuntimeCollectors(goMetrics bool) {
+	Registry.MustRegister(prometheus.NewProcessCollector(os.Getpid(), ""))
+	if goMetrics {
+		Registry.MustRegister(prometheus.NewGoCollector())
+	}
+}
+
+// RegisterCacheSize exports the number of objects returned by <size> as
+// rescheduler_cache_objects{cache="<cache>"}, so that memory held by informer
+// caches and in-memory state can be told apart and leaks spotted. Together
+// with the Go runtime collector it helps size the rescheduler's requests.
+func RegisterCacheSize(cache string, size func() int) {
+	Registry.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace:   "rescheduler",
+			Name:        "cache_objects",
+			Help:        "Number of objects held in an informer cache or in-memory state, by cache.",
+			ConstLabels: prometheus.Labels{"cache": cache},
+		},
+		func() float64 { return float64(size()) }))
+}
+
+// Handler serves the metrics in Registry.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
 }
@@ -0,0 +1,106 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricNamesArePrefixed(t *testing.T) {
+	UnschedulableCriticalPodsCount.WithLabelValues("test").Inc()
+	PlacementsCount.WithLabelValues("success", "test").Inc()
+	families, err := Registry.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+	if len(families) == 0 {
+		t.Fatalf("no metrics gathered")
+	}
+	for _, family := range families {
+		if !strings.HasPrefix(family.GetName(), "rescheduler_") {
+			t.Errorf("metric %s is not prefixed with rescheduler_", family.GetName())
+		}
+	}
+}
+
+func TestRegisterCacheSize(t *testing.T) {
+	objects := 3
+	RegisterCacheSize("test", func() int { return objects })
+	objects = 5
+	families, err := Registry.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != "rescheduler_cache_objects" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			if m.GetLabel()[0].GetValue() != "test" {
+				continue
+			}
+			if m.GetGauge().GetValue() != 5 {
+				t.Errorf("expected 5 objects, got %v", m.GetGauge().GetValue())
+			}
+			return
+		}
+	}
+	t.Errorf("rescheduler_cache_objects not gathered")
+}
+
+func TestPush(t *testing.T) {
+	DeletedPodsCount.Inc()
+	var method, path, body, user, password string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		method, path, body = r.Method, r.URL.Path, string(data)
+		user, password, _ = r.BasicAuth()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	err := Push(http.DefaultClient, PushTarget{
+		URL:      server.URL,
+		Job:      "rescheduler",
+		Instance: "rescheduler-1",
+		Username: "user",
+		Password: "secret",
+	})
+	if err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+	if method != http.MethodPut || path != "/metrics/job/rescheduler/instance/rescheduler-1" {
+		t.Errorf("unexpected request %s %s", method, path)
+	}
+	if user != "user" || password != "secret" {
+		t.Errorf("unexpected credentials %q:%q", user, password)
+	}
+	if !strings.Contains(body, "rescheduler_deleted_pods_count") {
+		t.Errorf("pushed metrics don't contain rescheduler_deleted_pods_count:\n%s", body)
+	}
+
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad metrics", http.StatusBadRequest)
+	})
+	if err := Push(http.DefaultClient, PushTarget{URL: server.URL, Job: "rescheduler", Instance: "i"}); err == nil {
+		t.Errorf("expected an error for status 400")
+	}
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+// PushTarget describes a Pushgateway group the metrics in Registry are pushed to.
+type PushTarget struct {
+	// URL is the base URL of the Pushgateway, e.g. http://pushgateway:9091.
+	URL string
+	// Job and Instance form the grouping key.
+	Job      string
+	Instance string
+	// Username and Password enable basic auth; BearerToken enables token auth.
+	Username    string
+	Password    string
+	BearerToken string
+}
+
+// Push replaces the metrics of the target's group with the current contents of Registry.
+func Push(client *http.Client, target PushTarget) error {
+	families, err := Registry.Gather()
+	if err != nil {
+		return fmt.Errorf("failed to gather metrics: %v", err)
+	}
+	body := &bytes.Buffer{}
+	encoder := expfmt.NewEncoder(body, expfmt.FmtText)
+	for _, family := range families {
+		if err := encoder.Encode(family); err != nil {
+			return fmt.Errorf("failed to encode metric %s: %v", family.GetName(), err)
+		}
+	}
+
+	pushURL := fmt.Sprintf("%s/metrics/job/%s/instance/%s", target.URL, url.PathEscape(target.Job), url.PathEscape(target.Instance))
+	req, err := http.NewRequest(http.MethodPut, pushURL, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", string(expfmt.FmtText))
+	if target.Username != "" {
+		req.SetBasicAuth(target.Username, target.Password)
+	}
+	if target.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+target.BearerToken)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status %s from %s: %s", resp.Status, pushURL, bytes.TrimSpace(message))
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	kube_utils "k8s.io/autoscaler/cluster-autoscaler/utils/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// nodeReadinessHandler notifies <trigger> whenever a node becomes ready and
+// schedulable, either because it joined the cluster or because it recovered.
+// Critical pods may fit there without evictions, so they shouldn't wait for
+// the next housekeeping interval. It is added to the informer of the node
+// lister and never blocks: a pending notification already covers later
+// transitions.
+func nodeReadinessHandler(trigger chan<- struct{}) cache.ResourceEventHandlerFuncs {
+	notify := func(node *v1.Node) {
+		glog.V(2).Infof("Node %v became ready, triggering housekeeping", node.Name)
+		select {
+		case trigger <- struct{}{}:
+		default:
+		}
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if node, ok := obj.(*v1.Node); ok && kube_utils.IsNodeReadyAndSchedulable(node) {
+				notify(node)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldNode, ok := oldObj.(*v1.Node)
+			if !ok {
+				return
+			}
+			if node, ok := newObj.(*v1.Node); ok && !kube_utils.IsNodeReadyAndSchedulable(oldNode) && kube_utils.IsNodeReadyAndSchedulable(node) {
+				notify(node)
+			}
+		},
+	}
+}
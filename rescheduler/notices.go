@@ -0,0 +1,106 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kube_client "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// DisruptionNoticesAnnotationKey on a namespace lists the pods of the
+// namespace the rescheduler deleted recently, newest first, so that teams
+// without cluster-level access find out why their pods were evicted.
+const DisruptionNoticesAnnotationKey = "rescheduler.alpha.kubernetes.io/recent-disruptions"
+
+// maxDisruptionNotices is the number of notices kept per namespace.
+const maxDisruptionNotices = 10
+
+// disruptionNotice tells that Pod was deleted to make room for CriticalPod.
+type disruptionNotice struct {
+	Pod         string      `json:"pod"`
+	CriticalPod string      `json:"criticalPod"`
+	Node        string      `json:"node"`
+	DecisionID  string      `json:"decisionID,omitempty"`
+	Time        metav1.Time `json:"time"`
+}
+
+// noticeVictimNamespaces adds a notice about each of <victims> to its
+// namespace, with --victim-namespace-notices. Notices older than the
+// disruption history window are dropped.
+func noticeVictimNamespaces(client kube_client.Interface, criticalPod *v1.Pod, node, decisionID string, victims []*v1.Pod, now time.Time) {
+	if !*victimNamespaceNotices {
+		return
+	}
+	byNamespace := map[string][]disruptionNotice{}
+	for _, victim := range victims {
+		byNamespace[victim.Namespace] = append(byNamespace[victim.Namespace], disruptionNotice{
+			Pod:         victim.Name,
+			CriticalPod: podId(criticalPod),
+			Node:        node,
+			DecisionID:  decisionID,
+			Time:        metav1.NewTime(now),
+		})
+	}
+	for namespace, notices := range byNamespace {
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			ns, err := client.CoreV1().Namespaces().Get(namespace, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			if ns.Annotations == nil {
+				ns.Annotations = map[string]string{}
+			}
+			annotation, err := json.Marshal(mergeDisruptionNotices(ns.Annotations[DisruptionNoticesAnnotationKey], notices, now))
+			if err != nil {
+				return err
+			}
+			ns.Annotations[DisruptionNoticesAnnotationKey] = string(annotation)
+			_, err = client.CoreV1().Namespaces().Update(ns)
+			return err
+		})
+		if err != nil {
+			repeats.Warningf("notice-namespace/"+namespace, "Failed to annotate namespace %s with recent disruptions: %v", namespace, err)
+		}
+	}
+}
+
+// mergeDisruptionNotices adds <notices> to those in <annotation>, keeping the
+// newest ones within the disruption history window.
+func mergeDisruptionNotices(annotation string, notices []disruptionNotice, now time.Time) []disruptionNotice {
+	existing := []disruptionNotice{}
+	if annotation != "" {
+		// a malformed annotation is replaced
+		json.Unmarshal([]byte(annotation), &existing)
+	}
+	merged := []disruptionNotice{}
+	for _, notice := range append(notices, existing...) {
+		if now.Sub(notice.Time.Time) < *disruptionHistoryWindow {
+			merged = append(merged, notice)
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].Time.After(merged[j].Time.Time) })
+	if len(merged) > maxDisruptionNotices {
+		merged = merged[:maxDisruptionNotices]
+	}
+	return merged
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	kube_client "k8s.io/client-go/kubernetes"
+)
+
+// Annotations on a DaemonSet which override the flags and config for its
+// critical pods.
+const (
+	// DisabledAnnotationKey set to "true" leaves the pods alone.
+	DisabledAnnotationKey = "rescheduler.alpha.kubernetes.io/disabled"
+	// MaxVictimsAnnotationKey caps the evictions of a single placement, "0"
+	// allows only placements without evictions. It replaces the maxVictims
+	// of the priority class.
+	MaxVictimsAnnotationKey = "rescheduler.alpha.kubernetes.io/max-victims"
+	// VictimNamespacesAnnotationKey is a comma separated list of the only
+	// namespaces whose pods may be evicted.
+	VictimNamespacesAnnotationKey = "rescheduler.alpha.kubernetes.io/victim-namespaces"
+	// ReservationNodeSelectorAnnotationKey is a label selector restricting
+	// the nodes which may be reserved.
+	ReservationNodeSelectorAnnotationKey = "rescheduler.alpha.kubernetes.io/reservation-node-selector"
+)
+
+// daemonSetOverrides are the overrides parsed from the annotations above.
+type daemonSetOverrides struct {
+	Disabled bool
+	// MaxVictims is nil if the DaemonSet doesn't cap evictions.
+	MaxVictims *int
+	// VictimNamespaces is nil if pods of all namespaces may be evicted.
+	VictimNamespaces sets.String
+	// NodeSelector is nil if all nodes may be reserved.
+	NodeSelector labels.Selector
+}
+
+// daemonSetOverridesOf returns the overrides set on the DaemonSet owning
+// <pod>. If it can't be read, there are none.
+func daemonSetOverridesOf(client kube_client.Interface, pod *v1.Pod) daemonSetOverrides {
+	for _, ref := range pod.OwnerReferences {
+		if ref.Kind != "DaemonSet" {
+			continue
+		}
+		ds, err := client.AppsV1().DaemonSets(pod.Namespace).Get(ref.Name, metav1.GetOptions{})
+		if errors.IsNotFound(err) || (err == nil && ds == nil) {
+			return daemonSetOverrides{}
+		}
+		if err != nil {
+			repeats.Warningf("get-daemonset/"+pod.Namespace+"/"+ref.Name,
+				"Failed to get DaemonSet %s/%s of pod %s, ignoring its overrides: %v", pod.Namespace, ref.Name, podId(pod), err)
+			return daemonSetOverrides{}
+		}
+		return parseDaemonSetOverrides(ds.Namespace+"/"+ds.Name, ds.Annotations)
+	}
+	return daemonSetOverrides{}
+}
+
+// parseDaemonSetOverrides parses the override annotations of DaemonSet <name>.
+// Invalid annotations are logged and ignored.
+func parseDaemonSetOverrides(name string, annotations map[string]string) daemonSetOverrides {
+	overrides := daemonSetOverrides{Disabled: annotations[DisabledAnnotationKey] == "true"}
+	if value, found := annotations[MaxVictimsAnnotationKey]; found {
+		if max, err := strconv.Atoi(value); err != nil || max < 0 {
+			glog.Warningf("Ignoring invalid %s annotation %q on DaemonSet %s", MaxVictimsAnnotationKey, value, name)
+		} else {
+			overrides.MaxVictims = &max
+		}
+	}
+	if value, found := annotations[VictimNamespacesAnnotationKey]; found {
+		overrides.VictimNamespaces = sets.NewString()
+		for _, namespace := range strings.Split(value, ",") {
+			if namespace = strings.TrimSpace(namespace); namespace != "" {
+				overrides.VictimNamespaces.Insert(namespace)
+			}
+		}
+	}
+	if value, found := annotations[ReservationNodeSelectorAnnotationKey]; found {
+		if selector, err := labels.Parse(value); err != nil {
+			glog.Warningf("Ignoring invalid %s annotation %q on DaemonSet %s: %v", ReservationNodeSelectorAnnotationKey, value, name, err)
+		} else {
+			overrides.NodeSelector = selector
+		}
+	}
+	return overrides
+}
+
+// maxVictims returns the eviction cap for <pod> from its DaemonSet or
+// priority class, or -1 if evictions aren't capped.
+func (o daemonSetOverrides) maxVictims(pod *v1.Pod) int {
+	if o.MaxVictims != nil {
+		return *o.MaxVictims
+	}
+	if max := priorityClassPolicyOf(pod).MaxVictims; max > 0 {
+		return max
+	}
+	return -1
+}
+
+// reservable drops the nodes not matching NodeSelector from <nodes>.
+func (o daemonSetOverrides) reservable(nodes []*v1.Node) []*v1.Node {
+	if o.NodeSelector == nil {
+		return nodes
+	}
+	matching := []*v1.Node{}
+	for _, node := range nodes {
+		if o.NodeSelector.Matches(labels.Set(node.Labels)) {
+			matching = append(matching, node)
+		}
+	}
+	return matching
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+)
+
+// TaintOwnersAnnotationKey is the node annotation mapping the values of
+// reservation taints on the node to the --taint-owner of the rescheduler which
+// added them. Taints without an entry belong to reschedulers without an owner.
+const TaintOwnersAnnotationKey = "rescheduler.alpha.kubernetes.io/taint-owners"
+
+func taintOwners(node *v1.Node) map[string]string {
+	owners := map[string]string{}
+	data, found := node.Annotations[TaintOwnersAnnotationKey]
+	if !found {
+		return owners
+	}
+	if err := json.Unmarshal([]byte(data), &owners); err != nil {
+		glog.Warningf("Ignoring invalid %s annotation on node %v: %v", TaintOwnersAnnotationKey, node.Name, err)
+		return map[string]string{}
+	}
+	return owners
+}
+
+// ownsTaint returns true if <taint> was added by a rescheduler with the same --taint-owner.
+func ownsTaint(node *v1.Node, taint v1.Taint) bool {
+	return taintOwners(node)[taint.Value] == *taintOwner
+}
+
+// setTaintOwners stores <owners> on <node>, removing the annotation if it is empty.
+// The annotations map is replaced rather than modified, as <node> may be shared with a cache.
+func setTaintOwners(node *v1.Node, owners map[string]string) {
+	annotations := map[string]string{}
+	for key, value := range node.Annotations {
+		annotations[key] = value
+	}
+	if len(owners) == 0 {
+		delete(annotations, TaintOwnersAnnotationKey)
+	} else {
+		data, _ := json.Marshal(owners)
+		annotations[TaintOwnersAnnotationKey] = string(data)
+	}
+	node.Annotations = annotations
+}
+
+// claimTaint records this rescheduler as the owner of <taint> on <node>.
+func claimTaint(node *v1.Node, taint v1.Taint) {
+	if *taintOwner == "" {
+		return
+	}
+	owners := taintOwners(node)
+	owners[taint.Value] = *taintOwner
+	setTaintOwners(node, owners)
+}
+
+// disownTaints removes the owner entries of <released> taints from <node>.
+func disownTaints(node *v1.Node, released []v1.Taint) {
+	owners := taintOwners(node)
+	if len(owners) == 0 {
+		return
+	}
+	for _, taint := range released {
+		delete(owners, taint.Value)
+	}
+	setTaintOwners(node, owners)
+}
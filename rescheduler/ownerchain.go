@@ -0,0 +1,173 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"time"
+
+	"github.com/hashicorp/golang-lru"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	kube_client "k8s.io/client-go/kubernetes"
+	"k8s.io/contrib/rescheduler/engine"
+)
+
+const (
+	// maxOwnerLinks bounds the number of controllers ownerResolver remembers
+	// the controller of.
+	maxOwnerLinks = 10000
+	// ownerLinkMaxAge is how long a remembered controller is trusted, as
+	// objects can be adopted and orphaned.
+	ownerLinkMaxAge = 10 * time.Minute
+	// maxOwnerChain bounds the length of owner chains, in case of cycles.
+	maxOwnerChain = 5
+)
+
+// ownerKey identifies a controller in the namespace of a pod.
+type ownerKey struct {
+	Kind string
+	Name string
+}
+
+func (k ownerKey) String() string {
+	if k.Kind == "" {
+		return ""
+	}
+	return k.Kind + "/" + k.Name
+}
+
+// owners resolves the controllers of critical pods. It is set in main; tests
+// leave it without a client, so that only the pods' own controllers are seen.
+var owners = newOwnerResolver(nil)
+
+// ownerResolver follows the controller references of pods through
+// intermediate owners, e.g. from a ReplicaSet to its Deployment.
+type ownerResolver struct {
+	client kube_client.Interface
+	// links maps controllers to ownerLinks.
+	links *lru.Cache
+}
+
+// ownerLink is the controller of a controller, as far as it was found.
+type ownerLink struct {
+	uid types.UID
+	// parent is nil for top-level controllers.
+	parent *metav1.OwnerReference
+	at     time.Time
+}
+
+func newOwnerResolver(client kube_client.Interface) *ownerResolver {
+	links, err := lru.New(maxOwnerLinks)
+	if err != nil {
+		panic(err)
+	}
+	return &ownerResolver{client: client, links: links}
+}
+
+// Len returns the number of controllers remembered.
+func (o *ownerResolver) Len() int {
+	return o.links.Len()
+}
+
+// Chain returns the controllers of <pod>, starting with its own and ending
+// with the top-level one. Controllers which can't be looked up, for example
+// custom resources, end the chain.
+func (o *ownerResolver) Chain(pod *v1.Pod) []ownerKey {
+	chain := []ownerKey{}
+	for ref := metav1.GetControllerOf(pod); ref != nil && len(chain) < maxOwnerChain; {
+		chain = append(chain, ownerKey{Kind: ref.Kind, Name: ref.Name})
+		if o.client == nil {
+			break
+		}
+		ref = o.parentOf(pod.Namespace, ref)
+	}
+	return chain
+}
+
+// TopOwner returns the top-level controller of <pod>, zero if it has none.
+func (o *ownerResolver) TopOwner(pod *v1.Pod) ownerKey {
+	chain := o.Chain(pod)
+	if len(chain) == 0 {
+		return ownerKey{}
+	}
+	return chain[len(chain)-1]
+}
+
+// IsDaemonSetPod is engine.IsDaemonSetPod which also accepts pods with a
+// DaemonSet further up their owner chain.
+func (o *ownerResolver) IsDaemonSetPod(pod *v1.Pod) bool {
+	if engine.IsDaemonSetPod(pod) {
+		return true
+	}
+	for _, owner := range o.Chain(pod) {
+		if owner.Kind == "DaemonSet" {
+			return true
+		}
+	}
+	return false
+}
+
+// parentOf returns the controller of the controller <ref> in <namespace>, or
+// nil if it has none or it can't be found.
+func (o *ownerResolver) parentOf(namespace string, ref *metav1.OwnerReference) *metav1.OwnerReference {
+	key := namespace + "/" + ref.Kind + "/" + ref.Name
+	if value, found := o.links.Get(key); found {
+		link := value.(ownerLink)
+		if link.uid == ref.UID && time.Since(link.at) < ownerLinkMaxAge {
+			return link.parent
+		}
+	}
+	object, err := o.get(namespace, ref)
+	if errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		repeats.Warningf("get-owner/"+key, "Failed to get %s %s/%s to find its owner: %v", ref.Kind, namespace, ref.Name, err)
+		return nil
+	}
+	if object == nil || object.GetUID() != ref.UID {
+		// an unknown kind, or the owner was replaced by one with the same name
+		return nil
+	}
+	link := ownerLink{uid: ref.UID, parent: metav1.GetControllerOf(object), at: time.Now()}
+	o.links.Add(key, link)
+	return link.parent
+}
+
+// get returns the controller <ref> in <namespace>, or nil if its kind isn't
+// one of the built-in controllers.
+func (o *ownerResolver) get(namespace string, ref *metav1.OwnerReference) (metav1.Object, error) {
+	options := metav1.GetOptions{}
+	switch ref.Kind {
+	case "ReplicaSet":
+		return o.client.AppsV1().ReplicaSets(namespace).Get(ref.Name, options)
+	case "Deployment":
+		return o.client.AppsV1().Deployments(namespace).Get(ref.Name, options)
+	case "DaemonSet":
+		return o.client.AppsV1().DaemonSets(namespace).Get(ref.Name, options)
+	case "StatefulSet":
+		return o.client.AppsV1().StatefulSets(namespace).Get(ref.Name, options)
+	case "ControllerRevision":
+		return o.client.AppsV1().ControllerRevisions(namespace).Get(ref.Name, options)
+	case "ReplicationController":
+		return o.client.CoreV1().ReplicationControllers(namespace).Get(ref.Name, options)
+	case "Job":
+		return o.client.BatchV1().Jobs(namespace).Get(ref.Name, options)
+	}
+	return nil, nil
+}
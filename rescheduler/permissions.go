@@ -0,0 +1,306 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kube_client "k8s.io/client-go/kubernetes"
+)
+
+// serviceAccountUserPrefix starts the user names of service accounts, as in
+// "system:serviceaccount:<namespace>:<name>".
+const serviceAccountUserPrefix = "system:serviceaccount:"
+
+// apiPermission is an API access a feature of the rescheduler relies on.
+type apiPermission struct {
+	// feature tells what doesn't work without the permission.
+	feature string
+	verbs   []string
+	group   string
+	// resource may name a subresource as in "pods/status".
+	resource string
+	// namespace is "" for access in all namespaces.
+	namespace string
+	// essential permissions are needed to carry out any placement, including
+	// reading the kill switch: without them the rescheduler runs in shadow mode.
+	essential bool
+}
+
+func (p apiPermission) String() string {
+	where := "in all namespaces"
+	if p.namespace != "" {
+		where = "in namespace " + p.namespace
+	}
+	resource := p.resource
+	if p.group != "" {
+		resource += "." + p.group
+	}
+	return fmt.Sprintf("%v %s %s", p.verbs, resource, where)
+}
+
+// requiredPermissions returns the permissions needed by the features
+// enabled with the current flags.
+func requiredPermissions() []apiPermission {
+	permissions := []apiPermission{
+		{feature: "finding nodes", verbs: []string{"get", "list", "watch"}, resource: "nodes"},
+		{feature: "finding pods", verbs: []string{"get", "list", "watch"}, resource: "pods"},
+		{feature: "tainting nodes", verbs: []string{"update"}, resource: "nodes", essential: true},
+		{feature: "releasing taints when updates fail", verbs: []string{"patch"}, resource: "nodes"},
+		{feature: "evicting pods", verbs: []string{"delete"}, resource: "pods", essential: true},
+		{feature: "events", verbs: []string{"create", "patch", "update"}, resource: "events"},
+		{feature: "the " + string(ReschedulerReservingCondition) + " pod condition", verbs: []string{"update"}, resource: "pods/status", namespace: *systemNamespace},
+		{feature: "DaemonSet overrides", verbs: []string{"get"}, group: "apps", resource: "daemonsets", namespace: *systemNamespace},
+		{feature: "the kill switch annotation", verbs: []string{"get"}, resource: "namespaces", essential: true},
+		{feature: "volume-aware victim selection", verbs: []string{"get"}, resource: "persistentvolumeclaims"},
+	}
+	// owner chains of critical pods are followed through the built-in controllers
+	for _, resource := range []struct{ group, resource string }{
+		{"", "replicationcontrollers"},
+		{"apps", "replicasets"},
+		{"apps", "deployments"},
+		{"apps", "daemonsets"},
+		{"apps", "statefulsets"},
+		{"apps", "controllerrevisions"},
+		{"batch", "jobs"},
+	} {
+		permissions = append(permissions, apiPermission{feature: "owner chains", verbs: []string{"get"}, group: resource.group, resource: resource.resource})
+	}
+	// the predicate checker runs the scheduler's predicates on informer caches
+	for _, resource := range []struct{ group, resource string }{
+		{"", "services"},
+		{"", "replicationcontrollers"},
+		{"", "persistentvolumes"},
+		{"", "persistentvolumeclaims"},
+		{"policy", "poddisruptionbudgets"},
+		{"apps", "statefulsets"},
+		{"extensions", "replicasets"},
+		{"storage.k8s.io", "storageclasses"},
+	} {
+		permissions = append(permissions, apiPermission{feature: "scheduler predicates", verbs: []string{"list", "watch"}, group: resource.group, resource: resource.resource})
+	}
+	if *killSwitchConfigMap != "" {
+		permissions = append(permissions, apiPermission{feature: "the kill switch ConfigMap", verbs: []string{"get"}, resource: "configmaps", namespace: ownNamespace(), essential: true})
+	}
+	if *effectiveConfigMap != "" {
+		permissions = append(permissions, apiPermission{feature: "the effective configuration ConfigMap", verbs: []string{"get", "create", "update"}, resource: "configmaps", namespace: ownNamespace()})
+	}
+	if *disruptionHistoryConfigMap != "" {
+		permissions = append(permissions, apiPermission{feature: "the disruption history ConfigMap", verbs: []string{"get", "create", "update"}, resource: "configmaps", namespace: ownNamespace()})
+	}
+	if *victimNamespaceNotices {
+		permissions = append(permissions, apiPermission{feature: "victim namespace notices", verbs: []string{"get", "update"}, resource: "namespaces"})
+	}
+	if *coverageReportInterval > 0 {
+		permissions = append(permissions, apiPermission{feature: "the DaemonSet coverage report", verbs: []string{"list"}, group: "apps", resource: "daemonsets", namespace: *systemNamespace})
+	}
+	if *coverageConfigMap != "" {
+		permissions = append(permissions, apiPermission{feature: "the DaemonSet coverage ConfigMap", verbs: []string{"get", "create", "update"}, resource: "configmaps", namespace: ownNamespace()})
+	}
+	if *eventSink == eventSinkEventsAPI {
+		permissions = append(permissions, apiPermission{feature: "events.k8s.io events", verbs: []string{"create", "update"}, group: "events.k8s.io", resource: "events"})
+	}
+	for _, spec := range *actionSinkSpecs {
+		if spec == "crd" {
+			permissions = append(permissions, apiPermission{feature: "the crd action sink", verbs: []string{"create"}, group: actionGroup, resource: actionResource, namespace: ownNamespace()})
+		}
+	}
+	if *adminListenAddress != "" {
+		permissions = append(permissions,
+			apiPermission{feature: "admin endpoint authentication", verbs: []string{"create"}, group: "authentication.k8s.io", resource: "tokenreviews"},
+			apiPermission{feature: "admin endpoint authorization", verbs: []string{"create"}, group: "authorization.k8s.io", resource: "subjectaccessreviews"})
+	}
+	// impersonation is checked against the rescheduler's own identity, all
+	// other permissions against the impersonated one
+	if strings.HasPrefix(*impersonateUser, serviceAccountUserPrefix) {
+		namespace := strings.SplitN(strings.TrimPrefix(*impersonateUser, serviceAccountUserPrefix), ":", 2)[0]
+		permissions = append(permissions, apiPermission{feature: "--impersonate-user", verbs: []string{"impersonate"}, resource: "serviceaccounts", namespace: namespace})
+	} else if *impersonateUser != "" {
+		permissions = append(permissions, apiPermission{feature: "--impersonate-user", verbs: []string{"impersonate"}, resource: "users"})
+	}
+	if len(*impersonateGroups) > 0 {
+		permissions = append(permissions, apiPermission{feature: "--impersonate-group", verbs: []string{"impersonate"}, resource: "groups"})
+	}
+	if *shortfallConfigMap != "" {
+		permissions = append(permissions, apiPermission{feature: "the capacity shortfall ConfigMap", verbs: []string{"get", "create", "update"}, resource: "configmaps", namespace: ownNamespace()})
+	}
+	return permissions
+}
+
+// missingEssentialPermissions is set when the rescheduler may not taint nodes,
+// delete pods or read the kill switch, which makes it stay in shadow mode.
+var missingEssentialPermissions bool
+
+// inShadowMode returns true if taints and evictions should only be reported,
+// because shadow mode is on or the rescheduler lacks the permissions for them.
+func inShadowMode() bool {
+	return currentConfig().ShadowMode || missingEssentialPermissions
+}
+
+// probePermissions checks <permissions> with SelfSubjectAccessReviews and
+// warns about the features which won't work. It returns the permissions which
+// are missing. Permissions which can't be checked are assumed to be granted.
+// Impersonation isn't checked: the client's reviews run as the impersonated
+// identity, which isn't the one which needs it.
+func probePermissions(client kube_client.Interface, permissions []apiPermission) []apiPermission {
+	missing := []apiPermission{}
+	for _, permission := range permissions {
+		for _, verb := range permission.verbs {
+			if verb == "impersonate" {
+				continue
+			}
+			allowed, err := accessAllowed(client, permission, verb)
+			if err != nil {
+				glog.Warningf("Failed to check permission to %s %s: %v", verb, permission.resource, err)
+				continue
+			}
+			if !allowed {
+				glog.Warningf("Missing permission to %s %s, %s won't work", verb, permission.resource, permission.feature)
+				missing = append(missing, permission)
+				break
+			}
+		}
+	}
+	return missing
+}
+
+func accessAllowed(client kube_client.Interface, permission apiPermission, verb string) (bool, error) {
+	resource, subresource := permission.resource, ""
+	if parts := strings.SplitN(resource, "/", 2); len(parts) == 2 {
+		resource, subresource = parts[0], parts[1]
+	}
+	review, err := client.AuthorizationV1().SelfSubjectAccessReviews().Create(&authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace:   permission.namespace,
+				Verb:        verb,
+				Group:       permission.group,
+				Resource:    resource,
+				Subresource: subresource,
+			},
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	return review.Status.Allowed, nil
+}
+
+// degradeToPermissions probes the permissions of the enabled features and
+// falls back to shadow mode if taints, evictions or reading the kill switch
+// aren't allowed.
+func degradeToPermissions(client kube_client.Interface) {
+	for _, permission := range probePermissions(client, requiredPermissions()) {
+		if permission.essential {
+			missingEssentialPermissions = true
+		}
+	}
+	if missingEssentialPermissions {
+		glog.Warningf("The rescheduler lacks permissions essential to placements, running in shadow mode")
+	}
+}
+
+// printRBAC writes a ClusterRole named <name> with the cluster-wide
+// permissions in <permissions>, followed by a Role for each namespace with
+// namespaced ones, as YAML documents.
+func printRBAC(w io.Writer, name string, permissions []apiPermission) error {
+	rulesByNamespace := map[string][]rbacv1.PolicyRule{}
+	for _, permission := range permissions {
+		rules := rulesByNamespace[permission.namespace]
+		merged := false
+		for i := range rules {
+			if rules[i].APIGroups[0] == permission.group && rules[i].Resources[0] == permission.resource {
+				rules[i].Verbs = mergeVerbs(rules[i].Verbs, permission.verbs)
+				merged = true
+			}
+		}
+		if !merged {
+			rules = append(rules, rbacv1.PolicyRule{
+				APIGroups: []string{permission.group},
+				Resources: []string{permission.resource},
+				Verbs:     mergeVerbs(nil, permission.verbs),
+			})
+		}
+		rulesByNamespace[permission.namespace] = rules
+	}
+	namespaces := []string{}
+	for namespace := range rulesByNamespace {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	objects := []interface{}{}
+	for _, namespace := range namespaces {
+		rules := rulesByNamespace[namespace]
+		sort.Slice(rules, func(i, j int) bool {
+			if rules[i].APIGroups[0] != rules[j].APIGroups[0] {
+				return rules[i].APIGroups[0] < rules[j].APIGroups[0]
+			}
+			return rules[i].Resources[0] < rules[j].Resources[0]
+		})
+		if namespace == "" {
+			objects = append(objects, &rbacv1.ClusterRole{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+				ObjectMeta: metav1.ObjectMeta{Name: name},
+				Rules:      rules,
+			})
+		} else {
+			objects = append(objects, &rbacv1.Role{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role"},
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+				Rules:      rules,
+			})
+		}
+	}
+	for i, object := range objects {
+		data, err := yaml.Marshal(object)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Fprintln(w, "---")
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeVerbs returns the sorted union of <verbs> and <more>.
+func mergeVerbs(verbs, more []string) []string {
+	merged := append([]string{}, verbs...)
+	for _, verb := range more {
+		found := false
+		for _, v := range merged {
+			found = found || v == verb
+		}
+		if !found {
+			merged = append(merged, verb)
+		}
+	}
+	sort.Strings(merged)
+	return merged
+}
@@ -0,0 +1,405 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/contrib/rescheduler/engine"
+	"k8s.io/contrib/rescheduler/metrics"
+)
+
+// planningPass is the state shared by the critical pods planned together.
+type planningPass struct {
+	plan  *engine.Plan
+	nodes []*v1.Node
+	lists *podLists
+	// planned are the nodes of plan.Placements.
+	planned sets.String
+	spread  *engine.ReplicaSpread
+	// deadline is when the pass is over --planning-budget, zero if there is
+	// no budget. Once it passed, truncated is set and pods are planned on a
+	// sample of the nodes.
+	deadline  time.Time
+	truncated bool
+}
+
+// available returns the nodes of the pass which have no placement yet.
+func (p *planningPass) available() []*v1.Node {
+	nodes := make([]*v1.Node, 0, len(p.nodes))
+	for _, node := range p.nodes {
+		if !p.planned.Has(node.Name) {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// buildPlan decides where each of <criticalPods> should be placed. It only
+// reads cluster state; the plan is carried out by applyPlan. All pods are
+// planned in one pass: nodes and the pods on them are listed once, and each
+// node is reserved for at most one of the pods, as a node planned for one pod
+// can't be reserved for another one when the plan is applied. A pod which
+// only fits on a node planned for an earlier pod gets it if the earlier pod
+// fits elsewhere, see resolveConflict.
+func (r *rescheduler) buildPlan(ctx context.Context, criticalPods []*v1.Pod) *engine.Plan {
+	plan := engine.NewPlan()
+	if len(criticalPods) == 0 {
+		return plan
+	}
+	allNodes, err := r.nodeLister.List()
+	if err != nil {
+		repeats.Errorf("list-nodes", "Failed to list nodes: %v", err)
+		return plan
+	}
+	lists, err := r.boundPods.snapshot(allNodes)
+	if err != nil {
+		repeats.Errorf("snapshot-pods", "Failed to take the pods of all nodes from the cache, listing them per node: %v", err)
+	}
+	if lists == nil {
+		lists = newPodLists()
+	}
+	pass := &planningPass{plan: plan, nodes: allNodes, lists: lists, planned: sets.NewString(), spread: engine.NewReplicaSpread()}
+	if *planningBudget > 0 {
+		pass.deadline = r.clock.Now().Add(*planningBudget)
+	}
+	for _, pod := range byUrgency(criticalPods) {
+		if ctx.Err() != nil {
+			break
+		}
+		if failedPlacements.GivenUp(pod) {
+			glog.V(2).Infof("Not placing critical pod %s, it was given up after %d timed out placements.", podId(pod), *maxPlacementAttempts)
+			continue
+		}
+		if failedPlacements.BackingOff(pod, r.clock.Now()) {
+			glog.V(2).Infof("Not placing critical pod %s yet, its last placement failed recently.", podId(pod))
+			continue
+		}
+		overrides := daemonSetOverridesOf(r.client, pod)
+		if overrides.Disabled {
+			glog.V(2).Infof("Not placing critical pod %s, disabled by its DaemonSet.", podId(pod))
+			continue
+		}
+		glog.Infof("Critical pod %s is unschedulable. Trying to find a spot for it.", podId(pod))
+		metrics.UnschedulableCriticalPodsCount.WithLabelValues(k8sApp(pod)).Inc()
+		available := pass.available()
+		placement, reason := r.planPod(ctx, pass, pod, overrides, available)
+		if placement == nil {
+			placement, reason = r.resolveConflict(ctx, pass, pod, overrides, reason)
+		}
+		if placement == nil {
+			unplaceable := &engine.Unplaceable{Pod: pod, Reason: reason, DecisionID: newDecisionID()}
+			decisions.AddUnplaceable(unplaceable, len(available))
+			plan.Unplaceable = append(plan.Unplaceable, unplaceable)
+			continue
+		}
+		r.addPlacement(pass, placement, len(available))
+	}
+	return plan
+}
+
+// planPod plans <pod> on the first of <nodes> it fits on, returning either
+// the placement or why there is none.
+func (r *rescheduler) planPod(ctx context.Context, pass *planningPass, pod *v1.Pod, overrides daemonSetOverrides, nodes []*v1.Node) (*engine.Placement, string) {
+	nodes = candidateNodes(prefilterNodes(failedPlacements.untried(overrides.reservable(nodes), pod), pod), pod, append(pass.spread.Scorers(), failedPlacements)...)
+	snapshot := r.findSnapshotWithinBudget(ctx, pass, nodes, pod)
+	if snapshot == nil {
+		return nil, "no node satisfies predicates"
+	}
+	placement, err := engine.PlanPlacement(r.predicateChecker, snapshot, pod)
+	if err != nil {
+		return nil, err.Error()
+	}
+	if max := overrides.maxVictims(pod); max >= 0 && len(placement.Victims) > max {
+		return nil, fmt.Sprintf("node %s needs %d victims, at most %d are allowed", snapshot.Node.Name, len(placement.Victims), max)
+	}
+	return placement, ""
+}
+
+// prefilterNodes drops the <nodes> <pod> can't run on according to
+// engine.Prefilter, before they are ordered and the pods on them are listed.
+func prefilterNodes(nodes []*v1.Node, pod *v1.Pod) []*v1.Node {
+	candidates := make([]*v1.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if err := engine.Prefilter(node, pod); err != nil {
+			glog.V(4).Infof("Skipping node %v: %v", node.Name, err)
+			if prefilterErr, ok := err.(*engine.PrefilterError); ok {
+				metrics.PrefilteredNodesCount.WithLabelValues(prefilterErr.Check).Inc()
+			}
+			continue
+		}
+		candidates = append(candidates, node)
+	}
+	return candidates
+}
+
+// addPlacement adds <placement> to the plan of <pass>.
+func (r *rescheduler) addPlacement(pass *planningPass, placement *engine.Placement, candidates int) {
+	pod, node := placement.Pod, placement.Node
+	path := placementPath(placement)
+	glog.Infof("Critical pod %s fits on node %v with %s (%d victims).", podId(pod), node.Name, strings.Replace(path, "_", " ", -1), len(placement.Victims))
+	metrics.PlacementPathsCount.WithLabelValues(path).Inc()
+	metrics.PlacementOwnersCount.WithLabelValues(ownerKind(pod)).Inc()
+	pass.spread.Add(node, pod)
+	pass.planned.Insert(node.Name)
+	placement.DecisionID = newDecisionID()
+	placement.Taint = reservationTaint(pod, r.clock.Now())
+	decisions.AddPlacement(placement, candidates)
+	pass.plan.Placements = append(pass.plan.Placements, placement)
+}
+
+// ownerKind labels <pod> in metrics with the kind of its top-level controller.
+func ownerKind(pod *v1.Pod) string {
+	if kind := owners.TopOwner(pod).Kind; kind != "" {
+		return kind
+	}
+	return "none"
+}
+
+// resolveConflict is called for <pod>, which fits on none of the nodes
+// without a placement for <reason>. If it fits on a node planned for an
+// earlier pod, which was planned first for being at least as urgent, that pod
+// is moved to another node if there is one, and <pod> takes its node.
+// Otherwise the conflict is reported in the returned reason.
+func (r *rescheduler) resolveConflict(ctx context.Context, pass *planningPass, pod *v1.Pod, overrides daemonSetOverrides, reason string) (*engine.Placement, string) {
+	for i, holder := range pass.plan.Placements {
+		if ctx.Err() != nil {
+			break
+		}
+		placement, _ := r.planPod(ctx, pass, pod, overrides, []*v1.Node{holder.Node})
+		if placement == nil {
+			continue
+		}
+		moved, _ := r.planPod(ctx, pass, holder.Pod, daemonSetOverridesOf(r.client, holder.Pod), pass.available())
+		if moved == nil {
+			glog.Infof("Critical pod %s only fits on node %v, which is planned for %s with no other node for it.", podId(pod), holder.Node.Name, podId(holder.Pod))
+			metrics.ReservationConflictsCount.WithLabelValues("unresolved").Inc()
+			reason = fmt.Sprintf("%s; node %s fits but is needed by critical pod %s", reason, holder.Node.Name, podId(holder.Pod))
+			continue
+		}
+		glog.Infof("Critical pod %s only fits on node %v, moving %s from there to node %v.", podId(pod), holder.Node.Name, podId(holder.Pod), moved.Node.Name)
+		metrics.ReservationConflictsCount.WithLabelValues("resolved").Inc()
+		decisions.SetOutcome(holder.DecisionID, "moved")
+		pass.plan.Placements = append(pass.plan.Placements[:i], pass.plan.Placements[i+1:]...)
+		pass.spread.Remove(holder.Node, holder.Pod)
+		pass.planned.Delete(holder.Node.Name)
+		r.addPlacement(pass, moved, len(pass.nodes))
+		return placement, ""
+	}
+	return nil, reason
+}
+
+// byUrgency returns <pods> ordered by the urgency of their priority class,
+// highest first, then by priority, keeping the order of pods which are equal
+// in both.
+func byUrgency(pods []*v1.Pod) []*v1.Pod {
+	sorted := append([]*v1.Pod{}, pods...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return moreUrgent(sorted[i], sorted[j])
+	})
+	return sorted
+}
+
+// moreUrgent returns true if pod <a> goes before pod <b> in byUrgency.
+func moreUrgent(a, b *v1.Pod) bool {
+	if ua, ub := priorityClassPolicyOf(a).Urgency, priorityClassPolicyOf(b).Urgency; ua != ub {
+		return ua > ub
+	}
+	return podPriority(a) > podPriority(b)
+}
+
+// podPriority returns the priority of <pod>, 0 if it has none.
+func podPriority(pod *v1.Pod) int32 {
+	if pod.Spec.Priority == nil {
+		return 0
+	}
+	return *pod.Spec.Priority
+}
+
+// placementPath tells whether <placement> needs evictions: findNodeForPod
+// only settles for a node with victims if the pod fits nowhere as it is.
+func placementPath(placement *engine.Placement) string {
+	return evictionsPath(len(placement.Victims))
+}
+
+// evictionsPath labels a placement with <victims> evictions in metrics.
+func evictionsPath(victims int) string {
+	if victims == 0 {
+		return "no_evictions"
+	}
+	return "evictions"
+}
+
+// applyPlan carries out <plan>. In shadow mode it only reports what would be done.
+func (r *rescheduler) applyPlan(ctx context.Context, plan *engine.Plan) {
+	unplaceablePods.Set(plan.Unplaceable)
+	summarized := r.reportShortfall(plan.Unplaceable)
+	for _, unplaceable := range plan.Unplaceable {
+		pod := unplaceable.Pod
+		recordOutcome(pod, unplaceable.DecisionID, "no_node")
+		repeats.Errorf("unplaceable/"+podId(pod), "Pod %s can't be scheduled on any existing node: %s", podId(pod), unplaceable.Reason)
+		if summarized {
+			continue
+		}
+		repeats.Eventf(r.recorder, "unplaceable/"+podId(pod), pod, placementAnnotations(pod, unplaceable.DecisionID), v1.EventTypeWarning, EventReasonNoFeasibleNode,
+			"Critical pod %s doesn't fit on any node.", podId(pod))
+	}
+
+	shadow := inShadowMode()
+	plan.Placements = placementsByUrgency(plan.Placements)
+	budgetExhausted := false
+	for i, placement := range plan.Placements {
+		if ctx.Err() != nil {
+			skipPlan(&engine.Plan{Placements: plan.Placements[i:]}, "cancelled")
+			for _, skipped := range plan.Placements[i:] {
+				recordOutcome(skipped.Pod, skipped.DecisionID, "aborted")
+			}
+			return
+		}
+		if shadow {
+			shadowPlacement(r.recorder, placement)
+			decisions.SetOutcome(placement.DecisionID, "shadow")
+			continue
+		}
+		pod := placement.Pod
+		if len(placement.Victims) > 0 && !currentConfig().MaintenanceWindows.EvictionsAllowed(pod, r.clock.Now()) {
+			glog.V(2).Infof("Not placing pod %s on node %v now, the maintenance windows don't allow evictions", podId(pod), placement.Node.Name)
+			skipPlan(&engine.Plan{Placements: []*engine.Placement{placement}}, "maintenance_window")
+			continue
+		}
+		if remaining := evictions.Remaining(r.clock.Now()); !withinEvictionBudget(placement, remaining, &budgetExhausted) {
+			glog.Infof("Deferring placement of pod %s on node %v, it needs %d evictions and %d are left in the eviction budget", podId(pod), placement.Node.Name, len(placement.Victims), remaining)
+			skipPlan(&engine.Plan{Placements: []*engine.Placement{placement}}, "eviction_budget")
+			repeats.Eventf(r.recorder, "budget/"+podId(pod), pod, placementAnnotations(pod, placement.DecisionID), v1.EventTypeWarning, EventReasonEvictionBudgetExhausted,
+				"Placing critical pod %s is deferred: it needs %d evictions, %d are left in the eviction budget and more important critical pods go first.", podId(pod), len(placement.Victims), remaining)
+			continue
+		}
+		repeats.ForgetAll("unplaceable/"+podId(pod), EventReasonNoFeasibleNode)
+		glog.Infof("Trying to place the pod %s on node %v (decision %s, instance %s)", podId(pod), placement.Node.Name, placement.DecisionID, instanceID())
+
+		victims, err := prepareNodeForPod(ctx, r.client, r.recorder, r.predicateChecker, placement.Snapshot, pod, placement.Taint, placement.DecisionID)
+		if err != nil {
+			glog.Warningf("%+v", err)
+			metrics.PlacementErrorsCount.WithLabelValues(placementErrorKind(ctx, err)).Inc()
+			recordOutcome(pod, placement.DecisionID, "failed")
+			failedPlacements.Record(pod, placement.Node.Name, r.clock.Now())
+			r.podsBeingProcessed.MarkFinished(pod)
+			if len(victims) > 0 {
+				// the evictions which went through count all the same
+				evictions.Spend(len(victims), r.clock.Now())
+				disruptions.Record(victims, r.clock.Now())
+				disruptions.Save(r.client)
+				noticeVictimNamespaces(r.client, pod, placement.Node.Name, placement.DecisionID, victims, r.clock.Now())
+			}
+		} else {
+			if spared := sparedVictims(placement.Victims, victims); spared > 0 {
+				glog.Infof("%d of the pods planned to be evicted for pod %s on node %v were no longer in the way", spared, podId(pod), placement.Node.Name)
+				metrics.SparedVictimsCount.WithLabelValues("revalidation").Add(float64(spared))
+			}
+			if len(victims) > 0 {
+				evictions.Spend(len(victims), r.clock.Now())
+				disruptions.Record(victims, r.clock.Now())
+				disruptions.Save(r.client)
+				noticeVictimNamespaces(r.client, pod, placement.Node.Name, placement.DecisionID, victims, r.clock.Now())
+			}
+			r.podsBeingProcessed.AddReservation(reservation{pod: pod, node: placement.Node.Name, taint: placement.Taint, decisionID: placement.DecisionID, victims: victims})
+			setReservingCondition(r.client, pod, v1.ConditionTrue, conditionReasonNodeReserved,
+				fmt.Sprintf("Node %s is reserved for this pod and %d pods were deleted there (decision %s).", placement.Node.Name, len(victims), placement.DecisionID))
+			go func(pod *v1.Pod, decisionID string) {
+				if waitForScheduled(ctx, r.client, r.recorder, r.clock, r.podsBeingProcessed, pod, decisionID) {
+					r.retryPlacements()
+				}
+			}(pod, placement.DecisionID)
+		}
+	}
+}
+
+// placementErrorKind labels the error of a failed placement in metrics.
+// Placements fail as cancelled once <ctx> is done.
+func placementErrorKind(ctx context.Context, err error) string {
+	switch engine.KindOf(err) {
+	case engine.ErrTaintConflict:
+		return "taint_conflict"
+	case engine.ErrEvictionVetoed:
+		return "eviction_vetoed"
+	case engine.ErrNoFeasibleNode:
+		return "no_feasible_node"
+	}
+	if ctx.Err() != nil {
+		return "cancelled"
+	}
+	return "other"
+}
+
+// sparedVictims returns the number of <planned> victims which are not among
+// the <deleted> ones.
+func sparedVictims(planned, deleted []*v1.Pod) int {
+	uids := sets.NewString()
+	for _, pod := range deleted {
+		uids.Insert(string(pod.UID))
+	}
+	spared := 0
+	for _, pod := range planned {
+		if !uids.Has(string(pod.UID)) {
+			spared++
+		}
+	}
+	return spared
+}
+
+// k8sApp returns the k8s-app label of <pod>, used to label per-addon metrics.
+func k8sApp(pod *v1.Pod) string {
+	if l, found := pod.ObjectMeta.Labels["k8s-app"]; found {
+		return l
+	}
+	return "unknown"
+}
+
+// skipPlan records that the evictions in <plan> are not carried out because of <reason>.
+func skipPlan(plan *engine.Plan, reason string) {
+	victims := 0
+	for _, placement := range plan.Placements {
+		victims += len(placement.Victims)
+	}
+	if victims > 0 {
+		glog.Infof("Skipping %d planned evictions: %s", victims, reason)
+		metrics.SkippedEvictionsCount.WithLabelValues(reason).Add(float64(victims))
+	}
+	for _, placement := range plan.Placements {
+		decisions.SetOutcome(placement.DecisionID, "skipped: "+reason)
+	}
+}
+
+// printPlan writes <plan> to stdout if --print-plan is set.
+func printPlan(plan *engine.Plan) {
+	if *printPlanFormat == "" || plan.IsEmpty() {
+		return
+	}
+	data, err := plan.Print(*printPlanFormat)
+	if err != nil {
+		glog.Warningf("Failed to print plan: %v", err)
+		return
+	}
+	fmt.Println(string(data))
+}
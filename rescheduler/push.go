@@ -0,0 +1,83 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"k8s.io/contrib/rescheduler/metrics"
+)
+
+// pushTarget builds the Pushgateway target from flags. Credential files are
+// read on every push, so that rotated credentials are picked up.
+func pushTarget() (metrics.PushTarget, error) {
+	target := metrics.PushTarget{
+		URL:      strings.TrimSuffix(*pushGatewayURL, "/"),
+		Job:      *pushJob,
+		Instance: instanceID(),
+	}
+	if *pushBasicAuthFile != "" {
+		data, err := ioutil.ReadFile(*pushBasicAuthFile)
+		if err != nil {
+			return target, err
+		}
+		parts := strings.SplitN(strings.TrimSpace(string(data)), ":", 2)
+		if len(parts) != 2 {
+			return target, fmt.Errorf("%s must contain <username>:<password>", *pushBasicAuthFile)
+		}
+		target.Username, target.Password = parts[0], parts[1]
+	}
+	if *pushBearerTokenFile != "" {
+		data, err := ioutil.ReadFile(*pushBearerTokenFile)
+		if err != nil {
+			return target, err
+		}
+		target.BearerToken = strings.TrimSpace(string(data))
+	}
+	return target, nil
+}
+
+// pushMetrics pushes metrics to --push-gateway-url every --push-interval
+// until <ctx> is cancelled, and once more on the way out.
+func pushMetrics(ctx context.Context) {
+	client := &http.Client{Timeout: *pushInterval}
+	push := func() {
+		target, err := pushTarget()
+		if err == nil {
+			err = metrics.Push(client, target)
+		}
+		if err != nil {
+			repeats.Warningf("push-metrics", "Failed to push metrics to %s: %v", *pushGatewayURL, err)
+		}
+	}
+	ticker := time.NewTicker(*pushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			push()
+		case <-ctx.Done():
+			push()
+			return
+		}
+	}
+}
@@ -0,0 +1,232 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	kube_client "k8s.io/client-go/kubernetes"
+	kube_record "k8s.io/client-go/tools/record"
+)
+
+// releaseFailures counts the housekeeping passes in a row in which releasing
+// the taints of each node failed, and holds the orphans: taints which were
+// released but couldn't be removed from their node even with a JSON patch.
+// Orphans are retried by collectOrphanedTaints.
+var releaseFailures = &releaseFailureSet{failures: map[string]int{}, orphans: map[string][]v1.Taint{}}
+
+type releaseFailureSet struct {
+	failures map[string]int
+	orphans  map[string][]v1.Taint
+	mutex    sync.Mutex
+}
+
+// Fail records a failure to release taints on <node> and returns how many
+// times in a row it failed.
+func (s *releaseFailureSet) Fail(node string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.failures[node]++
+	return s.failures[node]
+}
+
+// Orphan records <taints> as orphans on <node>.
+func (s *releaseFailureSet) Orphan(node string, taints []v1.Taint) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	orphans := s.orphans[node]
+	for _, taint := range taints {
+		if !hasTaint(orphans, taint) {
+			orphans = append(orphans, taint)
+		}
+	}
+	s.orphans[node] = orphans
+}
+
+// HasOrphans returns true if taints on <node> are left to collectOrphanedTaints.
+func (s *releaseFailureSet) HasOrphans(node string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.orphans[node]) > 0
+}
+
+// Orphans returns a copy of the orphans, by node.
+func (s *releaseFailureSet) Orphans() map[string][]v1.Taint {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	orphans := make(map[string][]v1.Taint, len(s.orphans))
+	for node, taints := range s.orphans {
+		orphans[node] = append([]v1.Taint{}, taints...)
+	}
+	return orphans
+}
+
+// Forget drops the failures and orphans of <node>, once its taints are
+// released or it is gone.
+func (s *releaseFailureSet) Forget(node string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.failures, node)
+	delete(s.orphans, node)
+}
+
+// Len returns the number of orphans.
+func (s *releaseFailureSet) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	count := 0
+	for _, taints := range s.orphans {
+		count += len(taints)
+	}
+	return count
+}
+
+func hasTaint(taints []v1.Taint, taint v1.Taint) bool {
+	for _, t := range taints {
+		if t.Key == taint.Key && t.Value == taint.Value && t.Effect == taint.Effect {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseFailed handles a failed update of <node> which would have released
+// the <released> taints. The update is retried in the next housekeeping pass
+// until it failed more than --taint-release-retries times in a row, e.g.
+// because a webhook denies it. Then a warning event is recorded on the node
+// and the taints are removed with a JSON patch, which leaves the rest of the
+// node alone; if that fails too, they are left to collectOrphanedTaints.
+// Nothing has to be released on a deleted node. It returns true if the
+// patch released the taints.
+func releaseFailed(client kube_client.Interface, recorder kube_record.EventRecorder, node *v1.Node, released []v1.Taint, err error) bool {
+	if errors.IsNotFound(err) {
+		glog.Infof("Node %v is gone, its taints don't need to be released", node.Name)
+		releaseFailures.Forget(node.Name)
+		return false
+	}
+	failures := releaseFailures.Fail(node.Name)
+	repeats.Warningf("release-taints/"+node.Name, "Error while releasing taints on node %v (%d times in a row): %v", node.Name, failures, err)
+	if failures <= *taintReleaseRetries || len(released) == 0 {
+		return false
+	}
+	if releaseFailures.HasOrphans(node.Name) {
+		// already escalated, collectOrphanedTaints patches the node
+		releaseFailures.Orphan(node.Name, released)
+		return false
+	}
+	recorder.Eventf(node, v1.EventTypeWarning, EventReasonTaintReleaseFailed,
+		"Failed to release the rescheduler taint on node %s %d times in a row, removing it with a JSON patch: %v", node.Name, failures, err)
+	if err := patchOutTaints(client, node.Name, released); err != nil {
+		glog.Warningf("Failed to remove taints from node %v with a JSON patch, leaving them to the next pass: %v", node.Name, err)
+		releaseFailures.Orphan(node.Name, released)
+		return false
+	}
+	glog.Infof("Released taints on node %v with a JSON patch", node.Name)
+	releaseFailures.Forget(node.Name)
+	return true
+}
+
+// releasedPods returns the critical pods the <released> reservation taints
+// of <node> were for, according to its ledger. Taints without an entry are
+// named by their value.
+func releasedPods(node *v1.Node, released []v1.Taint) []string {
+	ledger := reservationLedger(node)
+	pods := make([]string, 0, len(released))
+	for _, taint := range released {
+		if entry, found := ledger[taint.Value]; found {
+			pods = append(pods, entry.Pod)
+		} else {
+			pods = append(pods, taint.Value)
+		}
+	}
+	return pods
+}
+
+// recordReleasedNode records an event on <node> for each of <pods> whose
+// reservation of it was released, so that they show next to the
+// ReservedNode events in kubectl describe node.
+func recordReleasedNode(recorder kube_record.EventRecorder, node *v1.Node, pods []string) {
+	for _, pod := range pods {
+		recorder.Eventf(node, v1.EventTypeNormal, EventReasonReleasedNode, "Node %s no longer reserved for critical pod %s.", node.Name, pod)
+		recordAction(action{Verb: actionRelease, Node: node.Name, CriticalPod: pod})
+	}
+}
+
+// collectOrphanedTaints tries to remove the orphaned taints again. Orphans on
+// nodes which are gone or no longer have them are dropped.
+func collectOrphanedTaints(ctx context.Context, client kube_client.Interface) {
+	for node, taints := range releaseFailures.Orphans() {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := patchOutTaints(client, node, taints); err != nil {
+			repeats.Warningf("orphaned-taints/"+node, "Failed to remove %d orphaned taints from node %v: %v", len(taints), node, err)
+			continue
+		}
+		glog.Infof("Released orphaned taints on node %v", node)
+		releaseFailures.Forget(node)
+		repeats.ForgetAll("orphaned-taints/" + node)
+	}
+}
+
+// jsonPatchOperation is an operation of a JSON patch, see RFC 6902.
+type jsonPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// patchOutTaints removes <taints> from the node <nodeName> with a JSON patch.
+// The patch tests the taints are unchanged, so that it never drops taints
+// added meanwhile. It succeeds if the node is gone or has none of <taints>.
+func patchOutTaints(client kube_client.Interface, nodeName string, taints []v1.Taint) error {
+	node, err := client.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	remaining := []v1.Taint{}
+	for _, taint := range node.Spec.Taints {
+		if !hasTaint(taints, taint) {
+			remaining = append(remaining, taint)
+		}
+	}
+	if len(remaining) == len(node.Spec.Taints) {
+		return nil
+	}
+	patch, err := json.Marshal([]jsonPatchOperation{
+		{Op: "test", Path: "/spec/taints", Value: node.Spec.Taints},
+		{Op: "replace", Path: "/spec/taints", Value: remaining},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = client.CoreV1().Nodes().Patch(nodeName, types.JSONPatchType, patch)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	kube_record "k8s.io/client-go/tools/record"
+)
+
+// maxRepeatEntries bounds the number of keys remembered by a repeatLimiter.
+// When it is exceeded, keys which haven't been seen for a full interval are dropped.
+const maxRepeatEntries = 10000
+
+type repeatEntry struct {
+	lastEmitted time.Time
+	suppressed  int
+}
+
+// repeatLimiter lets a message with a given key through at most once per
+// interval and counts how many times it was suppressed in between.
+type repeatLimiter struct {
+	clock    clock.Clock
+	interval func() time.Duration
+	entries  map[string]*repeatEntry
+	mutex    sync.Mutex
+}
+
+func newRepeatLimiter(clock clock.Clock, interval func() time.Duration) *repeatLimiter {
+	return &repeatLimiter{
+		clock:    clock,
+		interval: interval,
+		entries:  make(map[string]*repeatEntry),
+	}
+}
+
+// repeats is shared by all code paths emitting messages which can repeat every
+// housekeeping pass.
+var repeats = newRepeatLimiter(clock.RealClock{}, func() time.Duration { return *repeatedMessageInterval })
+
+// Allow reports whether a message with <key> should be emitted now and, if so,
+// how many times it was suppressed since it was last emitted.
+func (l *repeatLimiter) Allow(key string) (bool, int) {
+	interval := l.interval()
+	if interval <= 0 {
+		return true, 0
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := l.clock.Now()
+	entry, found := l.entries[key]
+	if !found {
+		if len(l.entries) >= maxRepeatEntries {
+			l.prune(now, interval)
+		}
+		l.entries[key] = &repeatEntry{lastEmitted: now}
+		return true, 0
+	}
+	if now.Sub(entry.lastEmitted) < interval {
+		entry.suppressed++
+		return false, 0
+	}
+	suppressed := entry.suppressed
+	entry.lastEmitted = now
+	entry.suppressed = 0
+	return true, suppressed
+}
+
+// Forget drops <key>, so that the next message with it is emitted immediately.
+// It should be called once the condition behind the message is resolved.
+func (l *repeatLimiter) Forget(key string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	delete(l.entries, key)
+}
+
+func (l *repeatLimiter) prune(now time.Time, interval time.Duration) {
+	for key, entry := range l.entries {
+		if now.Sub(entry.lastEmitted) >= interval {
+			delete(l.entries, key)
+		}
+	}
+}
+
+func repeatedSuffix(suppressed int) string {
+	if suppressed == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (repeated %d times)", suppressed)
+}
+
+// Warningf logs a warning unless one with the same <key> was logged within the interval.
+func (l *repeatLimiter) Warningf(key string, format string, args ...interface{}) {
+	if ok, suppressed := l.Allow("log/" + key); ok {
+		glog.WarningDepth(1, fmt.Sprintf(format, args...)+repeatedSuffix(suppressed))
+	}
+}
+
+// Errorf logs an error unless one with the same <key> was logged within the interval.
+func (l *repeatLimiter) Errorf(key string, format string, args ...interface{}) {
+	if ok, suppressed := l.Allow("log/" + key); ok {
+		glog.ErrorDepth(1, fmt.Sprintf(format, args...)+repeatedSuffix(suppressed))
+	}
+}
+
+// Eventf records an event with <annotations> on <object> unless one with the
+// same <key> and reason was recorded within the interval.
+func (l *repeatLimiter) Eventf(recorder kube_record.EventRecorder, key string, object runtime.Object, annotations map[string]string, eventType, reason, format string, args ...interface{}) {
+	if ok, suppressed := l.Allow("event/" + reason + "/" + key); ok {
+		annotatedEventf(recorder, object, annotations, eventType, reason, "%s", fmt.Sprintf(format, args...)+repeatedSuffix(suppressed))
+	}
+}
+
+// ForgetAll drops the log and event entries for <key> with the given event reasons.
+func (l *repeatLimiter) ForgetAll(key string, reasons ...string) {
+	l.Forget("log/" + key)
+	for _, reason := range reasons {
+		l.Forget("event/" + reason + "/" + key)
+	}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	kube_record "k8s.io/client-go/tools/record"
+)
+
+func TestRepeatLimiter(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Now())
+	limiter := newRepeatLimiter(fakeClock, func() time.Duration { return time.Minute })
+
+	ok, suppressed := limiter.Allow("a")
+	assert.True(t, ok)
+	assert.Equal(t, 0, suppressed)
+
+	fakeClock.Step(10 * time.Second)
+	ok, _ = limiter.Allow("a")
+	assert.False(t, ok)
+	ok, _ = limiter.Allow("a")
+	assert.False(t, ok)
+	ok, _ = limiter.Allow("b")
+	assert.True(t, ok)
+
+	fakeClock.Step(time.Minute)
+	ok, suppressed = limiter.Allow("a")
+	assert.True(t, ok)
+	assert.Equal(t, 2, suppressed)
+
+	limiter.Forget("a")
+	ok, suppressed = limiter.Allow("a")
+	assert.True(t, ok)
+	assert.Equal(t, 0, suppressed)
+}
+
+func TestRepeatLimiterDisabled(t *testing.T) {
+	limiter := newRepeatLimiter(clock.NewFakeClock(time.Now()), func() time.Duration { return 0 })
+	for i := 0; i < 3; i++ {
+		ok, _ := limiter.Allow("a")
+		assert.True(t, ok)
+	}
+}
+
+func TestRepeatLimiterEventf(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Now())
+	limiter := newRepeatLimiter(fakeClock, func() time.Duration { return time.Minute })
+	recorder := kube_record.NewFakeRecorder(10)
+	pod := createTestPod("p1", "kube-system", true, true, 100)
+
+	for i := 0; i < 4; i++ {
+		limiter.Eventf(recorder, "key", pod, nil, v1.EventTypeNormal, "Reason", "Message %d", i)
+		fakeClock.Step(20 * time.Second)
+	}
+	assert.Equal(t, "Normal Reason Message 0", <-recorder.Events)
+	assert.Equal(t, "Normal Reason Message 3 (repeated 2 times)", <-recorder.Events)
+	assert.Equal(t, 0, len(recorder.Events))
+
+	limiter.ForgetAll("key", "Reason")
+	limiter.Eventf(recorder, "key", pod, nil, v1.EventTypeNormal, "Reason", "Message")
+	assert.Equal(t, "Normal Reason Message", <-recorder.Events)
+}
@@ -17,47 +17,46 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"encoding/json"
 	goflag "flag"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	ca_simulator "k8s.io/autoscaler/cluster-autoscaler/simulator"
 
 	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
-	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/clock"
 	kube_utils "k8s.io/autoscaler/cluster-autoscaler/utils/kubernetes"
 	kube_client "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
-	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
+	v1lister "k8s.io/client-go/listers/core/v1"
 	kube_restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
 	kube_record "k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/contrib/rescheduler/engine"
 	"k8s.io/contrib/rescheduler/metrics"
-	kubeapi "k8s.io/kubernetes/pkg/apis/core"
-	kubectl_util "k8s.io/kubernetes/pkg/kubectl/cmd/util"
-	"k8s.io/kubernetes/pkg/kubelet/types"
-	"k8s.io/kubernetes/pkg/scheduler/schedulercache"
 
 	"github.com/golang/glog"
-	"github.com/prometheus/client_golang/prometheus"
 	flag "github.com/spf13/pflag"
 )
 
 const (
-	criticalPodAnnotation      = "scheduler.alpha.kubernetes.io/critical-pod"
-	criticalAddonsOnlyTaintKey = "CriticalAddonsOnly"
+	criticalAddonsOnlyTaintKey = engine.CriticalAddonsOnlyTaintKey
 	// TaintsAnnotationKey represents the key of taints data (json serialized)
 	// in the Annotations of a Node.
 	TaintsAnnotationKey string = "scheduler.alpha.kubernetes.io/taints"
-
-	// HighestUserDefinablePriority is the highest priority for user defined priority classes. Priority values larger than 1 billion are reserved for Kubernetes system use.
-	HighestUserDefinablePriority = int32(1000000000)
-	// SystemCriticalPriority is the beginning of the range of priority values for critical system components.
-	SystemCriticalPriority = 2 * HighestUserDefinablePriority
 )
 
 var (
@@ -69,9 +68,60 @@ var (
 		`Optional, if this controller is running in a kubernetes cluster, use the
 		 pod secrets for creating a Kubernetes client.`)
 
+	kubeconfig = flags.String("kubeconfig", "",
+		`Path to the kubeconfig used with --running-in-cluster=false. If empty,
+		 $KUBECONFIG or ~/.kube/config is used.`)
+
+	kubeContext = flags.String("kube-context", "",
+		`Context of the kubeconfig used with --running-in-cluster=false. If empty,
+		 its current context is used.`)
+
+	clusterContexts = flags.String("cluster-contexts", "",
+		`Optional comma separated contexts of --kubeconfig, or "*" for all of them, to
+		 manage as separate clusters. A rescheduler process is run for each, with the
+		 other flags, and their metrics are served on --listen-address with a cluster
+		 label.`)
+
+	clusterKubeconfigDir = flags.String("cluster-kubeconfig-dir", "",
+		`Optional directory of kubeconfigs, e.g. a mounted Secret, each managed as a
+		 separate cluster named after the file, using its current context, like
+		 --cluster-contexts.`)
+
 	contentType = flags.String("kube-api-content-type", "application/vnd.kubernetes.protobuf",
 		`Content type of requests sent to apiserver.`)
 
+	credentialsRefreshInterval = flags.Duration("credentials-refresh-interval", time.Minute,
+		`How often the bearer token is re-read from the service account token file, or
+		 from the kubeconfig and its token file, so that rotated tokens are used without a
+		 restart. It is also re-read when the API server rejects it. 0 disables this.`)
+
+	apiCAFile = flags.String("api-ca-file", "",
+		`Optional PEM bundle of the CAs trusted for the apiserver's certificate, replacing
+		 the one of the service account or kubeconfig, e.g. for private link endpoints.`)
+
+	apiTLSServerName = flags.String("api-tls-server-name", "",
+		`Optional name the apiserver's certificate is checked against, and sent for SNI,
+		 when the apiserver is reached through an address its certificate doesn't cover.`)
+
+	apiProxyURL = flags.String("api-proxy-url", "",
+		`Optional proxy for apiserver connections. Without it HTTPS_PROXY, HTTP_PROXY
+		 and NO_PROXY from the environment are honored.`)
+
+	apiUserAgent = flags.String("api-user-agent", "rescheduler",
+		`User-Agent of requests to the apiserver, e.g. to match rescheduler traffic in
+		 a flow schema or in audit logs.`)
+
+	impersonateUser = flags.String("impersonate-user", "",
+		`Optional user to impersonate for all apiserver requests, e.g.
+		 system:serviceaccount:kube-system:rescheduler, so that its requests get their
+		 own identity for priority and fairness and accounting.`)
+
+	impersonateGroups = flags.StringSlice("impersonate-group", nil,
+		`Groups to impersonate along with --impersonate-user.`)
+
+	apiTimeout = flags.Duration("api-timeout", 30*time.Second,
+		`Timeout of a single request to the apiserver. 0 means no timeout.`)
+
 	housekeepingInterval = flags.Duration("housekeeping-interval", 10*time.Second,
 		`How often rescheduler takes actions.`)
 
@@ -84,14 +134,274 @@ var (
 
 	podScheduledTimeout = flags.Duration("pod-scheduled-timeout", 10*time.Minute,
 		`How long should rescheduler wait for critical pod to be scheduled
-		 after evicting pods to make a spot for it.`)
+		 after evicting pods to make a spot for it. Priority classes in --config and
+		 the rescheduler.alpha.kubernetes.io/pod-scheduled-timeout pod annotation
+		 can override it.`)
+
+	planningBudget = flags.Duration("planning-budget", 30*time.Second,
+		`How long planning the critical pods of one housekeeping pass may take.
+		 Pods still being planned then are planned on --planning-sample-size
+		 random nodes plus the nodes recent placements succeeded on. 0 means no budget.`)
+
+	planningSampleSize = flags.Int("planning-sample-size", 100,
+		`Number of random nodes pods are planned on once --planning-budget is exceeded.`)
 
 	listenAddress = flags.String("listen-address", "127.0.0.1:9235",
-		`Address to listen on for serving prometheus metrics`)
+		`Address to listen on for serving prometheus metrics. IPv6 addresses are
+		 bracketed, e.g. "[::1]:9235"; "[::]:9235" listens on IPv4 and IPv6. Port 0
+		 lets the system choose a free port, see --listen-address-file. "unix:/path"
+		 serves on a unix socket instead, e.g. for a sidecar scraper on a hostNetwork
+		 pod where no TCP port may be opened.`)
+
+	advertiseAddress = flags.String("advertise-address", "",
+		`Host or IP under which the metrics server is reachable, written to
+		 --listen-address-file. Defaults to the host of --listen-address, or the
+		 hostname if it listens on all addresses.`)
+
+	listenAddressFile = flags.String("listen-address-file", "",
+		`Optional file to which the advertised address of the metrics server is written
+		 once it listens, so that the port chosen for port 0 can be found.`)
+
+	goMetrics = flags.Bool("go-metrics", true,
+		`Export Go runtime metrics (go_*). Process metrics (process_*) are always exported.`)
+
+	pushGatewayURL = flags.String("push-gateway-url", "",
+		`Optional base URL of a Prometheus Pushgateway to push metrics to, for clusters
+		 where metrics can't be scraped. The pull endpoint keeps working.`)
+
+	pushInterval = flags.Duration("push-interval", time.Minute,
+		`How often metrics are pushed to --push-gateway-url.`)
+
+	pushJob = flags.String("push-job", "rescheduler",
+		`Job label of the pushed metrics. The instance label is the pod name.`)
+
+	pushBasicAuthFile = flags.String("push-basic-auth-file", "",
+		`Optional file containing <username>:<password> for the Pushgateway.`)
+
+	pushBearerTokenFile = flags.String("push-bearer-token-file", "",
+		`Optional file containing a bearer token for the Pushgateway.`)
+
+	metricsFailureFatal = flags.Bool("metrics-failure-fatal", true,
+		`If true, the rescheduler exits when the HTTP server on --listen-address can't be
+		 started after several attempts. If false, it logs the error, keeps retrying in the
+		 background and continues rescheduling without serving metrics.`)
 
 	gracePeriod = flags.Duration("grace-period", 10*time.Second,
 		"How long to wait for rescheduled pods to terminate. If negative, the grace period specified in each pod"+
 			" will be used. If 0, pods will be immediately terminated.")
+
+	configFile = flags.String("config", "",
+		`Optional path to a YAML file overriding flag values (housekeepingInterval,
+		 podScheduledTimeout, gracePeriod, shadowMode, evictionBudget,
+		 evictionBudgetWindow, criticalPodSelector) and holding further settings. The
+		 file is reloaded when it changes or on SIGHUP. Other flags, e.g.
+		 --node-shard-selector, are only read on startup.`)
+
+	shadowMode = flags.Bool("shadow-mode", false,
+		`If true, critical pod placement is fully computed but instead of tainting nodes and
+		 deleting pods, WouldTaint and WouldDelete events are emitted on the affected objects.`)
+
+	printPlanFormat = flags.String("print-plan", "",
+		`If set to "json" or "yaml", the plan computed in each housekeeping pass is
+		 printed to stdout in that format.`)
+
+	dumpFlagsAndExit = flags.Bool("dump-flags", false,
+		`Print the effective value of every flag and exit.`)
+
+	forecastFormat = flags.String("forecast", "",
+		`If set to "json" or "yaml", print where every pending critical pod would be placed
+		 and which pods would be deleted for it, given the current cluster state, and exit
+		 without changing anything. Meant for review before enabling the rescheduler.`)
+
+	printRBACAndExit = flags.Bool("print-rbac", false,
+		`Print the ClusterRole and Roles granting exactly the permissions needed by the
+		 features enabled with the other flags, and exit.`)
+
+	effectiveConfigMap = flags.String("effective-config-configmap", "",
+		`Optional name of a ConfigMap in the rescheduler's namespace where the effective
+		 configuration and flags are recorded on startup and after each reload, e.g.
+		 rescheduler-effective-config. Credentials in URLs are left out.`)
+
+	eventSink = flags.String("event-sink", eventSinkAPIServer,
+		`Where events go: "apiserver" records them, "events-api" records them with the
+		 events.k8s.io/v1beta1 API, where an event repeating within minutes updates the
+		 series of the first one instead of adding objects, "log" only logs them and
+		 "none" drops them, for clusters with event storms or strict event quotas.`)
+
+	eventNamespace = flags.String("event-namespace", "",
+		`Optional namespace all events are recorded in, instead of the namespace of the
+		 object they are about. Events about nodes go to kube-system then, as the
+		 apiserver requires.`)
+
+	eventQPS = flags.Float64("event-qps", 0,
+		`If positive, how many events per second may be written to the apiserver;
+		 events beyond --event-burst are dropped and counted in
+		 rescheduler_dropped_events_total.`)
+
+	eventBurst = flags.Int("event-burst", 25,
+		`How many events may be written at once before --event-qps applies.`)
+
+	repeatedMessageInterval = flags.Duration("repeated-message-interval", 5*time.Minute,
+		`Warnings and events which repeat every housekeeping pass (e.g. a critical pod
+		 which doesn't fit anywhere) are emitted at most once per this interval, with the
+		 number of suppressed repetitions appended. 0 disables the deduplication.`)
+
+	adminListenAddress = flags.String("admin-listen-address", "",
+		`Optional address to serve admin endpoints (/simulate, /debug/decisions) on, over HTTPS with
+		 TokenReview authentication and SubjectAccessReview authorization against the
+		 apiserver. "unix:/path" serves on a unix socket. If empty, admin endpoints are
+		 served without authentication on --listen-address.`)
+
+	adminTLSCertFile = flags.String("admin-tls-cert-file", "",
+		`Serving certificate for --admin-listen-address.`)
+
+	adminTLSKeyFile = flags.String("admin-tls-key-file", "",
+		`Private key for --admin-tls-cert-file.`)
+
+	taintOwner = flags.String("taint-owner", "",
+		`Optional ID of this rescheduler deployment, recorded for each taint it adds. Only
+		 taints with the same owner are released, so that several reschedulers (e.g. one
+		 per node pool) can share a cluster. Must be unique per deployment.`)
+
+	nodeScorers = flags.StringSlice("node-scorers", []string{"image-locality"},
+		`Scorers ordering the nodes tried for a critical pod, most important first.
+		 Available: image-locality, cost. Empty to try nodes in list order.`)
+	nodeCostLabel = flags.String("node-cost-label", "rescheduler.alpha.kubernetes.io/node-cost",
+		"Node label holding the relative cost of the node, used by the cost scorer.")
+
+	rwoVolumeVictims = flags.String("rwo-volume-victims", "allow",
+		`How pods with a ReadWriteOnce persistent volume claim are treated as victims: "allow" deletes them
+		 like other pods, "avoid" only if the critical pod doesn't fit otherwise, "protect" never.`)
+
+	requireOptIn = flags.Bool("require-opt-in", false,
+		`Only place critical pods annotated with rescheduler.alpha.kubernetes.io/managed=true,
+		 and leave all other critical pods to the scheduler.`)
+
+	criticalPodSelector = flags.String("critical-pod-selector", "",
+		`Optional label selector, e.g. "k8s-app in (kube-dns, cilium)", limiting the
+		 critical pods which are placed, for example to roll the rescheduler out to a few
+		 addons at a time.`)
+
+	daemonSetVictimPriorityGap = flags.Int32("daemonset-victim-priority-gap", 0,
+		`If positive, non-critical DaemonSet pods whose priority is at least this much
+		 lower than the critical pod's may be deleted to make room, e.g. log shippers
+		 which come back once there is capacity. 0 never deletes DaemonSet pods.`)
+
+	protectSystemNamespaceVictims = flags.Bool("protect-system-namespace-victims", true,
+		`Never delete pods in kube-system or --system-namespace to make room, unless they
+		 are annotated with rescheduler.alpha.kubernetes.io/evictable=true. Evicting addons
+		 which aren't marked critical to fit another addon can cascade.`)
+
+	victimNamespaceNotices = flags.Bool("victim-namespace-notices", false,
+		`Annotate the namespace of every deleted pod with the recent deletions there,
+		 see rescheduler.alpha.kubernetes.io/recent-disruptions, so that teams without
+		 cluster-level access can tell why their pods were evicted.`)
+
+	reservedNodes = flags.String("reserved-nodes", "skip",
+		`Which nodes already reserved for another critical pod are skipped: "skip" skips all of them,
+		 "skip-fresh" only those reserved within --pod-scheduled-timeout, whose placement may still succeed.`)
+
+	maxPlacementAttempts = flags.Int("max-placement-attempts", 3,
+		`How many placements of a critical pod may time out, each on a different node,
+		 before the pod is given up until the rescheduler restarts. 0 means no limit.`)
+
+	actionSinkSpecs = flags.StringSlice("action-sink", nil,
+		`Where to record every taint, eviction and release, in addition to events:
+		 "stdout" and "file:<path>" write a line of JSON per action, "webhook:<url>"
+		 POSTs it, and "crd" creates a ReschedulerAction (rescheduler.k8s.io/v1alpha1)
+		 in the rescheduler's namespace, whose CustomResourceDefinition has to be
+		 installed. May be repeated.`)
+
+	snapshotPods = flags.Bool("snapshot-pods", true,
+		`Keep the pods bound to nodes in an informer cache and plan each housekeeping
+		 pass on one snapshot of it, so that all decisions of the pass see the same
+		 state. Without it the pods of each candidate node are listed when the node
+		 is considered, which takes less memory in large clusters.`)
+
+	nodeSnapshotMaxAge = flags.Duration("node-snapshot-max-age", 10*time.Second,
+		`How long the pods listed on a node while choosing it for a critical pod are used to
+		 pick the victims once the node is reserved. Older snapshots are listed again.
+		 0 always lists them again.`)
+
+	taintReleaseRetries = flags.Int("taint-release-retries", 3,
+		`How many housekeeping passes in a row releasing the taints of a node may fail
+		 before a warning event is recorded on the node and the taints are removed with
+		 a JSON patch instead of an update.`)
+
+	evictionRetries = flags.Int("eviction-retries", 3,
+		`How many times deleting a victim is retried after transient errors, such as
+		 throttling or timeouts, before the placement goes on without it if the critical
+		 pod still fits, or is given up otherwise.`)
+
+	countTerminatingPods = flags.Bool("count-terminating-pods", false,
+		`Whether pods which are being deleted count as occupying their node until they are gone.
+		 By default they are ignored, since they free the node by themselves. They are never evicted.`)
+
+	nodeShardSelector = flags.String("node-shard-selector", "",
+		`Optional label selector restricting the nodes this instance reserves and releases,
+		 e.g. "cloud.google.com/gke-nodepool=pool-a". Instances with disjoint selectors can run
+		 side by side; each must have its own --taint-owner. The selector is applied by the
+		 apiserver, so nodes outside it are not watched or cached.`)
+
+	dedicatedAddonNodes = flags.Int("dedicated-addon-nodes", 0,
+		`If positive, this many nodes are kept tainted for critical addons only, instead of
+		 reserving nodes for unschedulable critical pods as they come. Existing pods are not
+		 evicted from those nodes.`)
+
+	dedicatedAddonNodesRotation = flags.Duration("dedicated-addon-nodes-rotation", 0,
+		`If positive, a node which has been dedicated to critical addons for this long is
+		 replaced by another one, one node per housekeeping pass. 0 never rotates.`)
+
+	disruptionHistoryConfigMap = flags.String("disruption-history-configmap", "",
+		`Optional name of a ConfigMap in the rescheduler's namespace where the controllers
+		 which recently lost pods to placements are kept across restarts.`)
+
+	disruptionHistoryWindow = flags.Duration("disruption-history-window", time.Hour,
+		`For how long pods of a controller which lost a pod to a placement are avoided as
+		 victims, so that the same workload isn't disrupted by every placement.`)
+
+	evictionBudgetSize = flags.Int("eviction-budget", 0,
+		`If positive, at most this many pods are evicted for placements within
+		 --eviction-budget-window. When the budget runs short, it is spent on the most
+		 urgent pending critical pods, by priority class and priority, and the placements
+		 of the others are deferred with an EvictionBudgetExhausted event. 0 means no
+		 budget.`)
+
+	evictionBudgetWindow = flags.Duration("eviction-budget-window", time.Hour,
+		`The sliding window of --eviction-budget.`)
+
+	capacityMetrics = flags.Bool("capacity-metrics", false,
+		`Export the free CPU and memory per zone and, for each pending critical pod, the
+		 number of nodes it fits on without evictions. This lists all pods in every
+		 housekeeping pass.`)
+
+	coverageReportInterval = flags.Duration("coverage-report-interval", 0,
+		`If positive, how often to report how many nodes each critical DaemonSet lacks
+		 a running pod on, and why, in the rescheduler_daemonset_missing_pods metric.`)
+
+	coverageConfigMap = flags.String("coverage-configmap", "",
+		`Optional name of a ConfigMap in the rescheduler's namespace to which the
+		 DaemonSet coverage report is also written. Requires --coverage-report-interval.`)
+
+	shortfallConfigMap = flags.String("shortfall-configmap", "",
+		`Optional name of a ConfigMap in the rescheduler's namespace to which the
+		 capacity missing for the critical pods the last plan found no node for is
+		 written, by zone and resource.`)
+
+	debugDecisions = flags.Int("debug-decisions", 0,
+		`If positive, the last this many placement decisions are kept in memory and
+		 served as JSON at /debug/decisions, next to the other admin endpoints.`)
+
+	injectFaults = flags.String("inject-faults", "",
+		`For testing only: comma separated fault=rate pairs, e.g. taint-conflict=0.1,
+		 injecting taint-conflict (node updates fail with a conflict), delete-forbidden
+		 (pod deletions fail with 403) or never-bind (placements wait as if the scheduler
+		 never bound the pod) with the given probability, to rehearse rollbacks.`)
+
+	killSwitchConfigMap = flags.String("kill-switch-configmap", "",
+		`Optional name of a ConfigMap in the rescheduler's namespace; setting its
+		 "disable-actions" key to "true" stops all tainting and evictions, same as the
+		 rescheduler.alpha.kubernetes.io/disable-actions annotation on the namespace.`)
 )
 
 func main() {
@@ -104,133 +414,462 @@ func main() {
 
 	flags.Parse(os.Args)
 
-	glog.Infof("Running Rescheduler")
+	if *dumpFlagsAndExit {
+		dumpFlags(os.Stdout)
+		os.Exit(0)
+	}
+	if errs := validateFlags(); len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "Invalid flags: %v\n", err)
+		}
+		os.Exit(1)
+	}
+	if *printRBACAndExit {
+		if err := printRBAC(os.Stdout, "rescheduler", requiredPermissions()); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to print RBAC manifest: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if *forecastFormat != "" {
+		if err := runForecast(os.Stdout, *forecastFormat); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to forecast disruptions: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	glog.Infof("Running Rescheduler as instance %s", instanceID())
 
+	config, err := loadConfig(*configFile)
+	if err != nil {
+		glog.Fatalf("Failed to load configuration: %v", err)
+	}
+	activeConfig.Set(config)
+
+	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
-		http.Handle("/metrics", prometheus.Handler())
-		err := http.ListenAndServe(*listenAddress, nil)
-		glog.Fatalf("Failed to start metrics: %v", err)
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+		sig := <-signals
+		glog.Infof("Received %v, shutting down", sig)
+		cancel()
 	}()
 
-	// TODO(piosz): figure out a better way of verifying cluster stabilization here.
-	time.Sleep(*initialDelay)
+	metrics.RegisterRuntimeCollectors(*goMetrics)
+	if *clusterContexts != "" || *clusterKubeconfigDir != "" {
+		clusters, err := managedClusters()
+		if err != nil {
+			glog.Fatalf("Failed to find the clusters to manage: %v", err)
+		}
+		superviseClusters(ctx, clusters)
+		return
+	}
+	http.Handle("/metrics", metrics.Handler())
+	if *pushGatewayURL != "" {
+		go pushMetrics(ctx)
+	}
+	serverDone := make(chan struct{})
+	go func() {
+		serveHTTP(ctx, *listenAddress, http.DefaultServeMux, nil, *metricsFailureFatal, publishListenAddress)
+		close(serverDone)
+	}()
 
-	kubeClient, err := createKubeClient(flags, *inCluster)
+	kubeClient, err := createKubeClient(*inCluster)
 	if err != nil {
 		glog.Fatalf("Failed to create kube client: %v", err)
 	}
+	if kubeClient, err = withInjectedFaults(kubeClient, *injectFaults); err != nil {
+		glog.Fatalf("Invalid --inject-faults: %v", err)
+	}
+	degradeToPermissions(kubeClient)
 
 	recorder := createEventRecorder(kubeClient)
-	predicateCheckerStopChannel := make(chan struct{})
-	predicateChecker, err := ca_simulator.NewPredicateChecker(kubeClient, predicateCheckerStopChannel)
+	owners = newOwnerResolver(kubeClient)
+	if actionSinks, err = newActionSinks(*actionSinkSpecs, kubeClient); err != nil {
+		glog.Fatalf("Invalid --action-sink: %v", err)
+	}
+	applyConfig(kubeClient, config)
+	stopChannel := ctx.Done()
+	predicateChecker, err := ca_simulator.NewPredicateChecker(kubeClient, stopChannel)
 	if err != nil {
 		glog.Fatalf("Failed to create predicate checker: %v", err)
 	}
 
-	stopChannel := make(chan struct{})
+	if *configFile != "" {
+		go watchConfig(*configFile, kubeClient, recorder, stopChannel)
+	}
 	unschedulablePodLister := kube_utils.NewUnschedulablePodInNamespaceLister(kubeClient, *systemNamespace, stopChannel)
-	nodeLister := kube_utils.NewReadyNodeLister(kubeClient, stopChannel)
+	if scorers, err = newScorers(*nodeScorers); err != nil {
+		glog.Fatalf("Invalid --node-scorers: %v", err)
+	}
+	nodeReady := make(chan struct{}, 1)
+	nodeLister := newReadyNodeLister(kubeClient, nodeReadinessHandler(nodeReady), stopChannel)
+	var boundPods *boundPodCache
+	if *snapshotPods {
+		boundPods = newBoundPodCache(kubeClient, stopChannel)
+	}
+
+	adminMux.Handle("/simulate", &simulateHandler{
+		client:           kubeClient,
+		predicateChecker: predicateChecker,
+		nodeLister:       nodeLister,
+	})
+	if *debugDecisions > 0 {
+		decisions = newDecisionLog(*debugDecisions)
+		adminMux.Handle("/debug/decisions", decisions)
+	}
+	serveAdmin(ctx, kubeClient)
+
+	if err := disruptions.Load(kubeClient); err != nil {
+		glog.Warningf("Failed to load disruption history, starting with an empty one: %v", err)
+	}
 
 	// TODO(piosz): consider reseting this set once every few hours.
-	podsBeingProcessed := NewPodSet()
+	r := &rescheduler{
+		client:                 kubeClient,
+		recorder:               recorder,
+		predicateChecker:       predicateChecker,
+		unschedulablePodLister: unschedulablePodLister,
+		pendingBoundPodLister:  newPendingBoundPodLister(kubeClient, *systemNamespace, stopChannel),
+		nodeLister:             nodeLister,
+		boundPods:              boundPods,
+		podsBeingProcessed:     NewPodSet(),
+		killSwitch:             &killSwitch{},
+		clock:                  clock.RealClock{},
+		nodeReady:              nodeReady,
+		retryNow:               make(chan struct{}, 1),
+	}
+	registerCacheSizes(r.podsBeingProcessed)
+	if *coverageReportInterval > 0 {
+		go r.reportCoverage(ctx)
+	}
+	r.run(ctx)
+	<-serverDone
+	glog.Infof("Rescheduler stopped")
+}
+
+// registerCacheSizes exports the sizes of the state kept in memory.
+func registerCacheSizes(podsBeingProcessed *podSet) {
+	metrics.RegisterCacheSize("placements", func() int {
+		placements, _ := podsBeingProcessed.Len()
+		return placements
+	})
+	metrics.RegisterCacheSize("finished_placements", func() int {
+		_, finished := podsBeingProcessed.Len()
+		return finished
+	})
+	metrics.RegisterCacheSize("failed_placements", failedPlacements.Len)
+	metrics.RegisterCacheSize("successful_nodes", successfulNodes.Len)
+	metrics.RegisterCacheSize("fit_failures", fitFailures.Len)
+	metrics.RegisterCacheSize("orphaned_taints", releaseFailures.Len)
+	metrics.RegisterCacheSize("shadow_predictions", shadowPredictions.Len)
+	metrics.RegisterCacheSize("disruption_history", disruptions.Len)
+	metrics.RegisterCacheSize("owner_links", owners.Len)
+}
+
+// rescheduler holds the clients and the state shared between housekeeping passes.
+type rescheduler struct {
+	client                 kube_client.Interface
+	recorder               kube_record.EventRecorder
+	predicateChecker       *ca_simulator.PredicateChecker
+	unschedulablePodLister kube_utils.PodLister
+	// pendingBoundPodLister lists the pods which are bound but pending, see reportStaticCriticalPods.
+	pendingBoundPodLister kube_utils.PodLister
+	nodeLister            kube_utils.NodeLister
+	// boundPods, if set, provides the pods planning passes are based on.
+	// Otherwise the pods of each candidate node are listed.
+	boundPods          *boundPodCache
+	podsBeingProcessed *podSet
+	killSwitch         *killSwitch
+	clock              clock.Clock
+	// nodeReady receives a value when a node became ready, see nodeReadinessHandler.
+	nodeReady <-chan struct{}
+	// retryNow receives a value when a placement was aborted and its pod
+	// should be placed again without waiting for the next interval.
+	retryNow chan struct{}
+}
+
+// run waits for the initial delay and then runs housekeeping every
+// housekeeping interval, and right away when a node becomes ready or a
+// placement has to be retried, until <ctx> is cancelled.
+func (r *rescheduler) run(ctx context.Context) {
+	// TODO(piosz): figure out a better way of verifying cluster stabilization here.
+	select {
+	case <-r.clock.After(*initialDelay):
+	case <-ctx.Done():
+		return
+	}
 
 	// As tolerations/taints feature changed from being specified in annotations
 	// to being specified in fields in Kubernetes 1.6, we need to make sure that
 	// any annotations that were created in the previous versions are removed.
-	releaseAllTaintsDeprecated(kubeClient, nodeLister)
+	releaseAllTaintsDeprecated(ctx, r.client, r.nodeLister)
 
-	releaseAllTaints(kubeClient, nodeLister, podsBeingProcessed)
+	releaseAllTaints(ctx, r.client, r.recorder, r.nodeLister, r.podsBeingProcessed)
 
+	// nodes which were ready before or during the initial delay don't matter
+	select {
+	case <-r.nodeReady:
+	default:
+	}
 	for {
 		select {
-		case <-time.After(*housekeepingInterval):
-			{
-				allUnschedulablePods, err := unschedulablePodLister.List()
-				if err != nil {
-					glog.Errorf("Failed to list unscheduled pods: %v", err)
-					continue
-				}
+		case <-r.clock.After(currentConfig().HousekeepingInterval.Duration):
+			r.housekeeping(ctx)
+		case <-r.nodeReady:
+			r.housekeeping(ctx)
+		case <-r.retryNow:
+			r.housekeeping(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
 
-				criticalDaemonSetPods := filterCriticalDaemonSetPods(allUnschedulablePods, podsBeingProcessed)
-
-				if len(criticalDaemonSetPods) > 0 {
-					for _, pod := range criticalDaemonSetPods {
-						glog.Infof("Critical pod %s is unschedulable. Trying to find a spot for it.", podId(pod))
-						k8sApp := "unknown"
-						if l, found := pod.ObjectMeta.Labels["k8s-app"]; found {
-							k8sApp = l
-						}
-						metrics.UnschedulableCriticalPodsCount.WithLabelValues(k8sApp).Inc()
-						nodes, err := nodeLister.List()
-						if err != nil {
-							glog.Errorf("Failed to list nodes: %v", err)
-							continue
-						}
-
-						node := findNodeForPod(kubeClient, predicateChecker, nodes, pod)
-						if node == nil {
-							glog.Errorf("Pod %s can't be scheduled on any existing node.", podId(pod))
-							recorder.Eventf(pod, v1.EventTypeNormal, "PodDoestFitAnyNode",
-								"Critical pod %s doesn't fit on any node.", podId(pod))
-							continue
-						}
-						glog.Infof("Trying to place the pod on node %v", node.Name)
-
-						err = prepareNodeForPod(kubeClient, recorder, predicateChecker, node, pod)
-						if err != nil {
-							glog.Warningf("%+v", err)
-						} else {
-							podsBeingProcessed.Add(pod)
-							go waitForScheduled(kubeClient, podsBeingProcessed, pod)
-						}
-					}
-				}
+// retryPlacements makes run start housekeeping right away. It never blocks:
+// a pending retry covers later ones too.
+func (r *rescheduler) retryPlacements() {
+	select {
+	case r.retryNow <- struct{}{}:
+	default:
+	}
+}
+
+// housekeeping tries to find a spot for every unschedulable critical pod, or
+// maintains the dedicated nodes with --dedicated-addon-nodes, and then
+// releases taints which are no longer needed.
+func (r *rescheduler) housekeeping(ctx context.Context) {
+	allUnschedulablePods, err := r.unschedulablePodLister.List()
+	if err != nil {
+		repeats.Errorf("list-unschedulable-pods", "Failed to list unscheduled pods: %v", err)
+		return
+	}
+
+	criticalDaemonSetPods := filterCriticalDaemonSetPods(allUnschedulablePods, r.podsBeingProcessed)
+	r.reportStaticCriticalPods()
+	shadowPredictions.Resolve(r.client)
+	if *capacityMetrics {
+		r.exportCapacity(criticalDaemonSetPods)
+	}
+
+	if *dedicatedAddonNodes > 0 {
+		// critical pods go to the dedicated nodes, nothing is reserved for them
+		if !r.killSwitch.Engaged(r.client) {
+			r.maintainDedicatedNodes(ctx)
+		}
+	} else if len(criticalDaemonSetPods) > 0 {
+		plan := r.buildPlan(ctx, criticalDaemonSetPods)
+		printPlan(plan)
+		if r.killSwitch.Engaged(r.client) {
+			skipPlan(plan, "kill_switch")
+		} else {
+			r.applyPlan(ctx, plan)
+		}
+	}
 
-				releaseAllTaints(kubeClient, nodeLister, podsBeingProcessed)
+	restoreReservations(r.client, r.recorder, r.podsBeingProcessed)
+	releaseAllTaints(ctx, r.client, r.recorder, r.nodeLister, r.podsBeingProcessed)
+	collectOrphanedTaints(ctx, r.client)
+}
+
+// restoreReservations re-adds the reservation taints of placements in flight
+// which were removed by an operator or another controller, so that the freed
+// space isn't taken by other pods before the critical pod is scheduled.
+func restoreReservations(client kube_client.Interface, recorder kube_record.EventRecorder, podsBeingProcessed *podSet) {
+	for _, r := range podsBeingProcessed.Reservations() {
+		taint := r.taint
+		var restored *v1.Node
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			node, err := client.CoreV1().Nodes().Get(r.node, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			for _, t := range node.Spec.Taints {
+				if t.Key == taint.Key && t.Value == taint.Value {
+					return nil
+				}
 			}
+			restored = node
+			return addTaint(client, node, taint, r.pod)
+		})
+		if err != nil {
+			repeats.Warningf("restore-reservation/"+r.node+"/"+podId(r.pod), "Failed to check reservation of node %v for pod %s: %v", r.node, podId(r.pod), err)
+			continue
+		}
+		if restored != nil {
+			glog.Warningf("Reservation taint of node %v for pod %s was removed, re-added it", r.node, podId(r.pod))
+			metrics.RestoredReservationsCount.Inc()
+			placementEventf(recorder, restored, r.pod, r.decisionID, v1.EventTypeWarning, EventReasonReservationRestored,
+				"Reservation of node %s for critical pod %s was removed while the pod is being placed; re-added it.", r.node, podId(r.pod))
 		}
 	}
 }
 
-func waitForScheduled(client kube_client.Interface, podsBeingProcessed *podSet, pod *v1.Pod) {
+// waitForScheduled polls <pod> every second until it is bound to a node, it
+// is replaced by a newer version, its reserved node is deleted, the pod
+// scheduled timeout expires or <ctx> is cancelled, and then removes it from
+// <podsBeingProcessed>. It returns true if the pod should be placed again
+// right away, because its node was deleted or it timed out on it.
+func waitForScheduled(ctx context.Context, client kube_client.Interface, recorder kube_record.EventRecorder, clock clock.Clock, podsBeingProcessed *podSet, pod *v1.Pod, decisionID string) bool {
 	glog.Infof("Waiting for pod %s to be scheduled", podId(pod))
-	err := wait.Poll(time.Second, *podScheduledTimeout, func() (bool, error) {
+	metrics.WaitingPlacements.Inc()
+	defer metrics.WaitingPlacements.Dec()
+	timeout := podScheduledTimeoutOf(pod)
+	start := clock.Now()
+	deadline := start.Add(timeout)
+	scheduled := false
+	neverBind := faults.Inject(faultNeverBind)
+	for !scheduled && clock.Now().Before(deadline) {
+		select {
+		case <-clock.After(time.Second):
+		case <-ctx.Done():
+			glog.Infof("Stopped waiting for pod %s to be scheduled: %v", podId(pod), ctx.Err())
+			recordOutcome(pod, decisionID, "aborted")
+			setReservingCondition(client, pod, v1.ConditionFalse, conditionReasonAborted, "The rescheduler stopped before this pod was scheduled.")
+			podsBeingProcessed.Remove(pod)
+			return false
+		}
 		p, err := client.CoreV1().Pods(pod.Namespace).Get(pod.Name, metav1.GetOptions{})
-		if err != nil {
-			glog.Warningf("Error while getting pod %s: %v", podId(pod), err)
-			return false, nil
+		if errors.IsNotFound(err) {
+			p = nil
+		} else if err != nil {
+			repeats.Warningf("get-pod/"+podId(pod), "Error while getting pod %s: %v", podId(pod), err)
+			continue
 		}
-		return p.Spec.NodeName != "", nil
-	})
-	if err != nil {
-		glog.Warningf("Timeout while waiting for pod %s to be scheduled after %v.", podId(pod), *podScheduledTimeout)
-	} else {
-		glog.Infof("Pod %v was successfully scheduled.", podId(pod))
+		if reason := stalePlacementReason(pod, p); reason != "" {
+			glog.Infof("Cancelling placement of pod %s (decision %s): %s", podId(pod), decisionID, reason)
+			placementEventf(recorder, pod, pod, decisionID, v1.EventTypeNormal, EventReasonPlacementCancelled,
+				"Cancelled placement of critical pod %s: %s.", podId(pod), reason)
+			recordOutcome(pod, decisionID, "cancelled")
+			r, found := podsBeingProcessed.Reservation(pod)
+			podsBeingProcessed.Remove(pod)
+			if found {
+				releaseReservation(ctx, client, recorder, podsBeingProcessed, r)
+			}
+			return false
+		}
+		scheduled = p.Spec.NodeName != "" && !neverBind
+		if scheduled {
+			successfulNodes.Add(p.Spec.NodeName, clock.Now())
+		}
+		if r, found := podsBeingProcessed.Reservation(pod); !scheduled && found && nodeDeleted(client, r.node) {
+			glog.Warningf("Node %v reserved for pod %s was deleted (decision %s), placing the pod again.", r.node, podId(pod), decisionID)
+			placementEventf(recorder, pod, pod, decisionID, v1.EventTypeWarning, EventReasonPlacementNodeDeleted,
+				"Node %s reserved for critical pod %s was deleted before the pod was scheduled.", r.node, podId(pod))
+			recordOutcome(pod, decisionID, "node_deleted")
+			metrics.PlacementDurationSeconds.WithLabelValues("node_deleted").Observe(clock.Since(start).Seconds())
+			setReservingCondition(client, pod, v1.ConditionFalse, conditionReasonNodeDeleted,
+				fmt.Sprintf("Node %s reserved for this pod was deleted, another node will be reserved (decision %s).", r.node, decisionID))
+			podsBeingProcessed.Remove(pod)
+			return true
+		}
+	}
+	if !scheduled {
+		glog.Warningf("Timeout while waiting for pod %s to be scheduled after %v.", podId(pod), timeout)
+		placementEventf(recorder, pod, pod, decisionID, v1.EventTypeWarning, EventReasonPlacementTimedOut,
+			"Critical pod %s was not scheduled within %v after its node was prepared.", podId(pod), timeout)
+		recordOutcome(pod, decisionID, "timeout")
+		metrics.PlacementDurationSeconds.WithLabelValues("timeout").Observe(clock.Since(start).Seconds())
+		setReservingCondition(client, pod, v1.ConditionFalse, conditionReasonTimedOut,
+			fmt.Sprintf("This pod wasn't scheduled within %v, the reservation was released (decision %s).", timeout, decisionID))
+		r, found := podsBeingProcessed.Reservation(pod)
+		podsBeingProcessed.Remove(pod)
+		return found && rollBackPlacement(ctx, client, recorder, clock, podsBeingProcessed, r)
+	}
+	duration := clock.Since(start)
+	glog.Infof("Pod %v was successfully scheduled after %v (decision %s).", podId(pod), duration, decisionID)
+	recordOutcome(pod, decisionID, "success")
+	metrics.PlacementDurationSeconds.WithLabelValues("success").Observe(duration.Seconds())
+	setReservingCondition(client, pod, v1.ConditionFalse, conditionReasonScheduled,
+		fmt.Sprintf("This pod was scheduled after %v (decision %s).", duration, decisionID))
+	if r, found := podsBeingProcessed.Reservation(pod); found {
+		metrics.SuccessfulPlacementPathsCount.WithLabelValues(evictionsPath(len(r.victims))).Inc()
 	}
+	failedPlacements.Forget(pod)
 	podsBeingProcessed.Remove(pod)
+	return false
+}
+
+// nodeDeleted returns true if <nodeName> is known to be gone. Other errors
+// are only logged, the node is checked again in the next poll.
+func nodeDeleted(client kube_client.Interface, nodeName string) bool {
+	_, err := client.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		repeats.Warningf("get-node/"+nodeName, "Error while getting node %v: %v", nodeName, err)
+	}
+	return errors.IsNotFound(err)
 }
 
-func createKubeClient(flags *flag.FlagSet, inCluster bool) (kube_client.Interface, error) {
+func createKubeClient(inCluster bool) (kube_client.Interface, error) {
 	var config *kube_restclient.Config
 	var err error
+	var loadToken func() (string, error)
 	if inCluster {
 		config, err = kube_restclient.InClusterConfig()
+		loadToken = func() (string, error) { return readTokenFile(serviceAccountTokenFile) }
 	} else {
-		clientConfig := kubectl_util.DefaultClientConfig(flags)
-		config, err = clientConfig.ClientConfig()
+		config, err = kubeconfigClientConfig(*kubeconfig, *kubeContext).ClientConfig()
+		loadToken = func() (string, error) {
+			// the kubeconfig is read again, so that both it and its token file may change
+			reloaded, err := kubeconfigClientConfig(*kubeconfig, *kubeContext).ClientConfig()
+			if err != nil {
+				return "", err
+			}
+			return reloaded.BearerToken, nil
+		}
 	}
 	if err != nil {
 		return nil, fmt.Errorf("error connecting to the client: %v", err)
 	}
+	if *apiCAFile != "" {
+		config.TLSClientConfig.CAFile, config.TLSClientConfig.CAData = *apiCAFile, nil
+	}
+	if *apiTLSServerName != "" {
+		config.TLSClientConfig.ServerName = *apiTLSServerName
+	}
+	if *apiProxyURL != "" {
+		proxy, err := url.Parse(*apiProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --api-proxy-url: %v", err)
+		}
+		withProxy(config, proxy)
+	}
+	if *credentialsRefreshInterval > 0 && config.BearerToken != "" {
+		withRotatingToken(config, loadToken, *credentialsRefreshInterval)
+	}
 	config.ContentType = *contentType
+	config.Timeout = *apiTimeout
+	config.UserAgent = *apiUserAgent
+	if *impersonateUser != "" {
+		config.Impersonate = kube_restclient.ImpersonationConfig{UserName: *impersonateUser, Groups: *impersonateGroups}
+	}
 	return kube_client.NewForConfigOrDie(config), nil
 }
 
+// kubeconfigClientConfig loads the context <contextName> of the kubeconfig at
+// <path>. Empty values stand for the default kubeconfig and its current context.
+func kubeconfigClientConfig(path, contextName string) clientcmd.ClientConfig {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = path
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{CurrentContext: contextName})
+}
+
 func createEventRecorder(client kube_client.Interface) kube_record.EventRecorder {
+	if *eventSink == eventSinkNone {
+		return &kube_record.FakeRecorder{}
+	}
+	sink := newEventSink(client)
 	eventBroadcaster := kube_record.NewBroadcaster()
 	eventBroadcaster.StartLogging(glog.Infof)
-	eventBroadcaster.StartRecordingToSink(&v1core.EventSinkImpl{Interface: v1core.New(client.CoreV1().RESTClient()).Events("")})
-	return eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "rescheduler"})
+	eventBroadcaster.StartRecordingToSink(sink)
+	// Host is the node the reporting replica runs on, set as NODE_NAME via the downward API.
+	source := v1.EventSource{Component: "rescheduler", Host: os.Getenv("NODE_NAME")}
+	return &annotatingRecorder{
+		EventRecorder: eventBroadcaster.NewRecorder(scheme.Scheme, source),
+		sink:          sink,
+		source:        source,
+	}
 }
 
 // copied from Kubernetes 1.5.4
@@ -245,18 +884,21 @@ func getTaintsFromNodeAnnotations(annotations map[string]string) ([]v1.Taint, er
 	return taints, nil
 }
 
-func releaseAllTaintsDeprecated(client kube_client.Interface, nodeLister kube_utils.NodeLister) {
+func releaseAllTaintsDeprecated(ctx context.Context, client kube_client.Interface, nodeLister kube_utils.NodeLister) {
 	glog.Infof("Removing all annotation taints because they are no longer supported.")
 	nodes, err := nodeLister.List()
 	if err != nil {
-		glog.Warningf("Cannot release taints - error while listing nodes: %v", err)
+		repeats.Warningf("list-nodes", "Cannot release taints - error while listing nodes: %v", err)
 		return
 	}
-	releaseTaintsOnNodesDeprecated(client, nodes)
+	releaseTaintsOnNodesDeprecated(ctx, client, nodes)
 }
 
-func releaseTaintsOnNodesDeprecated(client kube_client.Interface, nodes []*v1.Node) {
+func releaseTaintsOnNodesDeprecated(ctx context.Context, client kube_client.Interface, nodes []*v1.Node) {
 	for _, node := range nodes {
+		if ctx.Err() != nil {
+			return
+		}
 		taints, err := getTaintsFromNodeAnnotations(node.Annotations)
 		if err != nil {
 			glog.Warningf("Error while getting Taints for node %v: %v", node.Name, err)
@@ -282,103 +924,175 @@ func releaseTaintsOnNodesDeprecated(client kube_client.Interface, nodes []*v1.No
 			node.Annotations[TaintsAnnotationKey] = string(taintsJson)
 			_, err = client.CoreV1().Nodes().Update(node)
 			if err != nil {
-				glog.Warningf("Error while releasing taints on node %v: %v", node.Name, err)
+				repeats.Warningf("release-taints/"+node.Name, "Error while releasing taints on node %v: %v", node.Name, err)
 			} else {
+				repeats.ForgetAll("release-taints/" + node.Name)
 				glog.Infof("Successfully released all taints on node %v", node.Name)
 			}
 		}
 	}
 }
 
-func releaseAllTaints(client kube_client.Interface, nodeLister kube_utils.NodeLister, podsBeingProcessed *podSet) {
+func releaseAllTaints(ctx context.Context, client kube_client.Interface, recorder kube_record.EventRecorder, nodeLister kube_utils.NodeLister, podsBeingProcessed *podSet) {
 	nodes, err := nodeLister.List()
 	if err != nil {
-		glog.Warningf("Cannot release taints - error while listing nodes: %v", err)
+		repeats.Warningf("list-nodes", "Cannot release taints - error while listing nodes: %v", err)
 		return
 	}
-	releaseTaintsOnNodes(client, nodes, podsBeingProcessed)
+	releaseTaintsOnNodes(ctx, client, recorder, nodes, podsBeingProcessed)
 }
 
-func releaseTaintsOnNodes(client kube_client.Interface, nodes []*v1.Node, podsBeingProcessed *podSet) {
+// releaseTaintsOnNodes removes the taints of pods which are no longer being
+// processed. Only taints with the same --taint-owner are touched. Taints of
+// pods this instance never processed, e.g. left behind by a previous instance,
+// are counted as force-released. The age of the oldest taint which stays is
+// exported, so that stuck reservations can be alerted on.
+func releaseTaintsOnNodes(ctx context.Context, client kube_client.Interface, recorder kube_record.EventRecorder, nodes []*v1.Node, podsBeingProcessed *podSet) {
+	now := time.Now()
+	oldestTaintAge := time.Duration(0)
+	defer func() {
+		metrics.OldestTaintAgeSeconds.Set(oldestTaintAge.Seconds())
+	}()
 	for _, node := range nodes {
+		if ctx.Err() != nil {
+			return
+		}
 		newTaints := make([]v1.Taint, 0)
+		released := make([]v1.Taint, 0)
 		for _, taint := range node.Spec.Taints {
-			if taint.Key == criticalAddonsOnlyTaintKey && !podsBeingProcessed.HasId(taint.Value) {
+			owned := taint.Key == criticalAddonsOnlyTaintKey && ownsTaint(node, taint)
+			if owned && taint.Value == dedicatedTaintValue && *dedicatedAddonNodes > 0 {
+				// managed by maintainDedicatedNodes
+				newTaints = append(newTaints, taint)
+			} else if owned && reservationReleasable(taint, podsBeingProcessed, now) {
 				glog.Infof("Releasing taint %+v on node %v", taint, node.Name)
+				released = append(released, taint)
+				if !podsBeingProcessed.TakeFinished(reservedPodUID(taint)) {
+					metrics.ForceReleasedTaintsCount.Inc()
+				}
 			} else {
+				if owned && taint.TimeAdded != nil {
+					if age := now.Sub(taint.TimeAdded.Time); age > oldestTaintAge {
+						oldestTaintAge = age
+					}
+				}
 				newTaints = append(newTaints, taint)
 			}
 		}
 
-		if len(newTaints) != len(node.Spec.Taints) {
+		pods := releasedPods(node, released)
+		if len(released) > 0 {
 			node.Spec.Taints = newTaints
+			disownTaints(node, released)
+		}
+		// entries of taints removed by someone else are pruned along the way
+		if pruneReservationLedger(node) || len(released) > 0 {
 			_, err := client.CoreV1().Nodes().Update(node)
 			if err != nil {
-				glog.Warningf("Error while releasing taints on node %v: %v", node.Name, err)
+				if releaseFailed(client, recorder, node, released, err) {
+					recordReleasedNode(recorder, node, pods)
+				}
 			} else {
+				releaseFailures.Forget(node.Name)
+				repeats.ForgetAll("release-taints/" + node.Name)
 				glog.Infof("Successfully released all taints on node %v", node.Name)
+				recordReleasedNode(recorder, node, pods)
 			}
 		}
 	}
 }
 
+// prepareNodeForPod reserves the node of <planned> for <criticalPod> with
+// <taint> and deletes the victims, returning those which were deleted. The
+// victims are chosen from the pods in <planned> unless it is older than
+// --node-snapshot-max-age, in which case they are listed again, and deleted by
+// an evictionExecutor. Victims may have been deleted even if it returns error.
 // The caller of this function must remove the taint if this function returns error.
-func prepareNodeForPod(client kube_client.Interface, recorder kube_record.EventRecorder, predicateChecker *ca_simulator.PredicateChecker, originalNode *v1.Node, criticalPod *v1.Pod) error {
-	// Operate on a copy of the node to ensure pods running on the node will pass CheckPredicates below.
-	node := originalNode.DeepCopy()
-	err := addTaint(client, originalNode, podId(criticalPod))
+func prepareNodeForPod(ctx context.Context, client kube_client.Interface, recorder kube_record.EventRecorder, predicateChecker *ca_simulator.PredicateChecker, planned *engine.NodeSnapshot, criticalPod *v1.Pod, taint v1.Taint, decisionID string) ([]*v1.Pod, error) {
+	originalNode := planned.Node
+	var node *v1.Node
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		fresh, err := refreshNode(client, originalNode, criticalPod)
+		if err != nil {
+			return err
+		}
+		// Operate on a copy of the node to ensure pods running on the node will pass CheckPredicates below.
+		node = fresh.DeepCopy()
+		return addTaint(client, fresh, taint, criticalPod)
+	})
 	if err != nil {
-		return fmt.Errorf("Error while adding taint: %v", err)
+		return nil, &engine.TaintError{Node: originalNode.Name, Err: err}
+	}
+	placementEventf(recorder, node, criticalPod, decisionID, v1.EventTypeNormal, EventReasonReservedNode,
+		"Node %s reserved for critical pod %s.", originalNode.Name, podId(criticalPod))
+	recordAction(action{Verb: actionTaint, Node: node.Name, CriticalPod: podId(criticalPod), DecisionID: decisionID})
+
+	snapshot := planned
+	if *nodeSnapshotMaxAge > 0 && time.Since(planned.Taken) <= *nodeSnapshotMaxAge {
+		reused := *planned
+		reused.Node = node
+		snapshot = &reused
+	} else if snapshot, err = nodeSnapshot(client, nil, node, criticalPod); err != nil {
+		return nil, err
+	}
+	placement, err := engine.PlanPlacement(predicateChecker, snapshot, criticalPod)
+	if err != nil {
+		return nil, err
 	}
 
-	requiredPods, otherPods, err := groupPods(client, node)
+	deleted, err := newEvictionExecutor(client, recorder, predicateChecker, snapshot, criticalPod, decisionID).Run(ctx, placement.Victims)
 	if err != nil {
-		return err
+		return deleted, err
 	}
 
-	nodeInfo := schedulercache.NewNodeInfo(requiredPods...)
-	nodeInfo.SetNode(node)
+	// TODO(piosz): how to reset scheduler backoff?
+	return deleted, nil
+}
 
-	// check whether critical pod still fit
-	if err := predicateChecker.CheckPredicates(criticalPod, nil, nodeInfo, true); err != nil {
-		return fmt.Errorf("Pod %s doesn't fit to node %v: %v", podId(criticalPod), node.Name, err)
+// findVictims returns pods running on <node> which have to be deleted so that <criticalPod> fits there.
+func findVictims(client kube_client.Interface, predicateChecker *ca_simulator.PredicateChecker, node *v1.Node, criticalPod *v1.Pod) ([]*v1.Pod, error) {
+	snapshot, err := nodeSnapshot(client, nil, node, criticalPod)
+	if err != nil {
+		return nil, err
 	}
-	requiredPods = append(requiredPods, criticalPod)
-	nodeInfo = schedulercache.NewNodeInfo(requiredPods...)
-	nodeInfo.SetNode(node)
+	return engine.FindVictims(predicateChecker, snapshot, criticalPod)
+}
 
-	for _, p := range otherPods {
-		if err := predicateChecker.CheckPredicates(p, nil, nodeInfo, true); err != nil {
-			glog.Infof("Pod %s will be deleted in order to schedule critical pod %s.", podId(p), podId(criticalPod))
-			recorder.Eventf(p, v1.EventTypeNormal, "DeletedByRescheduler",
-				"Deleted by rescheduler in order to schedule critical pod %s.", podId(criticalPod))
-			deleteOptions := metav1.DeleteOptions{}
-			gracePeriodSeconds := int64(gracePeriod.Seconds())
-			if gracePeriodSeconds >= 0 && (p.Spec.TerminationGracePeriodSeconds == nil || *p.Spec.TerminationGracePeriodSeconds > gracePeriodSeconds) {
-				deleteOptions.GracePeriodSeconds = &gracePeriodSeconds
-			}
-			delErr := client.CoreV1().Pods(p.Namespace).Delete(p.Name, &deleteOptions)
-			if delErr != nil {
-				return fmt.Errorf("Failed to delete pod %s: %v", podId(p), delErr)
-			}
-			metrics.DeletedPodsCount.Inc()
-		} else {
-			newPods := append(nodeInfo.Pods(), p)
-			nodeInfo = schedulercache.NewNodeInfo(newPods...)
-			nodeInfo.SetNode(node)
-		}
+// refreshNode gets the current version of <node> right before it's tainted, so
+// that changes made by the kubelet or other controllers since it was listed
+// aren't overwritten, and checks it can still be reserved for <criticalPod>.
+func refreshNode(client kube_client.Interface, node *v1.Node, criticalPod *v1.Pod) (*v1.Node, error) {
+	fresh, err := client.CoreV1().Nodes().Get(node.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
 	}
-
-	// TODO(piosz): how to reset scheduler backoff?
-	return nil
+	if !kube_utils.IsNodeReadyAndSchedulable(fresh) {
+		return nil, fmt.Errorf("node %v is no longer ready and schedulable", node.Name)
+	}
+	if err := checkReservation(fresh); err != nil {
+		return nil, fmt.Errorf("node %v was reserved meanwhile: %v", node.Name, err)
+	}
+	if err := engine.CheckTolerations(fresh, criticalPod); err != nil {
+		return nil, fmt.Errorf("node %v changed: %v", node.Name, err)
+	}
+	if err := engine.CheckPlatform(fresh, criticalPod); err != nil {
+		return nil, fmt.Errorf("node %v changed: %v", node.Name, err)
+	}
+	return fresh, nil
 }
 
-func addTaint(client kube_client.Interface, node *v1.Node, value string) error {
-	node.Spec.Taints = append(node.Spec.Taints, v1.Taint{
-		Key:    criticalAddonsOnlyTaintKey,
-		Value:  value,
-		Effect: v1.TaintEffectNoSchedule,
-	})
+// addTaint adds <taint> to <node>. Reservation taints are recorded in the
+// ledger for <criticalPod>, which is nil for other taints.
+func addTaint(client kube_client.Interface, node *v1.Node, taint v1.Taint, criticalPod *v1.Pod) error {
+	if taint.TimeAdded == nil {
+		now := metav1.Now()
+		taint.TimeAdded = &now
+	}
+	node.Spec.Taints = append(node.Spec.Taints, taint)
+	claimTaint(node, taint)
+	if criticalPod != nil {
+		recordReservation(node, taint, criticalPod)
+	}
 
 	if _, err := client.CoreV1().Nodes().Update(node); err != nil {
 		return err
@@ -386,113 +1100,209 @@ func addTaint(client kube_client.Interface, node *v1.Node, value string) error {
 	return nil
 }
 
-// Currently the logic choose a random node which satisfies requirements (a critical pod fits there).
-// TODO(piosz): add a prioritization to this logic
-func findNodeForPod(client kube_client.Interface, predicateChecker *ca_simulator.PredicateChecker, nodes []*v1.Node, pod *v1.Pod) *v1.Node {
+// findNodeForPod returns the first of <nodes> the critical pod fits on without
+// any evictions or, if there is none, the first it fits on once pods are
+// deleted. Callers order <nodes> by preference with engine.OrderNodes.
+func findNodeForPod(ctx context.Context, client kube_client.Interface, predicateChecker *ca_simulator.PredicateChecker, nodes []*v1.Node, pod *v1.Pod) *v1.Node {
+	snapshot := findSnapshotForPod(ctx, client, predicateChecker, nil, nodes, pod)
+	if snapshot == nil {
+		return nil
+	}
+	return snapshot.Node
+}
+
+// findSnapshotForPod is findNodeForPod which returns the snapshot of the node
+// it was chosen on, so that the pods on it don't have to be listed again.
+// Pods already listed in <lists> aren't listed again either.
+func findSnapshotForPod(ctx context.Context, client kube_client.Interface, predicateChecker *ca_simulator.PredicateChecker, lists *podLists, nodes []*v1.Node, pod *v1.Pod) *engine.NodeSnapshot {
+	var withEvictions *engine.NodeSnapshot
 	for _, node := range nodes {
-		// ignore nodes with taints
-		if err := checkTaints(node); err != nil {
-			glog.Warningf("Skipping node %v due to %v", node.Name, err)
+		if ctx.Err() != nil {
+			return nil
 		}
-
-		requiredPods, _, err := groupPods(client, node)
+		snapshot, err := checkNodeSnapshot(client, predicateChecker, lists, node, pod)
 		if err != nil {
-			glog.Warningf("Skipping node %v due to error: %v", node.Name, err)
 			continue
 		}
+		if engine.FitsWithoutEvictions(predicateChecker, snapshot, pod) == nil {
+			if withEvictions != nil {
+				if victims, err := engine.FindVictims(predicateChecker, withEvictions, pod); err == nil {
+					metrics.SparedVictimsCount.WithLabelValues("free_fit").Add(float64(len(victims)))
+				}
+			}
+			return snapshot
+		}
+		if withEvictions == nil {
+			withEvictions = snapshot
+		}
+	}
+	return withEvictions
+}
 
-		nodeInfo := schedulercache.NewNodeInfo(requiredPods...)
-		nodeInfo.SetNode(node)
+// checkNodeForPod returns nil if <pod> fits on <node> once all pods which can be deleted are gone.
+func checkNodeForPod(client kube_client.Interface, predicateChecker *ca_simulator.PredicateChecker, node *v1.Node, pod *v1.Pod) error {
+	_, err := checkNodeSnapshot(client, predicateChecker, nil, node, pod)
+	return err
+}
 
-		if err := predicateChecker.CheckPredicates(pod, nil, nodeInfo, true); err == nil {
-			return node
-		}
+// checkNodeSnapshot is checkNodeForPod which also returns the snapshot of
+// <node> the check was based on.
+func checkNodeSnapshot(client kube_client.Interface, predicateChecker *ca_simulator.PredicateChecker, lists *podLists, node *v1.Node, pod *v1.Pod) (*engine.NodeSnapshot, error) {
+	// ignore nodes already reserved for another critical pod
+	if err := checkReservation(node); err != nil {
+		repeats.Warningf("skip-node/"+node.Name+"/"+podId(pod), "Skipping node %v due to %v", node.Name, err)
+		return nil, err
 	}
-	return nil
+	// don't list pods on nodes the pod can't run on anyway
+	if err := engine.Prefilter(node, pod); err != nil {
+		glog.V(4).Infof("Skipping node %v: %v", node.Name, err)
+		return nil, err
+	}
+
+	snapshot, err := nodeSnapshot(client, lists, node, pod)
+	if err != nil {
+		repeats.Warningf("list-pods/"+node.Name, "Skipping node %v due to error: %v", node.Name, err)
+		return nil, err
+	}
+	fingerprint, now := engine.FitFingerprint(snapshot, pod), time.Now()
+	if err := fitFailures.Get(snapshot, pod, fingerprint, now); err != nil {
+		glog.V(4).Infof("Skipping node %v, critical pod %s didn't fit there before: %v", node.Name, podId(pod), err)
+		return nil, err
+	}
+	err = engine.CheckNode(predicateChecker, snapshot, pod)
+	fitFailures.Set(snapshot, pod, fingerprint, err, now)
+	if conflict, ok := err.(*engine.HostPortConflictError); ok {
+		repeats.Warningf("host-port/"+node.Name+"/"+podId(pod), "Pod %s can't use node %v: %v", podId(pod), node.Name, conflict)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
 }
 
-func checkTaints(node *v1.Node) error {
-	for _, taint := range node.Spec.Taints {
-		if taint.Key == criticalAddonsOnlyTaintKey {
-			return fmt.Errorf("CriticalAddonsOnly taint with value: %v", taint.Value)
-		}
+// checkReservation applies --reserved-nodes: with "skip" every reserved node
+// is skipped, with "skip-fresh" only those reserved within --pod-scheduled-timeout.
+func checkReservation(node *v1.Node) error {
+	var freshFor time.Duration
+	if *reservedNodes == "skip-fresh" {
+		freshFor = currentConfig().PodScheduledTimeout.Duration
 	}
-	return nil
+	return engine.CheckReservation(node, time.Now(), freshFor)
 }
 
-// groupPods divides pods running on <node> into those which can't be deleted and the others
-func groupPods(client kube_client.Interface, node *v1.Node) ([]*v1.Pod, []*v1.Pod, error) {
-	podsOnNode, err := client.CoreV1().Pods(v1.NamespaceAll).List(
-		metav1.ListOptions{FieldSelector: fields.SelectorFromSet(fields.Set{"spec.nodeName": node.Name}).String()})
+// nodeSnapshot lists pods running on <node>, or takes them from <lists>,
+// classifying them as victims for <criticalPod>.
+func nodeSnapshot(client kube_client.Interface, lists *podLists, node *v1.Node, criticalPod *v1.Pod) (*engine.NodeSnapshot, error) {
+	pods, taken, err := lists.list(client, node)
 	if err != nil {
-		return []*v1.Pod{}, []*v1.Pod{}, err
+		return nil, err
 	}
+	classify := victimClassifier(client, daemonSetOverridesOf(client, criticalPod).VictimNamespaces)
+	rules := daemonSetVictimRules(currentConfig().RequiredPods, criticalPod)
+	return &engine.NodeSnapshot{Node: node, Pods: pods, ClassifyVictim: classify, RequiredPods: rules, CountTerminating: *countTerminatingPods, Taken: taken}, nil
+}
 
-	requiredPods := make([]*v1.Pod, 0)
-	otherPods := make([]*v1.Pod, 0)
-	for i := range podsOnNode.Items {
-		pod := &podsOnNode.Items[i]
+// podLists caches the pods listed per node during one planning pass, so
+// that a node considered for several pending critical pods is listed once.
+// A nil *podLists lists the pods every time.
+type podLists struct {
+	pods  map[string][]*v1.Pod
+	taken map[string]time.Time
+}
 
-		//creatorRef, err := ca_drain.CreatorRefKind(pod)
-		if err != nil {
-			return []*v1.Pod{}, []*v1.Pod{}, err
-		}
+func newPodLists() *podLists {
+	return &podLists{pods: map[string][]*v1.Pod{}, taken: map[string]time.Time{}}
+}
 
-		if isMirrorPod(pod) || isDaemonsetPod(pod) || isCriticalPod(pod) {
-			requiredPods = append(requiredPods, pod)
-		} else {
-			otherPods = append(otherPods, pod)
+// list returns the pods bound to <node> and when they were listed.
+func (l *podLists) list(client kube_client.Interface, node *v1.Node) ([]*v1.Pod, time.Time, error) {
+	if l != nil {
+		if pods, found := l.pods[node.Name]; found {
+			return pods, l.taken[node.Name], nil
 		}
 	}
-
-	return requiredPods, otherPods, nil
+	taken := time.Now()
+	podsOnNode, err := client.CoreV1().Pods(v1.NamespaceAll).List(
+		metav1.ListOptions{FieldSelector: fields.SelectorFromSet(fields.Set{"spec.nodeName": node.Name}).String()})
+	if err != nil {
+		return nil, taken, err
+	}
+	pods := make([]*v1.Pod, 0, len(podsOnNode.Items))
+	for i := range podsOnNode.Items {
+		pods = append(pods, &podsOnNode.Items[i])
+	}
+	if l != nil {
+		l.pods[node.Name], l.taken[node.Name] = pods, taken
+	}
+	return pods, taken, nil
 }
 
-func filterCriticalDaemonSetPods(allPods []*v1.Pod, podsBeingProcessed *podSet) []*v1.Pod {
-	criticalPods := []*v1.Pod{}
-	for _, pod := range allPods {
-		if isCriticalPod(pod) && isDaemonsetPod(pod) && !podsBeingProcessed.Has(pod) {
-			criticalPods = append(criticalPods, pod)
-		}
-	}
-	return criticalPods
+// newPendingBoundPodLister returns a lister of the pods in <namespace> which
+// are bound to a node but still pending, such as static pods the kubelet can't
+// admit, filled until <stopChannel> is closed.
+func newPendingBoundPodLister(client kube_client.Interface, namespace string, stopChannel <-chan struct{}) kube_utils.PodLister {
+	selector := fields.ParseSelectorOrDie("spec.nodeName!=,status.phase=" + string(v1.PodPending))
+	listWatch := cache.NewListWatchFromClient(client.CoreV1().RESTClient(), "pods", namespace, selector)
+	store := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	reflector := cache.NewReflector(listWatch, &v1.Pod{}, store, time.Hour)
+	go reflector.Run(stopChannel)
+	return &cachedPendingPodLister{lister: v1lister.NewPodLister(store)}
 }
 
-func isCriticalPod(pod *v1.Pod) bool {
-	return pod.Namespace == kubeapi.NamespaceSystem &&
-		(isCritical(pod.Annotations) || (pod.Spec.Priority != nil && isCriticalPodBasedOnPriority(*pod.Spec.Priority)))
+type cachedPendingPodLister struct {
+	lister v1lister.PodLister
 }
 
-// isCritical returns true if parameters bear the critical pod annotation
-func isCritical(annotations map[string]string) bool {
-	val, ok := annotations[criticalPodAnnotation]
-	if ok && val == "" {
-		return true
-	}
-	return false
+func (l *cachedPendingPodLister) List() ([]*v1.Pod, error) {
+	return l.lister.List(labels.Everything())
 }
 
-// isCriticalPodBasedOnPriority checks if the given pod is a critical pod based on priority resolved from pod Spec.
-func isCriticalPodBasedOnPriority(priority int32) bool {
-	if priority >= SystemCriticalPriority {
-		return true
+// reportStaticCriticalPods warns about the critical mirror pods which are
+// pending on their node. Static pods are bound to their node by the kubelet,
+// so reserving another node doesn't help them; they are never placed.
+func (r *rescheduler) reportStaticCriticalPods() {
+	pendingPods, err := r.pendingBoundPodLister.List()
+	if err != nil {
+		repeats.Errorf("list-pending-bound-pods", "Failed to list pending pods bound to nodes: %v", err)
+		return
+	}
+	metrics.StaticCriticalPodsCount.Reset()
+	for _, pod := range pendingPods {
+		if pod.Status.Phase != v1.PodPending || !engine.IsCriticalPod(pod) || !engine.IsMirrorPod(pod) {
+			continue
+		}
+		metrics.StaticCriticalPodsCount.WithLabelValues(k8sApp(pod)).Inc()
+		repeats.Warningf("static-pod/"+podId(pod), "Critical static pod %s is pending on node %v, room has to be made there by the kubelet or an operator", podId(pod), pod.Spec.NodeName)
+		repeats.Eventf(r.recorder, "static-pod/"+podId(pod), pod, nil, v1.EventTypeWarning, EventReasonStaticPodNotPlaced,
+			"Critical pod %s is a static pod, which the rescheduler can't place; make room for it on node %s.", podId(pod), pod.Spec.NodeName)
 	}
-	return false
 }
 
-// isMirrorPod checks whether the pod is a mirror pod.
-func isMirrorPod(pod *v1.Pod) bool {
-	_, found := pod.ObjectMeta.Annotations[types.ConfigMirrorAnnotationKey]
-	return found
+// ManagedAnnotationKey set to "true" on a critical pod opts it in to being
+// placed with --require-opt-in.
+const ManagedAnnotationKey = "rescheduler.alpha.kubernetes.io/managed"
+
+// optedIn returns true if <pod> may be placed given --require-opt-in.
+func optedIn(pod *v1.Pod) bool {
+	return !*requireOptIn || pod.Annotations[ManagedAnnotationKey] == "true"
 }
 
-// isDaemonSetPod checks where the pod is a daemonset pod.
-func isDaemonsetPod(pod *v1.Pod) bool {
-	ownerRefList := pod.ObjectMeta.GetOwnerReferences()
-	for _, ownerRef := range ownerRefList {
-		if ownerRef.Kind == "DaemonSet" {
-			return true
+func filterCriticalDaemonSetPods(allPods []*v1.Pod, podsBeingProcessed *podSet) []*v1.Pod {
+	// the selector was validated with the configuration
+	selector, _ := labels.Parse(currentConfig().CriticalPodSelector)
+	criticalPods := []*v1.Pod{}
+	for _, pod := range allPods {
+		if engine.IsCriticalPod(pod) && !engine.IsMirrorPod(pod) && owners.IsDaemonSetPod(pod) && !podsBeingProcessed.Has(pod) {
+			if !optedIn(pod) {
+				glog.V(4).Infof("Ignoring critical pod %s, it isn't annotated with %s=true", podId(pod), ManagedAnnotationKey)
+				continue
+			}
+			if selector != nil && !selector.Matches(labels.Set(pod.Labels)) {
+				glog.V(4).Infof("Ignoring critical pod %s, it doesn't match --critical-pod-selector", podId(pod))
+				continue
+			}
+			criticalPods = append(criticalPods, pod)
 		}
 	}
-	return false
+	return criticalPods
 }
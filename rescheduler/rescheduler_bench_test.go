@@ -0,0 +1,102 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	kube_record "k8s.io/client-go/tools/record"
+	"k8s.io/contrib/rescheduler/engine"
+	"k8s.io/contrib/rescheduler/synthetic"
+)
+
+var benchmarkClusterSizes = []int{100, 1000, 5000}
+
+// benchmarkCluster returns a cluster where the critical pod only fits on the
+// last node, so that findNodeForPod has to evaluate every node.
+func benchmarkCluster(nodes int) *synthetic.Cluster {
+	cluster := synthetic.NewCluster(synthetic.Options{
+		Nodes:                nodes,
+		PodsPerNode:          20,
+		DaemonSetPodsPerNode: 2,
+		NodeMilliCPU:         4000,
+		Seed:                 1,
+	})
+	bigNode := synthetic.NewNode("big-node", 16000)
+	cluster.Nodes = append(cluster.Nodes, bigNode)
+	for i := 0; i < 20; i++ {
+		pod := synthetic.NewPod(fmt.Sprintf("big-pod-%d", i), "default", 700)
+		pod.Spec.NodeName = bigNode.Name
+		cluster.Pods = append(cluster.Pods, pod)
+	}
+	return cluster
+}
+
+func BenchmarkFindNodeForPod(b *testing.B) {
+	predicateChecker := simulator.NewTestPredicateChecker()
+	criticalPod := synthetic.NewCriticalDaemonSetPod("critical-pod", 6000)
+	for _, size := range benchmarkClusterSizes {
+		cluster := benchmarkCluster(size)
+		client := cluster.Clientset()
+		b.Run(fmt.Sprintf("nodes=%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if node := findNodeForPod(context.Background(), client, predicateChecker, cluster.Nodes, criticalPod); node == nil {
+					b.Fatalf("no node found for critical pod")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkFindVictims(b *testing.B) {
+	predicateChecker := simulator.NewTestPredicateChecker()
+	criticalPod := synthetic.NewCriticalDaemonSetPod("critical-pod", 6000)
+	cluster := benchmarkCluster(1)
+	client := cluster.Clientset()
+	node := cluster.Nodes[len(cluster.Nodes)-1]
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := findVictims(client, predicateChecker, node, criticalPod); err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func BenchmarkPrepareNodeForPod(b *testing.B) {
+	predicateChecker := simulator.NewTestPredicateChecker()
+	criticalPod := synthetic.NewCriticalDaemonSetPod("critical-pod", 6000)
+	recorder := kube_record.NewFakeRecorder(1000)
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		cluster := benchmarkCluster(1)
+		client := cluster.Clientset()
+		node := cluster.Nodes[len(cluster.Nodes)-1]
+		b.StartTimer()
+		if _, err := prepareNodeForPod(context.Background(), client, recorder, predicateChecker, &engine.NodeSnapshot{Node: node}, criticalPod, reservationTaint(criticalPod, time.Now()), ""); err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+		b.StopTimer()
+		for len(recorder.Events) > 0 {
+			<-recorder.Events
+		}
+		b.StartTimer()
+	}
+}
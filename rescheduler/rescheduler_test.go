@@ -17,19 +17,35 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
 	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/autoscaler/cluster-autoscaler/simulator"
 	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
 	core "k8s.io/client-go/testing"
 	kube_record "k8s.io/client-go/tools/record"
+	"k8s.io/contrib/rescheduler/engine"
+	"k8s.io/contrib/rescheduler/metrics"
 )
 
 func TestWaitForScheduled(t *testing.T) {
@@ -48,8 +64,33 @@ func TestWaitForScheduled(t *testing.T) {
 	podsBeingProcessed.Add(pod)
 
 	assert.True(t, podsBeingProcessed.HasId("kube-system_test-pod"))
-	waitForScheduled(fakeClient, podsBeingProcessed, pod)
-	assert.False(t, podsBeingProcessed.HasId("kube-system_test-pod"))
+	fakeClock := clock.NewFakeClock(time.Now())
+	done := make(chan struct{})
+	go func() {
+		waitForScheduled(context.Background(), fakeClient, kube_record.NewFakeRecorder(10), fakeClock, podsBeingProcessed, pod, "")
+		close(done)
+	}()
+	stepClockUntil(t, fakeClock, time.Second, func() bool {
+		return !podsBeingProcessed.HasId("kube-system_test-pod")
+	})
+	<-done
+	// three polls and one to set the condition
+	assert.Equal(t, 4, counter)
+}
+
+// stepClockUntil advances <fakeClock> by <step> whenever somebody waits on it,
+// until <done> returns true.
+func stepClockUntil(t *testing.T, fakeClock *clock.FakeClock, step time.Duration, done func() bool) {
+	for i := 0; i < 10000 && !done(); i++ {
+		if fakeClock.HasWaiters() {
+			fakeClock.Step(step)
+		} else {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if !done() {
+		t.Fatalf("condition not met after stepping the clock")
+	}
 }
 
 func TestFilterCriticalPodsCreatedByDaemonSet(t *testing.T) {
@@ -74,6 +115,24 @@ func TestFilterCriticalPodsCreatedByDaemonSet(t *testing.T) {
 	filtered = filterCriticalDaemonSetPods(allPods, podsBeingProcessed)
 	assert.Equal(t, 1, len(filtered))
 	assert.Equal(t, "dns", filtered[0].Name)
+
+	flags.Set("require-opt-in", "true")
+	defer flags.Set("require-opt-in", "false")
+	assert.Empty(t, filterCriticalDaemonSetPods(allPods, podsBeingProcessed))
+	allPods[3].Annotations[ManagedAnnotationKey] = "true"
+	filtered = filterCriticalDaemonSetPods(allPods, podsBeingProcessed)
+	assert.Equal(t, 1, len(filtered))
+	assert.Equal(t, "dns", filtered[0].Name)
+	flags.Set("require-opt-in", "false")
+
+	allPods[3].Labels = map[string]string{"k8s-app": "kube-dns"}
+	config := configFromFlags()
+	config.CriticalPodSelector = "k8s-app in (kube-dns, cilium)"
+	activeConfig.Set(config)
+	defer activeConfig.Set(configFromFlags())
+	filtered = filterCriticalDaemonSetPods(allPods, podsBeingProcessed)
+	assert.Equal(t, 1, len(filtered))
+	assert.Equal(t, "dns", filtered[0].Name)
 }
 
 func TestReleaseTaintsOnNodes(t *testing.T) {
@@ -90,16 +149,53 @@ func TestReleaseTaintsOnNodes(t *testing.T) {
 		createTestNode("node1", 1000),
 		createTestNode("node2", 1000),
 		createTestNode("node3", 1000),
+		createTestNode("node4", 1000),
+		createTestNode("node5", 1000),
+		createTestNode("node6", 1000),
 	}
-	addTaintToNode(nodes[0], "kube-system_heapster")
-	addTaintToNode(nodes[1], "kube-system_dns")
+	addTaintToNode(nodes[0], "heapster")
+	addTaintToNode(nodes[1], "dns")
+	addTaintToNode(nodes[2], "kube-proxy")
+	added := metav1.NewTime(time.Now().Add(-time.Hour))
+	nodes[0].Spec.Taints[0].TimeAdded = &added
+	// left behind by an older rescheduler
+	nodes[3].Spec.Taints = []v1.Taint{{Key: criticalAddonsOnlyTaintKey, Value: "kube-system_fluentd", Effect: v1.TaintEffectNoSchedule}}
+	// reservations of another instance are kept until they expire
+	other := createTestPod("metrics-server", "kube-system", true, true, 100)
+	nodes[4].Spec.Taints = []v1.Taint{engine.ReservationTaint(other, time.Now().Add(time.Minute), "other-instance")}
+	nodes[5].Spec.Taints = []v1.Taint{engine.ReservationTaint(other, time.Now().Add(-time.Minute), "other-instance")}
 
 	podsBeingProcessed := NewPodSet()
 	podsBeingProcessed.Add(createTestPod("heapster", "kube-system", true, true, 200))
+	kubeProxy := createTestPod("kube-proxy", "kube-system", true, true, 200)
+	podsBeingProcessed.Add(kubeProxy)
+	podsBeingProcessed.Remove(kubeProxy)
+	forceReleased := metricValue(t, metrics.ForceReleasedTaintsCount)
 
-	releaseTaintsOnNodes(fakeClient, nodes, podsBeingProcessed)
+	recorder := kube_record.NewFakeRecorder(10)
+	releaseTaintsOnNodes(context.Background(), fakeClient, recorder, nodes, podsBeingProcessed)
 	assert.Equal(t, nodes[1].Name, getStringFromChan(updatedNodes))
+	assert.Equal(t, nodes[2].Name, getStringFromChan(updatedNodes))
+	assert.Equal(t, nodes[3].Name, getStringFromChan(updatedNodes))
+	assert.Equal(t, nodes[5].Name, getStringFromChan(updatedNodes))
 	assert.Equal(t, "Nothing returned", getStringFromChan(updatedNodes))
+	assert.Equal(t, forceReleased+3, metricValue(t, metrics.ForceReleasedTaintsCount))
+	assert.InDelta(t, time.Hour.Seconds(), metricValue(t, metrics.OldestTaintAgeSeconds), 60)
+	events := drainEvents(recorder)
+	assert.Equal(t, 4, strings.Count(events, EventReasonReleasedNode), events)
+	assert.Contains(t, events, "Node node4 no longer reserved for critical pod kube-system_fluentd.")
+}
+
+// metricValue returns the value of a counter or gauge.
+func metricValue(t *testing.T, metric prometheus.Metric) float64 {
+	m := &dto.Metric{}
+	if err := metric.Write(m); err != nil {
+		t.Fatalf("failed to read metric: %v", err)
+	}
+	if m.Gauge != nil {
+		return m.Gauge.GetValue()
+	}
+	return m.Counter.GetValue()
 }
 
 func TestReleaseTaintsOnNodesDeprecated(t *testing.T) {
@@ -120,7 +216,7 @@ func TestReleaseTaintsOnNodesDeprecated(t *testing.T) {
 	addTaintAnnotationToNode(nodes[0], "kube-system_heapster")
 	addTaintAnnotationToNode(nodes[1], "kube-system_dns")
 
-	releaseTaintsOnNodesDeprecated(fakeClient, nodes)
+	releaseTaintsOnNodesDeprecated(context.Background(), fakeClient, nodes)
 	assert.Equal(t, nodes[0].Name, getStringFromChan(updatedNodes))
 	assert.Equal(t, nodes[1].Name, getStringFromChan(updatedNodes))
 	assert.Equal(t, "Nothing returned", getStringFromChan(updatedNodes))
@@ -172,21 +268,38 @@ func TestFindNodeForPod(t *testing.T) {
 	pod3 := createTestPod("pod3", "kube-system", true, true, 800)
 	pod4 := createTestPod("pod4", "kube-system", true, true, 2200)
 
-	node := findNodeForPod(fakeClient, predicateChecker, nodes, pod1)
+	node := findNodeForPod(context.Background(), fakeClient, predicateChecker, nodes, pod1)
 	assert.Equal(t, "node1", node.Name)
 
-	node = findNodeForPod(fakeClient, predicateChecker, nodes, pod2)
+	// pod2 fits on node2 after evicting p1n2, but on node3 as it is.
+	node = findNodeForPod(context.Background(), fakeClient, predicateChecker, nodes, pod2)
+	assert.Equal(t, "node3", node.Name)
+
+	// p1n2 is in kube-system and so protected by default
+	node = findNodeForPod(context.Background(), fakeClient, predicateChecker, nodes[:2], pod2)
+	assert.Nil(t, node)
+	flags.Set("protect-system-namespace-victims", "false")
+	defer flags.Set("protect-system-namespace-victims", "true")
+	node = findNodeForPod(context.Background(), fakeClient, predicateChecker, nodes[:2], pod2)
 	assert.Equal(t, "node2", node.Name)
+	pods2[0].Annotations = map[string]string{EvictableAnnotationKey: "true"}
+	flags.Set("protect-system-namespace-victims", "true")
+	node = findNodeForPod(context.Background(), fakeClient, predicateChecker, nodes[:2], pod2)
+	assert.Equal(t, "node2", node.Name)
+	flags.Set("protect-system-namespace-victims", "false")
 
-	node = findNodeForPod(fakeClient, predicateChecker, nodes, pod3)
+	node = findNodeForPod(context.Background(), fakeClient, predicateChecker, nodes, pod3)
 	assert.Equal(t, "node3", node.Name)
 
-	node = findNodeForPod(fakeClient, predicateChecker, nodes, pod4)
+	node = findNodeForPod(context.Background(), fakeClient, predicateChecker, nodes, pod4)
 	assert.Nil(t, node)
 
 }
 
 func TestPrepareNodeForPod(t *testing.T) {
+	// the victims here are in kube-system
+	flags.Set("protect-system-namespace-victims", "false")
+	defer flags.Set("protect-system-namespace-victims", "true")
 	deletedPods := make(chan string, 10)
 	fakeClient := &fake.Clientset{}
 	fakeRecorder := kube_record.NewFakeRecorder(10)
@@ -202,7 +315,13 @@ func TestPrepareNodeForPod(t *testing.T) {
 	}
 	criticalPod := createTestPod("critical-pod", "kube-system", true, true, 500)
 
+	currentNode := node.DeepCopy()
+	fakeClient.Fake.AddReactor("get", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		return true, currentNode.DeepCopy(), nil
+	})
+	lists := 0
 	fakeClient.Fake.AddReactor("list", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		lists++
 		return true, &v1.PodList{Items: podsOnNode}, nil
 	})
 	fakeClient.Fake.AddReactor("delete", "pods", func(action core.Action) (bool, runtime.Object, error) {
@@ -211,20 +330,158 @@ func TestPrepareNodeForPod(t *testing.T) {
 		return true, nil, nil
 	})
 
-	err := prepareNodeForPod(fakeClient, fakeRecorder, predicateChecker, node, criticalPod)
+	_, err := prepareNodeForPod(context.Background(), fakeClient, fakeRecorder, predicateChecker, &engine.NodeSnapshot{Node: node}, criticalPod, reservationTaint(criticalPod, time.Now()), "")
 	assert.NoError(t, err)
 
 	assert.Equal(t, podsOnNode[2].Name, getStringFromChan(deletedPods))
 	assert.Equal(t, podsOnNode[3].Name, getStringFromChan(deletedPods))
 	assert.Equal(t, "Nothing returned", getStringFromChan(deletedPods))
+
+	// The node turned NotReady after it was listed.
+	currentNode.Status.Conditions[0].Status = v1.ConditionFalse
+	_, err = prepareNodeForPod(context.Background(), fakeClient, fakeRecorder, predicateChecker, &engine.NodeSnapshot{Node: node}, criticalPod, reservationTaint(criticalPod, time.Now()), "")
+	assert.Error(t, err)
+	assert.Equal(t, "taint_conflict", placementErrorKind(context.Background(), err))
+	assert.Equal(t, "Nothing returned", getStringFromChan(deletedPods))
+	assert.Equal(t, 1, lists)
+
+	// A recent snapshot from choosing the node is reused.
+	currentNode.Status.Conditions[0].Status = v1.ConditionTrue
+	planned := &engine.NodeSnapshot{
+		Node:  node,
+		Pods:  []*v1.Pod{&podsOnNode[0], &podsOnNode[2], &podsOnNode[3], &podsOnNode[4]},
+		Taken: time.Now(),
+	}
+	_, err = prepareNodeForPod(context.Background(), fakeClient, fakeRecorder, predicateChecker, planned, criticalPod, reservationTaint(criticalPod, time.Now()), "")
+	assert.NoError(t, err)
+	assert.Equal(t, podsOnNode[2].Name, getStringFromChan(deletedPods))
+	assert.Equal(t, "Nothing returned", getStringFromChan(deletedPods))
+	assert.Equal(t, 1, lists)
+
+	// The API server refuses to delete the victim.
+	fakeClient.Fake.PrependReactor("delete", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.NewForbidden(v1.Resource("pods"), "p3", fmt.Errorf("denied by webhook"))
+	})
+	_, err = prepareNodeForPod(context.Background(), fakeClient, fakeRecorder, predicateChecker, planned, criticalPod, reservationTaint(criticalPod, time.Now()), "")
+	assert.Equal(t, "eviction_vetoed", placementErrorKind(context.Background(), err))
+	assert.Contains(t, err.Error(), "denied by webhook")
+}
+
+func TestEvictionExecutor(t *testing.T) {
+	defer func(backoff wait.Backoff) { evictionRetryBackoff = backoff }(evictionRetryBackoff)
+	evictionRetryBackoff = wait.Backoff{Duration: time.Millisecond, Factor: 1}
+	predicateChecker := simulator.NewTestPredicateChecker()
+
+	required := createTestPod("required", "kube-system", true, true, 150)
+	v1Pod := createTestPod("v1", "default", false, false, 250)
+	v2Pod := createTestPod("v2", "default", false, false, 250)
+	v3Pod := createTestPod("v3", "default", false, false, 150)
+	criticalPod := createTestPod("critical-pod", "kube-system", true, true, 500)
+	snapshot := &engine.NodeSnapshot{Node: createTestNode("test-node", 1000), Pods: []*v1.Pod{required, v1Pod, v2Pod, v3Pod}}
+
+	// run deletes <victims>, failing the deletion of each pod with its error in <failures>.
+	run := func(failures map[string][]error, victims ...*v1.Pod) (*evictionExecutor, []string, error) {
+		fakeClient := &fake.Clientset{}
+		fakeClient.Fake.AddReactor("delete", "pods", func(action core.Action) (bool, runtime.Object, error) {
+			name := action.(core.DeleteAction).GetName()
+			if errs := failures[name]; len(errs) > 0 {
+				failures[name] = errs[1:]
+				return true, nil, errs[0]
+			}
+			return true, nil, nil
+		})
+		executor := newEvictionExecutor(fakeClient, kube_record.NewFakeRecorder(100), predicateChecker, snapshot, criticalPod, "")
+		deleted, err := executor.Run(context.Background(), victims)
+		names := []string{}
+		for _, p := range deleted {
+			names = append(names, p.Name)
+		}
+		return executor, names, err
+	}
+	vetoed := func(name string) []error {
+		return []error{errors.NewForbidden(v1.Resource("pods"), name, fmt.Errorf("denied by webhook"))}
+	}
+
+	// Transient errors are retried, victims deleted meanwhile are gone.
+	executor, deleted, err := run(map[string][]error{
+		"v1": {errors.NewTooManyRequests("slow down", 1), errors.NewServerTimeout(v1.Resource("pods"), "delete", 1)},
+		"v2": {errors.NewNotFound(v1.Resource("pods"), "v2")},
+	}, v1Pod, v2Pod)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"v1"}, deleted)
+	assert.Equal(t, victimEvicted, executor.Status(v1Pod))
+	assert.Equal(t, victimGone, executor.Status(v2Pod))
+
+	// The critical pod fits even if v3 stays.
+	executor, deleted, err = run(map[string][]error{"v3": vetoed("v3")}, v3Pod, v1Pod, v2Pod)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"v1", "v2"}, deleted)
+	assert.Equal(t, victimFailed, executor.Status(v3Pod))
+
+	// Nothing was deleted yet when v1 fails, so the placement is abandoned.
+	executor, deleted, err = run(map[string][]error{"v1": vetoed("v1")}, v1Pod, v2Pod)
+	assert.Equal(t, "eviction_vetoed", placementErrorKind(context.Background(), err))
+	assert.Empty(t, deleted)
+	assert.Equal(t, victimSkipped, executor.Status(v2Pod))
+
+	// v1 was deleted in vain when v2 fails, so the placement is rolled back.
+	flags.Set("eviction-retries", "1")
+	defer flags.Set("eviction-retries", "3")
+	executor, deleted, err = run(map[string][]error{"v2": {errors.NewTooManyRequests("slow down", 1), errors.NewTooManyRequests("slow down", 1)}}, v1Pod, v2Pod)
+	assert.Equal(t, "eviction_vetoed", placementErrorKind(context.Background(), err))
+	assert.Equal(t, []string{"v1"}, deleted)
+	assert.Equal(t, victimFailed, executor.Status(v2Pod))
+
+	// Cancelling the placement interrupts the backoff between retries.
+	evictionRetryBackoff = wait.Backoff{Duration: time.Hour, Factor: 1}
+	ctx, cancel := context.WithCancel(context.Background())
+	fakeClient := &fake.Clientset{}
+	fakeClient.Fake.AddReactor("delete", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		cancel()
+		return true, nil, errors.NewTooManyRequests("slow down", 1)
+	})
+	executor = newEvictionExecutor(fakeClient, kube_record.NewFakeRecorder(100), predicateChecker, snapshot, criticalPod, "")
+	_, err = executor.Run(ctx, []*v1.Pod{v1Pod, v2Pod})
+	assert.Error(t, err)
+	assert.Equal(t, "cancelled", placementErrorKind(ctx, err))
+	assert.Equal(t, victimSkipped, executor.Status(v1Pod))
+	assert.Equal(t, victimSkipped, executor.Status(v2Pod))
+}
+
+func TestShadowPlacement(t *testing.T) {
+	config := configFromFlags()
+	config.ShadowMode = true
+	activeConfig.Set(config)
+	defer activeConfig.Set(configFromFlags())
+	defer resetFailedPlacements()
+
+	client := newIntegrationCluster(500).Clientset()
+	client.ClearActions()
+	recorder := kube_record.NewFakeRecorder(100)
+	r := newTestRescheduler(client, recorder)
+	r.housekeeping(context.Background())
+
+	events := drainEvents(recorder)
+	assert.Equal(t, 1, strings.Count(events, EventReasonWouldTaint), events)
+	assert.Equal(t, 2, strings.Count(events, EventReasonWouldDelete), events)
+	assert.NotContains(t, events, EventReasonReservedNode)
+	assert.NotContains(t, events, EventReasonEvictedForCriticalPod)
+	for _, action := range client.Actions() {
+		switch action.GetVerb() {
+		case "create", "update", "patch", "delete", "deletecollection":
+			t.Errorf("unexpected action in shadow mode: %v %v", action.GetVerb(), action.GetResource())
+		}
+	}
+	assert.False(t, r.podsBeingProcessed.HasId("kube-system_critical"))
 }
 
 func createTestPod(name, namespace string, isCritical bool, isDaemonSet bool, cpu int64) *v1.Pod {
-	priority := SystemCriticalPriority + 1
+	priority := engine.SystemCriticalPriority + 1
 	pod := &v1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace: namespace,
 			Name:      name,
+			UID:       types.UID(namespace + "-" + name),
 			SelfLink:  fmt.Sprintf("/api/v1/namespaces/default/pods/%s", name),
 		},
 		Spec: v1.PodSpec{
@@ -240,7 +497,7 @@ func createTestPod(name, namespace string, isCritical bool, isDaemonSet bool, cp
 		},
 	}
 	if isCritical {
-		pod.ObjectMeta.Annotations = map[string]string{criticalPodAnnotation: ""}
+		pod.ObjectMeta.Annotations = map[string]string{engine.CriticalPodAnnotation: ""}
 		pod.Spec.Priority = &priority
 	}
 	if isDaemonSet {
@@ -279,12 +536,11 @@ func createTestNode(name string, cpu int64) *v1.Node {
 	return node
 }
 
-func addTaintToNode(node *v1.Node, name string) {
-	node.Spec.Taints = append(node.Spec.Taints, v1.Taint{
-		Key:    criticalAddonsOnlyTaintKey,
-		Value:  name,
-		Effect: v1.TaintEffectNoSchedule,
-	})
+// addTaintToNode reserves <node> for the critical pod <name> in kube-system.
+func addTaintToNode(node *v1.Node, name string) v1.Taint {
+	taint := reservationTaint(createTestPod(name, "kube-system", true, true, 100), time.Now())
+	node.Spec.Taints = append(node.Spec.Taints, taint)
+	return taint
 }
 
 func addTaintAnnotationToNode(node *v1.Node, name string) {
@@ -301,3 +557,695 @@ func getStringFromChan(c chan string) string {
 		return "Nothing returned"
 	}
 }
+
+func TestReleaseTaintsOnNodesWithOwner(t *testing.T) {
+	defer flags.Set("taint-owner", "")
+	updatedNodes := make(chan *v1.Node, 10)
+	fakeClient := &fake.Clientset{}
+	fakeClient.Fake.AddReactor("update", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		obj := action.(core.UpdateAction).GetObject().(*v1.Node)
+		updatedNodes <- obj
+		return true, obj, nil
+	})
+
+	claimed := createTestNode("node1", 1000)
+	addTaintToNode(claimed, "heapster")
+	addTaintToNode(claimed, "dns")
+	kubeProxy := addTaintToNode(claimed, "kube-proxy")
+	flags.Set("taint-owner", "pool-a")
+	claimTaint(claimed, claimed.Spec.Taints[0])
+	claimTaint(claimed, claimed.Spec.Taints[1])
+	flags.Set("taint-owner", "pool-b")
+	claimTaint(claimed, claimed.Spec.Taints[2])
+
+	// An instance without an owner doesn't touch owned taints.
+	flags.Set("taint-owner", "")
+	releaseTaintsOnNodes(context.Background(), fakeClient, kube_record.NewFakeRecorder(10), []*v1.Node{claimed}, NewPodSet())
+	assert.Equal(t, 0, len(updatedNodes))
+
+	flags.Set("taint-owner", "pool-a")
+	releaseTaintsOnNodes(context.Background(), fakeClient, kube_record.NewFakeRecorder(10), []*v1.Node{claimed}, NewPodSet())
+	updated := <-updatedNodes
+	assert.Equal(t, 1, len(updated.Spec.Taints))
+	assert.Equal(t, kubeProxy.Value, updated.Spec.Taints[0].Value)
+	assert.Equal(t, map[string]string{kubeProxy.Value: "pool-b"}, taintOwners(updated))
+}
+
+func TestReservationLedger(t *testing.T) {
+	node := createTestNode("node1", 1000)
+	client := fake.NewSimpleClientset(node)
+	pod := createTestPod("heapster", "kube-system", true, true, 100)
+	now := time.Now().Truncate(time.Second)
+	taint := reservationTaint(pod, now)
+	taint.TimeAdded = &metav1.Time{Time: now}
+	assert.NoError(t, addTaint(client, node.DeepCopy(), taint, pod))
+
+	reserved, err := client.CoreV1().Nodes().Get("node1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	entry, found := reservationLedger(reserved)[taint.Value]
+	assert.True(t, found)
+	assert.Equal(t, "kube-system_heapster", entry.Pod)
+	assert.Equal(t, currentConfig().PodScheduledTimeout.Duration, entry.Expires.Sub(entry.Reserved.Time))
+
+	releaseTaintsOnNodes(context.Background(), client, kube_record.NewFakeRecorder(10), []*v1.Node{reserved}, NewPodSet())
+	released, err := client.CoreV1().Nodes().Get("node1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, released.Spec.Taints)
+	assert.NotContains(t, released.Annotations, ReservationsAnnotationKey)
+
+	// Entries whose taint was removed by someone else are pruned.
+	stale := released.DeepCopy()
+	recordReservation(stale, taint, pod)
+	stale, err = client.CoreV1().Nodes().Update(stale)
+	assert.NoError(t, err)
+	releaseTaintsOnNodes(context.Background(), client, kube_record.NewFakeRecorder(10), []*v1.Node{stale}, NewPodSet())
+	pruned, err := client.CoreV1().Nodes().Get("node1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.NotContains(t, pruned.Annotations, ReservationsAnnotationKey)
+}
+
+func TestReleaseFailedEscalates(t *testing.T) {
+	flags.Set("taint-release-retries", "1")
+	defer flags.Set("taint-release-retries", "3")
+	defer releaseFailures.Forget("node1")
+
+	node := createTestNode("node1", 1000)
+	taint := addTaintToNode(node, "heapster")
+	other := v1.Taint{Key: "dedicated", Value: "monitoring", Effect: v1.TaintEffectNoSchedule}
+	node.Spec.Taints = append(node.Spec.Taints, other)
+	tracker := core.NewObjectTracker(scheme.Scheme, scheme.Codecs.UniversalDecoder())
+	assert.NoError(t, tracker.Add(node))
+	client := &fake.Clientset{}
+	client.AddReactor("*", "*", core.ObjectReaction(tracker))
+	client.PrependReactor("update", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.NewForbidden(v1.Resource("nodes"), "node1", fmt.Errorf("denied by webhook"))
+	})
+	patchFails := true
+	client.PrependReactor("patch", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		if patchFails {
+			return true, nil, fmt.Errorf("injected patch error")
+		}
+		gvr := v1.SchemeGroupVersion.WithResource("nodes")
+		current, err := tracker.Get(gvr, "", "node1")
+		if err != nil {
+			return true, nil, err
+		}
+		data, err := json.Marshal(current)
+		if err != nil {
+			return true, nil, err
+		}
+		patch, err := jsonpatch.DecodePatch(action.(core.PatchAction).GetPatch())
+		if err != nil {
+			return true, nil, err
+		}
+		if data, err = patch.Apply(data); err != nil {
+			return true, nil, err
+		}
+		patched := &v1.Node{}
+		if err := json.Unmarshal(data, patched); err != nil {
+			return true, nil, err
+		}
+		return true, patched, tracker.Update(gvr, patched, "")
+	})
+	recorder := kube_record.NewFakeRecorder(10)
+	release := func() {
+		current, err := client.CoreV1().Nodes().Get("node1", metav1.GetOptions{})
+		assert.NoError(t, err)
+		releaseTaintsOnNodes(context.Background(), client, recorder, []*v1.Node{current}, NewPodSet())
+	}
+
+	// The first failure is retried in the next pass.
+	release()
+	assert.Empty(t, drainEvents(recorder))
+	assert.Equal(t, 0, releaseFailures.Len())
+
+	// The second one is escalated, and as the patch fails too the taint is orphaned.
+	release()
+	assert.Contains(t, drainEvents(recorder), EventReasonTaintReleaseFailed)
+	assert.Equal(t, map[string][]v1.Taint{"node1": {taint}}, releaseFailures.Orphans())
+
+	// Later failures leave the orphan to collectOrphanedTaints.
+	release()
+	assert.Empty(t, drainEvents(recorder))
+	assert.Equal(t, 1, releaseFailures.Len())
+
+	patchFails = false
+	collectOrphanedTaints(context.Background(), client)
+	assert.Equal(t, 0, releaseFailures.Len())
+	released, err := client.CoreV1().Nodes().Get("node1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []v1.Taint{other}, released.Spec.Taints)
+
+	// Nothing is left to release on nodes which are gone.
+	releaseFailed(client, recorder, node, []v1.Taint{taint}, errors.NewNotFound(v1.Resource("nodes"), "node1"))
+	assert.Equal(t, 0, releaseFailures.Len())
+	assert.Empty(t, drainEvents(recorder))
+}
+
+func TestShardNodeLister(t *testing.T) {
+	poolA := createTestNode("node-a", 1000)
+	poolA.Labels = map[string]string{"pool": "a"}
+	notReady := createTestNode("node-a-not-ready", 1000)
+	notReady.Labels = map[string]string{"pool": "a"}
+	notReady.Status.Conditions[0].Status = v1.ConditionFalse
+	poolB := createTestNode("node-b", 1000)
+	poolB.Labels = map[string]string{"pool": "b"}
+	client := fake.NewSimpleClientset(poolA, notReady, poolB)
+
+	options := metav1.ListOptions{}
+	nodeShardListOptions(&options)
+	assert.Equal(t, "", options.LabelSelector)
+
+	assert.NoError(t, flags.Set("node-shard-selector", "pool=a"))
+	defer flags.Set("node-shard-selector", "")
+	stop := make(chan struct{})
+	defer close(stop)
+	trigger := make(chan struct{}, 1)
+	lister := newReadyNodeLister(client, nodeReadinessHandler(trigger), stop)
+	var nodes []*v1.Node
+	assert.NoError(t, wait.PollImmediate(10*time.Millisecond, time.Second, func() (bool, error) {
+		var err error
+		nodes, err = lister.List()
+		return len(nodes) > 0, err
+	}))
+	assert.Equal(t, []*v1.Node{poolA}, nodes)
+	// the readiness trigger shares the informer of the lister
+	select {
+	case <-trigger:
+	case <-time.After(time.Second):
+		t.Errorf("no readiness notification for node %s", poolA.Name)
+	}
+
+	nodes, err := (&apiReadyNodeLister{client: client}).List()
+	assert.NoError(t, err)
+	assert.Equal(t, []*v1.Node{poolA}, nodes)
+}
+
+func TestUsesRWOVolume(t *testing.T) {
+	claim := func(name string, mode v1.PersistentVolumeAccessMode) *v1.PersistentVolumeClaim {
+		return &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       v1.PersistentVolumeClaimSpec{AccessModes: []v1.PersistentVolumeAccessMode{mode}},
+		}
+	}
+	client := fake.NewSimpleClientset(claim("rwo", v1.ReadWriteOnce), claim("rox", v1.ReadOnlyMany))
+	withClaim := func(claimName string) *v1.Pod {
+		pod := createTestPod("pod-"+claimName, "default", false, false, 100)
+		pod.Spec.Volumes = []v1.Volume{{Name: "data", VolumeSource: v1.VolumeSource{
+			PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: claimName},
+		}}}
+		return pod
+	}
+
+	assert.False(t, usesRWOVolume(client, createTestPod("stateless", "default", false, false, 100)))
+	assert.True(t, usesRWOVolume(client, withClaim("rwo")))
+	assert.False(t, usesRWOVolume(client, withClaim("rox")))
+	assert.True(t, usesRWOVolume(client, withClaim("missing")))
+
+	assert.NotNil(t, victimClassifier(client, nil))
+	flags.Set("protect-system-namespace-victims", "false")
+	defer flags.Set("protect-system-namespace-victims", "true")
+	assert.Nil(t, victimClassifier(client, nil))
+	assert.NoError(t, flags.Set("rwo-volume-victims", "protect"))
+	defer flags.Set("rwo-volume-victims", "allow")
+	assert.Equal(t, engine.VictimProtected, victimClassifier(client, nil)(withClaim("rwo")))
+}
+
+func TestFindNodeForPodSkipsReservedNodes(t *testing.T) {
+	predicateChecker := simulator.NewTestPredicateChecker()
+	reserved := createTestNode("reserved", 1000)
+	taint := reservationTaint(createTestPod("other", "kube-system", true, true, 100), time.Now())
+	stale := metav1.NewTime(time.Now().Add(-time.Hour))
+	taint.TimeAdded = &stale
+	reserved.Spec.Taints = []v1.Taint{taint}
+	free := createTestNode("free", 1000)
+	client := fake.NewSimpleClientset()
+	pod := createTestPod("critical", "kube-system", true, true, 100)
+
+	node := findNodeForPod(context.Background(), client, predicateChecker, []*v1.Node{reserved, free}, pod)
+	assert.Equal(t, "free", node.Name)
+
+	assert.NoError(t, flags.Set("reserved-nodes", "skip-fresh"))
+	defer flags.Set("reserved-nodes", "skip")
+	node = findNodeForPod(context.Background(), client, predicateChecker, []*v1.Node{reserved, free}, pod)
+	assert.Equal(t, "reserved", node.Name)
+}
+
+func TestRestoreReservations(t *testing.T) {
+	stripped := createTestNode("stripped", 1000)
+	intact := createTestNode("intact", 1000)
+	dns := addTaintToNode(intact, "dns")
+	client := fake.NewSimpleClientset(stripped, intact)
+	recorder := kube_record.NewFakeRecorder(10)
+
+	heapster := createTestPod("heapster", "kube-system", true, true, 200)
+	podsBeingProcessed := NewPodSet()
+	podsBeingProcessed.AddReservation(reservation{pod: heapster, node: "stripped", taint: reservationTaint(heapster, time.Now()), decisionID: "decision-1"})
+	podsBeingProcessed.AddReservation(reservation{pod: createTestPod("dns", "kube-system", true, true, 200), node: "intact", taint: dns, decisionID: "decision-2"})
+	podsBeingProcessed.Add(createTestPod("unknown", "kube-system", true, true, 200))
+	restored := metricValue(t, metrics.RestoredReservationsCount)
+
+	restoreReservations(client, recorder, podsBeingProcessed)
+	restoreReservations(client, recorder, podsBeingProcessed)
+
+	node, err := client.CoreV1().Nodes().Get("stripped", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(node.Spec.Taints))
+	assert.Equal(t, heapster.UID, reservedPodUID(node.Spec.Taints[0]))
+	node, err = client.CoreV1().Nodes().Get("intact", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(node.Spec.Taints))
+	assert.Equal(t, restored+1, metricValue(t, metrics.RestoredReservationsCount))
+	assert.Contains(t, drainEvents(recorder), EventReasonReservationRestored)
+}
+
+func TestStalePlacementReason(t *testing.T) {
+	pod := createTestPod("p", "kube-system", true, true, 100)
+	pod.UID = "1"
+	pod.Labels = map[string]string{controllerRevisionHashLabel: "a"}
+
+	assert.Equal(t, "", stalePlacementReason(pod, pod))
+	assert.Equal(t, "the pod was deleted", stalePlacementReason(pod, nil))
+
+	recreated := pod.DeepCopy()
+	recreated.UID = "2"
+	assert.Equal(t, "the pod was recreated", stalePlacementReason(pod, recreated))
+
+	updated := pod.DeepCopy()
+	updated.Labels[controllerRevisionHashLabel] = "b"
+	assert.Equal(t, `its controller-revision-hash changed from "a" to "b"`, stalePlacementReason(pod, updated))
+}
+
+func TestNodeReadinessHandler(t *testing.T) {
+	trigger := make(chan struct{}, 1)
+	handler := nodeReadinessHandler(trigger)
+	ready := createTestNode("node", 1000)
+	notReady := createTestNode("node", 1000)
+	notReady.Status.Conditions[0].Status = v1.ConditionFalse
+	triggered := func() bool {
+		select {
+		case <-trigger:
+			return true
+		default:
+			return false
+		}
+	}
+
+	handler.OnAdd(notReady)
+	assert.False(t, triggered())
+	handler.OnUpdate(ready, ready)
+	assert.False(t, triggered())
+
+	handler.OnAdd(ready)
+	assert.True(t, triggered())
+	handler.OnUpdate(notReady, ready)
+	// a second transition doesn't block while the first is pending
+	handler.OnUpdate(notReady, ready)
+	assert.True(t, triggered())
+	assert.False(t, triggered())
+}
+
+func TestDisruptionHistory(t *testing.T) {
+	flags.Set("disruption-history-configmap", "rescheduler-disruptions")
+	defer flags.Set("disruption-history-configmap", "")
+	defer func() { disruptions.evictions = map[string][]time.Time{} }()
+
+	isController := true
+	victim := createTestPod("web-1", "default", false, false, 100)
+	victim.OwnerReferences = []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "web", Controller: &isController}}
+	sibling := victim.DeepCopy()
+	sibling.Name = "web-2"
+	other := createTestPod("db-1", "default", false, false, 100)
+	now := time.Now()
+
+	client := fake.NewSimpleClientset()
+	disruptions.Record([]*v1.Pod{victim, other}, now.Add(-time.Minute))
+	disruptions.Save(client)
+	disruptions.evictions = map[string][]time.Time{}
+	flags.Set("protect-system-namespace-victims", "false")
+	defer flags.Set("protect-system-namespace-victims", "true")
+	assert.Nil(t, victimClassifier(client, nil))
+
+	assert.NoError(t, disruptions.Load(client))
+	assert.True(t, disruptions.RecentlyDisrupted(sibling, now))
+	assert.False(t, disruptions.RecentlyDisrupted(other, now))
+	assert.False(t, disruptions.RecentlyDisrupted(sibling, now.Add(time.Hour)))
+	classify := victimClassifier(client, nil)
+	assert.Equal(t, engine.VictimAvoided, classify(sibling))
+	assert.Equal(t, engine.VictimAllowed, classify(other))
+}
+
+func TestInjectedFaults(t *testing.T) {
+	defer faults.Configure(nil, 0)
+
+	client, err := withInjectedFaults(fake.NewSimpleClientset(createTestNode("node1", 1000)), "")
+	assert.NoError(t, err)
+	assert.IsType(t, &fake.Clientset{}, client)
+	_, err = withInjectedFaults(client, "taint-conflict")
+	assert.Error(t, err)
+
+	node := createTestNode("node1", 1000)
+	pod := createTestPod("p1", "default", false, false, 100)
+	client, err = withInjectedFaults(fake.NewSimpleClientset(node, pod), "taint-conflict=1,delete-forbidden=1")
+	assert.NoError(t, err)
+	_, err = client.CoreV1().Nodes().Update(node)
+	assert.True(t, errors.IsConflict(err), "%v", err)
+	err = client.CoreV1().Pods("default").Delete("p1", &metav1.DeleteOptions{})
+	assert.True(t, errors.IsForbidden(err), "%v", err)
+	assert.False(t, faults.Inject(faultNeverBind))
+
+	faults.Configure(map[string]float64{faultDeleteForbidden: 0}, 0)
+	assert.NoError(t, client.CoreV1().Pods("default").Delete("p1", &metav1.DeleteOptions{}))
+}
+
+func TestCoverageReport(t *testing.T) {
+	flags.Set("coverage-configmap", "rescheduler-coverage")
+	defer flags.Set("coverage-configmap", "")
+	defer unplaceablePods.Set(nil)
+
+	isController := true
+	labels := map[string]string{"k8s-app": "agent"}
+	critical := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "kube-system", UID: "agent-uid"},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: v1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: labels, Annotations: map[string]string{engine.CriticalPodAnnotation: ""}}},
+		},
+		Status: appsv1.DaemonSetStatus{DesiredNumberScheduled: 6},
+	}
+	other := critical.DeepCopy()
+	other.Name, other.UID, other.Spec.Template.Annotations = "other", "other-uid", nil
+	objects := []runtime.Object{critical, other}
+	pods := map[string]*v1.Pod{}
+	for _, name := range []string{"running", "starting", "reserved", "unplaceable", "pending"} {
+		pod := createTestPod(name, "kube-system", true, true, 100)
+		pod.Labels = labels
+		pod.OwnerReferences = []metav1.OwnerReference{{Kind: "DaemonSet", Name: "agent", UID: "agent-uid", Controller: &isController}}
+		pods[name] = pod
+		objects = append(objects, pod)
+	}
+	pods["running"].Spec.NodeName, pods["running"].Status.Phase = "node1", v1.PodRunning
+	pods["starting"].Spec.NodeName, pods["starting"].Status.Phase = "node2", v1.PodPending
+
+	client := fake.NewSimpleClientset(objects...)
+	r := &rescheduler{client: client, podsBeingProcessed: NewPodSet(), clock: clock.NewFakeClock(time.Now())}
+	r.podsBeingProcessed.Add(pods["reserved"])
+	unplaceablePods.Set([]*engine.Unplaceable{{Pod: pods["unplaceable"]}})
+
+	report := r.coverageReport()
+	expected := []daemonSetCoverage{{
+		DaemonSet: "agent",
+		Desired:   6,
+		Running:   1,
+		Missing: map[string]int{
+			coverageStarting:           1,
+			coveragePendingReservation: 1,
+			coverageNoFeasibleNode:     1,
+			coverageUnschedulable:      1,
+			coverageNoPod:              1,
+		},
+	}}
+	assert.Equal(t, expected, report)
+	assert.Equal(t, 1.0, metricValue(t, metrics.DaemonSetMissingPods.WithLabelValues("agent", coverageNoFeasibleNode)))
+	configMap, err := client.CoreV1().ConfigMaps(ownNamespace()).Get("rescheduler-coverage", metav1.GetOptions{})
+	if assert.NoError(t, err) {
+		assert.Contains(t, configMap.Data[coverageReportKey], `"no_feasible_node": 1`)
+	}
+}
+
+func TestProbePermissions(t *testing.T) {
+	client := &fake.Clientset{}
+	client.AddReactor("create", "selfsubjectaccessreviews", func(action core.Action) (bool, runtime.Object, error) {
+		review := action.(core.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		attributes := review.Spec.ResourceAttributes
+		review.Status.Allowed = !(attributes.Verb == "delete" && attributes.Resource == "pods") &&
+			!(attributes.Resource == "pods" && attributes.Subresource == "status")
+		return true, review, nil
+	})
+	missing := probePermissions(client, requiredPermissions())
+	features := []string{}
+	for _, permission := range missing {
+		features = append(features, permission.feature)
+	}
+	assert.Equal(t, []string{"evicting pods", "the " + string(ReschedulerReservingCondition) + " pod condition"}, features)
+
+	defer func() { missingEssentialPermissions = false }()
+	assert.False(t, inShadowMode())
+	degradeToPermissions(client)
+	assert.True(t, inShadowMode())
+}
+
+func TestPrintRBAC(t *testing.T) {
+	permissions := []apiPermission{
+		{verbs: []string{"get", "list"}, resource: "pods"},
+		{verbs: []string{"delete", "get"}, resource: "pods"},
+		{verbs: []string{"get"}, group: "apps", resource: "daemonsets", namespace: "kube-system"},
+	}
+	out := &bytes.Buffer{}
+	assert.NoError(t, printRBAC(out, "rescheduler", permissions))
+	expected := `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  creationTimestamp: null
+  name: rescheduler
+rules:
+- apiGroups:
+  - ""
+  resources:
+  - pods
+  verbs:
+  - delete
+  - get
+  - list
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  creationTimestamp: null
+  name: rescheduler
+  namespace: kube-system
+rules:
+- apiGroups:
+  - apps
+  resources:
+  - daemonsets
+  verbs:
+  - get
+`
+	assert.Equal(t, expected, out.String())
+	assert.NoError(t, printRBAC(&bytes.Buffer{}, "rescheduler", requiredPermissions()))
+}
+
+func TestRequiredPermissionsOfAdminAndImpersonation(t *testing.T) {
+	defer func(admin, user string, groups []string) {
+		*adminListenAddress, *impersonateUser, *impersonateGroups = admin, user, groups
+	}(*adminListenAddress, *impersonateUser, *impersonateGroups)
+	*adminListenAddress = "127.0.0.1:9236"
+	*impersonateUser = "system:serviceaccount:kube-system:rescheduler"
+	*impersonateGroups = []string{"system:masters"}
+
+	permissions := map[string]string{}
+	for _, permission := range requiredPermissions() {
+		permissions[permission.resource+"."+permission.group] = strings.Join(permission.verbs, ",") + " in " + permission.namespace
+	}
+	assert.Equal(t, "create in ", permissions["tokenreviews.authentication.k8s.io"])
+	assert.Equal(t, "create in ", permissions["subjectaccessreviews.authorization.k8s.io"])
+	assert.Equal(t, "impersonate in kube-system", permissions["serviceaccounts."])
+	assert.Equal(t, "impersonate in ", permissions["groups."])
+
+	*impersonateUser = "rescheduler"
+	found := false
+	for _, permission := range requiredPermissions() {
+		found = found || (permission.resource == "users" && permission.verbs[0] == "impersonate")
+	}
+	assert.True(t, found)
+}
+
+func TestProtectSystemNamespaceVictims(t *testing.T) {
+	classify := victimClassifier(fake.NewSimpleClientset(), nil)
+	addon := createTestPod("addon", "kube-system", false, false, 100)
+	assert.Equal(t, engine.VictimProtected, classify(addon))
+	assert.Equal(t, engine.VictimAllowed, classify(createTestPod("web", "default", false, false, 100)))
+	addon.Annotations = map[string]string{EvictableAnnotationKey: "true"}
+	assert.Equal(t, engine.VictimAllowed, classify(addon))
+}
+
+func TestMergeDisruptionNotices(t *testing.T) {
+	now := time.Now()
+	notice := func(pod string, age time.Duration) disruptionNotice {
+		return disruptionNotice{Pod: pod, Time: metav1.NewTime(now.Add(-age))}
+	}
+	existing, err := json.Marshal([]disruptionNotice{notice("b", time.Minute), notice("old", 2*time.Hour)})
+	assert.NoError(t, err)
+	merged := mergeDisruptionNotices(string(existing), []disruptionNotice{notice("a", 0)}, now)
+	pods := []string{}
+	for _, n := range merged {
+		pods = append(pods, n.Pod)
+	}
+	assert.Equal(t, []string{"a", "b"}, pods)
+
+	many := []disruptionNotice{}
+	for i := 0; i < 2*maxDisruptionNotices; i++ {
+		many = append(many, notice(fmt.Sprintf("p%d", i), time.Duration(i)*time.Second))
+	}
+	merged = mergeDisruptionNotices("not json", many, now)
+	assert.Len(t, merged, maxDisruptionNotices)
+	assert.Equal(t, "p0", merged[0].Pod)
+}
+
+func TestFitFailureCache(t *testing.T) {
+	predicateChecker := simulator.NewTestPredicateChecker()
+	node := createTestNode("fit-failures", 1000)
+	required := createTestPod("required", "kube-system", true, true, 800)
+	required.Spec.NodeName = node.Name
+	required.ResourceVersion = "1"
+	client := fake.NewSimpleClientset(node, required)
+	pod := createTestPod("critical", "kube-system", true, true, 500)
+	hits := metricValue(t, metrics.FitFailureLookupsCount.WithLabelValues("hit"))
+
+	assert.Error(t, checkNodeForPod(client, predicateChecker, node, pod))
+	assert.Error(t, checkNodeForPod(client, predicateChecker, node, pod))
+	assert.Equal(t, hits+1, metricValue(t, metrics.FitFailureLookupsCount.WithLabelValues("hit")))
+
+	// the pod which can't be deleted shrank, so the node is checked again
+	required.Spec.Containers[0].Resources.Requests[v1.ResourceCPU] = *resource.NewMilliQuantity(200, resource.DecimalSI)
+	required.ResourceVersion = "2"
+	_, err := client.CoreV1().Pods(required.Namespace).Update(required)
+	assert.NoError(t, err)
+	assert.NoError(t, checkNodeForPod(client, predicateChecker, node, pod))
+	assert.Equal(t, hits+1, metricValue(t, metrics.FitFailureLookupsCount.WithLabelValues("hit")))
+}
+
+func TestEvictionBudget(t *testing.T) {
+	budget := &evictionBudget{}
+	now := time.Now()
+	assert.Equal(t, -1, budget.Remaining(now))
+
+	config := configFromFlags()
+	config.EvictionBudget = 3
+	activeConfig.Set(config)
+	defer activeConfig.Set(configFromFlags())
+	budget.Spend(2, now)
+	assert.Equal(t, 1, budget.Remaining(now))
+	budget.Spend(2, now.Add(30*time.Minute))
+	assert.Equal(t, 0, budget.Remaining(now.Add(30*time.Minute)))
+	assert.Equal(t, 1, budget.Remaining(now.Add(time.Hour)))
+
+	// a reloaded budget applies to the evictions already in the window
+	config.EvictionBudget = 5
+	config.EvictionBudgetWindow = metav1.Duration{Duration: 2 * time.Hour}
+	activeConfig.Set(config)
+	assert.Equal(t, 1, budget.Remaining(now.Add(time.Hour)))
+
+	// The budget goes to the more urgent pod, and the less urgent one waits
+	// even though its victims would fit.
+	high, low := int32(2000), int32(1000)
+	urgent := createTestPod("urgent", metav1.NamespaceSystem, true, false, 100)
+	urgent.Spec.Priority = &high
+	other := createTestPod("other", metav1.NamespaceSystem, true, false, 100)
+	other.Spec.Priority = &low
+	victims := []*v1.Pod{createTestPod("a", "default", false, false, 100), createTestPod("b", "default", false, false, 100)}
+	placements := placementsByUrgency([]*engine.Placement{
+		{Pod: other, Victims: victims[:1]},
+		{Pod: urgent, Victims: victims},
+		{Pod: other},
+	})
+	assert.Equal(t, urgent, placements[0].Pod)
+	exhausted := false
+	assert.False(t, withinEvictionBudget(placements[0], 1, &exhausted))
+	assert.False(t, withinEvictionBudget(placements[1], 1, &exhausted))
+	assert.True(t, withinEvictionBudget(placements[2], 1, &exhausted))
+	exhausted = false
+	assert.True(t, withinEvictionBudget(placements[0], -1, &exhausted))
+}
+
+func TestStaticCriticalPods(t *testing.T) {
+	static := createTestPod("kube-proxy-node-0", "kube-system", true, true, 0)
+	static.Annotations["kubernetes.io/config.mirror"] = "hash"
+	static.Spec.NodeName = "node-0"
+	assert.Empty(t, filterCriticalDaemonSetPods([]*v1.Pod{static}, NewPodSet()))
+
+	static.Status.Phase = v1.PodPending
+	running := createTestPod("kube-proxy-node-1", "kube-system", true, true, 0)
+	running.Annotations["kubernetes.io/config.mirror"] = "hash"
+	running.Spec.NodeName = "node-1"
+	running.Status.Phase = v1.PodRunning
+
+	recorder := kube_record.NewFakeRecorder(100)
+	client := fake.NewSimpleClientset(static, running)
+	r := newTestRescheduler(client, recorder)
+	// the gauge counts the pending static pods, not the passes which saw them
+	r.housekeeping(context.Background())
+	r.housekeeping(context.Background())
+	assert.Equal(t, 1.0, metricValue(t, metrics.StaticCriticalPodsCount.WithLabelValues("unknown")))
+	assert.Contains(t, drainEvents(recorder), EventReasonStaticPodNotPlaced)
+
+	static.Status.Phase = v1.PodRunning
+	client.CoreV1().Pods("kube-system").Update(static)
+	r.housekeeping(context.Background())
+	assert.Equal(t, 0.0, metricValue(t, metrics.StaticCriticalPodsCount.WithLabelValues("unknown")))
+}
+
+func TestOwnerResolver(t *testing.T) {
+	isController := true
+	controllerRef := func(kind, name string) []metav1.OwnerReference {
+		return []metav1.OwnerReference{{Kind: kind, Name: name, UID: types.UID(name), Controller: &isController}}
+	}
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "kube-dns", Namespace: "kube-system", UID: "kube-dns"}}
+	replicaSet := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "kube-dns-1", Namespace: "kube-system", UID: "kube-dns-1",
+		OwnerReferences: controllerRef("Deployment", "kube-dns")}}
+	daemonSet := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "cni", Namespace: "kube-system", UID: "cni",
+		OwnerReferences: controllerRef("NetworkPlugin", "cilium")}}
+	revision := &appsv1.ControllerRevision{ObjectMeta: metav1.ObjectMeta{Name: "cni-1", Namespace: "kube-system", UID: "cni-1",
+		OwnerReferences: controllerRef("DaemonSet", "cni")}}
+	client := fake.NewSimpleClientset(deployment, replicaSet, daemonSet, revision)
+	resolver := newOwnerResolver(client)
+
+	dns := createTestPod("kube-dns-1-abc", "kube-system", true, false, 100)
+	dns.OwnerReferences = controllerRef("ReplicaSet", "kube-dns-1")
+	assert.Equal(t, []ownerKey{{"ReplicaSet", "kube-dns-1"}, {"Deployment", "kube-dns"}}, resolver.Chain(dns))
+	assert.Equal(t, "Deployment/kube-dns", resolver.TopOwner(dns).String())
+	assert.False(t, resolver.IsDaemonSetPod(dns))
+	assert.Equal(t, ownerKey{"ReplicaSet", "kube-dns-1"}, newOwnerResolver(nil).TopOwner(dns))
+
+	// a DaemonSet reached through an intermediate owner, and owned by a custom resource
+	cni := createTestPod("cni-abc", "kube-system", true, false, 100)
+	cni.OwnerReferences = controllerRef("ControllerRevision", "cni-1")
+	assert.True(t, resolver.IsDaemonSetPod(cni))
+	assert.Equal(t, "NetworkPlugin/cilium", resolver.TopOwner(cni).String())
+	assert.False(t, newOwnerResolver(nil).IsDaemonSetPod(cni))
+
+	// links are cached
+	gets := len(client.Actions())
+	resolver.Chain(dns)
+	resolver.Chain(cni)
+	assert.Equal(t, gets, len(client.Actions()))
+	assert.Equal(t, 4, resolver.Len())
+
+	assert.Equal(t, ownerKey{}, resolver.TopOwner(createTestPod("bare", "default", false, false, 100)))
+}
+
+func TestDaemonSetVictimRules(t *testing.T) {
+	critical := createTestPod("critical", "kube-system", true, true, 100)
+	assert.Nil(t, daemonSetVictimRules(nil, critical))
+
+	flags.Set("daemonset-victim-priority-gap", "1000")
+	defer flags.Set("daemonset-victim-priority-gap", "0")
+	isController := true
+	shipper := func(priority int32) *v1.Pod {
+		pod := createTestPod(fmt.Sprintf("shipper-%d", priority), "logging", false, false, 100)
+		pod.OwnerReferences = []metav1.OwnerReference{{Kind: "DaemonSet", Name: "shipper", Controller: &isController}}
+		pod.Spec.Priority = &priority
+		return pod
+	}
+	rules := daemonSetVictimRules(nil, critical)
+	assert.False(t, rules.IsRequired(shipper(*critical.Spec.Priority-1000)))
+	assert.True(t, rules.IsRequired(shipper(*critical.Spec.Priority-999)))
+	// critical DaemonSet pods are never deleted
+	assert.True(t, rules.IsRequired(createTestPod("cni", "kube-system", true, true, 100)))
+
+	configured := &engine.RequiredPodRules{Required: []engine.PodMatcher{{Namespaces: []string{"logging"}}}}
+	rules = daemonSetVictimRules(configured, critical)
+	assert.True(t, rules.IsRequired(shipper(0)))
+	assert.Nil(t, configured.DaemonSetPriorityBelow)
+}
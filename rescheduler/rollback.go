@@ -0,0 +1,220 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/sets"
+	kube_client "k8s.io/client-go/kubernetes"
+	kube_record "k8s.io/client-go/tools/record"
+	"k8s.io/contrib/rescheduler/metrics"
+)
+
+const (
+	// podTemplateGenerationLabel and controllerRevisionHashLabel identify the
+	// DaemonSet template a pod was created from.
+	podTemplateGenerationLabel  = "pod-template-generation"
+	controllerRevisionHashLabel = "controller-revision-hash"
+)
+
+// rollBackPlacement undoes a placement whose critical pod wasn't scheduled in
+// time: it releases the reservation right away rather than in the next
+// housekeeping pass, reports the pods which were deleted in vain, and
+// remembers the node so that the next attempts use other nodes. It returns
+// true if the pod should be placed again right away, false once it was given
+// up after --max-placement-attempts.
+func rollBackPlacement(ctx context.Context, client kube_client.Interface, recorder kube_record.EventRecorder, clock clock.Clock, podsBeingProcessed *podSet, r reservation) bool {
+	victims := []string{}
+	for _, victim := range r.victims {
+		victims = append(victims, podId(victim))
+	}
+	metrics.EvictedInVainCount.Add(float64(len(victims)))
+	attempts := failedPlacements.RecordTimeout(r.pod, r.node, clock.Now())
+	releaseReservation(ctx, client, recorder, podsBeingProcessed, r)
+
+	evicted := "no pods were deleted"
+	if len(victims) > 0 {
+		evicted = "deleted in vain: " + strings.Join(victims, ", ")
+	}
+	placementEventf(recorder, r.pod, r.pod, r.decisionID, v1.EventTypeWarning, EventReasonPlacementRolledBack,
+		"Released node %s reserved for critical pod %s; %s.", r.node, podId(r.pod), evicted)
+	if failedPlacements.GivenUp(r.pod) {
+		glog.Errorf("Giving up on critical pod %s after %d placements timed out (decision %s).", podId(r.pod), attempts, r.decisionID)
+		placementEventf(recorder, r.pod, r.pod, r.decisionID, v1.EventTypeWarning, EventReasonPlacementAbandoned,
+			"Gave up placing critical pod %s after its placements on %d nodes timed out.", podId(r.pod), attempts)
+		return false
+	}
+	return true
+}
+
+// releaseReservation removes the taint of <r> from its node, unless another
+// placement in <podsBeingProcessed> still holds it.
+func releaseReservation(ctx context.Context, client kube_client.Interface, recorder kube_record.EventRecorder, podsBeingProcessed *podSet, r reservation) {
+	node, err := client.CoreV1().Nodes().Get(r.node, metav1.GetOptions{})
+	if err != nil {
+		glog.Warningf("Failed to get node %v to release its reservation for pod %s: %v", r.node, podId(r.pod), err)
+		return
+	}
+	releaseTaintsOnNodes(ctx, client, recorder, []*v1.Node{node}, podsBeingProcessed)
+}
+
+// stalePlacementReason tells why a placement made for <pod> no longer fits
+// its <current> version, which is nil if the pod is gone. It returns "" if the
+// placement is still valid. DaemonSet pods can't change in place, so a new
+// template shows up as a replaced pod or a new template generation.
+func stalePlacementReason(pod, current *v1.Pod) string {
+	if current == nil {
+		return "the pod was deleted"
+	}
+	if current.UID != pod.UID {
+		return "the pod was recreated"
+	}
+	for _, label := range []string{podTemplateGenerationLabel, controllerRevisionHashLabel} {
+		if current.Labels[label] != pod.Labels[label] {
+			return fmt.Sprintf("its %s changed from %q to %q", label, pod.Labels[label], current.Labels[label])
+		}
+	}
+	return ""
+}
+
+// failedPlacements remembers the node and time of the last failed or timed
+// out placement of each critical pod. As a scorer it makes such nodes the
+// last resort. Nodes a placement timed out on are excluded for the pod
+// altogether, and after --max-placement-attempts timeouts the pod is given up.
+var failedPlacements = &failedPlacementSet{placements: map[string]failedPlacement{}}
+
+type failedPlacement struct {
+	uid  types.UID
+	node string
+	at   time.Time
+	// timedOut are the nodes placements of the pod timed out on.
+	timedOut sets.String
+}
+
+type failedPlacementSet struct {
+	placements map[string]failedPlacement
+	mutex      sync.Mutex
+}
+
+// get returns the failures of <pod>, ignoring those of an earlier pod with
+// the same name. The caller must hold the mutex.
+func (s *failedPlacementSet) get(pod *v1.Pod) (failedPlacement, bool) {
+	failed, found := s.placements[podId(pod)]
+	if !found || failed.uid != pod.UID {
+		return failedPlacement{uid: pod.UID, timedOut: sets.NewString()}, false
+	}
+	return failed, true
+}
+
+// Record remembers that placing <pod> on <node> failed at <at>.
+func (s *failedPlacementSet) Record(pod *v1.Pod, node string, at time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	failed, _ := s.get(pod)
+	failed.node, failed.at = node, at
+	s.placements[podId(pod)] = failed
+}
+
+// RecordTimeout remembers that <pod> wasn't scheduled on <node> in time at
+// <at> and returns the number of placements of the pod which timed out.
+func (s *failedPlacementSet) RecordTimeout(pod *v1.Pod, node string, at time.Time) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	failed, _ := s.get(pod)
+	failed.node, failed.at = node, at
+	failed.timedOut.Insert(node)
+	s.placements[podId(pod)] = failed
+	return failed.timedOut.Len()
+}
+
+// Excluded returns true if a placement of <pod> on <node> timed out before.
+func (s *failedPlacementSet) Excluded(pod *v1.Pod, node string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	failed, _ := s.get(pod)
+	return failed.timedOut.Has(node)
+}
+
+// GivenUp returns true if --max-placement-attempts placements of <pod> timed out.
+func (s *failedPlacementSet) GivenUp(pod *v1.Pod) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	failed, _ := s.get(pod)
+	return *maxPlacementAttempts > 0 && failed.timedOut.Len() >= *maxPlacementAttempts
+}
+
+// BackingOff returns true if the last placement of <pod> failed less than the
+// retryAfter of its priority class before <now>.
+func (s *failedPlacementSet) BackingOff(pod *v1.Pod, now time.Time) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	retryAfter := priorityClassPolicyOf(pod).RetryAfter.Duration
+	failed, found := s.get(pod)
+	return found && retryAfter > 0 && now.Before(failed.at.Add(retryAfter))
+}
+
+// Forget drops the failed placement of <pod>, once it has been scheduled.
+func (s *failedPlacementSet) Forget(pod *v1.Pod) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.placements, podId(pod))
+}
+
+// Len returns the number of failed placements remembered.
+func (s *failedPlacementSet) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.placements)
+}
+
+// Name implements engine.Scorer.
+func (s *failedPlacementSet) Name() string {
+	return "previous-failure"
+}
+
+// Score implements engine.Scorer.
+func (s *failedPlacementSet) Score(node *v1.Node, pod *v1.Pod) int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if failed, found := s.get(pod); found && failed.node == node.Name {
+		return -1
+	}
+	return 0
+}
+
+// untried drops the <nodes> placements of <pod> timed out on.
+func (s *failedPlacementSet) untried(nodes []*v1.Node, pod *v1.Pod) []*v1.Node {
+	untried := make([]*v1.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if s.Excluded(pod, node.Name) {
+			glog.V(4).Infof("Skipping node %v, a placement of critical pod %s timed out there", node.Name, podId(pod))
+			continue
+		}
+		untried = append(untried, node)
+	}
+	return untried
+}
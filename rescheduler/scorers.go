@@ -0,0 +1,75 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	"k8s.io/contrib/rescheduler/engine"
+)
+
+// availableScorers build the scorers which can be enabled with --node-scorers.
+var availableScorers = map[string]func() engine.Scorer{
+	"image-locality": func() engine.Scorer { return engine.ImageLocalityScorer{} },
+	"cost":           func() engine.Scorer { return engine.CostScorer{Label: *nodeCostLabel} },
+}
+
+// scorers orders candidate nodes in candidateNodes. It is set from
+// --node-scorers in main; tests leave it empty.
+var scorers []engine.Scorer
+
+// newScorers returns the scorers with the given names, in order.
+func newScorers(names []string) ([]engine.Scorer, error) {
+	result := []engine.Scorer{}
+	for _, name := range names {
+		newScorer, found := availableScorers[name]
+		if !found {
+			return nil, fmt.Errorf("unknown scorer %q, available: %v", name, scorerNames())
+		}
+		result = append(result, newScorer())
+	}
+	return result, nil
+}
+
+func scorerNames() []string {
+	names := []string{}
+	for name := range availableScorers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// candidateNodes drops the nodes excluded by the annotation policies and
+// orders the rest: first by <extra>, then by the annotation policies, then by
+// --node-scorers.
+func candidateNodes(nodes []*v1.Node, pod *v1.Pod, extra ...engine.Scorer) []*v1.Node {
+	policies := currentConfig().NodeAnnotationPolicies
+	candidates := make([]*v1.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if policies.Excludes(node) {
+			glog.V(4).Infof("Skipping node %v excluded by annotation policy", node.Name)
+			continue
+		}
+		candidates = append(candidates, node)
+	}
+	ordering := append(append([]engine.Scorer{}, extra...), policies)
+	return engine.OrderNodes(candidates, pod, append(ordering, scorers...))
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/contrib/rescheduler/metrics"
+)
+
+var (
+	// serverRetryInitialDelay and serverRetryMaxDelay bound the exponential
+	// backoff between attempts to bind the HTTP server.
+	serverRetryInitialDelay = time.Second
+	serverRetryMaxDelay     = 30 * time.Second
+	// serverRetryFatalAttempts is how many failed attempts are tolerated before
+	// giving up when --metrics-failure-fatal is set.
+	serverRetryFatalAttempts = 6
+)
+
+// serveHTTP serves <handler> on <address> until <ctx> is cancelled, and then
+// shuts the server down gracefully. If <tlsConfig> is set HTTPS is served. If the address can't be bound (e.g. the
+// port is briefly taken by a previous instance) binding is retried with
+// backoff. With <fatal> set the process exits once retries are exhausted,
+// otherwise the rescheduler keeps working without its HTTP endpoints.
+// <onListen>, if set, is called with the bound address whenever the server
+// starts listening, which is how a port chosen by the system is found out.
+func serveHTTP(ctx context.Context, address string, handler http.Handler, tlsConfig *tls.Config, fatal bool, onListen func(net.Addr)) {
+	delay := serverRetryInitialDelay
+	for attempt := 1; ; attempt++ {
+		err := serveOnce(ctx, address, handler, tlsConfig, onListen)
+		if err == nil {
+			return
+		}
+		if fatal && attempt >= serverRetryFatalAttempts {
+			glog.Fatalf("Failed to serve HTTP on %s after %d attempts: %v", address, attempt, err)
+		}
+		glog.Errorf("Failed to serve HTTP on %s (attempt %d), retrying in %v: %v", address, attempt, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		delay *= 2
+		if delay > serverRetryMaxDelay {
+			delay = serverRetryMaxDelay
+		}
+	}
+}
+
+// serveOnce returns nil after a graceful shutdown, or the error which stopped the server.
+func serveOnce(ctx context.Context, address string, handler http.Handler, tlsConfig *tls.Config, onListen func(net.Addr)) error {
+	network, address := listenNetwork(address)
+	if network == "unix" {
+		removeStaleSocket(address)
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	if onListen != nil {
+		onListen(listener.Addr())
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	server := &http.Server{Handler: handler}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+	glog.Infof("Serving HTTP on %s", listener.Addr())
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			glog.Warningf("Error while shutting down HTTP server: %v", err)
+		}
+		return nil
+	}
+}
+
+// unixSocketPrefix marks listen addresses which are unix socket paths.
+const unixSocketPrefix = "unix:"
+
+// listenNetwork splits <address> into the network to listen on and the
+// address within it: "unix:/path" and "unix:///path" are unix socket paths,
+// for sidecar scrapers where no TCP port may be opened, and anything else is
+// a TCP host:port.
+func listenNetwork(address string) (string, string) {
+	if !strings.HasPrefix(address, unixSocketPrefix) {
+		return "tcp", address
+	}
+	return "unix", strings.TrimPrefix(strings.TrimPrefix(address, unixSocketPrefix), "//")
+}
+
+// removeStaleSocket removes the socket at <path> left behind by a previous
+// instance which didn't shut down cleanly, as it would keep the path from
+// being bound. Files which aren't sockets are left alone.
+func removeStaleSocket(path string) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			glog.Warningf("Failed to remove stale socket %s: %v", path, err)
+		}
+	}
+}
+
+// publishListenAddress reports the address the metrics server is bound to,
+// which with port 0 in --listen-address is only known once it listens: it is
+// logged, exported as rescheduler_listen_port and, with --listen-address-file,
+// written to a file, so that tests running several instances on one host can
+// find each of them.
+func publishListenAddress(addr net.Addr) {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return
+	}
+	advertised := advertisedAddress(tcpAddr)
+	glog.Infof("Metrics are served on %s, advertised as %s", addr, advertised)
+	metrics.ListenPort.Set(float64(tcpAddr.Port))
+	if *listenAddressFile != "" {
+		if err := writeFileAtomically(*listenAddressFile, []byte(advertised+"\n")); err != nil {
+			glog.Warningf("Failed to write the listen address to %s: %v", *listenAddressFile, err)
+		}
+	}
+}
+
+// advertisedAddress returns the address others should use to reach the
+// server bound to <addr>: --advertise-address if set, the bound IP unless it
+// is the unspecified address, and the hostname otherwise.
+func advertisedAddress(addr *net.TCPAddr) string {
+	host := *advertiseAddress
+	if host == "" && !addr.IP.IsUnspecified() {
+		host = addr.IP.String()
+		if addr.Zone != "" {
+			host += "%" + addr.Zone
+		}
+	}
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+	return net.JoinHostPort(host, strconv.Itoa(addr.Port))
+}
+
+// writeFileAtomically replaces <path> with <data>, so that readers never see
+// a partially written file.
+func writeFileAtomically(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/contrib/rescheduler/metrics"
+)
+
+func TestServeHTTPRetriesAndShutsDown(t *testing.T) {
+	serverRetryInitialDelay = 10 * time.Millisecond
+	serverRetryMaxDelay = 20 * time.Millisecond
+	defer func() {
+		serverRetryInitialDelay = time.Second
+		serverRetryMaxDelay = 30 * time.Second
+	}()
+
+	// Keep the port busy so that the first attempts fail.
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	address := busy.Addr().String()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		serveHTTP(ctx, address, mux, nil, false, nil)
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	busy.Close()
+
+	err = wait.Poll(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		resp, err := http.Get("http://" + address + "/ping")
+		if err != nil {
+			return false, nil
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return string(body) == "pong", nil
+	})
+	assert.NoError(t, err)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("server didn't shut down")
+	}
+}
+
+func TestPublishListenAddress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rescheduler")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "address")
+	flags.Set("listen-address-file", path)
+	defer flags.Set("listen-address-file", "")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go serveHTTP(ctx, "127.0.0.1:0", mux, nil, false, publishListenAddress)
+
+	var address []byte
+	err = wait.Poll(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		address, err = ioutil.ReadFile(path)
+		return err == nil, nil
+	})
+	assert.NoError(t, err)
+	resp, err := http.Get("http://" + strings.TrimSpace(string(address)) + "/ping")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+	_, port, err := net.SplitHostPort(strings.TrimSpace(string(address)))
+	assert.NoError(t, err)
+	assert.Equal(t, port, fmt.Sprint(metricValue(t, metrics.ListenPort)))
+}
+
+func TestAdvertisedAddress(t *testing.T) {
+	hostname, err := os.Hostname()
+	assert.NoError(t, err)
+	for _, tc := range []struct {
+		ip        string
+		advertise string
+		expected  string
+	}{
+		{ip: "127.0.0.1", expected: "127.0.0.1:9235"},
+		{ip: "::1", expected: "[::1]:9235"},
+		{ip: "::", expected: net.JoinHostPort(hostname, "9235")},
+		{ip: "0.0.0.0", advertise: "10.0.0.1", expected: "10.0.0.1:9235"},
+		{ip: "::", advertise: "fd00::1", expected: "[fd00::1]:9235"},
+	} {
+		flags.Set("advertise-address", tc.advertise)
+		assert.Equal(t, tc.expected, advertisedAddress(&net.TCPAddr{IP: net.ParseIP(tc.ip), Port: 9235}), "%+v", tc)
+	}
+	flags.Set("advertise-address", "")
+}
+
+func TestServeHTTPOnUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rescheduler")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "metrics.sock")
+	// a socket left behind by a previous instance doesn't keep the path from being bound
+	stale, err := net.Listen("unix", path)
+	assert.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		serveHTTP(ctx, "unix://"+path, mux, nil, false, nil)
+		close(done)
+	}()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	err = wait.Poll(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		resp, err := client.Get("http://rescheduler/ping")
+		if err != nil {
+			return false, nil
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return string(body) == "pong", nil
+	})
+	assert.NoError(t, err)
+
+	cancel()
+	<-done
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err), "socket removed on shutdown")
+}
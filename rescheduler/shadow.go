@@ -0,0 +1,110 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"sync"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	kube_client "k8s.io/client-go/kubernetes"
+	kube_record "k8s.io/client-go/tools/record"
+	"k8s.io/contrib/rescheduler/engine"
+	"k8s.io/contrib/rescheduler/metrics"
+)
+
+// shadowPlacement reports what applyPlan would do for <placement> with events
+// on the node and on the victims, without changing anything in the cluster.
+func shadowPlacement(recorder kube_record.EventRecorder, placement *engine.Placement) {
+	node, criticalPod := placement.Node, placement.Pod
+	glog.Infof("Shadow mode: would taint node %v for critical pod %s and delete %d pods", node.Name, podId(criticalPod), len(placement.Victims))
+	placementEventf(recorder, node, criticalPod, placement.DecisionID, v1.EventTypeNormal, EventReasonWouldTaint,
+		"Rescheduler in shadow mode would taint node %s for critical pod %s.", node.Name, podId(criticalPod))
+	metrics.ShadowActionsCount.WithLabelValues("taint").Inc()
+	shadowPredictions.Record(criticalPod, node.Name)
+
+	for _, p := range placement.Victims {
+		placementEventf(recorder, p, criticalPod, placement.DecisionID, v1.EventTypeNormal, EventReasonWouldDelete,
+			"Rescheduler in shadow mode would delete this pod in order to schedule critical pod %s on node %s.", podId(criticalPod), node.Name)
+		metrics.ShadowActionsCount.WithLabelValues("delete").Inc()
+		metrics.SkippedEvictionsCount.WithLabelValues("shadow_mode").Inc()
+	}
+}
+
+// shadowPredictions remembers the node picked in shadow mode for each critical
+// pod until the pod is scheduled, to tell how often the scheduler (or the
+// cluster autoscaler, by adding a node) ends up placing it somewhere else.
+var shadowPredictions = &predictionSet{predictions: map[string]prediction{}}
+
+type prediction struct {
+	namespace string
+	name      string
+	uid       types.UID
+	node      string
+}
+
+type predictionSet struct {
+	predictions map[string]prediction
+	mutex       sync.Mutex
+}
+
+// Record remembers that <node> would have been reserved for <pod>, replacing
+// the previous prediction for it.
+func (s *predictionSet) Record(pod *v1.Pod, node string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.predictions[podId(pod)] = prediction{namespace: pod.Namespace, name: pod.Name, uid: pod.UID, node: node}
+}
+
+// Len returns the number of predictions waiting for their pod to be scheduled.
+func (s *predictionSet) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.predictions)
+}
+
+// Resolve compares the predictions with the nodes the pods were scheduled on
+// meanwhile and counts the results: agreed, disagreed, or gone if the pod was
+// deleted or recreated before it was scheduled.
+func (s *predictionSet) Resolve(client kube_client.Interface) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for id, predicted := range s.predictions {
+		pod, err := client.CoreV1().Pods(predicted.namespace).Get(predicted.name, metav1.GetOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			repeats.Warningf("get-pod/"+id, "Error while getting pod %s: %v", id, err)
+			continue
+		}
+		var result string
+		switch {
+		case err != nil || pod.UID != predicted.uid:
+			result = "gone"
+		case pod.Spec.NodeName == "":
+			continue
+		case pod.Spec.NodeName == predicted.node:
+			result = "agreed"
+		default:
+			result = "disagreed"
+			glog.V(2).Infof("Shadow mode: pod %s was scheduled on node %v, not on node %v", id, pod.Spec.NodeName, predicted.node)
+		}
+		metrics.ShadowPredictionsCount.WithLabelValues(result).Inc()
+		delete(s.predictions, id)
+	}
+}
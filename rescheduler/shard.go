@@ -0,0 +1,84 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	kube_utils "k8s.io/autoscaler/cluster-autoscaler/utils/kubernetes"
+	kube_client "k8s.io/client-go/kubernetes"
+	v1lister "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/contrib/rescheduler/metrics"
+)
+
+// nodeShardListOptions restricts lists and watches of nodes to the
+// --node-shard-selector, so that several rescheduler instances can each manage
+// a disjoint subset of nodes. The selector is applied by the apiserver, so
+// nodes of other shards are neither transferred nor cached.
+func nodeShardListOptions(options *metav1.ListOptions) {
+	options.LabelSelector = *nodeShardSelector
+}
+
+// nodeShardListWatch lists and watches the nodes of this instance's shard.
+func nodeShardListWatch(client kube_client.Interface) *cache.ListWatch {
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			nodeShardListOptions(&options)
+			return client.CoreV1().Nodes().List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			nodeShardListOptions(&options)
+			return client.CoreV1().Nodes().Watch(options)
+		},
+	}
+}
+
+// readyNodeLister lists the ready and schedulable nodes of the shard from an
+// informer cache, like kube_utils.ReadyNodeLister does for all nodes.
+type readyNodeLister struct {
+	lister v1lister.NodeLister
+}
+
+// newReadyNodeLister starts filling the cache of the returned lister until
+// <stopChannel> is closed. <handler> is notified of the changes to the cache,
+// so that other watchers of nodes can share it.
+func newReadyNodeLister(client kube_client.Interface, handler cache.ResourceEventHandler, stopChannel <-chan struct{}) kube_utils.NodeLister {
+	store, controller := cache.NewIndexerInformer(nodeShardListWatch(client), &v1.Node{}, time.Hour, handler, cache.Indexers{})
+	go controller.Run(stopChannel)
+	metrics.RegisterCacheSize("node_lister", func() int { return len(store.ListKeys()) })
+	return &readyNodeLister{lister: v1lister.NewNodeLister(store)}
+}
+
+func (l *readyNodeLister) List() ([]*v1.Node, error) {
+	nodes, err := l.lister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	ready := make([]*v1.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if kube_utils.IsNodeReadyAndSchedulable(node) {
+			ready = append(ready, node)
+		}
+	}
+	return ready, nil
+}
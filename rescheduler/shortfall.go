@@ -0,0 +1,147 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/contrib/rescheduler/engine"
+	"k8s.io/contrib/rescheduler/metrics"
+)
+
+// shortfallReportKey is the key of the report in --shortfall-configmap.
+const shortfallReportKey = "shortfall.json"
+
+// anyZone is the zone of the shortfall of pods which may run in every zone.
+const anyZone = "any"
+
+// zoneShortfall is the capacity missing in a zone: what the critical pods
+// confined to it, which fit on no node, request. Pods confined to several
+// zones are counted under all of them joined with commas.
+type zoneShortfall struct {
+	Zone        string   `json:"zone"`
+	Pods        []string `json:"pods"`
+	MilliCPU    int64    `json:"milliCPU"`
+	MemoryBytes int64    `json:"memoryBytes"`
+}
+
+// capacityShortfall sums the requests of the <unplaceable> pods by zone. The
+// sums are a lower bound of the capacity to add: the pods may not fit even
+// on empty nodes of that size, e.g. because of host ports.
+func capacityShortfall(unplaceable []*engine.Unplaceable) []zoneShortfall {
+	byZone := map[string]*zoneShortfall{}
+	for _, u := range unplaceable {
+		zone := anyZone
+		if zones := engine.RequiredZones(u.Pod); zones != nil {
+			zone = strings.Join(zones.List(), ",")
+		}
+		shortfall, found := byZone[zone]
+		if !found {
+			shortfall = &zoneShortfall{Zone: zone}
+			byZone[zone] = shortfall
+		}
+		cpu, memory := engine.PodRequests(u.Pod)
+		shortfall.Pods = append(shortfall.Pods, podId(u.Pod))
+		shortfall.MilliCPU += cpu
+		shortfall.MemoryBytes += memory
+	}
+	report := make([]zoneShortfall, 0, len(byZone))
+	for _, shortfall := range byZone {
+		report = append(report, *shortfall)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].Zone < report[j].Zone })
+	return report
+}
+
+// describeShortfall returns a one line summary of <report>.
+func describeShortfall(report []zoneShortfall) string {
+	zones := make([]string, 0, len(report))
+	for _, shortfall := range report {
+		zones = append(zones, fmt.Sprintf("%s: %d pods, %v CPU, %v memory", shortfall.Zone, len(shortfall.Pods),
+			resource.NewMilliQuantity(shortfall.MilliCPU, resource.DecimalSI), resource.NewQuantity(shortfall.MemoryBytes, resource.BinarySI)))
+	}
+	return strings.Join(zones, "; ")
+}
+
+// reportShortfall exports the capacity missing for the <unplaceable> pods as
+// metrics and, with --shortfall-configmap, in a ConfigMap. If there are
+// several pods it also records a single event on the rescheduler's own pod
+// and returns true: the pods then get no NoFeasibleNode events of their own,
+// which would only say the same thing many times.
+func (r *rescheduler) reportShortfall(unplaceable []*engine.Unplaceable) bool {
+	report := capacityShortfall(unplaceable)
+	metrics.CapacityShortfallCPUCores.Reset()
+	metrics.CapacityShortfallMemoryBytes.Reset()
+	for _, shortfall := range report {
+		metrics.CapacityShortfallCPUCores.WithLabelValues(shortfall.Zone).Set(float64(shortfall.MilliCPU) / 1000)
+		metrics.CapacityShortfallMemoryBytes.WithLabelValues(shortfall.Zone).Set(float64(shortfall.MemoryBytes))
+	}
+	if *shortfallConfigMap != "" {
+		if err := r.saveShortfallReport(report); err != nil {
+			repeats.Warningf("save-shortfall-report", "Failed to save capacity shortfall report to ConfigMap %s/%s: %v", ownNamespace(), *shortfallConfigMap, err)
+		}
+	}
+
+	self := selfReference()
+	if len(unplaceable) < 2 || self == nil {
+		repeats.ForgetAll("capacity-shortfall", EventReasonCapacityShortfall)
+		return false
+	}
+	repeats.Eventf(r.recorder, "capacity-shortfall", self, nil, v1.EventTypeWarning, EventReasonCapacityShortfall,
+		"%d critical pods don't fit on any node, they request %s.", len(unplaceable), describeShortfall(report))
+	return true
+}
+
+// saveShortfallReport writes <report> to --shortfall-configmap unless it
+// holds the same report already, which saves an update every pass while
+// nothing changes.
+func (r *rescheduler) saveShortfallReport(report []zoneShortfall) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	configMaps := r.client.CoreV1().ConfigMaps(ownNamespace())
+	existing, err := configMaps.Get(*shortfallConfigMap, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		glog.V(2).Infof("Creating capacity shortfall ConfigMap %s/%s", ownNamespace(), *shortfallConfigMap)
+		_, err = configMaps.Create(&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: *shortfallConfigMap, Namespace: ownNamespace()},
+			Data:       map[string]string{shortfallReportKey: string(data)},
+		})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if existing.Data[shortfallReportKey] == string(data) {
+		return nil
+	}
+	if existing.Data == nil {
+		existing.Data = map[string]string{}
+	}
+	existing.Data[shortfallReportKey] = string(data)
+	_, err = configMaps.Update(existing)
+	return err
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	ca_simulator "k8s.io/autoscaler/cluster-autoscaler/simulator"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	kube_utils "k8s.io/autoscaler/cluster-autoscaler/utils/kubernetes"
+	kube_client "k8s.io/client-go/kubernetes"
+	"k8s.io/contrib/rescheduler/engine"
+)
+
+// simulationResult describes what the rescheduler would do for a pod.
+type simulationResult struct {
+	// Node is the node which would be reserved for the pod, empty if none fits.
+	Node string `json:"node,omitempty"`
+	// Victims are the pods which would be deleted from Node.
+	Victims []string `json:"victims"`
+	// PredicateFailures maps names of nodes the pod doesn't fit on to the reason.
+	PredicateFailures map[string]string `json:"predicateFailures,omitempty"`
+	Error             string            `json:"error,omitempty"`
+}
+
+// simulateHandler serves POST /simulate. The request body is a Pod manifest
+// (YAML or JSON) and the response is a simulationResult computed from the
+// live cluster state. Nothing in the cluster is changed.
+type simulateHandler struct {
+	client           kube_client.Interface
+	predicateChecker *ca_simulator.PredicateChecker
+	nodeLister       kube_utils.NodeLister
+}
+
+// maxSimulateBodyBytes limits the size of the pod manifests accepted by
+// simulateHandler, which may be served without authentication.
+const maxSimulateBodyBytes = 1 << 20
+
+func (h *simulateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxSimulateBodyBytes))
+	if err != nil {
+		// MaxBytesReader returns the body up to the limit before failing
+		if int64(len(body)) >= maxSimulateBodyBytes {
+			http.Error(w, "pod manifest is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pod := &v1.Pod{}
+	if err := yaml.Unmarshal(body, pod); err != nil {
+		http.Error(w, "failed to parse pod: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	nodes, err := h.nodeLister.List()
+	if err != nil {
+		http.Error(w, "failed to list nodes: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result := simulatePlacement(r.Context(), h.client, h.predicateChecker, nodes, pod)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		glog.Warningf("Failed to write simulation result: %v", err)
+	}
+}
+
+// simulatePlacement reports the reason for rejecting each node and then
+// picks the node and victims with findSnapshotForPod and
+// engine.PlanPlacement, like a placement does. The pods of each node are
+// listed once for both.
+func simulatePlacement(ctx context.Context, client kube_client.Interface, predicateChecker *ca_simulator.PredicateChecker, nodes []*v1.Node, pod *v1.Pod) simulationResult {
+	result := simulationResult{
+		Victims:           []string{},
+		PredicateFailures: map[string]string{},
+	}
+	lists := newPodLists()
+	candidates := candidateNodes(nodes, pod)
+	for _, node := range candidates {
+		if ctx.Err() != nil {
+			result.Error = ctx.Err().Error()
+			return result
+		}
+		if _, err := checkNodeSnapshot(client, predicateChecker, lists, node, pod); err != nil {
+			result.PredicateFailures[node.Name] = err.Error()
+		}
+	}
+	snapshot := findSnapshotForPod(ctx, client, predicateChecker, lists, candidates, pod)
+	if snapshot == nil {
+		if ctx.Err() != nil {
+			result.Error = ctx.Err().Error()
+		} else {
+			result.Error = "pod doesn't fit on any node"
+		}
+		return result
+	}
+
+	result.Node = snapshot.Node.Name
+	placement, err := engine.PlanPlacement(predicateChecker, snapshot, pod)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	for _, victim := range placement.Victims {
+		result.Victims = append(result.Victims, podId(victim))
+	}
+	return result
+}
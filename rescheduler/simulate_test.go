@@ -0,0 +1,109 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	"k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+)
+
+type testNodeLister struct {
+	nodes []*v1.Node
+}
+
+func (l *testNodeLister) List() ([]*v1.Node, error) {
+	return l.nodes, nil
+}
+
+func TestSimulateHandler(t *testing.T) {
+	// the victims here are in kube-system
+	flags.Set("protect-system-namespace-victims", "false")
+	defer flags.Set("protect-system-namespace-victims", "true")
+	nodes := []*v1.Node{
+		createTestNode("node1", 500),
+		createTestNode("node2", 1000),
+	}
+	pods2 := []v1.Pod{
+		*createTestPod("p1n2", "kube-system", false, false, 500),
+		*createTestPod("p2n2", "kube-system", true, true, 300),
+	}
+	fakeClient := &fake.Clientset{}
+	fakeClient.Fake.AddReactor("list", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		restrictions := action.(core.ListAction).GetListRestrictions().Fields.String()
+		podList := &v1.PodList{}
+		if restrictions == "spec.nodeName=node2" {
+			podList.Items = pods2
+		}
+		return true, podList, nil
+	})
+	handler := &simulateHandler{
+		client:           fakeClient,
+		predicateChecker: simulator.NewTestPredicateChecker(),
+		nodeLister:       &testNodeLister{nodes: nodes},
+	}
+
+	manifest := `
+apiVersion: v1
+kind: Pod
+metadata:
+  name: new-addon
+  namespace: kube-system
+spec:
+  containers:
+  - name: addon
+    resources:
+      requests:
+        cpu: 600m
+`
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/simulate", strings.NewReader(manifest)))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	result := simulationResult{}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &result))
+	assert.Equal(t, "node2", result.Node)
+	assert.Equal(t, []string{"kube-system_p1n2"}, result.Victims)
+	assert.Contains(t, result.PredicateFailures, "node1")
+	assert.Empty(t, result.Error)
+
+	// like a placement, it prefers a later node where nothing has to be deleted
+	handler.nodeLister = &testNodeLister{nodes: append(nodes, createTestNode("node3", 1000))}
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/simulate", strings.NewReader(manifest)))
+	result = simulationResult{}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &result))
+	assert.Equal(t, "node3", result.Node)
+	assert.Empty(t, result.Victims)
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/simulate", strings.NewReader(manifest+strings.Repeat("#", maxSimulateBodyBytes))))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/simulate", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	kube_client "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/contrib/rescheduler/metrics"
+)
+
+// podNodeIndex indexes the pods of a boundPodCache by node name.
+const podNodeIndex = "node"
+
+// boundPodCache is an informer cache of the pods bound to nodes. With
+// --snapshot-pods each planning pass takes the pods of all nodes from it at
+// once, so that every decision of the pass sees the same cluster state and
+// no pods are listed while choosing nodes.
+type boundPodCache struct {
+	indexer cache.Indexer
+	synced  cache.InformerSynced
+}
+
+// newBoundPodCache starts filling the returned cache until <stopChannel> is closed.
+func newBoundPodCache(client kube_client.Interface, stopChannel <-chan struct{}) *boundPodCache {
+	bound := fields.ParseSelectorOrDie("spec.nodeName!=").String()
+	listWatch := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = bound
+			return client.CoreV1().Pods(v1.NamespaceAll).List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = bound
+			return client.CoreV1().Pods(v1.NamespaceAll).Watch(options)
+		},
+	}
+	indexer, controller := cache.NewIndexerInformer(listWatch, &v1.Pod{}, time.Hour, cache.ResourceEventHandlerFuncs{},
+		cache.Indexers{podNodeIndex: podNodeName})
+	go controller.Run(stopChannel)
+	metrics.RegisterCacheSize("pod_informer", func() int { return len(indexer.ListKeys()) })
+	return &boundPodCache{indexer: indexer, synced: controller.HasSynced}
+}
+
+func podNodeName(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object %T", obj)
+	}
+	return []string{pod.Spec.NodeName}, nil
+}
+
+// snapshot returns the pods bound to each of <nodes> as podLists, or nil if
+// the cache hasn't synced yet and the pods have to be listed from the
+// apiserver. The pods are shared with the cache and must not be changed.
+func (c *boundPodCache) snapshot(nodes []*v1.Node) (*podLists, error) {
+	if c == nil || !c.synced() {
+		return nil, nil
+	}
+	lists := newPodLists()
+	taken := time.Now()
+	for _, node := range nodes {
+		objs, err := c.indexer.ByIndex(podNodeIndex, node.Name)
+		if err != nil {
+			return nil, err
+		}
+		pods := make([]*v1.Pod, 0, len(objs))
+		for _, obj := range objs {
+			pods = append(pods, obj.(*v1.Pod))
+		}
+		lists.pods[node.Name], lists.taken[node.Name] = pods, taken
+	}
+	return lists, nil
+}
@@ -0,0 +1,267 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package synthetic generates fake clusters of nodes and pods for benchmarks
+// and tests of the rescheduler.
+package synthetic
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	core "k8s.io/client-go/testing"
+)
+
+const criticalPodAnnotation = "scheduler.alpha.kubernetes.io/critical-pod"
+
+// Options describe the shape of a generated cluster.
+type Options struct {
+	// Nodes is the number of nodes.
+	Nodes int
+	// PodsPerNode is the number of pods scheduled on each node.
+	PodsPerNode int
+	// NodeMilliCPU is the allocatable CPU of every node.
+	NodeMilliCPU int64
+	// DaemonSetPodsPerNode is how many of the pods on each node are critical DaemonSet pods.
+	DaemonSetPodsPerNode int
+	// Seed makes the pod sizes reproducible.
+	Seed int64
+}
+
+// Cluster is a generated set of nodes and the pods running on them.
+type Cluster struct {
+	Nodes []*v1.Node
+	Pods  []*v1.Pod
+}
+
+// NewCluster generates a cluster according to opts. Pod CPU requests are
+// random, but pods never exceed the node's allocatable CPU.
+func NewCluster(opts Options) *Cluster {
+	r := rand.New(rand.NewSource(opts.Seed))
+	cluster := &Cluster{}
+	for i := 0; i < opts.Nodes; i++ {
+		node := NewNode(fmt.Sprintf("node-%d", i), opts.NodeMilliCPU)
+		cluster.Nodes = append(cluster.Nodes, node)
+		if opts.PodsPerNode == 0 {
+			continue
+		}
+		perPod := opts.NodeMilliCPU / int64(opts.PodsPerNode)
+		for j := 0; j < opts.PodsPerNode; j++ {
+			cpu := perPod/2 + r.Int63n(perPod/2+1)
+			pod := NewPod(fmt.Sprintf("pod-%d-%d", i, j), metav1.NamespaceDefault, cpu)
+			if j < opts.DaemonSetPodsPerNode {
+				pod = NewCriticalDaemonSetPod(fmt.Sprintf("ds-%d-%d", i, j), cpu)
+			}
+			pod.Spec.NodeName = node.Name
+			cluster.Pods = append(cluster.Pods, pod)
+		}
+	}
+	return cluster
+}
+
+// NewNode returns a ready node with the given allocatable CPU.
+func NewNode(name string, milliCPU int64) *v1.Node {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Status: v1.NodeStatus{
+			Capacity: v1.ResourceList{
+				v1.ResourceCPU:    *resource.NewMilliQuantity(milliCPU, resource.DecimalSI),
+				v1.ResourceMemory: *resource.NewQuantity(2*1024*1024*1024, resource.DecimalSI),
+				v1.ResourcePods:   *resource.NewQuantity(110, resource.DecimalSI),
+			},
+			Conditions: []v1.NodeCondition{
+				{
+					Type:   v1.NodeReady,
+					Status: v1.ConditionTrue,
+				},
+			},
+		},
+	}
+	node.Status.Allocatable = node.Status.Capacity
+	return node
+}
+
+// NewPod returns an unscheduled pod requesting the given CPU.
+func NewPod(name, namespace string, milliCPU int64) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			UID:       types.UID(namespace + "/" + name),
+			SelfLink:  fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", namespace, name),
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{
+					Resources: v1.ResourceRequirements{
+						Requests: v1.ResourceList{
+							v1.ResourceCPU: *resource.NewMilliQuantity(milliCPU, resource.DecimalSI),
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// NewCriticalDaemonSetPod returns a critical pod in kube-system owned by a DaemonSet.
+func NewCriticalDaemonSetPod(name string, milliCPU int64) *v1.Pod {
+	pod := NewPod(name, metav1.NamespaceSystem, milliCPU)
+	pod.Annotations = map[string]string{criticalPodAnnotation: ""}
+	pod.OwnerReferences = []metav1.OwnerReference{{Kind: "DaemonSet", APIVersion: "v1", Name: name}}
+	return pod
+}
+
+// Clientset returns a fake clientset serving the cluster's objects. Unlike the
+// default fake, pod lists honor the spec.nodeName field selector, which the
+// rescheduler uses to find pods running on a node. Pods are served from a
+// per-node index rather than the generic object tracker, which doesn't scale
+// to the cluster sizes used in benchmarks.
+func (c *Cluster) Clientset() *fake.Clientset {
+	tracker := core.NewObjectTracker(scheme.Scheme, scheme.Codecs.UniversalDecoder())
+	for _, node := range c.Nodes {
+		if err := tracker.Add(node); err != nil {
+			panic(err)
+		}
+	}
+	index := newPodIndex(c.Pods)
+
+	client := &fake.Clientset{}
+	client.AddReactor("list", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		nodeName, found := "", false
+		if selector := action.(core.ListAction).GetListRestrictions().Fields; selector != nil {
+			nodeName, found = selector.RequiresExactMatch("spec.nodeName")
+		}
+		return true, index.list(action.GetNamespace(), nodeName, found), nil
+	})
+	client.AddReactor("get", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		name := action.(core.GetAction).GetName()
+		if pod := index.get(action.GetNamespace(), name); pod != nil {
+			return true, pod, nil
+		}
+		return true, nil, errors.NewNotFound(v1.Resource("pods"), name)
+	})
+	client.AddReactor("update", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		pod := action.(core.UpdateAction).GetObject().(*v1.Pod)
+		if !index.update(pod) {
+			return true, nil, errors.NewNotFound(v1.Resource("pods"), pod.Name)
+		}
+		return true, pod, nil
+	})
+	client.AddReactor("delete", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		name := action.(core.DeleteAction).GetName()
+		if !index.delete(action.GetNamespace(), name) {
+			return true, nil, errors.NewNotFound(v1.Resource("pods"), name)
+		}
+		return true, nil, nil
+	})
+	client.AddReactor("*", "*", core.ObjectReaction(tracker))
+	return client
+}
+
+// podIndex is a thread safe store of pods indexed by node name.
+type podIndex struct {
+	byKey  map[string]*v1.Pod
+	byNode map[string]map[string]*v1.Pod
+	mutex  sync.Mutex
+}
+
+func newPodIndex(pods []*v1.Pod) *podIndex {
+	index := &podIndex{
+		byKey:  make(map[string]*v1.Pod),
+		byNode: make(map[string]map[string]*v1.Pod),
+	}
+	for _, pod := range pods {
+		key := pod.Namespace + "/" + pod.Name
+		index.byKey[key] = pod
+		if index.byNode[pod.Spec.NodeName] == nil {
+			index.byNode[pod.Spec.NodeName] = make(map[string]*v1.Pod)
+		}
+		index.byNode[pod.Spec.NodeName][key] = pod
+	}
+	return index
+}
+
+func (i *podIndex) list(namespace, nodeName string, byNode bool) *v1.PodList {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	pods := i.byKey
+	if byNode {
+		pods = i.byNode[nodeName]
+	}
+	podList := &v1.PodList{}
+	for _, pod := range pods {
+		if namespace == "" || namespace == pod.Namespace {
+			podList.Items = append(podList.Items, *pod.DeepCopy())
+		}
+	}
+	sort.Slice(podList.Items, func(a, b int) bool {
+		return podList.Items[a].Name < podList.Items[b].Name
+	})
+	return podList
+}
+
+func (i *podIndex) get(namespace, name string) *v1.Pod {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if pod, found := i.byKey[namespace+"/"+name]; found {
+		return pod.DeepCopy()
+	}
+	return nil
+}
+
+func (i *podIndex) update(pod *v1.Pod) bool {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	key := pod.Namespace + "/" + pod.Name
+	old, found := i.byKey[key]
+	if !found {
+		return false
+	}
+	delete(i.byNode[old.Spec.NodeName], key)
+	pod = pod.DeepCopy()
+	i.byKey[key] = pod
+	if i.byNode[pod.Spec.NodeName] == nil {
+		i.byNode[pod.Spec.NodeName] = make(map[string]*v1.Pod)
+	}
+	i.byNode[pod.Spec.NodeName][key] = pod
+	return true
+}
+
+func (i *podIndex) delete(namespace, name string) bool {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	key := namespace + "/" + name
+	pod, found := i.byKey[key]
+	if !found {
+		return false
+	}
+	delete(i.byKey, key)
+	delete(i.byNode[pod.Spec.NodeName], key)
+	return true
+}
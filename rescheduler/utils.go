@@ -21,6 +21,7 @@ import (
 	"sync"
 
 	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 func podId(pod *v1.Pod) string {
@@ -29,23 +30,64 @@ func podId(pod *v1.Pod) string {
 
 // Thread safe implementation of set of Pods.
 type podSet struct {
-	set   map[string]struct{}
-	mutex sync.Mutex
+	// set maps the pods to the nodes reserved for them, if known.
+	set map[string]reservation
+	// finished are the UIDs of pods removed from the set whose taints may not
+	// have been released yet, see TakeFinished.
+	finished map[types.UID]struct{}
+	mutex    sync.Mutex
 }
 
 // NewPodSet creates new instance of podSet.
 func NewPodSet() *podSet {
 	return &podSet{
-		set:   make(map[string]struct{}),
-		mutex: sync.Mutex{},
+		set:      make(map[string]reservation),
+		finished: make(map[types.UID]struct{}),
+		mutex:    sync.Mutex{},
 	}
 }
 
+// reservation is a node reserved for a critical pod.
+type reservation struct {
+	pod        *v1.Pod
+	node       string
+	taint      v1.Taint
+	decisionID string
+	// victims are the pods deleted to make room on node.
+	victims []*v1.Pod
+}
+
 // Add the pod to the set.
 func (s *podSet) Add(pod *v1.Pod) {
+	s.AddReservation(reservation{pod: pod})
+}
+
+// AddReservation adds the pod of <r> to the set, remembering its node.
+func (s *podSet) AddReservation(r reservation) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.set[podId(r.pod)] = r
+}
+
+// Reservation returns the reservation for <pod> if it is in the set.
+func (s *podSet) Reservation(pod *v1.Pod) (reservation, bool) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	s.set[podId(pod)] = struct{}{}
+	r, found := s.set[podId(pod)]
+	return r, found
+}
+
+// Reservations returns the pods in the set whose node is known.
+func (s *podSet) Reservations() []reservation {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	reservations := []reservation{}
+	for _, r := range s.set {
+		if r.node != "" {
+			reservations = append(reservations, r)
+		}
+	}
+	return reservations
 }
 
 // Remove the pod from set.
@@ -53,6 +95,33 @@ func (s *podSet) Remove(pod *v1.Pod) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 	delete(s.set, podId(pod))
+	s.finished[pod.UID] = struct{}{}
+}
+
+// MarkFinished records that processing of <pod> ended without it being added
+// to the set, e.g. because preparing its node failed after tainting it.
+func (s *podSet) MarkFinished(pod *v1.Pod) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.finished[pod.UID] = struct{}{}
+}
+
+// TakeFinished returns whether the pod with <uid> was processed by this
+// instance and has been removed since, and forgets about it.
+func (s *podSet) TakeFinished(uid types.UID) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, found := s.finished[uid]
+	delete(s.finished, uid)
+	return found
+}
+
+// Len returns the number of pods in the set and the number of finished ones
+// not taken yet.
+func (s *podSet) Len() (int, int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.set), len(s.finished)
 }
 
 // Has checks whether the pod is in the set.
@@ -67,3 +136,15 @@ func (s *podSet) HasId(pod string) bool {
 	_, found := s.set[pod]
 	return found
 }
+
+// HasUID checks whether the pod with <uid> is in the set.
+func (s *podSet) HasUID(uid types.UID) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, r := range s.set {
+		if r.pod.UID == uid {
+			return true
+		}
+	}
+	return false
+}
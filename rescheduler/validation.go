@@ -0,0 +1,288 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// validateFlags checks flag values and their combinations and returns every
+// problem found, so that all of them can be reported at once on startup.
+func validateFlags() []error {
+	errs := []error{}
+	config := configFromFlags()
+	if err := config.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if *initialDelay < 0 {
+		errs = append(errs, fmt.Errorf("--initial-delay must not be negative, got %v", *initialDelay))
+	}
+	if *credentialsRefreshInterval < 0 {
+		errs = append(errs, fmt.Errorf("--credentials-refresh-interval must not be negative, got %v", *credentialsRefreshInterval))
+	}
+	if *nodeSnapshotMaxAge < 0 {
+		errs = append(errs, fmt.Errorf("--node-snapshot-max-age must not be negative, got %v", *nodeSnapshotMaxAge))
+	}
+	if *eventSink != eventSinkAPIServer && *eventSink != eventSinkEventsAPI && *eventSink != eventSinkLog && *eventSink != eventSinkNone {
+		errs = append(errs, fmt.Errorf("--event-sink must be apiserver, events-api, log or none, got %q", *eventSink))
+	}
+	if *eventQPS < 0 || *eventBurst < 1 {
+		errs = append(errs, fmt.Errorf("--event-qps must not be negative and --event-burst must be positive, got %v and %d", *eventQPS, *eventBurst))
+	}
+	if *eventNamespace != "" {
+		for _, msg := range validation.IsDNS1123Label(*eventNamespace) {
+			errs = append(errs, fmt.Errorf("--event-namespace %q is invalid: %s", *eventNamespace, msg))
+		}
+	}
+	for _, spec := range *actionSinkSpecs {
+		if _, _, err := parseActionSink(spec); err != nil {
+			errs = append(errs, fmt.Errorf("--action-sink: %v", err))
+		}
+	}
+	if *maxPlacementAttempts < 0 {
+		errs = append(errs, fmt.Errorf("--max-placement-attempts must not be negative, got %d", *maxPlacementAttempts))
+	}
+	if *planningBudget < 0 {
+		errs = append(errs, fmt.Errorf("--planning-budget must not be negative, got %v", *planningBudget))
+	}
+	if *planningSampleSize < 1 {
+		errs = append(errs, fmt.Errorf("--planning-sample-size must be positive, got %d", *planningSampleSize))
+	}
+	if *taintReleaseRetries < 0 {
+		errs = append(errs, fmt.Errorf("--taint-release-retries must not be negative, got %d", *taintReleaseRetries))
+	}
+	if *evictionRetries < 0 {
+		errs = append(errs, fmt.Errorf("--eviction-retries must not be negative, got %d", *evictionRetries))
+	}
+	if *apiTimeout < 0 {
+		errs = append(errs, fmt.Errorf("--api-timeout must not be negative, got %v", *apiTimeout))
+	}
+	if _, err := newScorers(*nodeScorers); err != nil {
+		errs = append(errs, fmt.Errorf("--node-scorers: %v", err))
+	}
+	if *reservedNodes != "skip" && *reservedNodes != "skip-fresh" {
+		errs = append(errs, fmt.Errorf("--reserved-nodes must be skip or skip-fresh, got %q", *reservedNodes))
+	}
+	if _, found := rwoVolumeVictimClasses[*rwoVolumeVictims]; !found {
+		errs = append(errs, fmt.Errorf("--rwo-volume-victims must be allow, avoid or protect, got %q", *rwoVolumeVictims))
+	}
+	if *nodeShardSelector != "" {
+		if _, err := labels.Parse(*nodeShardSelector); err != nil {
+			errs = append(errs, fmt.Errorf("--node-shard-selector: %v", err))
+		}
+		if *taintOwner == "" {
+			errs = append(errs, fmt.Errorf("--node-shard-selector requires --taint-owner, so that shards don't release each other's taints"))
+		}
+	}
+	if *daemonSetVictimPriorityGap < 0 {
+		errs = append(errs, fmt.Errorf("--daemonset-victim-priority-gap must not be negative, got %d", *daemonSetVictimPriorityGap))
+	}
+	if *dedicatedAddonNodes < 0 {
+		errs = append(errs, fmt.Errorf("--dedicated-addon-nodes must not be negative, got %d", *dedicatedAddonNodes))
+	}
+	if *dedicatedAddonNodesRotation < 0 {
+		errs = append(errs, fmt.Errorf("--dedicated-addon-nodes-rotation must not be negative, got %v", *dedicatedAddonNodesRotation))
+	}
+	if *disruptionHistoryWindow <= 0 {
+		errs = append(errs, fmt.Errorf("--disruption-history-window must be positive, got %v", *disruptionHistoryWindow))
+	}
+	if _, err := parseFaultRates(*injectFaults); err != nil {
+		errs = append(errs, fmt.Errorf("--inject-faults: %v", err))
+	}
+	if *coverageReportInterval < 0 {
+		errs = append(errs, fmt.Errorf("--coverage-report-interval must not be negative, got %v", *coverageReportInterval))
+	}
+	if *coverageConfigMap != "" && *coverageReportInterval <= 0 {
+		errs = append(errs, fmt.Errorf("--coverage-configmap requires --coverage-report-interval"))
+	}
+	if *debugDecisions < 0 {
+		errs = append(errs, fmt.Errorf("--debug-decisions must not be negative, got %d", *debugDecisions))
+	}
+	if *systemNamespace == "" {
+		errs = append(errs, fmt.Errorf("--system-namespace must not be empty"))
+	}
+	if err := validateListenAddress(*listenAddress); err != nil {
+		errs = append(errs, fmt.Errorf("--listen-address: %v", err))
+	}
+	if _, _, err := net.SplitHostPort(*advertiseAddress); err == nil || strings.ContainsAny(*advertiseAddress, "[]") {
+		errs = append(errs, fmt.Errorf("--advertise-address must be a host or IP without a port, got %q", *advertiseAddress))
+	}
+	if *adminListenAddress != "" {
+		if err := validateListenAddress(*adminListenAddress); err != nil {
+			errs = append(errs, fmt.Errorf("--admin-listen-address: %v", err))
+		}
+		if listenAddressesCollide(*listenAddress, *adminListenAddress) {
+			errs = append(errs, fmt.Errorf("--admin-listen-address must differ from --listen-address, both are %q", *adminListenAddress))
+		}
+		if *adminTLSCertFile == "" || *adminTLSKeyFile == "" {
+			errs = append(errs, fmt.Errorf("--admin-listen-address requires --admin-tls-cert-file and --admin-tls-key-file"))
+		}
+	}
+	if len(*impersonateGroups) > 0 && *impersonateUser == "" {
+		errs = append(errs, fmt.Errorf("--impersonate-group requires --impersonate-user"))
+	}
+	if *apiProxyURL != "" {
+		if u, err := url.Parse(*apiProxyURL); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") {
+			errs = append(errs, fmt.Errorf("--api-proxy-url must be an http(s) or socks5 URL, got %q", *apiProxyURL))
+		}
+	}
+	if *apiCAFile != "" {
+		if _, err := ioutil.ReadFile(*apiCAFile); err != nil {
+			errs = append(errs, fmt.Errorf("--api-ca-file: %v", err))
+		}
+	}
+	if *pushGatewayURL != "" {
+		if u, err := url.Parse(*pushGatewayURL); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			errs = append(errs, fmt.Errorf("--push-gateway-url must be an http(s) URL, got %q", *pushGatewayURL))
+		}
+		if *pushInterval <= 0 {
+			errs = append(errs, fmt.Errorf("--push-interval must be positive, got %v", *pushInterval))
+		}
+	}
+	if *clusterContexts != "" && *clusterKubeconfigDir != "" {
+		errs = append(errs, fmt.Errorf("--cluster-contexts and --cluster-kubeconfig-dir are mutually exclusive"))
+	}
+	if *clusterKubeconfigDir != "" {
+		if _, err := ioutil.ReadDir(*clusterKubeconfigDir); err != nil {
+			errs = append(errs, fmt.Errorf("--cluster-kubeconfig-dir: %v", err))
+		}
+	}
+	if *clusterContexts != "" || *clusterKubeconfigDir != "" {
+		// the rescheduler of each cluster serves its metrics to the supervisor only
+		if *pushGatewayURL != "" || *adminListenAddress != "" {
+			errs = append(errs, fmt.Errorf("--push-gateway-url and --admin-listen-address are not supported with several clusters"))
+		}
+	}
+	switch *forecastFormat {
+	case "", "json", "yaml":
+	default:
+		errs = append(errs, fmt.Errorf("--forecast must be json or yaml, got %q", *forecastFormat))
+	}
+	switch *printPlanFormat {
+	case "", "json", "yaml":
+	default:
+		errs = append(errs, fmt.Errorf("--print-plan must be json or yaml, got %q", *printPlanFormat))
+	}
+	return errs
+}
+
+func validateListenAddress(address string) error {
+	if network, path := listenNetwork(address); network == "unix" {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("unix socket path must be absolute, got %q", path)
+		}
+		return nil
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if strings.Contains(host, ":") {
+		// only bracketed IPv6 literals get here, optionally with a zone
+		if ip := net.ParseIP(strings.SplitN(host, "%", 2)[0]); ip == nil {
+			return fmt.Errorf("invalid IPv6 address %q in %q", host, address)
+		}
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 0 || p > 65535 {
+		return fmt.Errorf("invalid port %q in %q", port, address)
+	}
+	return nil
+}
+
+// listenAddressesCollide tells whether listening on both <a> and <b> would
+// fail: they are the same unix socket path, or the same TCP port on hosts
+// which overlap, i.e. are equal or either is a wildcard. Port 0 never
+// collides, as the system picks a free port for each.
+func listenAddressesCollide(a, b string) bool {
+	networkA, addressA := listenNetwork(a)
+	networkB, addressB := listenNetwork(b)
+	if networkA != networkB {
+		return false
+	}
+	if networkA == "unix" {
+		return filepath.Clean(addressA) == filepath.Clean(addressB)
+	}
+	hostA, portA, errA := net.SplitHostPort(addressA)
+	hostB, portB, errB := net.SplitHostPort(addressB)
+	if errA != nil || errB != nil {
+		return false
+	}
+	numberA, errA := strconv.Atoi(portA)
+	numberB, errB := strconv.Atoi(portB)
+	if errA != nil || errB != nil || numberA == 0 || numberA != numberB {
+		return false
+	}
+	return wildcardHost(hostA) || wildcardHost(hostB) || hostA == hostB ||
+		(net.ParseIP(hostA) != nil && net.ParseIP(hostA).Equal(net.ParseIP(hostB)))
+}
+
+// wildcardHost tells whether listening on <host> listens on all addresses.
+func wildcardHost(host string) bool {
+	ip := net.ParseIP(host)
+	return host == "" || (ip != nil && ip.IsUnspecified())
+}
+
+// dumpFlags writes the effective value of every flag to <w>. URLs, which may
+// carry credentials, are written without their userinfo and query.
+func dumpFlags(w io.Writer) {
+	flags.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		switch f.Name {
+		case "api-proxy-url", "push-gateway-url":
+			if value != "" {
+				value = redactURL(value)
+			}
+		case "action-sink":
+			specs := []string{}
+			for _, spec := range *actionSinkSpecs {
+				if strings.HasPrefix(spec, "webhook:") {
+					spec = "webhook:" + redactURL(strings.TrimPrefix(spec, "webhook:"))
+				}
+				specs = append(specs, spec)
+			}
+			value = "[" + strings.Join(specs, ",") + "]"
+		}
+		fmt.Fprintf(w, "--%s=%s\n", f.Name, value)
+	})
+}
+
+// redactURL replaces the userinfo and the query of <rawURL> with "REDACTED".
+// A value which isn't a URL is redacted as a whole.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "REDACTED"
+	}
+	if u.User != nil {
+		u.User = url.User("REDACTED")
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = "REDACTED"
+	}
+	return u.String()
+}
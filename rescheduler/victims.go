@@ -0,0 +1,117 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	kube_client "k8s.io/client-go/kubernetes"
+	"k8s.io/contrib/rescheduler/engine"
+)
+
+// EvictableAnnotationKey set to "true" on a pod in a system namespace lets it
+// be deleted to make room although --protect-system-namespace-victims is set.
+const EvictableAnnotationKey = "rescheduler.alpha.kubernetes.io/evictable"
+
+// Values of --rwo-volume-victims.
+var rwoVolumeVictimClasses = map[string]engine.VictimClass{
+	"allow":   engine.VictimAllowed,
+	"avoid":   engine.VictimAvoided,
+	"protect": engine.VictimProtected,
+}
+
+// daemonSetVictimRules returns <rules> with the DaemonSet pods which may be
+// deleted for <criticalPod> according to --daemonset-victim-priority-gap.
+func daemonSetVictimRules(rules *engine.RequiredPodRules, criticalPod *v1.Pod) *engine.RequiredPodRules {
+	if *daemonSetVictimPriorityGap <= 0 {
+		return rules
+	}
+	below := int64(podPriority(criticalPod)) - int64(*daemonSetVictimPriorityGap) + 1
+	if below <= math.MinInt32 {
+		return rules
+	}
+	withGap := engine.RequiredPodRules{}
+	if rules != nil {
+		withGap = *rules
+	}
+	threshold := int32(below)
+	withGap.DaemonSetPriorityBelow = &threshold
+	return &withGap
+}
+
+// victimClassifier returns the engine.NodeSnapshot.ClassifyVictim function
+// implementing the victim flags, or nil if all victims are allowed. Pods
+// outside <namespaces> are protected, unless it is nil, as are pods in system
+// namespaces with --protect-system-namespace-victims. Pods of controllers
+// which were disrupted recently are avoided.
+func victimClassifier(client kube_client.Interface, namespaces sets.String) func(*v1.Pod) engine.VictimClass {
+	rwoClass := rwoVolumeVictimClasses[*rwoVolumeVictims]
+	now := time.Now()
+	if rwoClass == engine.VictimAllowed && namespaces == nil && !*protectSystemNamespaceVictims && disruptions.Empty(now) {
+		return nil
+	}
+	return func(pod *v1.Pod) engine.VictimClass {
+		if namespaces != nil && !namespaces.Has(pod.Namespace) {
+			return engine.VictimProtected
+		}
+		if *protectSystemNamespaceVictims && isSystemNamespace(pod.Namespace) && pod.Annotations[EvictableAnnotationKey] != "true" {
+			return engine.VictimProtected
+		}
+		if rwoClass != engine.VictimAllowed && usesRWOVolume(client, pod) {
+			return rwoClass
+		}
+		if disruptions.RecentlyDisrupted(pod, now) {
+			return engine.VictimAvoided
+		}
+		return engine.VictimAllowed
+	}
+}
+
+// isSystemNamespace returns true for kube-system and --system-namespace.
+func isSystemNamespace(namespace string) bool {
+	return namespace == metav1.NamespaceSystem || namespace == *systemNamespace
+}
+
+// usesRWOVolume returns true if <pod> mounts a ReadWriteOnce persistent
+// volume claim. Evicting such a pod leaves the volume attached until it's
+// detached, which can keep its replacement pending for minutes. Claims which
+// can't be read are assumed to be ReadWriteOnce.
+func usesRWOVolume(client kube_client.Interface, pod *v1.Pod) bool {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+		claim, err := client.CoreV1().PersistentVolumeClaims(pod.Namespace).Get(volume.PersistentVolumeClaim.ClaimName, metav1.GetOptions{})
+		if err != nil {
+			repeats.Warningf("get-pvc/"+pod.Namespace+"/"+volume.PersistentVolumeClaim.ClaimName,
+				"Failed to get claim %s of pod %s, assuming ReadWriteOnce: %v", volume.PersistentVolumeClaim.ClaimName, podId(pod), err)
+			return true
+		}
+		for _, mode := range claim.Spec.AccessModes {
+			if mode == v1.ReadWriteOnce {
+				glog.V(4).Infof("Pod %s uses ReadWriteOnce claim %s", podId(pod), claim.Name)
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/api/core/v1"
+)
+
+// maintenanceWindows restricts evictions to, or forbids them during, weekly
+// windows of time. Placements without evictions are never restricted.
+type maintenanceWindows struct {
+	// Mode is "allow" to allow evictions only inside the windows, or
+	// "forbid" to forbid them inside the windows.
+	Mode string `json:"mode"`
+	// TimeZone is the IANA name of the zone the windows are in, UTC by default.
+	TimeZone string              `json:"timeZone,omitempty"`
+	Windows  []maintenanceWindow `json:"windows"`
+	// ExemptPriorityClasses may evict at any time, e.g. system-node-critical.
+	ExemptPriorityClasses []string `json:"exemptPriorityClasses,omitempty"`
+}
+
+// maintenanceWindow is a daily span of time, e.g. 22:00 to 06:00.
+type maintenanceWindow struct {
+	// Days on which the window starts, e.g. ["Sat", "Sun"]; every day if empty.
+	Days []string `json:"days,omitempty"`
+	// Start and End are "15:04" times. An End before Start ends the next day.
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"Sun": time.Sunday, "Mon": time.Monday, "Tue": time.Tuesday, "Wed": time.Wednesday,
+	"Thu": time.Thursday, "Fri": time.Friday, "Sat": time.Saturday,
+}
+
+func (w *maintenanceWindows) validate() error {
+	if w.Mode != "allow" && w.Mode != "forbid" {
+		return fmt.Errorf("mode must be allow or forbid, got %q", w.Mode)
+	}
+	if _, err := time.LoadLocation(w.TimeZone); err != nil {
+		return fmt.Errorf("timeZone: %v", err)
+	}
+	for _, window := range w.Windows {
+		for _, day := range window.Days {
+			if _, found := weekdays[day]; !found {
+				return fmt.Errorf("unknown day %q, use Mon, Tue, ...", day)
+			}
+		}
+		for _, clock := range []string{window.Start, window.End} {
+			if _, err := time.Parse("15:04", clock); err != nil {
+				return fmt.Errorf("invalid time %q, use HH:MM", clock)
+			}
+		}
+	}
+	return nil
+}
+
+// EvictionsAllowed returns true if evictions for <pod> are allowed at <now>.
+// Without windows they always are.
+func (w *maintenanceWindows) EvictionsAllowed(pod *v1.Pod, now time.Time) bool {
+	if w == nil {
+		return true
+	}
+	for _, class := range w.ExemptPriorityClasses {
+		if pod.Spec.PriorityClassName == class {
+			return true
+		}
+	}
+	location, err := time.LoadLocation(w.TimeZone)
+	if err != nil {
+		location = time.UTC
+	}
+	now = now.In(location)
+	inside := false
+	for _, window := range w.Windows {
+		if window.contains(now) {
+			inside = true
+			break
+		}
+	}
+	return inside == (w.Mode == "allow")
+}
+
+// contains returns true if <t> falls into the window.
+func (w maintenanceWindow) contains(t time.Time) bool {
+	start, _ := time.Parse("15:04", w.Start)
+	end, _ := time.Parse("15:04", w.End)
+	startMinute := start.Hour()*60 + start.Minute()
+	endMinute := end.Hour()*60 + end.Minute()
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	if endMinute <= startMinute {
+		// the window spans midnight, early times belong to the previous day's window
+		if minute < endMinute {
+			day = (day + 6) % 7
+		} else if minute < startMinute {
+			return false
+		}
+	} else if minute < startMinute || minute >= endMinute {
+		return false
+	}
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if weekdays[d] == day {
+			return true
+		}
+	}
+	return false
+}